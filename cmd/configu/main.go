@@ -16,6 +16,6 @@ func main() {
 	logutil.SetupLogger(true)
 
 	// For custom flags and commands,
-	// see comments in pkg/cmdconfig/main.go
+	// generate a main func with "configu -scaffold-cmd <name>"
 	cmdconfig.Main(version)
 }
@@ -1,10 +1,39 @@
+@echo off
+REM Helper script to toggle env with configu command in cmd.exe, see
+REM https://github.com/mozey/config#toggling-env-on-windows
+REM NOTE Do not use setlocal, the env must be set in the calling shell
+
+if not exist "%GOPATH%\bin\configu.exe" (
+    echo %GOPATH%\bin\configu.exe not found
+    exit /b 1
+)
+
+REM APP_DIR is the full path to the application basedir.
+REM The config file must exist under this path,
+REM and project files can be referenced relative to APP_DIR
 set APP_DIR=%cd%
+
+REM Default env is dev, first arg overrides
 set ENV=%1
-IF "%ENV%"=="" set ENV=dev
+if "%ENV%"=="" set ENV=dev
+echo Setting env for %ENV%
 
-%GOPATH%\bin\configu -os windows -env %ENV% > %APP_DIR%\conf.tmp.bat
-if %errorlevel% neq 0 exit /b %errorlevel%
-call %APP_DIR%\conf.tmp.bat
-del /f %APP_DIR%\conf.tmp.bat
+REM Set env as per config file,
+REM keys removed from the config file are unset
+set CONF_TMP=%TEMP%\conf.%RANDOM%.bat
+"%GOPATH%\bin\configu" -os windows -env %ENV% > "%CONF_TMP%"
+if %errorlevel% neq 0 (
+    type "%CONF_TMP%"
+    del /f "%CONF_TMP%"
+    set CONF_TMP=
+    exit /b 1
+)
+call "%CONF_TMP%"
+del /f "%CONF_TMP%"
+set CONF_TMP=
+set APP_DIR=%cd%
 
-REM done
+REM Print application env
+set APP_
+set AWS_ 2>nul
+exit /b 0
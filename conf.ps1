@@ -0,0 +1,38 @@
+# Helper func to toggle env with configu command in PowerShell, see
+# https://github.com/mozey/config#toggling-env-on-windows
+# This script is intended to be dot sourced from the PowerShell profile
+function conf {
+    param(
+        # Default env is dev
+        [string]$Name = "dev"
+    )
+
+    $configu = Join-Path $env:GOPATH "bin" | Join-Path -ChildPath "configu.exe"
+    if (-not (Test-Path $configu)) {
+        Write-Host "$configu not found"
+        return
+    }
+
+    # APP_DIR is the full path to the application basedir.
+    # The config file must exist under this path,
+    # and project files can be referenced relative to APP_DIR
+    $env:APP_DIR = (Get-Location).Path
+    Write-Host "Setting env for $Name"
+
+    # Set env as per config file,
+    # keys removed from the config file are unset.
+    # Output is only evaluated if configu succeeds
+    $output = & $configu -os powershell -env $Name | Out-String
+    if ($LASTEXITCODE -ne 0) {
+        Write-Host $output
+        return
+    }
+    Invoke-Expression $output
+    $env:APP_DIR = (Get-Location).Path
+
+    # Print application env
+    Get-ChildItem Env: |
+        Where-Object { $_.Name -like "APP_*" -or $_.Name -like "AWS_*" } |
+        Sort-Object Name |
+        Format-Table -AutoSize
+}
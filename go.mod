@@ -3,6 +3,7 @@ module github.com/mozey/config
 go 1.21.4
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/matryer/is v1.4.1
 	github.com/mozey/errors v0.1.0
 	github.com/mozey/logutil v0.0.0-20201020133158-b1ec1d809337
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mozey/config/pkg/cmdconfig"
+	"github.com/mozey/config/pkg/share"
+	"github.com/pkg/errors"
+)
+
+// Errors returned by the command that may be matched with errors.Is,
+// the message of the returned error includes the details
+var (
+	ErrAppDirNotSet   = cmdconfig.ErrAppDirNotSet("")
+	ErrConfigNotFound = cmdconfig.ErrConfigNotFound("", "")
+	ErrDuplicateKey   = cmdconfig.ErrDuplicateKey("")
+	ErrInvalidEnv     = cmdconfig.ErrInvalidEnv("")
+	ErrMissingKey     = cmdconfig.ErrMissingKey("")
+	ErrProtectedEnv   = cmdconfig.ErrProtectedEnv("")
+)
+
+// Config file formats
+const (
+	FormatENV  = "env"
+	FormatSH   = "sh"
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+// Formats returns the config file formats in load precedence order,
+// e.g. if the env and JSON files exist for an env, the env file is loaded
+func Formats() []string {
+	formats := make([]string, 0)
+	for _, fileType := range share.LoadPrecedence() {
+		formats = append(formats, strings.TrimPrefix(fileType, "."))
+	}
+	return formats
+}
+
+// fileType returns the file extension for format
+func fileType(format string) (string, error) {
+	for _, f := range Formats() {
+		if f == format {
+			return fmt.Sprintf(".%s", format), nil
+		}
+	}
+	return "", errors.Errorf("invalid format %s, expected one of %s",
+		format, strings.Join(Formats(), ", "))
+}
+
+// Marshal config in the given format, keys are sorted
+func Marshal(config map[string]string, format string) ([]byte, error) {
+	t, err := fileType(format)
+	if err != nil {
+		return nil, err
+	}
+	return cmdconfig.MarshalConfig(config, t)
+}
+
+// Unmarshal config in the given format,
+// the config must have a flat key value structure
+func Unmarshal(b []byte, format string) (map[string]string, error) {
+	t, err := fileType(format)
+	if err != nil {
+		return nil, err
+	}
+	return share.UnmarshalConfig(fmt.Sprintf("config%s", t), b)
+}
+
+// LoadConfig reads the config file for env in appDir,
+// as per the file loading precedence, see Formats.
+// Prefix env with "sample." to load the sample config file
+func LoadConfig(appDir, env string) (
+	configPath string, config map[string]string, err error) {
+
+	configPath, b, err := cmdconfig.ReadConfigFile(appDir, env)
+	if err != nil {
+		return configPath, config, err
+	}
+	config, err = share.UnmarshalConfig(configPath, b)
+	return configPath, config, err
+}
+
+// .............................................................................
+
+// Params for NewCommand
+type Params struct {
+	// Version to print with the version flag
+	Version string
+	// Args are configu flags, excluding the program name,
+	// e.g. []string{"-env", "prod", "-key", "APP_FOO", "-value", "foo"}
+	Args []string
+	// Stdin for commands that read input, defaults to os.Stdin
+	Stdin io.Reader
+	// Stdout for Apply, defaults to os.Stdout
+	Stdout io.Writer
+	// Stderr for Apply, e.g. warnings, defaults to os.Stderr
+	Stderr io.Writer
+}
+
+// Command is a validated configu command line.
+// APP_DIR, or the dir env var for the prefix flag, must be set
+type Command struct {
+	in *cmdconfig.CmdIn
+}
+
+// NewCommand parses and validates the flags in params.Args.
+// Flag errors are returned, not printed
+func NewCommand(params Params) (*Command, error) {
+	fs := flag.NewFlagSet("configu", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	in, err := cmdconfig.ParseFlagSet(fs, params.Version, params.Args)
+	if err != nil {
+		return nil, err
+	}
+	in.Stdin, in.Stdout, in.Stderr = params.Stdin, params.Stdout, params.Stderr
+	err = in.Valid()
+	if err != nil {
+		return nil, err
+	}
+	return &Command{in: in}, nil
+}
+
+// AppDir returns the application root the command runs in
+func (c *Command) AppDir() string {
+	return c.in.AppDir
+}
+
+// Run the command. No files are written, and nothing is printed,
+// call Apply on the result for that
+func (c *Command) Run() (*Result, error) {
+	out, err := cmdconfig.Cmd(c.in)
+	if err != nil {
+		return nil, err
+	}
+	r := &Result{
+		Cmd:      out.Cmd,
+		Warnings: out.Warnings,
+		ExitCode: out.ExitCode,
+		in:       c.in,
+		out:      out,
+	}
+	if out.Buf != nil {
+		r.Output = out.Buf.String()
+	}
+	for _, file := range out.Files {
+		if file.Path == "" {
+			continue
+		}
+		f := File{Path: file.Path, Delete: file.Del}
+		if file.Buf != nil {
+			f.Content = bytes.Clone(file.Buf.Bytes())
+		}
+		r.Files = append(r.Files, f)
+	}
+	return r, nil
+}
+
+// File to be written or deleted by Apply
+type File struct {
+	// Path is absolute, or relative to the working dir
+	Path string
+	// Content of the file, empty if the file is deleted
+	Content []byte
+	// Delete the file at Path instead of writing Content
+	Delete bool
+}
+
+// Result of running a command
+type Result struct {
+	// Cmd is the command that was run, e.g. cmdconfig.CmdSetEnv
+	Cmd string
+	// Output printed to stdout by Apply, unless files are written
+	Output string
+	// Files written or deleted by Apply, unless the dry run flag is set
+	Files []File
+	// Warnings printed to stderr by Apply
+	Warnings []string
+	// ExitCode returned by Apply,
+	// e.g. compare sets it if keys don't match
+	ExitCode int
+
+	in  *cmdconfig.CmdIn
+	out *cmdconfig.CmdOut
+}
+
+// Apply the result the same way the configu command does,
+// i.e. print the output and write the files, and return the exit code
+func (r *Result) Apply() (exitCode int, err error) {
+	return r.in.Process(r.out)
+}
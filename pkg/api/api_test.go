@@ -0,0 +1,90 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mozey/config/pkg/cmdconfig"
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestFormats(t *testing.T) {
+	is := testutil.Setup(t)
+
+	is.Equal([]string{FormatENV, FormatSH, FormatJSON, FormatYAML}, Formats())
+
+	config := map[string]string{"APP_B": "b", "APP_A": "a"}
+	for _, format := range Formats() {
+		b, err := Marshal(config, format)
+		is.NoErr(err)
+		m, err := Unmarshal(b, format)
+		is.NoErr(err)
+		is.Equal(config, m)
+	}
+	b, err := Marshal(config, FormatJSON)
+	is.NoErr(err)
+	is.Equal("{\n    \"APP_A\": \"a\",\n    \"APP_B\": \"b\"\n}", string(b))
+
+	_, err = Marshal(config, "toml")
+	is.True(err != nil)
+}
+
+func TestCommand(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+	t.Setenv("APP_DIR", tmp)
+
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"),
+		[]byte(`{"APP_FOO": "foo"}`), 0644)
+	is.NoErr(err)
+
+	configPath, config, err := LoadConfig(tmp, "dev")
+	is.NoErr(err)
+	is.Equal(filepath.Join(tmp, "config.dev.json"), configPath)
+	is.Equal(map[string]string{"APP_FOO": "foo"}, config)
+
+	stdout := new(bytes.Buffer)
+	c, err := NewCommand(Params{
+		Args:   []string{"-key", "APP_BAR", "-value", "bar"},
+		Stdout: stdout,
+		Stderr: new(bytes.Buffer),
+	})
+	is.NoErr(err)
+	is.Equal(tmp, c.AppDir())
+
+	// Run does not write files
+	r, err := c.Run()
+	is.NoErr(err)
+	is.Equal(cmdconfig.CmdUpdateConfig, r.Cmd)
+	is.Equal(1, len(r.Files))
+	_, config, err = LoadConfig(tmp, "dev")
+	is.NoErr(err)
+	is.Equal(1, len(config))
+
+	exitCode, err := r.Apply()
+	is.NoErr(err)
+	is.Equal(0, exitCode)
+	_, config, err = LoadConfig(tmp, "dev")
+	is.NoErr(err)
+	is.Equal(map[string]string{"APP_BAR": "bar", "APP_FOO": "foo"}, config)
+
+	// Errors
+	_, err = NewCommand(Params{Args: []string{"-nope"}})
+	is.True(err != nil)
+	c, err = NewCommand(Params{Args: []string{"-env", "prod"}})
+	is.NoErr(err)
+	_, err = c.Run()
+	is.True(errors.Is(err, ErrConfigNotFound))
+	is.True(!errors.Is(err, ErrMissingKey))
+	t.Setenv("APP_DIR", "")
+	_, err = NewCommand(Params{})
+	is.True(errors.Is(err, ErrAppDirNotSet))
+}
@@ -0,0 +1,19 @@
+// Package api is the supported surface for tools built on configu.
+//
+// The configu command is implemented in pkg/cmdconfig, and the runtime
+// used by generated code in pkg/share. Those packages are refactored as
+// features are added, so tools importing them may break on upgrade.
+// This package wraps them with a small API that follows semantic versioning:
+//
+//   - Exported identifiers are not removed or changed in a breaking way,
+//     unless the major version of the module changes
+//   - Fields may be added to structs, so use keyed struct literals
+//   - Identifiers to be removed are marked "Deprecated:" in the doc comment,
+//     and kept for at least two minor releases after that
+//   - Command line flags passed to NewCommand follow the same rules,
+//     Result.Cmd is one of the Cmd constants in pkg/cmdconfig
+//
+// Use pkg/cmdconfig directly, e.g. RunWith for custom flags and plugins,
+// if this package does not cover your use case, and open an issue
+// to have it added here
+package api
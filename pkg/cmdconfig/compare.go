@@ -0,0 +1,148 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+)
+
+// Severity of a key mismatch reported by compare
+const (
+	// SeverityError for required keys, the exit code is set
+	SeverityError = "error"
+	// SeverityWarning for optional keys, see KeySchema
+	SeverityWarning = "warning"
+)
+
+// compareKey is a key that is missing in one of the compared envs
+type compareKey struct {
+	Key string `json:"key"`
+	// Missing is the env without the key
+	Missing string `json:"missing,omitempty"`
+	// Present is the env with a deprecated key
+	Present string `json:"present,omitempty"`
+	// ReplacedBy is the replacement for a deprecated key
+	ReplacedBy string `json:"replacedBy,omitempty"`
+	Severity   string `json:"severity"`
+	// Owner of the key, see Owners
+	Owner string `json:"owner,omitempty"`
+}
+
+// compareReport is printed by compare with the JSON flag
+type compareReport struct {
+	Env     string       `json:"env"`
+	Compare string       `json:"compare"`
+	Keys    []compareKey `json:"keys"`
+	// Deprecated keys that are still present, see KeySchema
+	Deprecated []compareKey `json:"deprecated,omitempty"`
+	// Values that differ, only set with the values flag
+	Values   []compareValue `json:"values,omitempty"`
+	Errors   int            `json:"errors"`
+	Warnings int            `json:"warnings"`
+}
+
+// compareValue is a key with different values in the compared envs,
+// secret values are redacted
+type compareValue struct {
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	Compare string `json:"compare"`
+	// Owner of the key, see Owners
+	Owner string `json:"owner,omitempty"`
+}
+
+// add a key missing in env, severity is determined by the schema
+func (r *compareReport) add(key, env string, schema Schema, owner string) {
+	severity := SeverityError
+	if schema[key].Optional {
+		severity = SeverityWarning
+		r.Warnings++
+	} else {
+		r.Errors++
+	}
+	r.Keys = append(r.Keys,
+		compareKey{Key: key, Missing: env, Severity: severity, Owner: owner})
+}
+
+// addDeprecated adds a deprecated key present in env, it's a warning
+func (r *compareReport) addDeprecated(key, env string, schema Schema, owner string) {
+	r.Warnings++
+	r.Deprecated = append(r.Deprecated, compareKey{
+		Key:        key,
+		Present:    env,
+		Severity:   SeverityWarning,
+		Owner:      owner,
+		ReplacedBy: schema[key].ReplacedBy,
+	})
+}
+
+// addValue adds a key with different values,
+// secret values are redacted but still compared
+func (r *compareReport) addValue(key, value, compare string, secret bool,
+	owner string) {
+
+	if secret {
+		value, compare = redacted, redacted
+	} else {
+		value, compare = redact(key, value), redact(key, compare)
+	}
+	r.Values = append(r.Values, compareValue{
+		Key: key, Value: value, Compare: compare, Owner: owner})
+}
+
+// writeValues prints the values table to buf
+func (r *compareReport) writeValues(buf *bytes.Buffer) error {
+	if len(r.Values) == 0 {
+		return nil
+	}
+	w := tabwriter.NewWriter(buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "KEY\t%s\t%s\n", r.Env, r.Compare)
+	for _, v := range r.Values {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", v.Key, v.Value, v.Compare)
+	}
+	return errors.WithStack(w.Flush())
+}
+
+// sort keys by owner and name
+func (r *compareReport) sort() {
+	sort.Slice(r.Keys, func(i, j int) bool {
+		return ownerLess(
+			r.Keys[i].Owner, r.Keys[i].Key, r.Keys[j].Owner, r.Keys[j].Key)
+	})
+	sort.Slice(r.Deprecated, func(i, j int) bool {
+		if r.Deprecated[i].Key != r.Deprecated[j].Key {
+			return r.Deprecated[i].Key < r.Deprecated[j].Key
+		}
+		return r.Deprecated[i].Present < r.Deprecated[j].Present
+	})
+	sort.Slice(r.Values, func(i, j int) bool {
+		return ownerLess(
+			r.Values[i].Owner, r.Values[i].Key, r.Values[j].Owner, r.Values[j].Key)
+	})
+}
+
+// validIgnore returns an error if a glob is malformed, see path.Match
+func validIgnore(globs []string) error {
+	for _, glob := range globs {
+		_, err := path.Match(glob, "")
+		if err != nil {
+			return errors.Wrapf(err, "glob %s", glob)
+		}
+	}
+	return nil
+}
+
+// ignoreKey returns true if the key matches one of the globs,
+// globs must be validated with validIgnore
+func ignoreKey(globs []string, key string) bool {
+	for _, glob := range globs {
+		if ok, _ := path.Match(glob, key); ok {
+			return true
+		}
+	}
+	return false
+}
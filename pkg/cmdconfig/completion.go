@@ -0,0 +1,197 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// ShellFish is supported for completion, see Completions.
+// The shell flag does not support fish, set the os flag instead, see OSFish
+const ShellFish = OSFish
+
+// Words printed for completion scripts, the output is one word per line
+const (
+	CompletionEnvs = "envs"
+	CompletionKeys = "keys"
+)
+
+// templatePosixCompletion completes flags, env names, and key names.
+// Envs and keys are listed by calling configu, APP_DIR defaults to the
+// working dir, the same as the conf func
+var templatePosixCompletion = `# Shell completion for configu, add to ~/.{{.Shell}}rc
+#   eval "$(configu -completion {{.Shell}})"
+{{- if eq .Shell "zsh"}}
+autoload -U +X bashcompinit && bashcompinit
+{{- end}}
+_configu() {
+    local cur="${COMP_WORDS[COMP_CWORD]}"
+    local prev="${COMP_WORDS[COMP_CWORD-1]}"
+    local app_dir
+    app_dir="$(printenv {{.Prefix}}DIR || pwd)"
+    local conf_env="dev" i
+    for ((i = 1; i < COMP_CWORD - 1; i++)); do
+        if [ "${COMP_WORDS[i]}" = "-env" ]; then
+            conf_env="${COMP_WORDS[i+1]}"
+        fi
+    done
+    local words
+    case "${prev}" in
+        -env|-compare)
+            words="$({{.Prefix}}DIR="${app_dir}" command configu -prefix {{.Prefix}} -completion envs 2>/dev/null)" ;;
+        -key|-get)
+            words="$({{.Prefix}}DIR="${app_dir}" command configu -prefix {{.Prefix}} -env "${conf_env}" -completion keys 2>/dev/null)" ;;
+        -shell|-completion)
+            words="{{join .Shells " "}}" ;;
+        *)
+            words="{{join .Flags " "}}" ;;
+    esac
+    COMPREPLY=($(compgen -W "${words}" -- "${cur}"))
+}
+complete -o default -F _configu configu
+`
+
+// templateFishCompletion defines the completions for fish
+var templateFishCompletion = `# Shell completion for configu, add to ~/.config/fish/config.fish
+#   configu -completion fish | source
+function __configu_words
+    set -l app_dir (printenv {{.Prefix}}DIR; or pwd)
+    set -l conf_env dev
+    set -l args (commandline -opc)
+    for i in (seq (math (count $args) - 1))
+        if test "$args[$i]" = -env
+            set conf_env $args[(math $i + 1)]
+        end
+    end
+    switch "$args[-1]"
+        case -env -compare
+            env {{.Prefix}}DIR=$app_dir configu -prefix {{.Prefix}} -completion envs 2>/dev/null
+        case -key -get
+            env {{.Prefix}}DIR=$app_dir configu -prefix {{.Prefix}} -env $conf_env -completion keys 2>/dev/null
+        case -shell -completion
+            printf '%s\n' {{join .Shells " "}}
+        case '*'
+            printf '%s\n' {{join .Flags " "}}
+    end
+end
+complete -c configu -f -a '(__configu_words)'
+`
+
+// templatePowerShellCompletion registers an argument completer
+var templatePowerShellCompletion = `# PowerShell completion for configu, add to $PROFILE
+#   configu -completion powershell | Out-String | Invoke-Expression
+Register-ArgumentCompleter -Native -CommandName configu -ScriptBlock {
+    param($wordToComplete, $commandAst, $cursorPosition)
+    $elements = @($commandAst.CommandElements | ForEach-Object { "$_" })
+    $confEnv = "dev"
+    for ($i = 1; $i -lt $elements.Count - 1; $i++) {
+        if ($elements[$i] -eq "-env") { $confEnv = $elements[$i + 1] }
+    }
+    $prev = if ($wordToComplete) { $elements[-2] } else { $elements[-1] }
+    $appDir = $env:{{.Prefix}}DIR
+    if (-not $env:{{.Prefix}}DIR) { $env:{{.Prefix}}DIR = (Get-Location).Path }
+    $words = switch ($prev) {
+        { $_ -in "-env", "-compare" } { & configu -prefix {{.Prefix}} -completion envs 2>$null }
+        { $_ -in "-key", "-get" } { & configu -prefix {{.Prefix}} -env $confEnv -completion keys 2>$null }
+        { $_ -in "-shell", "-completion" } { @({{psjoin .Shells}}) }
+        default { @({{psjoin .Flags}}) }
+    }
+    $env:{{.Prefix}}DIR = $appDir
+    $words | Where-Object { $_ -like "$wordToComplete*" } | ForEach-Object {
+        [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)
+    }
+}
+`
+
+// completionTemplates maps shells to the completion template
+var completionTemplates = map[string]string{
+	ShellBash:       templatePosixCompletion,
+	ShellFish:       templateFishCompletion,
+	ShellPowerShell: templatePowerShellCompletion,
+	ShellZsh:        templatePosixCompletion,
+}
+
+// Completions returns the sorted list of shells
+// supported by the completion flag
+func Completions() []string {
+	shells := make([]string, 0, len(completionTemplates))
+	for shell := range completionTemplates {
+		shells = append(shells, shell)
+	}
+	sort.Strings(shells)
+	return shells
+}
+
+// completion prints the completion script for the given shell,
+// or the env or key names for the script to complete
+func completion(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
+	buf = new(bytes.Buffer)
+
+	switch in.Completion {
+	case CompletionEnvs:
+		envs, err := listEnvs(in.dirs, in.AppDir, false)
+		if err != nil {
+			return buf, files, err
+		}
+		sort.Strings(envs)
+		for _, env := range envs {
+			buf.WriteString(fmt.Sprintf("%s\n", env))
+		}
+		return buf, files, nil
+
+	case CompletionKeys:
+		_, config, err := newConf(confParams{
+			dirs:   in.dirs,
+			prefix: in.Prefix,
+			appDir: in.AppDir,
+			env:    in.Env,
+		})
+		if err != nil {
+			return buf, files, err
+		}
+		// Assuming config.Keys is already sorted
+		for _, key := range config.Keys {
+			buf.WriteString(fmt.Sprintf("%s\n", key))
+		}
+		return buf, files, nil
+	}
+
+	s, ok := completionTemplates[in.Completion]
+	if !ok {
+		return buf, files, errors.Errorf(
+			"invalid completion %s, expected one of %s", in.Completion,
+			strings.Join(Completions(), ", "))
+	}
+	t, err := template.New(in.Completion).Funcs(template.FuncMap{
+		"join": strings.Join,
+		"psjoin": func(words []string) string {
+			quoted := make([]string, 0, len(words))
+			for _, word := range words {
+				quoted = append(quoted, PowerShellQuote(word))
+			}
+			return strings.Join(quoted, ", ")
+		},
+	}).Parse(s)
+	if err != nil {
+		return buf, files, errors.WithStack(err)
+	}
+	flags := make([]string, 0, len(in.flags))
+	for _, name := range in.flags {
+		flags = append(flags, fmt.Sprintf("-%s", name))
+	}
+	err = t.Execute(buf, map[string]interface{}{
+		"Shell":  in.Completion,
+		"Prefix": in.Prefix,
+		"Shells": Completions(),
+		"Flags":  flags,
+	})
+	if err != nil {
+		return buf, files, errors.WithStack(err)
+	}
+
+	return buf, files, nil
+}
@@ -0,0 +1,118 @@
+package cmdconfig
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestCompletionWords(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"),
+		[]byte(`{"APP_FOO": "foo", "APP_BAR": "bar"}`), perms)
+	is.NoErr(err)
+	err = os.WriteFile(filepath.Join(tmp, "config.prod.json"),
+		[]byte(`{"APP_BAZ": "baz"}`), perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	in.Completion = CompletionEnvs
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdCompletion, out.Cmd)
+	is.Equal("dev\nprod\n", out.Buf.String())
+
+	in.Completion = CompletionKeys
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal("APP_BAR\nAPP_FOO\n", out.Buf.String())
+
+	in.Env = "prod"
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal("APP_BAZ\n", out.Buf.String())
+}
+
+func TestCompletion(t *testing.T) {
+	is := testutil.Setup(t)
+
+	fs := flag.NewFlagSet("configu", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	in, err := parseFlags(fs, "", []string{"-completion", "nope"}, nil)
+	is.NoErr(err)
+	is.NoErr(in.Valid()) // App dir is not required
+	_, err = Cmd(in)
+	is.True(err != nil)
+
+	for _, shell := range Completions() {
+		in.Completion = shell
+		out, err := Cmd(in)
+		is.NoErr(err)
+		is.True(strings.Contains(out.Buf.String(), "-completion envs"))
+		is.True(strings.Contains(out.Buf.String(), FlagCompletion))
+	}
+
+	in.Completion = ShellBash
+	out, err := Cmd(in)
+	is.NoErr(err)
+
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not found")
+	}
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	// Stub configu, keys are listed for the env
+	err = os.WriteFile(filepath.Join(tmp, "configu"), []byte(`#!/bin/sh
+case "$*" in
+    *"-completion envs"*) printf 'dev\nprod\n' ;;
+    *"-env prod -completion keys"*) echo APP_PROD ;;
+    *"-completion keys"*) echo APP_DEV ;;
+esac
+`), 0755)
+	is.NoErr(err)
+
+	cmd := exec.Command(bash, "--norc", "-c", `
+eval "$(cat)"
+words() {
+    COMP_WORDS=("$@")
+    COMP_CWORD=$(($# - 1))
+    _configu
+    echo "${COMPREPLY[*]}"
+}
+words configu -env p
+words configu -env prod -key ""
+words configu -get ""
+words configu -compl
+`)
+	cmd.Dir = tmp
+	cmd.Stdin = out.Buf
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("PATH=%s%c%s", tmp, os.PathListSeparator, os.Getenv("PATH")))
+	b, err := cmd.CombinedOutput()
+	is.NoErr(err)
+	is.Equal("prod\nAPP_PROD\nAPP_DEV\n-completion\n", string(b))
+}
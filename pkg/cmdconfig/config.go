@@ -5,11 +5,16 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"sort"
 	"strings"
+	"sync"
+	"text/template"
 
 	"github.com/mozey/config/pkg/share"
 	"github.com/pkg/errors"
@@ -44,6 +49,8 @@ type conf struct {
 	Map map[string]string
 	// Keys sorted
 	Keys []string
+	// Dirs maps keys to the dir of the config file the key was loaded from
+	Dirs map[string]string
 }
 
 func (c *conf) refreshKeys() {
@@ -65,6 +72,9 @@ func (c *conf) extend(ext *conf) error {
 			return ErrDuplicateKey(k)
 		}
 		c.Map[k] = v
+		if c.Dirs != nil {
+			c.Dirs[k] = ext.Dirs[k]
+		}
 	}
 	return nil
 }
@@ -81,6 +91,8 @@ type CmdIn struct {
 	Prefix string
 	// PrintVersion for printing the build version
 	PrintVersion bool
+	// Verbose errors include the stack trace
+	Verbose bool
 	// Env selects the config file
 	Env string
 	// All makes the cmd apply to all config files in APP_DIR, including samples
@@ -90,17 +102,43 @@ type CmdIn struct {
 	Del bool
 	// Compare config file keys
 	Compare string
+	// CompareValues also lists keys with different values
+	CompareValues bool
+	// Validate env against the sample and schema
+	Validate bool
+	// Lint key names, see lintKey
+	Lint bool
+	// Prune deletes keys that are not in the sample, see pruneKeys
+	Prune bool
+	// Fmt rewrites config files in canonical form, see fmtConfig
+	Fmt bool
+	// From is the config file to convert, see convertConfig
+	From string
+	// To is the path or format to convert config files to
+	To string
+	// File is read instead of the config file for env,
+	// e.g. a config artifact pulled from a server, see validFile
+	File string
 	// Keys to update
 	Keys ArgMap
 	// Value to update
 	Values ArgMap
 	// PrintValue for the given key
 	PrintValue string
-	// Generate config helper
+	// PrintValues for the given keys, in addition to PrintValue,
+	// may be comma separated lists
+	PrintValues ArgMap
+	// Template for printing values, e.g. "{{.APP_HOST}}:{{.APP_PORT}}"
+	Template string
+	// Transform printed values, or the template output, see Transforms
+	Transform ArgMap
+	// Generate config helper at path
 	Generate string
-	CSV      bool
-	Sep      string
-	DryRun   bool
+	// GenerateAll config helpers at the listed paths, in addition to Generate
+	GenerateAll ArgMap
+	CSV         bool
+	Sep         string
+	DryRun      bool
 	// Base64 encode config file
 	Base64 bool
 	// OS overrides the compiled x-platform config
@@ -111,6 +149,120 @@ type CmdIn struct {
 	Extend ArgMap
 	// Merge with parent config
 	Merge bool
+	// Parent dir for merge, by default the parent is searched for
+	Parent string
+	// dirs caches directory listings for the duration of the command
+	dirs *dirCache
+	// warnings for the user, see CmdOut
+	warnings []string
+	// preview of changes to env, see CmdOut
+	preview *bytes.Buffer
+	// manifest is the optional project manifest, see LoadManifest
+	manifest *Manifest
+	// flags defined on the flag set, see completion
+	flags []string
+	// Clean deletes orphaned generated files
+	Clean bool
+	// Export config in the given format, see ExportFormats
+	Export string
+	// ExportBundle writes config files for the envs to this tar.gz file
+	ExportBundle string
+	// ImportBundle validates and unpacks the bundle in APP_DIR
+	ImportBundle string
+	// Stats summarizes config per env
+	Stats bool
+	// Refs lists secret references per env
+	Refs bool
+	// Rename a key in all config files for env, i.e. OLD=NEW
+	Rename string
+	// ListKeys prints the key names for the selected envs
+	ListKeys bool
+	// KeysDetail also lists the type and source file of each key
+	KeysDetail bool
+	// IgnoreValues lists values that may be repeated for distinct keys
+	IgnoreValues ArgMap
+	// Safe only unsets env vars previously exported by configu
+	Safe bool
+	// Preview changes to env before printing set env commands
+	Preview bool
+	// AWS login and assume role for env, see awsLogin
+	AWS bool
+	// EncryptKey encrypts the values for these keys, see encryptKeys
+	EncryptKey ArgMap
+	// DecryptKey replaces encrypted values for these keys with the plaintext
+	DecryptKey ArgMap
+	// Parity compares env with the local shell and these files,
+	// see parityConfig
+	Parity ArgMap
+	// Exec runs Command with the config for env, see execCmd
+	Exec bool
+	// Command and args after the flags, e.g. configu -exec -- ./server
+	Command []string
+	// Secrets resolves secret references, see share.SecretSchemes
+	Secrets bool
+	// Force updates to protected envs or value types, overwrite changed S3 objects,
+	// or overwrite files when importing a bundle or scaffolding a command
+	Force bool
+	// Service name, the command runs in the service dir
+	Service string
+	// Workspace runs the command for all services in the manifest
+	Workspace bool
+	// Pull config file from S3 URI
+	Pull string
+	// Push config file to S3 URI
+	Push string
+	// Import keys from Kubernetes URIs, e.g. k8s://namespace/configmap/name
+	Import ArgMap
+	// ImportEnv imports env vars matching the prefix, see importEnv
+	ImportEnv bool
+	// Shell prints the conf func for the given shell, see Shells
+	Shell string
+	// Completion prints the completion script for the given shell,
+	// see Completions, or the words for CompletionEnvs and CompletionKeys
+	Completion string
+	// Init scaffolds config files and the config package, see initProject
+	Init bool
+	// ScaffoldCmd generates cmd/<name>/main.go for custom builds
+	ScaffoldCmd string
+	// JSON output for machine-readable results
+	JSON bool
+	// Ignore globs for keys skipped by compare
+	Ignore ArgMap
+	// RequireOwner for new keys, see Owners
+	RequireOwner bool
+	// Sample creates or updates sample config files from the config files
+	Sample bool
+	// SyncSamples adds keys missing from the samples, see syncSamples
+	SyncSamples bool
+	// SampleDefaults generates defaults from the sample config file
+	SampleDefaults bool
+	// Must generates getters that panic if the value is empty
+	Must bool
+	// Precedence for generated code, see PrecedenceEnv and PrecedenceVars
+	Precedence string
+	// Watch generates FileNameWatchGo
+	Watch bool
+	// ConfigTest generates the DirConfigTest package
+	ConfigTest bool
+	// BindFlags generates FileNameFlagsGo
+	BindFlags bool
+	// NoVars generates config without package vars for ldflags
+	NoVars bool
+	// Envconfig generates FileNameEnvconfigGo
+	Envconfig bool
+	// TypeScript definitions are generated in this dir, see FileNameConfigDTs
+	TypeScript string
+	// TypeScriptLoader also generates FileNameLoaderTs
+	TypeScriptLoader bool
+	// Ldflags prints -X flags for the config package at this path
+	Ldflags string
+
+	// Stdin for commands that read input, defaults to os.Stdin
+	Stdin io.Reader
+	// Stdout for Process, defaults to os.Stdout
+	Stdout io.Writer
+	// Stderr for Process, e.g. warnings, defaults to os.Stderr
+	Stderr io.Writer
 }
 
 type CmdInParams struct {
@@ -134,20 +286,142 @@ func (in *CmdIn) Valid() error {
 		in.Prefix = fmt.Sprintf("%s_", prefix)
 	}
 
+	// Shell init is used in profile scripts, i.e. outside of the app dir
+	if in.Shell != "" {
+		return nil
+	}
+	if in.Completion != "" &&
+		in.Completion != CompletionEnvs && in.Completion != CompletionKeys {
+		return nil
+	}
+
 	// AppDir is required
 	appDirKey := fmt.Sprintf("%sDIR", in.Prefix)
 	appDir := os.Getenv(appDirKey)
+	if in.File != "" {
+		err := in.validFile()
+		if err != nil {
+			return err
+		}
+		if appDir == "" {
+			// The file may be inspected outside of a project
+			appDir = filepath.Dir(in.File)
+		}
+	}
+	if in.From != "" || in.To != "" {
+		err := in.validConvert()
+		if err != nil {
+			return err
+		}
+		if appDir == "" && in.From != "" {
+			// Files may be converted outside of a project
+			appDir = filepath.Dir(in.From)
+		}
+	}
+	if appDir == "" && in.Init {
+		// New projects don't have APP_DIR set yet
+		wd, err := os.Getwd()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		appDir = wd
+	}
 	if appDir == "" {
 		// Don't set default APP_DIR, the user must explicitely set it.
 		// Default value could cause unexpected behavior with generated code,
 		// or make issues with features like base64 config hard to debug
-		return errors.Errorf("%v env not set\n", appDirKey)
+		return errors.WithStack(ErrAppDirNotSet(appDirKey))
 	}
 	in.AppDir = appDir
 
+	// Export syntax depends on the shell, unless the os flag is set
+	if in.OS == "" {
+		in.OS = DetectOS()
+	}
+
+	// Services are listed in the manifest
+	if in.Service != "" {
+		if in.Workspace {
+			return errors.Errorf("%s and %s flags are exclusive",
+				FlagService, FlagWorkspace)
+		}
+		s, err := in.manifest.service(in.Service)
+		if err != nil {
+			return err
+		}
+		in.useService(s)
+	}
+
+	// Envs must be listed in the manifest, if any
+	err := in.manifest.validEnv(in.Env)
+	if err != nil {
+		return err
+	}
+	if in.Compare != "" && in.Compare != share.Sample {
+		err = in.manifest.validEnv(in.Compare)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = validIgnore(in.Ignore)
+	if err != nil {
+		return err
+	}
+
+	if in.Exec && len(in.Command) == 0 {
+		return errors.Errorf("%s requires a command, e.g. configu -%s -- ./server",
+			FlagExec, FlagExec)
+	}
+
+	if in.Precedence != "" &&
+		in.Precedence != PrecedenceEnv && in.Precedence != PrecedenceVars {
+		return errors.Errorf("invalid %s %s, expected %s or %s", FlagPrecedence,
+			in.Precedence, PrecedenceEnv, PrecedenceVars)
+	}
+
+	return nil
+}
+
+// validFile checks the file flag is only used with read commands,
+// and makes the path absolute
+func (in *CmdIn) validFile() error {
+	readCmd := len(in.printKeys()) > 0 || in.ListKeys || in.CSV ||
+		in.Base64 || in.Validate
+	if !readCmd {
+		return errors.Errorf("%s is only supported with %s", FlagFile,
+			strings.Join([]string{
+				FlagGet, FlagKeys, FlagCSV, FlagBase64, FlagValidate}, ", "))
+	}
+	if in.All || strings.ContainsAny(in.Env, "*,") {
+		return errors.Errorf("%s requires a single env", FlagFile)
+	}
+	if len(in.Extend) > 0 || in.Merge || in.Service != "" || in.Workspace {
+		return errors.Errorf("%s can't be used with %s, %s, %s, or %s", FlagFile,
+			FlagExtend, FlagMerge, FlagService, FlagWorkspace)
+	}
+	fileType := filepath.Ext(in.File)
+	valid := false
+	for _, t := range share.LoadPrecedence() {
+		valid = valid || t == fileType
+	}
+	if !valid {
+		return errors.Errorf("invalid %s %s, expected extension %s",
+			FlagFile, in.File, strings.Join(share.LoadPrecedence(), ", "))
+	}
+	file, err := filepath.Abs(in.File)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	in.File = file
 	return nil
 }
 
+// warn appends warnings for the user
+func (in *CmdIn) warn(warnings ...string) {
+	in.warnings = append(in.warnings, warnings...)
+}
+
 // .............................................................................
 
 type File struct {
@@ -155,6 +429,10 @@ type File struct {
 	Path string
 	// Buf for new file content
 	Buf *bytes.Buffer
+	// Del removes the file at Path instead of writing Buf
+	Del bool
+	// Perm for the file, defaults to 0644
+	Perm os.FileMode
 }
 
 type Files []File
@@ -165,6 +443,10 @@ func (files Files) Print(buf *bytes.Buffer) {
 		// empty file.Path implies nothing was generated
 		if file.Path != "" {
 			buf.WriteString("\n")
+			if file.Del {
+				buf.WriteString(fmt.Sprintf("// Delete: %s\n", file.Path))
+				continue
+			}
 			buf.WriteString(fmt.Sprintf("// FilePath: %s", file.Path))
 			buf.Write(file.Buf.Bytes())
 		}
@@ -177,6 +459,15 @@ func (files Files) Save(buf *bytes.Buffer) (err error) {
 	for _, file := range files {
 		// empty file.Path implies nothing was generated
 		if file.Path != "" {
+			if file.Del {
+				err := os.Remove(file.Path)
+				if err != nil && !os.IsNotExist(err) {
+					log.Info().Str("file_path", file.Path).Msg("")
+					return errors.WithStack(err)
+				}
+				buf.WriteString(fmt.Sprintf("deleted %s\n", file.Path))
+				continue
+			}
 			// Make sure parent dirs exist
 			err := os.MkdirAll(filepath.Dir(file.Path), 0755)
 			if err != nil {
@@ -184,11 +475,22 @@ func (files Files) Save(buf *bytes.Buffer) (err error) {
 				return errors.WithStack(err)
 			}
 			// Write the file
-			err = os.WriteFile(file.Path, file.Buf.Bytes(), 0644)
+			perm := file.Perm
+			if perm == 0 {
+				perm = 0644
+			}
+			err = os.WriteFile(file.Path, file.Buf.Bytes(), perm)
 			if err != nil {
 				log.Info().Str("file_path", file.Path).Msg("")
 				return errors.WithStack(err)
 			}
+			if file.Perm != 0 {
+				// WriteFile doesn't change the perm of existing files
+				err = os.Chmod(file.Path, perm)
+				if err != nil {
+					return errors.WithStack(err)
+				}
+			}
 			// Print file path only
 			buf.WriteString(file.Path)
 			buf.WriteString("\n")
@@ -210,6 +512,12 @@ type CmdOut struct {
 	Buf *bytes.Buffer
 	// Files to write if in.DryRun is not set
 	Files Files
+	// Warnings to print, these don't affect the exit code
+	Warnings []string
+	// Preview of changes to env, printed to stderr
+	Preview *bytes.Buffer
+	// cmd to run with the exec flag
+	cmd *exec.Cmd
 }
 
 // .............................................................................
@@ -217,45 +525,59 @@ type CmdOut struct {
 // listSamples if set, otherwise list non-samples
 type listSamples bool
 
-// getEnvs globs all config files in APP_DIR to list possible values of env
+// envFileName submatches env from file name.
+// Env must start with a word character, and may contain hyphens
+var envFileName = regexp.MustCompile("^config\\.(\\w+[\\w\\-]*)\\.json$")
+
+// getEnvs lists all config files in APP_DIR to list possible values of env
 func getEnvs(appDir string, samples listSamples) (envs []string, err error) {
-	envs = make([]string, 0)
+	return listEnvs(nil, appDir, samples)
+}
 
-	// Find matching files
-	fileNamePattern := "config.*.json"
-	if samples {
-		fileNamePattern = "sample.config.*.json"
-	}
-	pattern := filepath.Join(appDir, fileNamePattern)
-	matches, err := filepath.Glob(pattern)
-	if err != nil {
-		return envs, errors.WithStack(err)
-	}
+// listEnvs lists possible values of env given the names in appDir
+func listEnvs(dirs *dirCache, appDir string, samples listSamples) (
+	envs []string, err error) {
+
+	envs = make([]string, 0)
 
-	// Regexp to submatch env from file name.
-	// Env must start with a word character, and may contain hyphens
-	s := "config\\.(\\w+[\\w\\-]*)\\.json"
-	r, err := regexp.Compile(s)
+	names, err := dirs.names(appDir)
 	if err != nil {
-		return envs, errors.WithStack(err)
+		return envs, err
 	}
 
-	for _, match := range matches {
-		baseName := filepath.Base(match)
-		matches := r.FindStringSubmatch(baseName)
-		if len(matches) == 2 {
+	samplePrefix := share.SamplePrefix()
+	for name := range names {
+		baseName := name
+		if samples {
+			if !strings.HasPrefix(name, samplePrefix) {
+				continue
+			}
+			baseName = strings.TrimPrefix(name, samplePrefix)
+		}
+		matches := envFileName.FindStringSubmatch(baseName)
+		if len(matches) == 2 && matches[1] != EnvTypes {
 			env := matches[1]
-			samplePrefix := share.SamplePrefix()
-			if strings.HasPrefix(baseName, samplePrefix) {
+			if samples {
 				env = fmt.Sprintf("%s%s", samplePrefix, env)
 			}
 			envs = append(envs, env)
 		}
 	}
+	// Same order as filepath.Glob
+	sort.Strings(envs)
+
 	return envs, nil
 }
 
+// ReadConfigFile reads the config file for env,
+// as per the file loading precedence
 func ReadConfigFile(appDir, env string) (configPath string, b []byte, err error) {
+	return readConfigFile(nil, appDir, env)
+}
+
+func readConfigFile(dirs *dirCache, appDir, env string) (
+	configPath string, b []byte, err error) {
+
 	found := false
 	paths, err := share.GetConfigFilePaths(appDir, env)
 	if err != nil {
@@ -265,22 +587,18 @@ func ReadConfigFile(appDir, env string) (configPath string, b []byte, err error)
 
 	// Don't change scope of configPath variable!
 	for _, configPath = range paths {
-		_, err := os.Stat(configPath)
+		exists, err := dirs.exists(configPath)
 		if err != nil {
-			if os.IsNotExist(err) {
-				// log.Debug().Str("config_path", configPath).Msg("Not found")
-				continue
-			} else {
-				return configPath, b, errors.WithStack(err)
-			}
+			return configPath, b, err
+		}
+		if !exists {
+			// log.Debug().Str("config_path", configPath).Msg("Not found")
+			continue
 		}
 
 		// Config file exists, try to read it
 		b, err = os.ReadFile(configPath)
 		if err != nil {
-			log.Error().
-				Str("config_path", configPath).
-				Msg("ReadConfigFile")
 			return configPath, b, errors.WithStack(err)
 		}
 		found = true
@@ -288,12 +606,11 @@ func ReadConfigFile(appDir, env string) (configPath string, b []byte, err error)
 	}
 
 	if !found {
-		err = errors.Errorf("config file not found for env %s", env)
-		log.Error().
-			Str("appDir", appDir).
-			Strs("paths", paths).
-			Msg("ReadConfigFile")
-		return configPath, b, err
+		report, err := probeReport(dirs, appDir, env, paths)
+		if err != nil {
+			return configPath, b, err
+		}
+		return configPath, b, errors.WithStack(ErrConfigNotFound(env, report))
 	}
 	// log.Debug().Str("config_path", configPath).Msg("Found")
 
@@ -305,43 +622,95 @@ func ReadConfigFile(appDir, env string) (configPath string, b []byte, err error)
 }
 
 // loadConf loads config from a file
-func loadConf(appDir string, env string) (
+func loadConf(dirs *dirCache, appDir string, env string) (
 	configPath string, c *conf, err error) {
 
 	// New config
 	c = &conf{}
 
-	configPath, b, err := ReadConfigFile(appDir, env)
-	if err != nil {
+	configPath, b, err := readConfigFile(dirs, appDir, env)
+	var configMap map[string]string
+	if errors.Is(err, ErrConfigNotFound("", "")) {
+		// Config files take precedence over directive comments
+		directivesPath, directives, dirErr := readDirectives(dirs, appDir, env)
+		if dirErr != nil {
+			return configPath, c, dirErr
+		}
+		if directivesPath == "" {
+			return configPath, c, err
+		}
+		configPath, configMap = directivesPath, directives
+	} else if err != nil {
 		return configPath, c, err
+	} else {
+		configMap, err = share.UnmarshalConfig(configPath, b)
+		if err != nil {
+			log.Info().Str("config_path", configPath).Msg("")
+			return configPath, c, err
+		}
+	}
+
+	c.Map = configMap
+	c.Dirs = make(map[string]string)
+	for key := range configMap {
+		c.Dirs[key] = appDir
 	}
+	c.refreshKeys()
 
+	return configPath, c, nil
+}
+
+// loadFileConf loads config from the file at configPath,
+// instead of the config file for env in APP_DIR
+func loadFileConf(configPath string) (c *conf, err error) {
+	c = &conf{}
+
+	b, err := os.ReadFile(configPath)
+	if err != nil {
+		return c, errors.WithStack(err)
+	}
+	if strings.TrimSpace(string(b)) == "" {
+		return c, errors.Errorf("empty file %s", configPath)
+	}
 	configMap, err := share.UnmarshalConfig(configPath, b)
 	if err != nil {
-		log.Info().Str("config_path", configPath).Msg("")
-		return configPath, c, err
+		return c, errors.WithMessagef(err, "invalid %s", configPath)
 	}
 
 	c.Map = configMap
+	c.Dirs = make(map[string]string)
+	for key := range configMap {
+		c.Dirs[key] = filepath.Dir(configPath)
+	}
 	c.refreshKeys()
 
-	return configPath, c, nil
+	return c, nil
 }
 
 type confParams struct {
+	dirs   *dirCache
 	prefix string
 	appDir string
 	env    string
 	extend []string
 	merge  bool
+	parent string
+	// file overrides the config file for env, see CmdIn.File
+	file string
 }
 
 // newConf constructor for conf
 func newConf(params confParams) (
 	configPaths []string, c *conf, err error) {
 
+	if params.file != "" {
+		// Extensions are not loaded for files outside APP_DIR
+		c, err = loadFileConf(params.file)
+		return []string{params.file}, c, err
+	}
+
 	// Default
-	configPaths, c, err = newSingleConf(params.appDir, params.env)
+	configPaths, c, err = newCachedConf(params.dirs, params.appDir, params.env)
 	if err != nil {
 		return configPaths, c, err
 	}
@@ -354,6 +723,7 @@ func newConf(params confParams) (
 	if len(params.extend) > 0 {
 		// Extend config
 		return newExtendedConf(extConfParams{
+			dirs:        params.dirs,
 			mainConf:    c,
 			configPaths: configPaths,
 			appDir:      params.appDir,
@@ -364,10 +734,12 @@ func newConf(params confParams) (
 	} else if params.merge && len(configPaths) > 0 {
 		// Merge with parent config
 		return newMergedConf(mergeConfParams{
+			dirs:       params.dirs,
 			extConf:    c,
 			configPath: configPaths[0],
 			appDir:     params.appDir,
 			env:        params.env,
+			parent:     params.parent,
 		})
 	}
 
@@ -391,6 +763,7 @@ func newConf(params confParams) (
 					extend, filepath.Join(extDir, extension))
 			}
 			return newExtendedConf(extConfParams{
+				dirs:        params.dirs,
 				mainConf:    c,
 				configPaths: configPaths,
 				appDir:      params.appDir,
@@ -405,7 +778,15 @@ func newConf(params confParams) (
 
 // newSingleConf reads a config file and sets the key map
 func newSingleConf(appDir string, env string) (configPaths []string, c *conf, err error) {
-	configPath, c, err := loadConf(appDir, env)
+	return newCachedConf(nil, appDir, env)
+}
+
+// newCachedConf is the same as newSingleConf,
+// but uses dirs to check if config files exist
+func newCachedConf(dirs *dirCache, appDir string, env string) (
+	configPaths []string, c *conf, err error) {
+
+	configPath, c, err := loadConf(dirs, appDir, env)
 	if err != nil {
 		return configPaths, c, err
 	}
@@ -414,7 +795,38 @@ func newSingleConf(appDir string, env string) (configPaths []string, c *conf, er
 	return configPaths, c, nil
 }
 
+// maxConfChain limits the number of config files loaded for one env
+const maxConfChain = 32
+
+// confChain lists config file paths in the order they were loaded,
+// it's used to detect the same file being loaded more than once
+type confChain []string
+
+// add configPath to the chain. Paths are compared after resolving symlinks,
+// the error includes the full chain of paths involved
+func (chain confChain) add(configPath string) (confChain, error) {
+	realPath, err := filepath.EvalSymlinks(configPath)
+	if err != nil {
+		realPath = configPath
+	}
+	for _, p := range chain {
+		realP, err := filepath.EvalSymlinks(p)
+		if err != nil {
+			realP = p
+		}
+		if realP == realPath {
+			return chain, ErrConfCycle(append(chain, configPath))
+		}
+	}
+	chain = append(chain, configPath)
+	if len(chain) > maxConfChain {
+		return chain, ErrConfChainDepth(chain)
+	}
+	return chain, nil
+}
+
 type extConfParams struct {
+	dirs        *dirCache
 	mainConf    *conf
 	configPaths []string
 	appDir      string
@@ -432,14 +844,19 @@ func newExtendedConf(params extConfParams) (
 	// Main config
 	c = params.mainConf
 	configPaths = params.configPaths
+	chain := append(confChain{}, configPaths...)
 
 	// Try to load the extension config
 	for _, extDir := range params.extend {
-		configPath, extConf, err := loadConf(
+		configPath, extConf, err := loadConf(params.dirs,
 			filepath.Join(params.appDir, extDir), params.env)
 		if err != nil {
 			return configPaths, c, err
 		}
+		chain, err = chain.add(configPath)
+		if err != nil {
+			return configPaths, c, err
+		}
 		configPaths = append(configPaths, configPath)
 		// Extend main config
 		err = c.extend(extConf)
@@ -454,39 +871,95 @@ func newExtendedConf(params extConfParams) (
 }
 
 type mergeConfParams struct {
+	dirs       *dirCache
 	extConf    *conf
 	configPath string
 	appDir     string
 	env        string
+	// parent dir, if empty the parent is searched for
+	parent string
+}
+
+// rootDir returns true if dir is a filesystem root or empty,
+// e.g. / or C:\ and \\host\share\ on Windows
+func rootDir(dir string) bool {
+	rest := strings.TrimPrefix(dir, filepath.VolumeName(dir))
+	if rest == "" || rest == "." || rest == string(filepath.Separator) {
+		return true
+	}
+	// Dir returns the same path for roots not matched above
+	return filepath.Dir(dir) == dir
+}
+
+// parentBoundaries mark the project root,
+// the search for a parent config stops at the first dir containing one
+var parentBoundaries = []string{".git", "go.mod", FileNameManifest}
+
+// parentBoundary returns true if dir is the project root
+func parentBoundary(dirs *dirCache, dir string) (bool, error) {
+	for _, name := range parentBoundaries {
+		found, err := dirs.exists(filepath.Join(dir, name))
+		if err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
 }
 
 // newMergedConf merges an extension with a parent config file
 func newMergedConf(params mergeConfParams) (
 	configPaths []string, c *conf, err error) {
 
-	// Search for parent config relative to appDir
 	configPath := ""
-	parentDir := filepath.Dir(params.appDir)
-	for {
-		// Try to load parent config
-		configPath, c, err = loadConf(parentDir, params.env)
-		if err == nil {
-			// Found it
-			configPaths = append(configPaths, configPath)
-			break
-		}
-		// Go up another level
-		parentDir = filepath.Dir(parentDir)
-		if parentDir == string(filepath.Separator) || parentDir == "." {
-			// Stop searching at root or if path is empty
-			break
+	if params.parent != "" {
+		// Parent dir is relative to appDir, unless it's absolute
+		parentDir := params.parent
+		if !filepath.IsAbs(parentDir) {
+			parentDir = filepath.Join(params.appDir, parentDir)
+		}
+		configPath, c, err = loadConf(params.dirs, parentDir, params.env)
+		if err != nil {
+			return configPaths, c, err
+		}
+		configPaths = append(configPaths, configPath)
+
+	} else {
+		// Search for parent config relative to appDir
+		parentDir := filepath.Dir(params.appDir)
+		for {
+			// Try to load parent config
+			configPath, c, err = loadConf(params.dirs, parentDir, params.env)
+			if err == nil {
+				// Found it
+				configPaths = append(configPaths, configPath)
+				break
+			}
+			// Don't search beyond the project root,
+			// e.g. config files in the home dir are not related
+			boundary, err := parentBoundary(params.dirs, parentDir)
+			if err != nil {
+				return configPaths, c, err
+			}
+			if boundary {
+				break
+			}
+			// Go up another level
+			parentDir = filepath.Dir(parentDir)
+			if rootDir(parentDir) {
+				// Stop searching at root or if path is empty
+				break
+			}
 		}
 	}
 	if len(configPaths) == 0 {
 		return configPaths, c, ErrParentNotFound
 	}
 
-	// Extension path
+	// Extension path, e.g. a symlink to the parent dir
+	_, err = confChain(configPaths).add(params.configPath)
+	if err != nil {
+		return configPaths, c, err
+	}
 	configPaths = append(configPaths, params.configPath)
 
 	// Merge with parent config
@@ -503,198 +976,640 @@ func newMergedConf(params mergeConfParams) (
 // .............................................................................
 
 // compareKeys for config files,
-// buf (if not empty) contains keys that didn't match
-func compareKeys(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
+// buf (if not empty) contains keys that didn't match.
+// If compare is share.Sample, env is compared with the sample for env.
+// With the JSON flag, buf contains the compareReport,
+// and the exit code is only set for mismatched required keys
+func compareKeys(in *CmdIn) (
+	buf *bytes.Buffer, files []File, exitCode int, err error) {
+
 	buf = new(bytes.Buffer)
 
+	// Compare with the sample for env, e.g. sample.dev
+	compare := in.Compare
+	sample := compare == share.Sample
+	if sample {
+		if in.Env == "*" || strings.HasPrefix(in.Env, share.SamplePrefix()) {
+			return buf, files, exitCode, errors.Errorf(
+				"compare %s requires a single env that is not a sample",
+				share.Sample)
+		}
+		compare = fmt.Sprintf("%s%s", share.SamplePrefix(), in.Env)
+	}
+
 	_, config, err := newConf(confParams{
+		dirs:   in.dirs,
 		appDir: in.AppDir,
 		env:    in.Env,
 		extend: in.Extend,
 		merge:  in.Merge,
+		parent: in.Parent,
 	})
 	if err != nil {
-		return buf, files, err
+		return buf, files, exitCode, err
 	}
 	_, compConfig, err := newConf(confParams{
+		dirs:   in.dirs,
 		appDir: in.AppDir,
-		env:    in.Compare,
+		env:    compare,
 		extend: in.Extend,
 		merge:  in.Merge,
+		parent: in.Parent,
 	})
 	if err != nil {
-		return buf, files, err
+		return buf, files, exitCode, err
 	}
 
-	unmatched := make([]string, 0, len(config.Keys)+len(compConfig.Keys))
+	schema, err := readSchema(in.dirs, in.AppDir)
+	if err != nil {
+		return buf, files, exitCode, err
+	}
+
+	owners := keyOwners{manifest: in.manifest, schema: schema}
+
+	report := compareReport{
+		Env:     in.Env,
+		Compare: compare,
+		Keys:    make([]compareKey, 0),
+	}
+
+	// Keys matching the ignore flags or manifest are not compared
+	ignore := make([]string, 0)
+	ignore = append(ignore, in.manifest.ignore()...)
+	ignore = append(ignore, in.Ignore...)
 
 	// Compare config keys
 	for _, item := range config.Keys {
-		if _, ok := compConfig.Map[item]; !ok {
-			unmatched = append(unmatched, item)
+		if _, ok := compConfig.Map[item]; !ok && !ignoreKey(ignore, item) {
+			report.add(item, compare, schema, owners.owner(item))
 		}
 	}
 	for _, item := range compConfig.Keys {
-		if _, ok := config.Map[item]; !ok {
-			unmatched = append(unmatched, item)
+		if _, ok := config.Map[item]; !ok && !ignoreKey(ignore, item) {
+			report.add(item, in.Env, schema, owners.owner(item))
+		}
+	}
+	// Values are compared for keys in both envs
+	if in.CompareValues {
+		for _, item := range config.Keys {
+			compValue, ok := compConfig.Map[item]
+			if !ok || compValue == config.Map[item] || ignoreKey(ignore, item) {
+				continue
+			}
+			report.addValue(item, config.Map[item], compValue,
+				schema[item].Secret || in.manifest.secretKey(item),
+				owners.owner(item))
+		}
+	}
+	// Deprecated keys should be removed from both envs
+	for _, c := range []struct {
+		env    string
+		config *conf
+	}{{in.Env, config}, {compare, compConfig}} {
+		for _, item := range c.config.Keys {
+			if schema[item].Deprecated && !ignoreKey(ignore, item) {
+				report.addDeprecated(item, c.env, schema, owners.owner(item))
+			}
 		}
 	}
+	report.sort()
 
-	// Add unmatched keys to buffer
-	sort.Strings(unmatched)
-	for _, item := range unmatched {
-		buf.WriteString(fmt.Sprintf("%s%s", item, "\n"))
+	if in.JSON {
+		b, err := json.MarshalIndent(report, "", "    ")
+		if err != nil {
+			return buf, files, exitCode, errors.WithStack(err)
+		}
+		buf.Write(b)
+		buf.WriteString("\n")
+		if report.Errors > 0 {
+			exitCode = 1
+		}
+		return buf, files, exitCode, nil
 	}
 
-	return buf, files, nil
+	// Deprecated keys don't set the exit code
+	for _, item := range report.Deprecated {
+		if item.ReplacedBy != "" {
+			in.warn(fmt.Sprintf("%s is deprecated in %s, use %s",
+				item.Key, item.Present, item.ReplacedBy))
+			continue
+		}
+		in.warn(fmt.Sprintf("%s is deprecated in %s", item.Key, item.Present))
+	}
+
+	// Add unmatched keys to buffer,
+	// when comparing with the sample the missing side is stated.
+	// Keys are grouped by owner if owners are declared
+	grouped := owners.enabled()
+	for i, item := range report.Keys {
+		if grouped && (i == 0 || report.Keys[i-1].Owner != item.Owner) {
+			buf.WriteString(ownerHeading(item.Owner))
+		}
+		if sample {
+			buf.WriteString(fmt.Sprintf(
+				"%s missing in %s\n", item.Key, item.Missing))
+			continue
+		}
+		buf.WriteString(fmt.Sprintf("%s%s", item.Key, "\n"))
+	}
+	if buf.Len() > 0 {
+		exitCode = 1
+	}
+
+	// Different values are expected, they don't set the exit code
+	if len(report.Values) > 0 {
+		if buf.Len() > 0 {
+			buf.WriteString("\n")
+		}
+		err = report.writeValues(buf)
+		if err != nil {
+			return buf, files, exitCode, err
+		}
+	}
+
+	return buf, files, exitCode, nil
 }
 
 // .............................................................................
 
+// validateUpdate checks the keys and values to update,
+// it is called once before refreshing config for the listed envs
+func validateUpdate(
+	prefix string, keys ArgMap, values ArgMap, del bool) error {
+
+	for i, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			return errors.Errorf(
+				"key %s must start with prefix %s", key, prefix)
+		}
+		if !del && i > len(values)-1 {
+			return errors.Errorf("missing value for key %s", key)
+		}
+	}
+	return nil
+}
+
+// warnUnknownKeys warns about keys to update that are not in any of the envs,
+// with the closest existing keys, since the key is likely mistyped.
+// The keys that are not in any of the envs are returned
+func warnUnknownKeys(in *CmdIn, envs []string) (newKeys []string, err error) {
+	existing := make(map[string]bool)
+	keys := make([]string, 0)
+	for _, env := range envs {
+		_, c, err := newCachedConf(in.dirs, in.AppDir, env)
+		if err != nil {
+			return newKeys, err
+		}
+		for _, key := range c.Keys {
+			if !existing[key] {
+				existing[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range in.Keys {
+		if existing[key] {
+			continue
+		}
+		newKeys = append(newKeys, key)
+		suggestions := suggest(key, keys)
+		if in.Del {
+			in.warn(fmt.Sprintf("%s not found%s", key, didYouMean(suggestions)))
+		} else if len(suggestions) > 0 {
+			// New keys are expected, unless similar to existing keys
+			in.warn(fmt.Sprintf(
+				"%s is a new key%s", key, didYouMean(suggestions)))
+		}
+	}
+	return newKeys, nil
+}
+
+// Values that grow by more than sizeChangeFactor, to at least sizeChangeMin
+// bytes, are likely pasted by mistake, e.g. a certificate instead of a port
+const (
+	sizeChangeFactor = 10
+	sizeChangeMin    = 256
+)
+
+// checkTypeChanges returns an error if a value to set does not parse as the
+// type declared in the schema, or changes the inferred type of the existing
+// value, e.g. an int port replaced with a URL. Keys with a declared type,
+// including string, are not inferred. Values that grow by much more than the
+// existing value are also checked. That is usually a copy-paste mistake,
+// the changes are listed as warnings, and allowed with the force flag
+func checkTypeChanges(in *CmdIn, envs []string) error {
+	schema, err := readSchema(in.dirs, in.AppDir)
+	if err != nil {
+		return err
+	}
+	changed := make([]string, 0)
+	for i, key := range in.Keys {
+		value := in.Values[i]
+		declared := schema[key].Type
+		if declared != "" && declared != TypeString &&
+			parseType(declared, value) != nil {
+			changed = append(changed, key)
+			in.warn(fmt.Sprintf("%s is declared as %s in %s, got %q",
+				key, declared, FileNameTypes, redact(key, value)))
+			continue
+		}
+		newType := inferType(value)
+		for _, env := range envs {
+			_, c, err := newCachedConf(in.dirs, in.AppDir, env)
+			if err != nil {
+				return err
+			}
+			old := c.Map[key]
+			if share.Encrypted(old) {
+				// The plaintext is not known here
+				continue
+			}
+			oldType := inferType(old)
+			if declared == "" && oldType != "" && newType != "" &&
+				oldType != newType {
+				changed = append(changed, key)
+				in.warn(fmt.Sprintf("%s in %s changes from %s to %s",
+					key, env, oldType, newType))
+				break
+			}
+			if old != "" && len(value) >= sizeChangeMin &&
+				len(value) > sizeChangeFactor*len(old) {
+				changed = append(changed, key)
+				in.warn(fmt.Sprintf("%s in %s grows from %d to %d bytes",
+					key, env, len(old), len(value)))
+				break
+			}
+		}
+	}
+	if len(changed) > 0 && !in.Force {
+		return ErrTypeChange(changed)
+	}
+	return nil
+}
+
 // refreshConfigByEnv replaces the given key value pairs in the specified env,
-// and returns sorted bytes that can be used to update the config file
-func refreshConfigByEnv(
-	appDir string, prefix string, env string, keys ArgMap, values ArgMap,
+// and returns sorted bytes that can be used to update the config file.
+// Changed is false if the values are already set, or the keys to delete
+// don't exist, and the format is not overridden.
+// Keys and values must be validated before calling this func
+func refreshConfigByEnv(dirs *dirCache,
+	appDir string, env string, keys ArgMap, values ArgMap,
 	del bool, format string) (
-	configPaths []string, b []byte, err error) {
+	configPaths []string, b []byte, changed bool, replaced string, err error) {
 
 	// Read config for the given env from file
-	configPaths, conf, err := newSingleConf(appDir, env)
+	configPaths, conf, err := newCachedConf(dirs, appDir, env)
 	if err != nil {
-		return configPaths, b, err
+		return configPaths, b, changed, replaced, err
 	}
 
-	// Validate input
 	for i, key := range keys {
-		if !strings.HasPrefix(key, prefix) {
-			return configPaths, b, errors.Errorf(
-				"key for env %s must start with prefix %s", env, prefix)
-		}
-
+		value, ok := conf.Map[key]
 		if del {
 			// Delete the key
-			_, ok := conf.Map[key]
 			if ok {
 				delete(conf.Map, key)
+				changed = true
 			}
 
 		} else {
-			if i > len(values)-1 {
-				return configPaths, b, errors.Errorf(
-					"env %s missing value for key %s", env, key)
+			// Set value, encrypted values stay encrypted
+			newValue, unchanged, err := encryptUpdate(key, value, values[i])
+			if err != nil {
+				return configPaths, b, changed, replaced,
+					errors.WithMessagef(err, "env %s", env)
+			}
+			if !unchanged || !ok {
+				conf.Map[key] = newValue
+				changed = true
 			}
-			value := values[i]
-
-			// Set value
-			conf.Map[key] = value
 		}
-
-		conf.refreshKeys()
 	}
+	conf.refreshKeys()
 
 	// Marshal config
 	if len(configPaths) == 0 {
-		return configPaths, b, errors.Errorf("empty config path")
+		return configPaths, b, changed, replaced, errors.Errorf("empty config path")
 	}
 	fileType := filepath.Ext(configPaths[0])
-	var MarshalErr error
 	dotFormat := fmt.Sprintf(".%s", format)
 	if dotFormat == share.FileTypeENV ||
 		dotFormat == share.FileTypeSH ||
 		dotFormat == share.FileTypeJSON ||
 		dotFormat == share.FileTypeYAML {
 		//	Override config file format
+		if fileType != dotFormat {
+			changed = true
+			replaced = configPaths[0]
+		}
 		fileType = dotFormat
 		configPaths[0], err = share.GetConfigFilePath(appDir, env, dotFormat)
 		if err != nil {
-			return configPaths, b, err
+			return configPaths, b, changed, replaced, err
 		}
 	}
+	b, err = marshalConf(conf, fileType)
+	if err != nil {
+		return configPaths, b, changed, replaced, err
+	}
+
+	return configPaths, b, changed, replaced, nil
+}
+
+// MarshalConfig to bytes for the given file type, e.g. share.FileTypeJSON.
+// Keys are sorted, the same as config files written by the command
+func MarshalConfig(configMap map[string]string, fileType string) (
+	b []byte, err error) {
+
+	c := &conf{Map: configMap}
+	c.refreshKeys()
+	return marshalConf(c, fileType)
+}
+
+// marshalConf to bytes for the given file type
+func marshalConf(conf *conf, fileType string) (b []byte, err error) {
 	if fileType == share.FileTypeENV || fileType == share.FileTypeSH {
-		b, MarshalErr = MarshalENV(conf)
+		b, err = MarshalENV(conf)
 	} else if fileType == share.FileTypeJSON {
-		b, MarshalErr = json.MarshalIndent(conf.Map, "", "    ")
+		b, err = json.MarshalIndent(conf.Map, "", "    ")
 	} else if fileType == share.FileTypeYAML {
-		b, MarshalErr = yaml.Marshal(conf.Map)
+		b, err = yaml.Marshal(conf.Map)
+	} else {
+		// E.g. config in directive comments is read-only
+		return b, errors.Errorf("can't write config to %s files", fileType)
 	}
-	if MarshalErr != nil {
-		return configPaths, b, errors.WithStack(MarshalErr)
+	if err != nil {
+		return b, errors.WithStack(err)
 	}
-
-	return configPaths, b, nil
+	return b, nil
 }
 
-func updateConfig(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
-	buf = new(bytes.Buffer)
-	var b []byte
-	var envs []string
-
+// updateEnvs lists the envs to update as per the all and env flags
+func updateEnvs(in *CmdIn) (envs []string, err error) {
 	if in.All {
 		// All config files (non-sample and sample)
-		e, err := getEnvs(in.AppDir, listSamples(false))
+		e, err := listEnvs(in.dirs, in.AppDir, listSamples(false))
 		if err != nil {
-			return buf, files, err
+			return envs, err
 		}
 		envs = append(envs, e...)
-		e, err = getEnvs(in.AppDir, listSamples(true))
+		e, err = listEnvs(in.dirs, in.AppDir, listSamples(true))
 		if err != nil {
-			return buf, files, err
+			return envs, err
 		}
 		envs = append(envs, e...)
 
 	} else if in.Env == "*" {
 		// Wildcard for non-sample config files
-		envs, err = getEnvs(in.AppDir, listSamples(false))
+		envs, err = listEnvs(in.dirs, in.AppDir, listSamples(false))
 		if err != nil {
-			return buf, files, err
+			return envs, err
 		}
 
 	} else if in.Env == "sample.*" {
 		// Wildcard for sample config files
-		envs, err = getEnvs(in.AppDir, listSamples(true))
+		envs, err = listEnvs(in.dirs, in.AppDir, listSamples(true))
 		if err != nil {
-			return buf, files, err
+			return envs, err
 		}
 
 	} else {
-		// Only the config file as per the env flag
-		envs = append(envs, in.Env)
+		// Only the config files as per the env flag, e.g. "prod,stage"
+		envs = append(envs, splitList(ArgMap{in.Env})...)
 	}
 
-	// Refresh config for the listed envs
-	files = make([]File, len(envs))
+	return envs, nil
+}
+
+// eachEnv calls fn for the listed envs concurrently,
+// the number of goroutines is limited to the number of CPUs.
+// The first error is returned after all calls are done
+func eachEnv(envs []string, fn func(i int, env string) error) error {
+	sem := make(chan struct{}, runtime.NumCPU())
+	errs := make([]error, len(envs))
+	var wg sync.WaitGroup
 	for i, env := range envs {
-		var configPaths []string
-		configPaths, b, err = refreshConfigByEnv(
-			in.AppDir, in.Prefix, env, in.Keys, in.Values, in.Del, in.Format)
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, env string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs[i] = fn(i, env)
+		}(i, env)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func updateConfig(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
+	buf = new(bytes.Buffer)
+
+	envs, err := updateEnvs(in)
+	if err != nil {
+		return buf, files, err
+	}
+
+	// Validate input once for all envs
+	err = validateUpdate(in.Prefix, in.Keys, in.Values, in.Del)
+	if err != nil {
+		return buf, files, err
+	}
+	newKeys, err := warnUnknownKeys(in, envs)
+	if err != nil {
+		return buf, files, err
+	}
+	if in.RequireOwner && !in.Del {
+		err = requireOwners(in, newKeys)
+		if err != nil {
+			return buf, files, err
+		}
+	}
+	if !in.Force {
+		for _, env := range envs {
+			if in.manifest.protected(env) {
+				return buf, files, ErrProtectedEnv(env)
+			}
+		}
+	}
+	if !in.Del {
+		err = checkTypeChanges(in, envs)
 		if err != nil {
 			return buf, files, err
 		}
+	}
+
+	// Format flag takes precedence over the manifest
+	format := in.Format
+	if format == "" {
+		format = in.manifest.format()
+	}
+
+	// Refresh config for the listed envs.
+	// Files are read and marshalled concurrently,
+	// the dir listing is shared
+	refreshed := make([]File, len(envs))
+	removed := make([]*File, len(envs))
+	report := updateReport{Files: make([]updateFile, len(envs))}
+	err = eachEnv(envs, func(i int, env string) error {
+		configPaths, b, changed, replaced, err := refreshConfigByEnv(in.dirs,
+			in.AppDir, env, in.Keys, in.Values, in.Del, format)
+		if err != nil {
+			return err
+		}
 		if len(configPaths) == 0 {
-			return buf, files, errors.Errorf("empty config path")
+			return errors.Errorf("empty config path")
 		}
-		files[i] = File{
+		refreshed[i] = File{
 			Path: configPaths[0],
 			Buf:  bytes.NewBuffer(b),
 		}
+		if replaced != "" && in.Format == "" {
+			// Files are converted to the manifest format,
+			// the old file would take precedence when loading config
+			removed[i] = &File{Path: replaced, Del: true}
+		}
+		report.Files[i] = updateFile{
+			Env: env, Path: configPaths[0], Changed: changed}
+		return nil
+	})
+	if err != nil {
+		return buf, files, err
+	}
+
+	// Unchanged files are not written,
+	// so repeated calls don't touch the files
+	files = make([]File, 0, len(envs))
+	for i, file := range refreshed {
+		if !report.Files[i].Changed {
+			if !in.JSON {
+				buf.WriteString(fmt.Sprintf("no change %s\n", file.Path))
+			}
+			continue
+		}
+		report.Changed = true
+		files = append(files, file)
+		if removed[i] != nil {
+			files = append(files, *removed[i])
+		}
+	}
+	if in.JSON {
+		b, err := json.MarshalIndent(report, "", "    ")
+		if err != nil {
+			return buf, files, errors.WithStack(err)
+		}
+		buf.Write(b)
+		buf.WriteString("\n")
 	}
 
 	return buf, files, nil
 }
 
+// updateReport is printed by update config with the JSON flag,
+// automation can check changed to skip commits or notifications
+type updateReport struct {
+	Changed bool         `json:"changed"`
+	Files   []updateFile `json:"files"`
+}
+
+// updateFile is a config file for update config
+type updateFile struct {
+	Env     string `json:"env"`
+	Path    string `json:"path"`
+	Changed bool   `json:"changed"`
+}
+
 // .............................................................................
 
 type envKeys map[string]bool
 
-// setEnv commands to be executed in the shell
-func setEnv(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
-	_, config, err := newConf(confParams{
+// exportConf returns the config to export for env, as per setEnv.
+// Secrets are the keys with secret values, e.g. for preview,
+// and state is the session state read from statePath
+func exportConf(in *CmdIn) (config *conf, secrets map[string]bool,
+	state *session, statePath string, err error) {
+
+	_, config, err = newConf(confParams{
+		dirs:   in.dirs,
 		prefix: in.Prefix,
 		appDir: in.AppDir,
 		env:    in.Env,
 		// extend and merge is not set here, however,
 		// extensions may be listed in the config file
 	})
+	if err != nil {
+		return config, secrets, state, statePath, err
+	}
+
+	// Exporting keys outside the prefix might break the user's shell
+	in.warn(keyCollisions(in.Prefix, in.otherPrefixes(), config)...)
+
+	// Temporary credentials may have expired
+	err = refreshExpired(in, config)
+	if err != nil {
+		return config, secrets, state, statePath, err
+	}
+
+	// Encrypted values are always decrypted
+	secrets = make(map[string]bool)
+	keys, err := decryptConf(config)
+	if err != nil {
+		return config, secrets, state, statePath, err
+	}
+	for _, key := range keys {
+		secrets[key] = true
+	}
+
+	// Secret references are only resolved if explicitly enabled
+	if in.Secrets {
+		keys, err := share.ResolveSecrets(config.Map)
+		if err != nil {
+			return config, secrets, state, statePath, err
+		}
+		for _, key := range keys {
+			secrets[key] = true
+		}
+	}
+
+	// Session state has overrides, and keys exported with the safe flag
+	statePath, err = sessionPath()
+	if err != nil {
+		if in.Safe {
+			return config, secrets, state, statePath, err
+		}
+		// Without a cache dir, e.g. HOME is not set, there are no overrides
+		return config, secrets, &session{Keys: make([]string, 0)}, "", nil
+	}
+	state, err = readSession(statePath)
+	if err != nil {
+		return config, secrets, state, statePath, err
+	}
+	overrides, expired := state.override(in.Env, now())
+	if expired {
+		in.warn(fmt.Sprintf(
+			"session overrides for env %s expired, use the %s flag to refresh them",
+			in.Env, FlagAWS))
+	}
+	if len(overrides) > 0 {
+		for key, value := range overrides {
+			config.Map[key] = value
+			secrets[key] = true
+		}
+		config.refreshKeys()
+	}
+
+	return config, secrets, state, statePath, nil
+}
+
+// setEnv commands to be executed in the shell
+func setEnv(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
+	config, secrets, state, statePath, err := exportConf(in)
 	if err != nil {
 		return buf, files, err
 	}
@@ -717,20 +1632,50 @@ func setEnv(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
 	exportFormat := ExportFormat
 	unsetFormat := UnsetFormat
 
+	escape := func(value string) string { return value }
+
 	// Override default format by specifying os flag
-	if in.OS == "windows" {
+	if in.OS == OSWindows {
 		exportFormat = WindowsExportFormat
 		unsetFormat = WindowsUnsetFormat
-	} else if in.OS == "linux" || in.OS == "darwin" {
+	} else if in.OS == OSPowerShell {
+		exportFormat = PowerShellExportFormat
+		unsetFormat = PowerShellUnsetFormat
+		escape = PowerShellEscape
+	} else if in.OS == OSFish {
+		exportFormat = FishExportFormat
+		unsetFormat = FishUnsetFormat
+		escape = FishEscape
+	} else if in.OS == OSPosix || in.OS == "linux" || in.OS == "darwin" {
 		exportFormat = OtherExportFormat
 		unsetFormat = OtherUnsetFormat
 	}
 
+	changes := make(envChanges, 0)
+
 	// Commands to set env
 	for _, key := range config.Keys {
-		buf.WriteString(fmt.Sprintf(exportFormat, key, config.Map[key]))
+		value := config.Map[key]
+		buf.WriteString(fmt.Sprintf(exportFormat, key, escape(value)))
 		buf.WriteString("\n")
 		envKeys[key] = false
+		secret := secrets[key] || in.manifest.secretKey(key)
+		if old, ok := os.LookupEnv(key); !ok {
+			changes = append(changes, envChange{
+				Change: ChangeAdd, Key: key, New: value, Secret: secret})
+		} else if old != value {
+			changes = append(changes, envChange{
+				Change: ChangeUpdate, Key: key, Old: old, New: value,
+				Secret: secret})
+		}
+	}
+
+	// Override keys may be outside the prefix, e.g. AWS credentials,
+	// unset them if the override is not exported anymore, e.g. it expired
+	for key := range state.overridden(os.LookupEnv) {
+		if _, ok := config.Map[key]; !ok {
+			envKeys[key] = true
+		}
 	}
 
 	// Don't print command to unset APP_DIR
@@ -740,12 +1685,38 @@ func setEnv(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
 		envKeys[appDirKey] = false
 	}
 
-	// Unset env vars not listed in the config file
+	// Unset env vars not listed in the config file,
+	// in safe mode only keys previously exported by configu are unset
+	exported := state.exported()
 	for key, unset := range envKeys {
-		if unset {
+		if unset && (!in.Safe || exported[key]) {
 			buf.WriteString(fmt.Sprintf(unsetFormat, key))
 			buf.WriteString("\n")
+			changes = append(changes, envChange{
+				Change: ChangeUnset, Key: key, Old: os.Getenv(key),
+				Secret: in.manifest.secretKey(key)})
+		}
+	}
+
+	if in.Preview {
+		schema, err := readSchema(in.dirs, in.AppDir)
+		if err != nil {
+			return buf, files, err
 		}
+		changes.setOwners(keyOwners{manifest: in.manifest, schema: schema})
+		changes.sort()
+		in.preview = new(bytes.Buffer)
+		changes.write(in.preview)
+	}
+
+	if in.Safe {
+		// Keep track of keys exported in this session
+		state.Keys = config.Keys
+		file, err := state.file(statePath)
+		if err != nil {
+			return buf, files, err
+		}
+		files = append(files, file)
 	}
 
 	return buf, files, nil
@@ -757,14 +1728,21 @@ func generateCSV(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
 	buf = new(bytes.Buffer)
 
 	_, config, err := newConf(confParams{
+		dirs:   in.dirs,
 		appDir: in.AppDir,
 		env:    in.Env,
 		extend: in.Extend,
 		merge:  in.Merge,
+		parent: in.Parent,
+		file:   in.File,
 	})
 	if err != nil {
 		return buf, files, err
 	}
+	_, err = decryptConf(config)
+	if err != nil {
+		return buf, files, err
+	}
 
 	a := make([]string, len(config.Keys))
 	for i, key := range config.Keys {
@@ -793,14 +1771,21 @@ func encodeBase64(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
 	buf = new(bytes.Buffer)
 
 	_, config, err := newConf(confParams{
+		dirs:   in.dirs,
 		appDir: in.AppDir,
 		env:    in.Env,
 		extend: in.Extend,
 		merge:  in.Merge,
+		parent: in.Parent,
+		file:   in.File,
 	})
 	if err != nil {
 		return buf, files, err
 	}
+	_, err = decryptConf(config)
+	if err != nil {
+		return buf, files, err
+	}
 
 	b, err := json.Marshal(config.Map)
 	if err != nil {
@@ -814,24 +1799,109 @@ func encodeBase64(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
 
 // .............................................................................
 
+// splitList splits comma separated lists, e.g. "APP_FOO,APP_BAR"
+func splitList(values ArgMap) (items []string) {
+	items = make([]string, 0, len(values))
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// printKeys returns the PrintValue key followed by PrintValues
+func (in *CmdIn) printKeys() []string {
+	if in.PrintValue != "" {
+		return splitList(append(ArgMap{in.PrintValue}, in.PrintValues...))
+	}
+	return splitList(in.PrintValues)
+}
+
+// printValue prints the value for a single key as is.
+// Values for multiple keys are printed one per line,
+// or as JSON with the json flag, or with the template flag.
+// Values are converted with the transform flag before printing
 func printValue(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
 	buf = new(bytes.Buffer)
-	key := in.PrintValue
+	keys := in.printKeys()
+	transform, err := newTransform(splitList(in.Transform))
+	if err != nil {
+		return buf, files, err
+	}
 
 	_, config, err := newConf(confParams{
+		dirs:   in.dirs,
 		appDir: in.AppDir,
 		env:    in.Env,
 		extend: in.Extend,
 		merge:  in.Merge,
+		parent: in.Parent,
+		file:   in.File,
 	})
 	if err != nil {
 		return buf, files, err
 	}
+	_, err = decryptConf(config)
+	if err != nil {
+		return buf, files, err
+	}
+
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		value, ok := config.Map[key]
+		if !ok {
+			return buf, files, withSuggestions(
+				errors.Errorf("missing value for key %v", key), key, config.Keys)
+		}
+		values[key], err = transform(value)
+		if err != nil {
+			return buf, files, errors.WithMessagef(err, "key %s", key)
+		}
+	}
+
+	if in.Template != "" {
+		// Template may reference any key, not only the listed keys
+		t, err := template.New(FlagTemplate).
+			Option("missingkey=error").Parse(in.Template)
+		if err != nil {
+			return buf, files, errors.WithStack(err)
+		}
+		b := new(bytes.Buffer)
+		err = t.Execute(b, config.Map)
+		if err != nil {
+			return buf, files, errors.WithStack(err)
+		}
+		// Transforms apply to the template output
+		s, err := transform(b.String())
+		if err != nil {
+			return buf, files, err
+		}
+		buf.WriteString(s)
+		return buf, files, nil
+	}
 
-	if value, ok := config.Map[key]; ok {
-		buf.WriteString(value)
+	if in.JSON {
+		b, err := json.MarshalIndent(values, "", "    ")
+		if err != nil {
+			return buf, files, errors.WithStack(err)
+		}
+		buf.Write(b)
+		buf.WriteString("\n")
 		return buf, files, nil
 	}
 
-	return buf, files, errors.Errorf("missing value for key %v", key)
+	if len(keys) == 1 {
+		// Single value is printed as is, e.g. for APP_FOO=$(configu -get APP_FOO)
+		buf.WriteString(values[keys[0]])
+		return buf, files, nil
+	}
+	for _, key := range keys {
+		buf.WriteString(values[key])
+		buf.WriteString("\n")
+	}
+	return buf, files, nil
 }
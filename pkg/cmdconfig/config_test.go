@@ -355,6 +355,123 @@ func TestNewMergedConf(t *testing.T) {
 	is.Equal(filepath.Base(filepath.Dir(configPaths[1])), ext1)
 }
 
+func TestMergedConfParent(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	env := share.EnvDev
+
+	// Unrelated config outside the project, e.g. in the home dir
+	err = os.WriteFile(
+		filepath.Join(tmp, ".env"), []byte("APP_HOME=foo"), perms)
+	is.NoErr(err)
+
+	// Project root is marked by the .git dir
+	projectPath := filepath.Join(tmp, "project")
+	err = os.MkdirAll(filepath.Join(projectPath, ".git"), dirPerms)
+	is.NoErr(err)
+	extPath := filepath.Join(projectPath, "ext")
+	err = os.Mkdir(extPath, dirPerms)
+	is.NoErr(err)
+	err = os.WriteFile(
+		filepath.Join(extPath, ".env"), []byte("APP_EXT=foo"), perms)
+	is.NoErr(err)
+
+	configPaths, extConf, err := newSingleConf(extPath, env)
+	is.NoErr(err)
+	params := mergeConfParams{
+		extConf:    extConf,
+		configPath: configPaths[0],
+		appDir:     extPath,
+		env:        env,
+	}
+	_, _, err = newMergedConf(params)
+	is.True(errors.Is(err, ErrParentNotFound))
+
+	// Explicit parent dir, relative to the app dir
+	params.parent = filepath.Join("..", "..")
+	configPaths, c, err := newMergedConf(params)
+	is.NoErr(err)
+	is.Equal(filepath.Join(tmp, ".env"), configPaths[0])
+	is.Equal("foo", c.Map["APP_HOME"])
+	is.Equal("foo", c.Map["APP_EXT"])
+
+	// Explicit parent must have a config file
+	params.parent = projectPath
+	_, _, err = newMergedConf(params)
+	is.True(err != nil)
+}
+
+func TestConfCycle(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	env := share.EnvDev
+
+	mainPath := filepath.Join(tmp, ".env")
+	err = os.WriteFile(mainPath, []byte("APP_MAIN=foo"), perms)
+	is.NoErr(err)
+	ext1Path := filepath.Join(tmp, "ext1")
+	err = os.Mkdir(ext1Path, dirPerms)
+	is.NoErr(err)
+	err = os.WriteFile(
+		filepath.Join(ext1Path, ".env"), []byte("APP_EXT1=foo"), perms)
+	is.NoErr(err)
+	// Symlink to the main config dir
+	err = os.Symlink(tmp, filepath.Join(tmp, "link"))
+	is.NoErr(err)
+
+	for _, extend := range [][]string{
+		{"."},
+		{"ext1", "ext1"},
+		{"link"},
+	} {
+		configPaths, mainConf, err := newSingleConf(tmp, env)
+		is.NoErr(err)
+		_, _, err = newExtendedConf(extConfParams{
+			mainConf:    mainConf,
+			configPaths: configPaths,
+			appDir:      tmp,
+			env:         env,
+			extend:      extend,
+		})
+		is.True(errors.Is(err, ErrConfCycle(nil)))
+		// Error includes the chain of paths
+		is.True(strings.Contains(err.Error(), mainPath))
+	}
+
+	// Merge from a symlink to the parent dir
+	linkPath := filepath.Join(tmp, "link")
+	configPaths, extConf, err := newSingleConf(linkPath, env)
+	is.NoErr(err)
+	_, _, err = newMergedConf(mergeConfParams{
+		extConf:    extConf,
+		configPath: configPaths[0],
+		appDir:     linkPath,
+		env:        env,
+	})
+	is.True(errors.Is(err, ErrConfCycle(nil)))
+
+	// Chain depth is limited
+	chain := confChain{}
+	for i := 0; i < maxConfChain; i++ {
+		chain, err = chain.add(filepath.Join(tmp, fmt.Sprintf("%d", i)))
+		is.NoErr(err)
+	}
+	_, err = chain.add(filepath.Join(tmp, "last"))
+	is.True(errors.Is(err, ErrConfChainDepth(nil)))
+}
+
 func TestCompareKeys(t *testing.T) {
 	is := testutil.Setup(t)
 
@@ -391,6 +508,245 @@ func TestCompareKeys(t *testing.T) {
 	is.Equal(1, out.ExitCode)
 }
 
+func TestCompareKeysJSON(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"),
+		[]byte(`{"APP_ONE": "1", "APP_DEV_TOOLS": "true"}`), perms)
+	is.NoErr(err)
+	err = os.WriteFile(filepath.Join(tmp, "config.prod.json"),
+		[]byte(`{"APP_ONE": "1", "APP_BAR": "bar"}`), perms)
+	is.NoErr(err)
+	err = os.WriteFile(filepath.Join(tmp, FileNameTypes),
+		[]byte(`{"APP_DEV_TOOLS": {"type": "bool", "optional": true}}`), perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	in.Compare = EnvProd
+	in.JSON = true
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	report := compareReport{}
+	err = json.Unmarshal(out.Buf.Bytes(), &report)
+	is.NoErr(err)
+	is.Equal(compareReport{
+		Env:     share.EnvDev,
+		Compare: EnvProd,
+		Keys: []compareKey{
+			{Key: "APP_BAR", Missing: share.EnvDev, Severity: SeverityError},
+			{Key: "APP_DEV_TOOLS", Missing: EnvProd, Severity: SeverityWarning},
+		},
+		Errors:   1,
+		Warnings: 1,
+	}, report)
+	is.Equal(1, out.ExitCode)
+
+	// Optional keys don't set the exit code
+	err = os.WriteFile(filepath.Join(tmp, "config.prod.json"),
+		[]byte(`{"APP_ONE": "1"}`), perms)
+	is.NoErr(err)
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(0, out.ExitCode)
+
+	// Without the JSON flag all keys must match
+	in.JSON = false
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal("APP_DEV_TOOLS\n", out.Buf.String())
+	is.Equal(1, out.ExitCode)
+}
+
+func TestCompareKeysDeprecated(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"),
+		[]byte(`{"APP_HOST": "h", "APP_OLD_HOST": "h", "APP_LEGACY": "x"}`),
+		perms)
+	is.NoErr(err)
+	err = os.WriteFile(filepath.Join(tmp, "config.prod.json"),
+		[]byte(`{"APP_HOST": "h", "APP_OLD_HOST": "h", "APP_LEGACY": "x"}`),
+		perms)
+	is.NoErr(err)
+	err = os.WriteFile(filepath.Join(tmp, FileNameTypes), []byte(`{
+		"APP_OLD_HOST": {"replacedBy": "APP_HOST"},
+		"APP_LEGACY": {"deprecated": true}
+	}`), perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	in.Compare = EnvProd
+
+	// Deprecated keys are warnings
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal("", out.Buf.String())
+	is.Equal(0, out.ExitCode)
+	is.Equal([]string{
+		"APP_LEGACY is deprecated in dev",
+		"APP_LEGACY is deprecated in prod",
+		"APP_OLD_HOST is deprecated in dev, use APP_HOST",
+		"APP_OLD_HOST is deprecated in prod, use APP_HOST",
+	}, out.Warnings)
+
+	in.JSON = true
+	out, err = Cmd(in)
+	is.NoErr(err)
+	report := compareReport{}
+	err = json.Unmarshal(out.Buf.Bytes(), &report)
+	is.NoErr(err)
+	is.Equal(0, len(report.Keys))
+	is.Equal(4, len(report.Deprecated))
+	is.Equal(compareKey{Key: "APP_OLD_HOST", Present: share.EnvDev,
+		Severity: SeverityWarning, ReplacedBy: "APP_HOST"},
+		report.Deprecated[2])
+	is.Equal(4, report.Warnings)
+	is.Equal(0, out.ExitCode)
+
+	// Ignored keys are not reported
+	in.Ignore = ArgMap{"APP_LEGACY"}
+	out, err = Cmd(in)
+	is.NoErr(err)
+	report = compareReport{}
+	err = json.Unmarshal(out.Buf.Bytes(), &report)
+	is.NoErr(err)
+	is.Equal(2, len(report.Deprecated))
+}
+
+func TestCompareKeysIgnore(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"), []byte(
+		`{"APP_ONE": "1", "APP_DEV_TOOLS_A": "a", "APP_DEV_TOOLS_B": "b"}`), perms)
+	is.NoErr(err)
+	err = os.WriteFile(filepath.Join(tmp, "config.prod.json"),
+		[]byte(`{"APP_ONE": "1", "APP_CDN": "cdn"}`), perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	in.Compare = EnvProd
+	in.Ignore = ArgMap{"APP_DEV_TOOLS_*"}
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal("APP_CDN\n", out.Buf.String())
+	is.Equal(1, out.ExitCode)
+
+	// Manifest globs are combined with the flags
+	in.manifest = &Manifest{Ignore: []string{"APP_CDN"}}
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal("", out.Buf.String())
+	is.Equal(0, out.ExitCode)
+
+	// Compare with the sample for env
+	err = os.WriteFile(filepath.Join(tmp, "sample.config.dev.json"),
+		[]byte(`{"APP_ONE": "", "APP_TWO": ""}`), perms)
+	is.NoErr(err)
+	in.Ignore = ArgMap{"APP_DEV_TOOLS_*"}
+	in.Compare = share.Sample
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal("APP_TWO missing in dev\n", out.Buf.String())
+	is.Equal(1, out.ExitCode)
+	in.Env = "sample.dev"
+	_, err = Cmd(in)
+	is.True(err != nil)
+
+	// Malformed glob
+	t.Setenv("APP_DIR", tmp)
+	in.Ignore = ArgMap{"APP_["}
+	is.True(in.Valid() != nil)
+}
+
+func TestCompareValues(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"), []byte(`{
+		"APP_DB_PASSWORD": "dev",
+		"APP_HOST": "localhost",
+		"APP_KEY": "a",
+		"APP_ONE": "1",
+		"APP_DEV": "x"
+	}`), perms)
+	is.NoErr(err)
+	err = os.WriteFile(filepath.Join(tmp, "config.prod.json"), []byte(`{
+		"APP_DB_PASSWORD": "prod",
+		"APP_HOST": "example.com",
+		"APP_KEY": "b",
+		"APP_ONE": "1"
+	}`), perms)
+	is.NoErr(err)
+	err = os.WriteFile(filepath.Join(tmp, FileNameTypes),
+		[]byte(`{"APP_KEY": {"type": "string", "secret": true}}`), perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	in.Compare = EnvProd
+	in.CompareValues = true
+
+	// Secrets are redacted, different values don't set the exit code
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(`APP_DEV
+
+KEY              dev         prod
+APP_DB_PASSWORD  <redacted>  <redacted>
+APP_HOST         localhost   example.com
+APP_KEY          <redacted>  <redacted>
+`, out.Buf.String())
+	is.Equal(1, out.ExitCode)
+
+	in.Ignore = ArgMap{"APP_DEV", "APP_DB_*"}
+	in.JSON = true
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(0, out.ExitCode)
+	report := compareReport{}
+	is.NoErr(json.Unmarshal(out.Buf.Bytes(), &report))
+	is.Equal([]compareValue{
+		{Key: "APP_HOST", Value: "localhost", Compare: "example.com"},
+		{Key: "APP_KEY", Value: redacted, Compare: redacted},
+	}, report.Values)
+}
+
 func TestUpdateConfigSingleJSON(t *testing.T) {
 	is := testutil.Setup(t)
 
@@ -428,6 +784,172 @@ func TestUpdateConfigSingleJSON(t *testing.T) {
 	// 2021-08-15 Use keys exactly as per config file
 	// Xrequire.Empty(t, m["APP_bar"], "keys must be uppercase")
 	is.Equal("update 2", m["APP_bar"])
+	// Keys similar to existing keys are likely mistyped
+	is.Equal([]string{"APP_bar is a new key, did you mean APP_BAR?"},
+		out.Warnings)
+
+	in.Keys = ArgMap{"APP_BAZ"}
+	in.Values = ArgMap{""}
+	in.Del = true
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal([]string{"APP_BAZ not found, did you mean APP_BAR?"},
+		out.Warnings)
+}
+
+func TestUpdateConfigTypeChange(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"),
+		[]byte(`{"APP_PORT": "8080", "APP_TIMEOUT": "5s",
+			"APP_VERSION": "1"}`), perms)
+	is.NoErr(err)
+	err = os.WriteFile(filepath.Join(tmp, FileNameTypes),
+		[]byte(`{"APP_TIMEOUT": "duration", "APP_VERSION": "string"}`), perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	in.Keys = ArgMap{"APP_PORT"}
+	in.Values = ArgMap{"9090"}
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(0, len(out.Warnings))
+
+	// Inferred type changes
+	in.Values = ArgMap{"https://example.com"}
+	_, err = Cmd(in)
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "APP_PORT"))
+	in.Force = true
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal([]string{"APP_PORT in dev changes from int to url"}, out.Warnings)
+
+	// Declared type
+	in.Force = false
+	in.Keys = ArgMap{"APP_TIMEOUT"}
+	in.Values = ArgMap{"10"}
+	_, err = Cmd(in)
+	is.True(err != nil)
+	in.Values = ArgMap{"10m"}
+	_, err = Cmd(in)
+	is.NoErr(err)
+
+	// Declared strings are not inferred
+	in.Keys = ArgMap{"APP_VERSION"}
+	in.Values = ArgMap{"v1.2.3"}
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(0, len(out.Warnings))
+
+	// Size changes
+	in.Keys = ArgMap{"APP_PORT"}
+	in.Values = ArgMap{strings.Repeat("x", sizeChangeMin)}
+	_, err = Cmd(in)
+	is.True(err != nil)
+	in.Force = true
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal([]string{"APP_PORT in dev changes from int to string"},
+		out.Warnings)
+	in.Keys = ArgMap{"APP_TIMEOUT"}
+	in.Values = ArgMap{strings.Repeat("1s", sizeChangeMin/2)}
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal([]string{"APP_TIMEOUT in dev grows from 2 to 256 bytes"},
+		out.Warnings)
+	in.Force = false
+
+	// New keys and deletes are not checked
+	in.Keys = ArgMap{"APP_NEW"}
+	in.Values = ArgMap{"x"}
+	_, err = Cmd(in)
+	is.NoErr(err)
+	in.Keys = ArgMap{"APP_PORT"}
+	in.Del = true
+	_, err = Cmd(in)
+	is.NoErr(err)
+}
+
+func TestUpdateConfigNoChange(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	configPath := filepath.Join(tmp, "config.dev.json")
+	prodPath := filepath.Join(tmp, "config.prod.json")
+	// Not formatted, unchanged files must not be rewritten
+	err = os.WriteFile(configPath, []byte(`{"APP_FOO": "foo"}`), perms)
+	is.NoErr(err)
+	err = os.WriteFile(prodPath, []byte(`{"APP_FOO": "bar"}`), perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = "dev,prod"
+	in.Keys = ArgMap{"APP_FOO"}
+	in.Values = ArgMap{"foo"}
+	in.Stdout = new(bytes.Buffer)
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(1, len(out.Files))
+	is.Equal(prodPath, out.Files[0].Path)
+	is.Equal(fmt.Sprintf("no change %s\n", configPath), out.Buf.String())
+	exitCode, err := in.Process(out)
+	is.NoErr(err)
+	is.Equal(0, exitCode)
+
+	// Repeated calls don't change the files
+	in.Stdout = new(bytes.Buffer)
+	in.JSON = true
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(0, len(out.Files))
+	exitCode, err = in.Process(out)
+	is.NoErr(err)
+	is.Equal(0, exitCode)
+	report := updateReport{}
+	is.NoErr(json.Unmarshal(in.Stdout.(*bytes.Buffer).Bytes(), &report))
+	is.True(!report.Changed)
+	is.Equal([]updateFile{
+		{Env: share.EnvDev, Path: configPath},
+		{Env: "prod", Path: prodPath},
+	}, report.Files)
+	b, err := os.ReadFile(configPath)
+	is.NoErr(err)
+	is.Equal(`{"APP_FOO": "foo"}`, string(b))
+
+	// Deleting a missing key
+	in.Keys = ArgMap{"APP_MISSING"}
+	in.Del = true
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(0, len(out.Files))
+
+	in.Keys = ArgMap{"APP_FOO"}
+	in.Env = "prod"
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(1, len(out.Files))
+	report = updateReport{}
+	is.NoErr(json.Unmarshal(out.Buf.Bytes(), &report))
+	is.True(report.Changed)
 }
 
 func TestUpdateConfigMulti(t *testing.T) {
@@ -625,8 +1147,8 @@ func TestSetEnv(t *testing.T) {
 
 	if runtime.GOOS == "windows" {
 		is.True(strings.Contains(s, "set APP_BAR=bar"))
-		is.True(strings.Contains(s, "set APP_FOO=\"\""))
-		is.True(!strings.Contains(s, "set APP_DIR=\"\""))
+		is.True(strings.Contains(s, "set APP_FOO=\n"))
+		is.True(!strings.Contains(s, "set APP_DIR=\n"))
 
 	} else {
 		is.True(strings.Contains(s, "export APP_BAR=bar"))
@@ -746,6 +1268,63 @@ func TestGet(t *testing.T) {
 	is.Equal(0, out.ExitCode)
 	actual = out.Buf.String()
 	is.Equal("bar", actual)
+
+	// Mistyped key
+	in.PrintValue = "APP_FO"
+	_, err = Cmd(in)
+	is.True(err != nil)
+	is.Equal("missing value for key APP_FO, did you mean APP_FOO?", err.Error())
+
+	// Multiple keys, repeated or comma separated
+	in.PrintValue = ""
+	in.PrintValues = ArgMap{"APP_FOO", "APP_BAR"}
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal("foo\nbar\n", out.Buf.String())
+	in.PrintValues = ArgMap{"APP_BAR, APP_FOO"}
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal("bar\nfoo\n", out.Buf.String())
+
+	in.JSON = true
+	out, err = Cmd(in)
+	is.NoErr(err)
+	m := make(map[string]string)
+	err = json.Unmarshal(out.Buf.Bytes(), &m)
+	is.NoErr(err)
+	is.Equal(map[string]string{"APP_FOO": "foo", "APP_BAR": "bar"}, m)
+	in.JSON = false
+
+	// PrintValue is printed before PrintValues
+	in.PrintValue = "APP_FOO"
+	in.PrintValues = ArgMap{"APP_BAR"}
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal("foo\nbar\n", out.Buf.String())
+
+	// Template
+	in.PrintValue = ""
+	in.PrintValues = ArgMap{}
+	in.Template = "{{.APP_FOO}}:{{.APP_BAR}}"
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdGet, out.Cmd)
+	is.Equal("foo:bar", out.Buf.String())
+	in.Template = "{{.APP_BAZ}}"
+	_, err = Cmd(in)
+	is.True(err != nil)
+
+	// Transforms apply to each value, or the template output
+	in.Template = "{{.APP_FOO}}:{{.APP_BAR}}"
+	in.Transform = ArgMap{TransformBase64}
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal("Zm9vOmJhcg==", out.Buf.String())
+	in.Template = ""
+	in.PrintValues = ArgMap{"APP_FOO,APP_BAR"}
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal("Zm9v\nYmFy\n", out.Buf.String())
 }
 
 func TestTypeConversionFns(t *testing.T) {
@@ -803,6 +1382,43 @@ func TestTypeConversionFns(t *testing.T) {
 	is.True(err != nil)
 	is.Equal(int64(0), i)
 
+	// port
+	c.SetBar("8080")
+	p, err := c.FnBar().Port()
+	is.NoErr(err)
+	is.Equal(8080, p)
+	for _, v := range []string{"0", "65536", "-1", "xxx"} {
+		c.SetBar(v)
+		_, err = c.FnBar().Port()
+		is.True(err != nil)
+	}
+
+	// lists
+	c.SetBar(" https://a.example.com, https://b.example.com ,, ")
+	is.Equal([]string{"https://a.example.com", "https://b.example.com"},
+		c.FnBar().Strings(","))
+	c.SetBar("")
+	is.Equal([]string{}, c.FnBar().Strings(","))
+	c.SetBar("1; 2;3")
+	ints, err := c.FnBar().Int64s(";")
+	is.NoErr(err)
+	is.Equal([]int64{1, 2, 3}, ints)
+	c.SetBar("1,x")
+	_, err = c.FnBar().Int64s(",")
+	is.True(err != nil)
+
+	// url
+	c.SetBar("https://example.com:8443/api")
+	u, err := c.FnBar().URL()
+	is.NoErr(err)
+	is.Equal("example.com:8443", u.Host)
+	is.Equal("/api", u.Path)
+	for _, v := range []string{"example.com", "/api", "://x"} {
+		c.SetBar(v)
+		_, err = c.FnBar().URL()
+		is.True(err != nil)
+	}
+
 	// string
 	s := "This is a string"
 	c.SetBar(s)
@@ -905,3 +1521,229 @@ func TestLoadMap(t *testing.T) {
 	is.Equal(conf.Bar(), t.Name())
 	is.Equal(os.Getenv(key), t.Name())
 }
+
+func TestTransformFns(t *testing.T) {
+	is := testutil.Setup(t)
+
+	c := config.New()
+
+	c.SetBar("  FooBar ")
+	is.Equal("foobar", c.FnBar().Trim().Lower().String())
+	is.Equal("FOOBAR", c.FnBar().Trim().Upper().String())
+	is.Equal("Bar", c.FnBar().Trim().TrimPrefix("Foo").String())
+	is.Equal("Foo", c.FnBar().Trim().TrimSuffix("Bar").String())
+	is.Equal("  FooBaz ", c.FnBar().Replace("Bar", "Baz").String())
+	is.Equal("  FooBar ", c.FnBar().Default("x").String())
+	is.Equal("  FooBar ", c.Bar()) // Transforms must not change config
+
+	c.SetBar("   ")
+	is.Equal("x", c.FnBar().Trim().Default("x").String())
+
+	// Type conversion uses the output of the last transform
+	c.SetBar(" TRUE ")
+	b, err := c.FnBar().Trim().Bool()
+	is.NoErr(err)
+	is.True(b)
+	c.SetBar("")
+	i, err := c.FnBar().Default("123").Int64()
+	is.NoErr(err)
+	is.Equal(int64(123), i)
+}
+
+func TestTryMustFns(t *testing.T) {
+	is := testutil.Setup(t)
+
+	c := config.New()
+
+	c.SetBar("true")
+	b, ok := c.FnBar().TryBool()
+	is.True(ok)
+	is.True(b)
+	is.True(c.FnBar().MustBool())
+
+	c.SetBar("123")
+	i, ok := c.FnBar().TryInt64()
+	is.True(ok)
+	is.Equal(int64(123), i)
+	is.Equal(int64(123), c.FnBar().MustInt64())
+	f, ok := c.FnBar().TryFloat64()
+	is.True(ok)
+	is.Equal(float64(123), f)
+	is.Equal(float64(123), c.FnBar().MustFloat64())
+
+	c.SetBar("xxx")
+	_, ok = c.FnBar().TryBool()
+	is.True(!ok)
+	_, ok = c.FnBar().TryInt64()
+	is.True(!ok)
+	_, ok = c.FnBar().TryFloat64()
+	is.True(!ok)
+	c.SetBar("")
+	is.Equal(int64(1), c.FnBar().Default("1").MustInt64())
+	c.SetBar("xxx")
+
+	mustPanic := func(f func()) (msg string) {
+		defer func() {
+			msg = fmt.Sprint(recover())
+		}()
+		f()
+		return ""
+	}
+	msg := mustPanic(func() { c.FnBar().MustBool() })
+	is.True(strings.Contains(msg, "APP_BAR")) // Panic must name the key
+	msg = mustPanic(func() { c.FnBar().MustInt64() })
+	is.True(strings.Contains(msg, "APP_BAR"))
+	msg = mustPanic(func() { c.FnBar().MustFloat64() })
+	is.True(strings.Contains(msg, "APP_BAR"))
+}
+
+func TestSetEnvPowerShell(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	env := share.EnvDev
+
+	err = os.WriteFile(
+		filepath.Join(tmp, fmt.Sprintf("config.%v.json", env)),
+		[]byte(`{"APP_BAR": "bar \"$baz\" `+"`"+`"}`),
+		perms)
+	is.NoErr(err)
+
+	t.Setenv("APP_FOO", "foo")
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = env
+	in.OS = OSPowerShell
+
+	buf, _, err := setEnv(in)
+	is.NoErr(err)
+	s := buf.String()
+
+	is.True(strings.Contains(s, "$env:APP_BAR = \"bar `\"`$baz`\" ``\""))
+	is.True(strings.Contains(s, "Remove-Item Env:APP_FOO"))
+	is.True(!strings.Contains(s, "Remove-Item Env:APP_DIR"))
+}
+
+func TestSetEnvFish(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	env := share.EnvDev
+
+	err = os.WriteFile(
+		filepath.Join(tmp, fmt.Sprintf("config.%v.json", env)),
+		[]byte(`{"APP_BAR": "it's $baz"}`),
+		perms)
+	is.NoErr(err)
+
+	t.Setenv("APP_FOO", "foo")
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = env
+	in.OS = OSFish
+
+	buf, _, err := setEnv(in)
+	is.NoErr(err)
+	s := buf.String()
+
+	is.True(strings.Contains(s, `set -gx APP_BAR 'it\'s $baz'`))
+	is.True(strings.Contains(s, "set -e APP_FOO"))
+}
+
+func TestValidateUpdate(t *testing.T) {
+	is := testutil.Setup(t)
+
+	err := validateUpdate("APP_", ArgMap{"APP_FOO"}, ArgMap{"foo"}, false)
+	is.NoErr(err)
+	err = validateUpdate("APP_", ArgMap{"FOO"}, ArgMap{"foo"}, false)
+	is.True(err != nil) // Key must start with prefix
+	err = validateUpdate("APP_", ArgMap{"APP_FOO", "APP_BAR"}, ArgMap{"foo"}, false)
+	is.True(err != nil) // Missing value
+	err = validateUpdate("APP_", ArgMap{"APP_FOO", "APP_BAR"}, ArgMap{}, true)
+	is.NoErr(err) // Values not required to delete keys
+}
+
+func TestFileFlag(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+	appDir := filepath.Join(tmp, "app")
+	err = os.Mkdir(appDir, dirPerms)
+	is.NoErr(err)
+	err = os.WriteFile(filepath.Join(appDir, "sample.config.prod.json"),
+		[]byte(`{"APP_FOO": "", "APP_BAR": ""}`), perms)
+	is.NoErr(err)
+	// Config artifact outside APP_DIR, e.g. pulled from a server
+	file := filepath.Join(tmp, "server.env")
+	err = os.WriteFile(file, []byte("APP_FOO=foo\nAPP_BAZ=baz\n"), perms)
+	is.NoErr(err)
+
+	// APP_DIR is optional
+	t.Setenv("APP_DIR", "")
+	in := &CmdIn{Prefix: "APP_", Env: EnvProd, File: file}
+	in.PrintValue = "APP_FOO"
+	is.NoErr(in.Valid())
+	is.Equal(tmp, in.AppDir)
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal("foo", out.Buf.String())
+
+	in.PrintValue = ""
+	in.CSV = true
+	in.Sep = ","
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal("APP_BAZ=baz,APP_FOO=foo", out.Buf.String())
+
+	in.CSV = false
+	in.ListKeys = true
+	in.KeysDetail = true
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.True(strings.Contains(out.Buf.String(), "prod  APP_BAZ  string  server.env\n"))
+
+	// Validate against the sample for env in APP_DIR
+	t.Setenv("APP_DIR", appDir)
+	in = &CmdIn{Prefix: "APP_", Env: EnvProd, File: file, Validate: true}
+	is.NoErr(in.Valid())
+	is.Equal(appDir, in.AppDir)
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(1, out.ExitCode)
+	is.Equal(strings.Join([]string{
+		"prod APP_BAR missing: required key is not set",
+		"prod APP_BAZ unknown: not in the sample or schema",
+	}, "\n")+"\n", out.Buf.String())
+
+	// Only read commands for a single env are supported
+	in = &CmdIn{Prefix: "APP_", Env: EnvProd, File: file}
+	in.Keys = ArgMap{"APP_FOO"}
+	in.Values = ArgMap{"bar"}
+	is.True(in.Valid() != nil)
+	in = &CmdIn{Prefix: "APP_", Env: "*", File: file, ListKeys: true}
+	is.True(in.Valid() != nil)
+	in = &CmdIn{Prefix: "APP_", Env: EnvProd, File: file, ListKeys: true,
+		Merge: true}
+	is.True(in.Valid() != nil)
+	in = &CmdIn{Prefix: "APP_", Env: EnvProd, ListKeys: true,
+		File: filepath.Join(tmp, "server.txt")}
+	is.True(in.Valid() != nil)
+}
@@ -0,0 +1,369 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// keyLineRegexp matches the first line of a key in env, sh, and yaml files
+var keyLineRegexp = regexp.MustCompile(
+	`^\s*(?:export\s+)?([_a-zA-Z0-9]+)\s*[=:]`)
+
+// comments read from env, sh, and yaml files, see readComments
+type comments struct {
+	// Header are the comment lines at the top of the file,
+	// detached from the first key by a blank line
+	Header []string
+	// Above are the comment lines above each key. Comment blocks detached
+	// from the key by blank lines are kept, with a single blank line between
+	Above map[string][]string
+	// Inline are the yaml comments on the same line as the key
+	Inline map[string]string
+	// Trailing are the comment lines after the last key
+	Trailing []string
+}
+
+// empty returns true if there are no comments
+func (c comments) empty() bool {
+	return len(c.Header) == 0 && len(c.Above) == 0 &&
+		len(c.Inline) == 0 && len(c.Trailing) == 0
+}
+
+// trimBlank removes leading and trailing blank lines
+func trimBlank(lines []string) []string {
+	for len(lines) > 0 && lines[0] == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// yamlLineComment returns the comment at the end of a yaml key line,
+// i.e. a hash preceded by white space, after the value if it is quoted
+func yamlLineComment(line string) string {
+	_, value, _ := strings.Cut(line, ":")
+	offset := len(line) - len(value)
+	trimmed := strings.TrimLeft(value, " \t")
+	offset += len(value) - len(trimmed)
+	if strings.HasPrefix(trimmed, "'") || strings.HasPrefix(trimmed, "\"") {
+		// Escaped quotes are skipped as a pair, e.g. 'it''s' or "a\"b"
+		quote := trimmed[0]
+		i := 1
+		for ; i < len(trimmed); i++ {
+			if quote == '"' && trimmed[i] == '\\' {
+				i++
+			} else if trimmed[i] == quote {
+				if quote == '\'' && i+1 < len(trimmed) && trimmed[i+1] == quote {
+					i++
+					continue
+				}
+				break
+			}
+		}
+		offset += i
+	}
+	for i := offset; i < len(line); i++ {
+		if line[i] == '#' && (line[i-1] == ' ' || line[i-1] == '\t') {
+			return line[i:]
+		}
+	}
+	return ""
+}
+
+// readComments returns the comments in b.
+// JSON does not support comments
+func readComments(fileType string, b []byte) (c comments) {
+	c.Above = make(map[string][]string)
+	c.Inline = make(map[string]string)
+	if fileType == share.FileTypeJSON {
+		return c
+	}
+	lines := make([]string, 0)
+	first := true
+	for _, line := range strings.Split(string(b), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "#") {
+			lines = append(lines, trimmed)
+			continue
+		}
+		if trimmed == "" {
+			if len(lines) == 0 || lines[len(lines)-1] != "" {
+				lines = append(lines, "")
+			}
+			continue
+		}
+		if matches := keyLineRegexp.FindStringSubmatch(line); matches != nil {
+			above := lines
+			if first {
+				// The header stays at the top when the keys are sorted
+				for i := len(above) - 1; i >= 0; i-- {
+					if above[i] == "" {
+						c.Header = trimBlank(above[:i])
+						above = above[i+1:]
+						break
+					}
+				}
+				first = false
+			}
+			if above = trimBlank(above); len(above) > 0 {
+				c.Above[matches[1]] = above
+			}
+			if fileType == share.FileTypeYAML {
+				if comment := yamlLineComment(line); comment != "" {
+					c.Inline[matches[1]] = comment
+				}
+			}
+		}
+		lines = make([]string, 0)
+	}
+	// Keep the blank line between the last key and trailing comments
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(trimBlank(lines)) > 0 {
+		c.Trailing = lines
+	}
+	return c
+}
+
+// envSpecialChars must be quoted in env and sh files,
+// otherwise the shell expands or splits the value
+const envSpecialChars = " \t#'\"$`\\!*?[]{}()<>|&;~"
+
+// quoteENV returns the value in single quotes if required for env and
+// sh files, so the shell doesn't expand it. Single quotes in the value are
+// closed, escaped, and reopened. Values are read with share.UnmarshalENV,
+// that unescapes single quoted values, but values must be on a single line
+func quoteENV(key, value string) (string, error) {
+	if strings.ContainsAny(value, "\r\n") {
+		return value, errors.Errorf(
+			"value for key %s must not contain newlines", key)
+	}
+	if value == "" || strings.ContainsAny(value, envSpecialChars) {
+		return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'", nil
+	}
+	return value, nil
+}
+
+// marshalComments is the same as marshalConf,
+// but keeps comments if the file type supports it, and quotes env values
+func marshalComments(c *conf, fileType string, comments comments) (
+	b []byte, err error) {
+
+	if fileType == share.FileTypeJSON {
+		return marshalConf(c, fileType)
+	}
+	buf := new(bytes.Buffer)
+	if len(comments.Header) > 0 {
+		for _, comment := range comments.Header {
+			buf.WriteString(comment)
+			buf.WriteString("\n")
+		}
+		buf.WriteString("\n")
+	}
+	// Assuming c.Keys is already sorted
+	for _, key := range c.Keys {
+		above := comments.Above[key]
+		inline := comments.Inline[key]
+		value := c.Map[key]
+		var line []byte
+		if fileType == share.FileTypeYAML {
+			line, err = yaml.Marshal(map[string]string{key: value})
+			if err != nil {
+				return b, errors.WithStack(err)
+			}
+		} else {
+			value, err = quoteENV(key, value)
+			if err != nil {
+				return b, err
+			}
+			line = []byte(fmt.Sprintf("export %s=%s\n", key, value))
+		}
+		if inline != "" {
+			singleLine := bytes.Count(line, []byte("\n")) == 1
+			if fileType == share.FileTypeYAML && singleLine {
+				line = append(bytes.TrimSuffix(line, []byte("\n")),
+					[]byte(" "+inline+"\n")...)
+			} else {
+				// The hash would be part of env values
+				above = append(append([]string{}, above...), inline)
+			}
+		}
+		for _, comment := range above {
+			buf.WriteString(comment)
+			buf.WriteString("\n")
+		}
+		buf.Write(line)
+	}
+	for _, comment := range comments.Trailing {
+		buf.WriteString(comment)
+		buf.WriteString("\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// convertFileType returns the file type for format, e.g. "yaml" or ".yaml"
+func convertFileType(format string) (string, error) {
+	fileType := fmt.Sprintf(".%s", strings.TrimPrefix(format, "."))
+	for _, t := range share.LoadPrecedence() {
+		if t == fileType {
+			return fileType, nil
+		}
+	}
+	return "", errors.Errorf("invalid %s %s, expected one of %s",
+		FlagTo, format, strings.Join(share.LoadPrecedence(), ", "))
+}
+
+// convertPath returns the config file path for env in fileType
+func convertPath(appDir, env, fileType string) (string, error) {
+	if fileType != share.FileTypeENV {
+		return share.GetConfigFilePath(appDir, env, fileType)
+	}
+	// The env file type is only loaded for dev, see share.GetConfigFilePaths
+	if env != share.EnvDev {
+		return "", errors.Errorf(
+			"env %s can't be converted to %s, use %s instead",
+			env, share.FileTypeENV, share.FileTypeSH)
+	}
+	return share.GetConfigFilePath(appDir, "", fileType)
+}
+
+// convertFile returns the file at from converted to the file type of to
+func convertFile(from, to string) (file File, comments bool, err error) {
+	b, err := os.ReadFile(from)
+	if err != nil {
+		return file, false, errors.WithStack(err)
+	}
+	configMap, err := share.UnmarshalConfig(from, b)
+	if err != nil {
+		return file, false, errors.WithMessagef(err, "invalid %s", from)
+	}
+	c := &conf{Map: configMap}
+	c.refreshKeys()
+	fromComments := readComments(filepath.Ext(from), b)
+	b, err = marshalComments(c, filepath.Ext(to), fromComments)
+	if err != nil {
+		return file, false, errors.WithMessagef(err, "convert %s", from)
+	}
+	return File{Path: to, Buf: bytes.NewBuffer(b)}, !fromComments.empty(), nil
+}
+
+// convertConfig converts config files between formats.
+// With the from flag, the file is converted to the file at the to flag path.
+// Otherwise the config files for the envs, as per the all and env flags,
+// are converted to the to flag format, and the old files are deleted.
+// Comments are kept if the format supports it
+func convertConfig(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
+	buf = new(bytes.Buffer)
+
+	type conversion struct {
+		env  string
+		from string
+		to   string
+	}
+	conversions := make([]conversion, 0)
+
+	if in.From != "" {
+		to, err := filepath.Abs(in.To)
+		if err != nil {
+			return buf, files, errors.WithStack(err)
+		}
+		if _, err := convertFileType(filepath.Ext(to)); err != nil {
+			return buf, files, err
+		}
+		conversions = append(conversions, conversion{from: in.From, to: to})
+
+	} else {
+		fileType, err := convertFileType(in.To)
+		if err != nil {
+			return buf, files, err
+		}
+		envs, err := updateEnvs(in)
+		if err != nil {
+			return buf, files, err
+		}
+		for _, env := range envs {
+			if !in.Force && in.manifest.protected(env) {
+				return buf, files, ErrProtectedEnv(env)
+			}
+			from, _, err := readConfigFile(in.dirs, in.AppDir, env)
+			if err != nil {
+				return buf, files, err
+			}
+			to, err := convertPath(in.AppDir, env, fileType)
+			if err != nil {
+				return buf, files, err
+			}
+			if filepath.Ext(from) == fileType {
+				// Already in the format
+				continue
+			}
+			conversions = append(conversions,
+				conversion{env: env, from: from, to: to})
+		}
+	}
+
+	for _, c := range conversions {
+		if c.from == c.to {
+			continue
+		}
+		exists, err := in.dirs.exists(c.to)
+		if err != nil {
+			return buf, files, err
+		}
+		if exists && !in.Force {
+			return buf, files, errors.Errorf(
+				"%s exists, use the %s flag to overwrite it", c.to, FlagForce)
+		}
+		file, comments, err := convertFile(c.from, c.to)
+		if err != nil {
+			return buf, files, err
+		}
+		if comments && filepath.Ext(c.to) == share.FileTypeJSON {
+			in.warn(fmt.Sprintf("comments in %s are not supported by %s",
+				c.from, share.FileTypeJSON))
+		}
+		files = append(files, file)
+		if c.env != "" {
+			// The old file would take precedence, or be ignored,
+			// depending on the format
+			files = append(files, File{Path: c.from, Del: true})
+		}
+		buf.WriteString(fmt.Sprintf("convert %s → %s\n", c.from, c.to))
+	}
+
+	return buf, files, nil
+}
+
+// validConvert checks the from and to flags
+func (in *CmdIn) validConvert() error {
+	if in.To == "" {
+		return errors.Errorf("%s requires %s", FlagFrom, FlagTo)
+	}
+	if in.From == "" {
+		return nil
+	}
+	if in.All || strings.ContainsAny(in.Env, "*,") {
+		return errors.Errorf("%s and %s are exclusive", FlagFrom, FlagAll)
+	}
+	from, err := filepath.Abs(in.From)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := convertFileType(filepath.Ext(from)); err != nil {
+		return errors.Errorf("invalid %s %s, expected extension %s",
+			FlagFrom, in.From, strings.Join(share.LoadPrecedence(), ", "))
+	}
+	in.From = from
+	return nil
+}
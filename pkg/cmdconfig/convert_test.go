@@ -0,0 +1,131 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestQuoteENV(t *testing.T) {
+	is := testutil.Setup(t)
+
+	for value, expected := range map[string]string{
+		"foo":               "foo",
+		"":                  `''`,
+		"foo bar":           `'foo bar'`,
+		" foo":              `' foo'`,
+		"foo#bar":           `'foo#bar'`,
+		`"foo"`:             `'"foo"'`,
+		"it's":              `'it'\''s'`,
+		"$HOME":             `'$HOME'`,
+		"`id`":              "'`id`'",
+		"http://x/y=":       "http://x/y=",
+		"http://x?y=$(id)'": `'http://x?y=$(id)'\'''`,
+	} {
+		quoted, err := quoteENV("APP_FOO", value)
+		is.NoErr(err)
+		is.Equal(expected, quoted)
+		// Values must survive the round trip
+		m, err := share.UnmarshalENV(
+			[]byte("export APP_FOO=" + quoted + "\n"))
+		is.NoErr(err)
+		is.Equal(value, m["APP_FOO"])
+	}
+
+	_, err := quoteENV("APP_FOO", "foo\nbar")
+	is.True(err != nil)
+}
+
+func TestConvertConfig(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	prodPath := filepath.Join(tmp, ".env.prod.sh")
+	err = os.WriteFile(prodPath, []byte(`# Database
+# Primary only
+export APP_DB=postgres://db
+
+export APP_NAME="foo bar"
+`), perms)
+	is.NoErr(err)
+	devPath := filepath.Join(tmp, "config.dev.json")
+	err = os.WriteFile(devPath, []byte(`{"APP_EMPTY": "", "APP_FOO": "foo"}`),
+		perms)
+	is.NoErr(err)
+
+	// Convert a single file, comments are kept
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	in.From = prodPath
+	in.To = filepath.Join(tmp, "config.prod.yaml")
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdConvert, out.Cmd)
+	is.Equal(1, len(out.Files))
+	is.Equal(`# Database
+# Primary only
+APP_DB: postgres://db
+APP_NAME: foo bar
+`, out.Files[0].Buf.String())
+
+	// JSON doesn't support comments
+	in.To = filepath.Join(tmp, "config.prod.json")
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(1, len(out.Warnings))
+
+	// Convert env config files in APP_DIR, old files are deleted
+	in.From = ""
+	in.To = "sh"
+	in.Env = "*"
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(2, len(out.Files))
+	is.Equal(filepath.Join(tmp, ".env.dev.sh"), out.Files[0].Path)
+	is.Equal("export APP_EMPTY=''\nexport APP_FOO=foo\n",
+		out.Files[0].Buf.String())
+	is.Equal(devPath, out.Files[1].Path)
+	is.True(out.Files[1].Del)
+	is.NoErr(Files(out.Files).Save(new(bytes.Buffer)))
+
+	in.Env = share.EnvDev
+	in.To = "json"
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.NoErr(Files(out.Files).Save(new(bytes.Buffer)))
+	_, c, err := newSingleConf(tmp, share.EnvDev)
+	is.NoErr(err)
+	is.Equal(map[string]string{"APP_EMPTY": "", "APP_FOO": "foo"}, c.Map)
+
+	// The env format is only loaded for dev
+	in.Env = "prod"
+	in.To = "env"
+	_, err = Cmd(in)
+	is.True(err != nil)
+
+	// Existing files are only overwritten with the force flag
+	err = os.WriteFile(filepath.Join(tmp, "config.prod.json"), []byte(`{}`),
+		perms)
+	is.NoErr(err)
+	in.To = "json"
+	_, err = Cmd(in)
+	is.True(err != nil)
+	in.Force = true
+	_, err = Cmd(in)
+	is.NoErr(err)
+
+	in.To = "toml"
+	_, err = Cmd(in)
+	is.True(err != nil)
+}
@@ -0,0 +1,96 @@
+package cmdconfig
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// dirCache caches directory listings for the duration of a command.
+// Listing a dir is expensive if it contains many files,
+// therefore each dir is read at most once, e.g. when listing envs.
+// Paths in listed dirs are checked against the listing,
+// instead of calling os.Stat for every possible config file path.
+// Methods may be called on a nil cache, the file system is then read directly
+type dirCache struct {
+	mu sync.Mutex
+	// dirs maps a dir path to the set of file names in the dir
+	dirs map[string]map[string]bool
+}
+
+func newDirCache() *dirCache {
+	return &dirCache{
+		dirs: make(map[string]map[string]bool),
+	}
+}
+
+// readDirNames returns the set of names in dir,
+// the set is empty if dir does not exist
+func readDirNames(dir string) (names map[string]bool, err error) {
+	names = make(map[string]bool)
+	f, err := os.Open(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return names, nil
+		}
+		return names, errors.WithStack(err)
+	}
+	defer f.Close()
+	// Unlike os.ReadDir, names are not sorted
+	list, err := f.Readdirnames(-1)
+	if err != nil {
+		return names, errors.WithStack(err)
+	}
+	for _, name := range list {
+		names[name] = true
+	}
+	return names, nil
+}
+
+// names returns the set of names in dir
+func (dc *dirCache) names(dir string) (names map[string]bool, err error) {
+	if dc == nil {
+		return readDirNames(dir)
+	}
+
+	dir = filepath.Clean(dir)
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	names, ok := dc.dirs[dir]
+	if ok {
+		return names, nil
+	}
+	names, err = readDirNames(dir)
+	if err != nil {
+		return names, err
+	}
+	dc.dirs[dir] = names
+	return names, nil
+}
+
+// exists returns true if there is a file or dir at path.
+// The listing is used if the parent dir was listed already,
+// otherwise os.Stat is faster than listing a large dir
+func (dc *dirCache) exists(path string) (bool, error) {
+	var names map[string]bool
+	if dc != nil {
+		dc.mu.Lock()
+		names = dc.dirs[filepath.Clean(filepath.Dir(path))]
+		dc.mu.Unlock()
+	}
+
+	if names == nil {
+		_, err := os.Stat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return false, nil
+			}
+			return false, errors.WithStack(err)
+		}
+		return true, nil
+	}
+
+	return names[filepath.Base(path)], nil
+}
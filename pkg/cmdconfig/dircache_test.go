@@ -0,0 +1,159 @@
+package cmdconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestDirCache(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	configPath := filepath.Join(tmp, "config.dev.json")
+	err = os.WriteFile(configPath, []byte(`{"APP_FOO": "foo"}`), perms)
+	is.NoErr(err)
+
+	for _, dirs := range []*dirCache{nil, newDirCache()} {
+		exists, err := dirs.exists(configPath)
+		is.NoErr(err)
+		is.True(exists)
+		exists, err = dirs.exists(filepath.Join(tmp, "config.prod.json"))
+		is.NoErr(err)
+		is.True(!exists)
+		exists, err = dirs.exists(filepath.Join(tmp, "xxx", "config.json"))
+		is.NoErr(err)
+		is.True(!exists) // Dir does not exist
+	}
+
+	// Listing is cached for the duration of the command
+	dirs := newDirCache()
+	envs, err := listEnvs(dirs, tmp, false)
+	is.NoErr(err)
+	is.Equal([]string{share.EnvDev}, envs)
+	err = os.WriteFile(
+		filepath.Join(tmp, "config.prod.json"), []byte(`{}`), perms)
+	is.NoErr(err)
+	envs, err = listEnvs(dirs, tmp, false)
+	is.NoErr(err)
+	is.Equal([]string{share.EnvDev}, envs)
+	exists, err := dirs.exists(filepath.Join(tmp, "config.prod.json"))
+	is.NoErr(err)
+	is.True(!exists) // Listed dir is not read again
+	envs, err = listEnvs(newDirCache(), tmp, false)
+	is.NoErr(err)
+	is.Equal([]string{share.EnvDev, EnvProd}, envs)
+}
+
+// largeAppDir creates a dir with many files that are not config files,
+// and config files for the given number of envs
+func largeAppDir(b *testing.B, files int, envs int) string {
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < files; i++ {
+		err = os.WriteFile(
+			filepath.Join(tmp, fmt.Sprintf("file%d.txt", i)), []byte{}, perms)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	for i := 0; i < envs; i++ {
+		for _, prefix := range []string{"", share.SamplePrefix()} {
+			err = os.WriteFile(filepath.Join(tmp,
+				fmt.Sprintf("%sconfig.env%d.json", prefix, i)),
+				[]byte(`{"APP_FOO": "foo"}`), perms)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	return tmp
+}
+
+// globEnvs is the previous implementation of getEnvs,
+// kept for comparison with BenchmarkGetEnvs
+func globEnvs(appDir string) (envs []string, err error) {
+	matches, err := filepath.Glob(filepath.Join(appDir, "config.*.json"))
+	if err != nil {
+		return envs, err
+	}
+	r, err := regexp.Compile("config\\.(\\w+[\\w\\-]*)\\.json")
+	if err != nil {
+		return envs, err
+	}
+	for _, match := range matches {
+		matches := r.FindStringSubmatch(filepath.Base(match))
+		if len(matches) == 2 {
+			envs = append(envs, matches[1])
+		}
+	}
+	return envs, nil
+}
+
+func BenchmarkGetEnvs(b *testing.B) {
+	tmp := largeAppDir(b, 5000, 15)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	b.Run("glob", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			// Listing non-sample and sample files globs the dir twice
+			_, _ = globEnvs(tmp)
+			_, _ = globEnvs(tmp)
+		}
+	})
+
+	b.Run("cache", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			// New cache per invocation, the dir is read once
+			dirs := newDirCache()
+			_, _ = listEnvs(dirs, tmp, false)
+			_, _ = listEnvs(dirs, tmp, true)
+		}
+	})
+}
+
+func BenchmarkReadConfigFile(b *testing.B) {
+	tmp := largeAppDir(b, 5000, 15)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	envs, err := listEnvs(nil, tmp, false)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("stat", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, _ = globEnvs(tmp)
+			for _, env := range envs {
+				_, _, _ = readConfigFile(nil, tmp, env)
+			}
+		}
+	})
+
+	b.Run("cache", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			// Listing envs reads the dir, e.g. for the all flag
+			dirs := newDirCache()
+			_, _ = listEnvs(dirs, tmp, false)
+			for _, env := range envs {
+				_, _, _ = readConfigFile(dirs, tmp, env)
+			}
+		}
+	})
+}
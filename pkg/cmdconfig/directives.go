@@ -0,0 +1,77 @@
+package cmdconfig
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/pkg/errors"
+)
+
+// DirectivePrefix for config in Go comments, see readDirectives
+const DirectivePrefix = "//configu:"
+
+// directiveRegexp matches directive comments, e.g. "//configu:dev APP_PORT=8080"
+var directiveRegexp = regexp.MustCompile(
+	`^` + regexp.QuoteMeta(DirectivePrefix) + `(\S+)\s+([_a-zA-Z0-9]+)=(.*)$`)
+
+// readDirectives returns config for env declared in directive comments,
+// in the Go files in appDir, e.g. for tiny tools in package main that
+// don't have config files. Values may be quoted, the same as env files.
+// The config path is the first file with directives for env,
+// it's empty if there are none
+func readDirectives(dirs *dirCache, appDir, env string) (
+	configPath string, config map[string]string, err error) {
+
+	config = make(map[string]string)
+	names, err := dirs.names(appDir)
+	if err != nil {
+		return configPath, config, err
+	}
+	files := make([]string, 0)
+	for name := range names {
+		if filepath.Ext(name) == ".go" && !strings.HasSuffix(name, "_test.go") {
+			files = append(files, name)
+		}
+	}
+	sort.Strings(files)
+
+	for _, name := range files {
+		path := filepath.Join(appDir, name)
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return configPath, config, errors.WithStack(err)
+		}
+		if !bytes.Contains(b, []byte(DirectivePrefix)) {
+			continue
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(b))
+		for scanner.Scan() {
+			matches := directiveRegexp.FindStringSubmatch(
+				strings.TrimSpace(scanner.Text()))
+			if matches == nil || matches[1] != env {
+				continue
+			}
+			key := matches[2]
+			if _, ok := config[key]; ok {
+				return configPath, config, errors.WithMessagef(
+					ErrDuplicateKey(key), "directive in %s", path)
+			}
+			config[key] = share.UnquoteENV(strings.TrimSpace(matches[3]))
+			if configPath == "" {
+				configPath = path
+			}
+		}
+		err = scanner.Err()
+		if err != nil {
+			return configPath, config, errors.WithStack(err)
+		}
+	}
+
+	return configPath, config, nil
+}
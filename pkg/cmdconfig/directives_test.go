@@ -0,0 +1,88 @@
+package cmdconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestReadDirectives(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	mainPath := filepath.Join(tmp, "main.go")
+	main := []byte(`package main
+
+//configu:dev APP_PORT=8080
+//configu:dev APP_NAME="my tool"
+//configu:dev APP_GREETING='it'\''s'
+//configu:prod APP_PORT=80
+//configu:sample.dev APP_PORT=
+
+func main() {}
+`)
+	err = os.WriteFile(mainPath, main, perms)
+	is.NoErr(err)
+	// Test files are skipped
+	err = os.WriteFile(filepath.Join(tmp, "main_test.go"),
+		[]byte("package main\n\n//configu:dev APP_TEST=1\n"), perms)
+	is.NoErr(err)
+
+	configPath, c, err := newSingleConf(tmp, share.EnvDev)
+	is.NoErr(err)
+	is.Equal([]string{mainPath}, configPath)
+	is.Equal(map[string]string{
+		"APP_PORT":     "8080",
+		"APP_NAME":     "my tool",
+		"APP_GREETING": "it's", // Single quoted, the same as env files
+	}, c.Map)
+	_, c, err = newSingleConf(tmp, "sample.dev")
+	is.NoErr(err)
+	is.Equal(map[string]string{"APP_PORT": ""}, c.Map)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = "prod"
+	in.PrintValue = "APP_PORT"
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal("80", out.Buf.String())
+
+	// Directives are read-only
+	in.PrintValue = ""
+	in.Keys = ArgMap{"APP_PORT"}
+	in.Values = ArgMap{"81"}
+	_, err = Cmd(in)
+	is.True(err != nil)
+	b, err := os.ReadFile(mainPath)
+	is.NoErr(err)
+	is.Equal(string(main), string(b))
+
+	// Envs without directives or config files are not found
+	_, _, err = newSingleConf(tmp, "stage")
+	is.True(err != nil)
+
+	// Config files take precedence
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"),
+		[]byte(`{"APP_PORT": "9090"}`), perms)
+	is.NoErr(err)
+	_, c, err = newSingleConf(tmp, share.EnvDev)
+	is.NoErr(err)
+	is.Equal("9090", c.Map["APP_PORT"])
+
+	// Keys must be unique
+	err = os.WriteFile(filepath.Join(tmp, "flags.go"),
+		[]byte("package main\n\n//configu:prod APP_PORT=81\n"), perms)
+	is.NoErr(err)
+	_, _, err = newSingleConf(tmp, "prod")
+	is.True(err != nil)
+}
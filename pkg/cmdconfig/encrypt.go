@@ -0,0 +1,156 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/pkg/errors"
+)
+
+// encryptKeys encrypts or decrypts the values of the keys listed with the
+// encrypt key or decrypt key flags, in the config files for the envs
+// as per the all and env flags. Samples are skipped.
+// Values that are already encrypted, or decrypted, are not changed
+func encryptKeys(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
+	buf = new(bytes.Buffer)
+
+	if len(in.EncryptKey) > 0 && len(in.DecryptKey) > 0 {
+		return buf, files, errors.Errorf("%s and %s flags are exclusive",
+			FlagEncryptKey, FlagDecryptKey)
+	}
+	encrypt := len(in.EncryptKey) > 0
+	action, keys := "encrypt", splitList(in.EncryptKey)
+	if !encrypt {
+		action, keys = "decrypt", splitList(in.DecryptKey)
+	}
+	key := os.Getenv(share.EncryptionKeyEnvKey)
+	if key == "" {
+		return buf, files, errors.Errorf("set %s to %s values",
+			share.EncryptionKeyEnvKey, action)
+	}
+
+	envs, err := updateEnvs(in)
+	if err != nil {
+		return buf, files, err
+	}
+	for _, env := range envs {
+		if strings.HasPrefix(env, share.SamplePrefix()) {
+			continue
+		}
+		if !in.Force && in.manifest.protected(env) {
+			return buf, files, ErrProtectedEnv(env)
+		}
+		configPath, c, err := loadConf(in.dirs, in.AppDir, env)
+		if err != nil {
+			return buf, files, err
+		}
+
+		changed := make([]string, 0)
+		for _, item := range keys {
+			value, ok := c.Map[item]
+			if !ok {
+				return buf, files, withSuggestions(
+					errors.Errorf("missing key %s in env %s", item, env),
+					item, c.Keys)
+			}
+			if share.Encrypted(value) == encrypt {
+				continue
+			}
+			if encrypt {
+				value, err = share.EncryptValue(key, value)
+			} else {
+				value, err = share.DecryptValue(key, value)
+			}
+			if err != nil {
+				return buf, files, errors.WithMessagef(err,
+					"%s key %s in env %s", action, item, env)
+			}
+			c.Map[item] = value
+			changed = append(changed, item)
+		}
+		if len(changed) == 0 {
+			continue
+		}
+		b, err := marshalConf(c, filepath.Ext(configPath))
+		if err != nil {
+			return buf, files, err
+		}
+		files = append(files, File{Path: configPath, Buf: bytes.NewBuffer(b)})
+		for _, item := range changed {
+			buf.WriteString(fmt.Sprintf("%s %s in %s\n", action, item, configPath))
+		}
+	}
+
+	return buf, files, nil
+}
+
+// decryptConf replaces encrypted values in config with the plaintext,
+// and returns the decrypted keys
+func decryptConf(config *conf) (keys []string, err error) {
+	keys = make([]string, 0)
+	key := os.Getenv(share.EncryptionKeyEnvKey)
+	// Assuming config.Keys is already sorted
+	for _, item := range config.Keys {
+		value := config.Map[item]
+		if !share.Encrypted(value) {
+			continue
+		}
+		if key == "" {
+			return keys, errors.Errorf("%s is encrypted, set %s to decrypt it",
+				item, share.EncryptionKeyEnvKey)
+		}
+		value, err = share.DecryptValue(key, value)
+		if err != nil {
+			return keys, errors.WithMessagef(err, "key %s", item)
+		}
+		config.Map[item] = value
+		keys = append(keys, item)
+	}
+	return keys, nil
+}
+
+// encryptUpdate returns the value to set for a key, if the old value is
+// encrypted the new value is encrypted too, so updates don't store plaintext.
+// Unchanged is set if the new value is the same as the decrypted old value
+func encryptUpdate(item, old, value string) (
+	newValue string, unchanged bool, err error) {
+
+	if !share.Encrypted(old) || share.Encrypted(value) {
+		return value, old == value, nil
+	}
+	key := os.Getenv(share.EncryptionKeyEnvKey)
+	if key == "" {
+		return value, false, errors.Errorf(
+			"%s is encrypted, set %s to update it",
+			item, share.EncryptionKeyEnvKey)
+	}
+	decrypted, err := share.DecryptValue(key, old)
+	if err != nil {
+		return value, false, errors.WithMessagef(err, "key %s", item)
+	}
+	if decrypted == value {
+		return old, true, nil
+	}
+	newValue, err = share.EncryptValue(key, value)
+	if err != nil {
+		return value, false, errors.WithMessagef(err, "key %s", item)
+	}
+	return newValue, false, nil
+}
+
+// rejectEncrypted returns an error if config has encrypted values
+func rejectEncrypted(config *conf, env, action string) error {
+	// Assuming config.Keys is already sorted
+	for _, item := range config.Keys {
+		if share.Encrypted(config.Map[item]) {
+			return errors.Errorf(
+				"%s is encrypted in env %s, use the %s flag before %s",
+				item, env, FlagDecryptKey, action)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/mozey/config/pkg/testutil"
+)
+
+// encryptionKey returns a valid encryption key filled with b
+func encryptionKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(
+		bytes.Repeat([]byte{b}, share.EncryptionKeySize))
+}
+
+func TestEncryptKeys(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(tmp, "cache"))
+	t.Setenv("HOME", tmp)
+	t.Setenv(SessionEnvKey, t.Name())
+	t.Setenv(share.EncryptionKeyEnvKey, "")
+
+	configPath := filepath.Join(tmp, "config.dev.json")
+	err = os.WriteFile(configPath, []byte(`{
+		"APP_DB_PASSWORD": "s3cret",
+		"APP_FOO": "foo"
+	}`), perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	in.OS = OSPosix
+	in.EncryptKey = ArgMap{"APP_DB_PASSWORD"}
+
+	// Key is required
+	_, err = Cmd(in)
+	is.True(err != nil)
+	t.Setenv(share.EncryptionKeyEnvKey, "passphrase")
+	_, err = Cmd(in)
+	is.True(err != nil)
+	t.Setenv(share.EncryptionKeyEnvKey, encryptionKey(1))
+
+	_, err = Cmd(&CmdIn{AppDir: tmp, Prefix: "APP_", Env: share.EnvDev,
+		EncryptKey: ArgMap{"APP_MISSING"}})
+	is.True(err != nil)
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdEncrypt, out.Cmd)
+	is.Equal("encrypt APP_DB_PASSWORD in "+configPath+"\n", out.Buf.String())
+	is.NoErr(out.Files.Save(new(bytes.Buffer)))
+	_, c, err := newSingleConf(tmp, share.EnvDev)
+	is.NoErr(err)
+	is.True(share.Encrypted(c.Map["APP_DB_PASSWORD"]))
+	is.Equal("foo", c.Map["APP_FOO"])
+
+	// Encrypted values are not changed
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(0, len(out.Files))
+
+	// Values are decrypted for get and set env
+	in.EncryptKey = nil
+	in.PrintValue = "APP_DB_PASSWORD"
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal("s3cret", out.Buf.String())
+	in.PrintValue = ""
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.True(strings.Contains(out.Buf.String(), "APP_DB_PASSWORD=s3cret"))
+
+	// Exports and bundles don't have the key
+	in.Export = ExportMake
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.True(strings.Contains(out.Buf.String(), "APP_DB_PASSWORD := s3cret"))
+	in.Export = ""
+	_, err = Cmd(&CmdIn{AppDir: tmp, Prefix: "APP_", Env: share.EnvDev,
+		ExportBundle: filepath.Join(tmp, "bundle.tar.gz")})
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "APP_DB_PASSWORD is encrypted"))
+
+	// Encrypted values stay encrypted when set
+	set := &CmdIn{AppDir: tmp, Prefix: "APP_", Env: share.EnvDev,
+		Keys: ArgMap{"APP_DB_PASSWORD"}, Values: ArgMap{"s3cret"}}
+	out, err = Cmd(set)
+	is.NoErr(err)
+	is.Equal(0, len(out.Files))
+	set.Values = ArgMap{"n3w"}
+	out, err = Cmd(set)
+	is.NoErr(err)
+	is.Equal(1, len(out.Files))
+	is.True(!strings.Contains(out.Files[0].Buf.String(), "n3w"))
+	m, err := share.UnmarshalConfig(configPath, out.Files[0].Buf.Bytes())
+	is.NoErr(err)
+	value, err := share.DecryptValue(encryptionKey(1), m["APP_DB_PASSWORD"])
+	is.NoErr(err)
+	is.Equal("n3w", value)
+
+	// Wrong key
+	t.Setenv(share.EncryptionKeyEnvKey, encryptionKey(2))
+	_, err = Cmd(in)
+	is.True(err != nil)
+	t.Setenv(share.EncryptionKeyEnvKey, "")
+	_, err = Cmd(in)
+	is.True(err != nil)
+	_, err = Cmd(set)
+	is.True(err != nil)
+
+	// Decrypt
+	t.Setenv(share.EncryptionKeyEnvKey, encryptionKey(1))
+	in.DecryptKey = ArgMap{"APP_DB_PASSWORD"}
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal("decrypt APP_DB_PASSWORD in "+configPath+"\n", out.Buf.String())
+	is.NoErr(out.Files.Save(new(bytes.Buffer)))
+	_, c, err = newSingleConf(tmp, share.EnvDev)
+	is.NoErr(err)
+	is.Equal("s3cret", c.Map["APP_DB_PASSWORD"])
+
+	in.EncryptKey = ArgMap{"APP_FOO"}
+	_, err = Cmd(in)
+	is.True(err != nil)
+}
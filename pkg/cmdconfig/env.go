@@ -5,7 +5,8 @@ import (
 	"fmt"
 )
 
-// MarshalENV key value map to .env file bytes
+// MarshalENV key value map to .env file bytes,
+// values are quoted if required, see quoteENV
 func MarshalENV(c *conf) (b []byte, err error) {
 	buf := bytes.NewBufferString("")
 	// Assuming c.Keys is already sorted
@@ -14,6 +15,10 @@ func MarshalENV(c *conf) (b []byte, err error) {
 		if !ok {
 			return b, ErrMissingKey(key)
 		}
+		value, err = quoteENV(key, value)
+		if err != nil {
+			return b, err
+		}
 		_, err = buf.WriteString(fmt.Sprintf("export %s=%s\n", key, value))
 		if err != nil {
 			return b, err
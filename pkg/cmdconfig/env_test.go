@@ -51,10 +51,15 @@ func TestMarshalENV(t *testing.T) {
 
 	// TODO Preserve comments
 	// https://github.com/mozey/config/issues/34
-	envFileBytes := []byte(`export APP_FOO="foo"
-export APP_TEMPLATE=my name is {{.Name}}
+	envFileBytes := []byte(`export APP_FOO='"foo"'
+export APP_TEMPLATE='my name is {{.Name}}'
 export AWS_PROFILE=aws-local
 `)
 
 	is.Equal(string(envFileBytes), string(b))
+
+	// Values are quoted so they survive the round trip
+	m, err := share.UnmarshalENV(b)
+	is.NoErr(err)
+	is.Equal(c.Map, m)
 }
@@ -1,6 +1,10 @@
 package cmdconfig
 
-import "github.com/mozey/errors"
+import (
+	"strings"
+
+	"github.com/mozey/errors"
+)
 
 var ErrCmdConfig = errors.NewCause("cmdconfig")
 
@@ -12,7 +16,61 @@ var ErrMissingKey = func(key string) error {
 	return errors.NewWithCausef(ErrCmdConfig, "missing key %s", key)
 }
 
+var ErrAppDirNotSet = func(key string) error {
+	return errors.NewWithCausef(ErrCmdConfig,
+		"%s env not set, run conf in the project dir, or export %s=\"$(pwd)\"",
+		key, key)
+}
+
+var ErrConfigNotFound = func(env string, report string) error {
+	return errors.NewWithCausef(ErrCmdConfig,
+		"config file not found for env %s\n%s", env, report)
+}
+
 var ErrNotImplemented = errors.NewWithCausef(ErrCmdConfig, "not implemented")
 
 var ErrParentNotFound = errors.NewWithCausef(
 	ErrCmdConfig, "parent config not found")
+
+var ErrInvalidEnv = func(env string) error {
+	return errors.NewWithCausef(ErrCmdConfig, "env %s not listed in manifest", env)
+}
+
+var ErrProtectedEnv = func(env string) error {
+	return errors.NewWithCausef(ErrCmdConfig,
+		"env %s is protected, use the force flag to update", env)
+}
+
+var ErrConfCycle = func(chain []string) error {
+	return errors.NewWithCausef(ErrCmdConfig,
+		"config file loaded more than once %s", strings.Join(chain, " -> "))
+}
+
+var ErrConfChainDepth = func(chain []string) error {
+	return errors.NewWithCausef(ErrCmdConfig,
+		"too many config files %s", strings.Join(chain, " -> "))
+}
+
+var ErrConflict = func(uri string) error {
+	return errors.NewWithCausef(ErrCmdConfig,
+		"%s changed since it was last pulled, use the force flag to overwrite",
+		uri)
+}
+
+var ErrLocalChanges = func(configPath string) error {
+	return errors.NewWithCausef(ErrCmdConfig,
+		"%s changed since it was last pulled, use the force flag to overwrite",
+		configPath)
+}
+
+var ErrNoOwner = func(keys []string) error {
+	return errors.NewWithCausef(ErrCmdConfig,
+		"owner required for new keys %s, declare owners in %s or %s",
+		strings.Join(keys, ", "), FileNameManifest, FileNameTypes)
+}
+
+var ErrTypeChange = func(keys []string) error {
+	return errors.NewWithCausef(ErrCmdConfig,
+		"value type or size changed for %s, use the force flag to update",
+		strings.Join(keys, ", "))
+}
@@ -0,0 +1,104 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// execEnv returns environ with the config set, and env vars starting with
+// prefix removed if they are not in the config, the same as set env.
+// If exported is not nil, only those keys are removed, see the safe flag
+func execEnv(environ []string, prefix string, config *conf,
+	exported map[string]bool) (env []string) {
+
+	appDirKey := fmt.Sprintf("%sDIR", prefix)
+	env = make([]string, 0, len(environ)+len(config.Keys))
+	for _, v := range environ {
+		key, _, _ := strings.Cut(v, "=")
+		if _, ok := config.Map[key]; ok {
+			continue
+		}
+		stale := strings.HasPrefix(key, prefix) && key != appDirKey &&
+			(exported == nil || exported[key])
+		if stale {
+			continue
+		}
+		env = append(env, v)
+	}
+	// Assuming config.Keys is already sorted
+	for _, key := range config.Keys {
+		env = append(env, fmt.Sprintf("%s=%s", key, config.Map[key]))
+	}
+	return env
+}
+
+// execCmd returns the command after the flags, to be run by Process
+// with the env for the env flag, instead of evaluating set env commands
+func execCmd(in *CmdIn) (buf *bytes.Buffer, cmd *exec.Cmd, err error) {
+	buf = new(bytes.Buffer)
+
+	config, _, state, _, err := exportConf(in)
+	if err != nil {
+		return buf, cmd, err
+	}
+	var exported map[string]bool
+	if in.Safe {
+		exported = state.exported()
+	}
+
+	name, err := exec.LookPath(in.Command[0])
+	if err != nil {
+		return buf, cmd, errors.WithStack(err)
+	}
+	cmd = exec.Command(name, in.Command[1:]...)
+	cmd.Env = execEnv(os.Environ(), in.Prefix, config, exported)
+	buf.WriteString(strings.Join(in.Command, " "))
+	return buf, cmd, nil
+}
+
+// runCmd runs cmd with the standard streams and returns the exit code.
+// Interrupt and terminate signals are forwarded to the child process,
+// so it can shut down gracefully
+func runCmd(cmd *exec.Cmd, stdin io.Reader, stdout, stderr io.Writer) (
+	exitCode int, err error) {
+
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, stdout, stderr
+	err = cmd.Start()
+	if err != nil {
+		return 1, errors.WithStack(err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				_ = cmd.Process.Signal(sig)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	err = cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 1, errors.WithStack(err)
+	}
+	return 0, nil
+}
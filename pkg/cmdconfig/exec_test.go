@@ -0,0 +1,87 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestExecEnv(t *testing.T) {
+	is := testutil.Setup(t)
+
+	config := &conf{Map: map[string]string{"APP_FOO": "foo"}}
+	config.refreshKeys()
+	environ := []string{
+		"PATH=/bin", "APP_DIR=/app", "APP_FOO=old", "APP_STALE=x", "APP_OTHER=y"}
+
+	// Stale prefixed vars are removed
+	is.Equal([]string{"PATH=/bin", "APP_DIR=/app", "APP_FOO=foo"},
+		execEnv(environ, "APP_", config, nil))
+
+	// Safe mode only removes keys previously exported
+	is.Equal([]string{"PATH=/bin", "APP_DIR=/app", "APP_OTHER=y", "APP_FOO=foo"},
+		execEnv(environ, "APP_", config, map[string]bool{"APP_STALE": true}))
+}
+
+func TestExec(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(tmp, "cache"))
+	t.Setenv("HOME", tmp)
+	t.Setenv(SessionEnvKey, t.Name())
+	t.Setenv("APP_STALE", "stale")
+
+	err = os.WriteFile(filepath.Join(tmp, "config.prod.json"),
+		[]byte(`{"APP_FOO": "foo"}`), perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = "prod"
+	in.Exec = true
+	in.Command = []string{"sh", "-c", `echo "$APP_FOO,$APP_STALE"; exit 3`}
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdExec, out.Cmd)
+
+	// The exit code of the command is returned
+	stdout := new(bytes.Buffer)
+	in.Stdin = new(bytes.Buffer)
+	in.Stdout = stdout
+	in.Stderr = new(bytes.Buffer)
+	exitCode, err := in.Process(out)
+	is.NoErr(err)
+	is.Equal(3, exitCode)
+	is.Equal("foo,\n", stdout.String())
+
+	// Dry run prints the command
+	in.DryRun = true
+	stdout.Reset()
+	out, err = Cmd(in)
+	is.NoErr(err)
+	exitCode, err = in.Process(out)
+	is.NoErr(err)
+	is.Equal(0, exitCode)
+	is.Equal("sh -c echo \"$APP_FOO,$APP_STALE\"; exit 3\n", stdout.String())
+
+	// Env must exist
+	in.Env = share.EnvDev
+	_, err = Cmd(in)
+	is.True(err != nil)
+}
@@ -0,0 +1,74 @@
+package cmdconfig
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/pkg/errors"
+)
+
+// KeySuffixExpires is appended to a key for the companion key with the
+// expiry of the value, e.g. temporary credentials like STS session tokens.
+// The expiry is formatted as RFC3339, e.g.
+//
+//	APP_AWS_SESSION_TOKEN=...
+//	APP_AWS_SESSION_TOKEN_EXPIRES=2024-01-02T15:04:05Z
+const KeySuffixExpires = "_EXPIRES"
+
+// now is a variable so tests can set the time
+var now = time.Now
+
+// refreshExpired checks keys in config that have an expiry.
+// Expired values are refreshed with the secret reference in the manifest,
+// if secrets are enabled, otherwise a warning is printed.
+// The helper may print the new expiry on the line after the value.
+// Refreshed values are only exported, config files are not changed
+func refreshExpired(in *CmdIn, config *conf) error {
+	t := now()
+	for _, key := range config.Keys {
+		expiresKey := key + KeySuffixExpires
+		expires, ok := config.Map[expiresKey]
+		if !ok || expires == "" {
+			continue
+		}
+		expiry, err := time.Parse(time.RFC3339, expires)
+		if err != nil {
+			in.warn(fmt.Sprintf("invalid expiry %s=%s, expected RFC3339",
+				expiresKey, expires))
+			continue
+		}
+		if t.Before(expiry) {
+			continue
+		}
+
+		ref := in.manifest.refresh(key)
+		if ref == "" || !in.Secrets {
+			hint := ""
+			if ref != "" {
+				hint = fmt.Sprintf(", use the %s flag to refresh it", FlagSecrets)
+			}
+			in.warn(fmt.Sprintf("%s expired %s ago%s",
+				key, t.Sub(expiry).Round(time.Second), hint))
+			continue
+		}
+		refreshed, err := share.ResolveSecret(ref)
+		if err != nil {
+			return errors.WithMessagef(err, "refreshing %s", key)
+		}
+		value, newExpiry, _ := strings.Cut(refreshed, "\n")
+		config.Map[key] = strings.TrimRight(value, "\r")
+		newExpiry = strings.TrimSpace(newExpiry)
+		if newExpiry != "" {
+			if _, err := time.Parse(time.RFC3339, newExpiry); err != nil {
+				return errors.Errorf(
+					"invalid expiry from %s for %s, expected RFC3339", ref, key)
+			}
+		}
+		// The old expiry is not exported with the refreshed value
+		config.Map[expiresKey] = newExpiry
+		in.warn(fmt.Sprintf("%s expired, refreshed with %s", key, ref))
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package cmdconfig
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestRefreshExpired(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	original := now
+	now = func() time.Time {
+		return time.Date(2024, 1, 2, 16, 0, 0, 0, time.UTC)
+	}
+	defer (func() {
+		now = original
+	})()
+
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"), []byte(`{
+		"APP_TOKEN": "old",
+		"APP_TOKEN_EXPIRES": "2024-01-02T15:00:00Z",
+		"APP_VALID": "valid",
+		"APP_VALID_EXPIRES": "2024-01-02T17:00:00Z",
+		"APP_BAD": "bad",
+		"APP_BAD_EXPIRES": "tomorrow"
+	}`), perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+
+	// Expired values are exported with a warning
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal([]string{
+		"invalid expiry APP_BAD_EXPIRES=tomorrow, expected RFC3339",
+		"APP_TOKEN expired 1h0m0s ago",
+	}, out.Warnings)
+	is.True(strings.Contains(out.Buf.String(), "APP_TOKEN=old"))
+
+	// Refreshing requires the secrets flag
+	in.manifest = &Manifest{
+		Refresh: map[string]string{"APP_TOKEN": "helper://expiry-test/token"}}
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal("APP_TOKEN expired 1h0m0s ago, use the secrets flag to refresh it",
+		out.Warnings[1])
+
+	if runtime.GOOS == "windows" {
+		t.Skip("credential helper script requires a posix shell")
+	}
+	bin := filepath.Join(tmp, "bin")
+	is.NoErr(os.Mkdir(bin, dirPerms))
+	err = os.WriteFile(filepath.Join(bin, share.HelperPrefix+"expiry-test"),
+		[]byte("#!/bin/sh\necho new\necho 2024-01-02T17:00:00Z\n"), 0700)
+	is.NoErr(err)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	// The user's secret cache is not used
+	t.Setenv(share.SecretCacheKeyEnvKey, "")
+
+	in.Secrets = true
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal("APP_TOKEN expired, refreshed with helper://expiry-test/token",
+		out.Warnings[1])
+	is.True(strings.Contains(out.Buf.String(), "APP_TOKEN=new"))
+	is.True(strings.Contains(out.Buf.String(),
+		"APP_TOKEN_EXPIRES=2024-01-02T17:00:00Z"))
+
+	// Config files are not changed
+	_, c, err := newSingleConf(tmp, share.EnvDev)
+	is.NoErr(err)
+	is.Equal("old", c.Map["APP_TOKEN"])
+}
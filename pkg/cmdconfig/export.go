@@ -0,0 +1,226 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// .............................................................................
+// Export formats, i.e. the config map formatted for use with other tools
+
+// ExportECS for the environment of a container definition in an
+// AWS ECS task definition
+const ExportECS = "ecs"
+
+// ExportHeroku for a heroku config:set command
+const ExportHeroku = "heroku"
+
+// ExportMake for a Makefile include, e.g. "include config.mk"
+const ExportMake = "make"
+
+// ExportWinService for a PowerShell script that sets the environment
+// of a Windows service
+const ExportWinService = "winservice"
+
+// marshalFunc formats config for the given export format
+type marshalFunc func(c *conf) (b []byte, err error)
+
+// exportFormats maps export format to marshal func
+var exportFormats = map[string]marshalFunc{
+	ExportECS:        MarshalECS,
+	ExportHeroku:     MarshalHeroku,
+	ExportMake:       MarshalMake,
+	ExportWinService: MarshalWinService,
+}
+
+// ExportFormats returns the sorted list of supported export formats
+func ExportFormats() []string {
+	formats := make([]string, 0, len(exportFormats))
+	for format := range exportFormats {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// ecsKeyValuePair as per the ECS task definition
+type ecsKeyValuePair struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// MarshalECS key value map to the "environment" JSON fragment
+// of an ECS container definition, see
+// https://docs.aws.amazon.com/AmazonECS/latest/APIReference/API_KeyValuePair.html
+func MarshalECS(c *conf) (b []byte, err error) {
+	pairs := make([]ecsKeyValuePair, 0, len(c.Keys))
+	// Assuming c.Keys is already sorted
+	for _, key := range c.Keys {
+		value, ok := c.Map[key]
+		if !ok {
+			return b, ErrMissingKey(key)
+		}
+		pairs = append(pairs, ecsKeyValuePair{Name: key, Value: value})
+	}
+	environment, err := json.MarshalIndent(pairs, "", "    ")
+	if err != nil {
+		return b, errors.WithStack(err)
+	}
+	buf := bytes.NewBufferString("\"environment\": ")
+	buf.Write(environment)
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+// MarshalHeroku key value map to a single heroku config:set command,
+// values are quoted for POSIX shells, see
+// https://devcenter.heroku.com/articles/config-vars
+func MarshalHeroku(c *conf) (b []byte, err error) {
+	buf := bytes.NewBufferString("heroku config:set")
+	// Assuming c.Keys is already sorted
+	for _, key := range c.Keys {
+		value, ok := c.Map[key]
+		if !ok {
+			return b, ErrMissingKey(key)
+		}
+		buf.WriteString(" ")
+		buf.WriteString(key)
+		buf.WriteString("=")
+		buf.WriteString(ShellQuote(value))
+	}
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+// makeReplacer escapes variable references and comments
+var makeReplacer = strings.NewReplacer(
+	"$", "$$",
+	"#", "\\#",
+)
+
+// MarshalMake key value map to simply expanded Makefile variables,
+// i.e. "KEY := value" assignments
+// https://www.gnu.org/software/make/manual/html_node/Flavors.html
+func MarshalMake(c *conf) (b []byte, err error) {
+	buf := bytes.NewBufferString("")
+	// Assuming c.Keys is already sorted
+	for _, key := range c.Keys {
+		value, ok := c.Map[key]
+		if !ok {
+			return b, ErrMissingKey(key)
+		}
+		if strings.Contains(value, "\n") {
+			return b, errors.Errorf("value for key %s must not contain newlines", key)
+		}
+		buf.WriteString(fmt.Sprintf("%s := %s\n", key, makeValue(value)))
+	}
+	return buf.Bytes(), nil
+}
+
+// makeValue escapes value for the right hand side of an assignment.
+// Make strips leading whitespace, and a trailing backslash continues the
+// line, both are guarded with an empty variable reference, i.e. $()
+func makeValue(value string) string {
+	value = makeReplacer.Replace(value)
+	if strings.HasPrefix(value, " ") || strings.HasPrefix(value, "\t") {
+		value = "$()" + value
+	}
+	if strings.HasSuffix(value, "\\") {
+		value += "$()"
+	}
+	return value
+}
+
+// MarshalWinService key value map to a PowerShell script that sets the
+// Environment value (REG_MULTI_SZ) of a Windows service in the registry.
+// The set and setx commands don't apply to services,
+// the service must be restarted for changes to take effect. See
+// https://learn.microsoft.com/en-us/troubleshoot/windows-server/performance/environment-variables-for-services
+func MarshalWinService(c *conf) (b []byte, err error) {
+	buf := bytes.NewBufferString("")
+	buf.WriteString("param([Parameter(Mandatory=$true)][string]$ServiceName)\n")
+	buf.WriteString("Set-ItemProperty")
+	buf.WriteString(" -Path \"HKLM:\\SYSTEM\\CurrentControlSet\\Services\\$ServiceName\"")
+	buf.WriteString(" -Name Environment -Type MultiString -Value @(\n")
+	// Assuming c.Keys is already sorted
+	for i, key := range c.Keys {
+		value, ok := c.Map[key]
+		if !ok {
+			return b, ErrMissingKey(key)
+		}
+		if strings.ContainsAny(value, "\x00\r\n") {
+			return b, errors.Errorf(
+				"value for key %s must not contain newlines or null", key)
+		}
+		buf.WriteString("    ")
+		buf.WriteString(PowerShellQuote(fmt.Sprintf("%s=%s", key, value)))
+		if i < len(c.Keys)-1 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("\n")
+	}
+	buf.WriteString(")\n")
+	return buf.Bytes(), nil
+}
+
+// PowerShellQuote quotes s as a verbatim string for PowerShell,
+// embedded single quotes are doubled
+func PowerShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// shellSafe matches values that don't require quoting in POSIX shells
+var shellSafe = regexp.MustCompile(`^[a-zA-Z0-9_@%+=:,./-]+$`)
+
+// ShellQuote quotes s for use as a single word in POSIX shells.
+// Single quotes prevent expansion, and embedded single quotes are escaped
+func ShellQuote(s string) string {
+	if shellSafe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// exportConfig formats config as per the export flag
+func exportConfig(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
+	buf = new(bytes.Buffer)
+
+	marshal, ok := exportFormats[in.Export]
+	if !ok {
+		return buf, files, errors.Errorf(
+			"invalid export format %s, expected one of %s",
+			in.Export, strings.Join(ExportFormats(), ", "))
+	}
+
+	_, config, err := newConf(confParams{
+		dirs:   in.dirs,
+		prefix: in.Prefix,
+		appDir: in.AppDir,
+		env:    in.Env,
+		extend: in.Extend,
+		merge:  in.Merge,
+		parent: in.Parent,
+	})
+	if err != nil {
+		return buf, files, err
+	}
+	// Encrypted values can't be decrypted where the export is used
+	_, err = decryptConf(config)
+	if err != nil {
+		return buf, files, err
+	}
+
+	b, err := marshal(config)
+	if err != nil {
+		return buf, files, err
+	}
+	buf.Write(b)
+
+	return buf, files, nil
+}
@@ -0,0 +1,137 @@
+package cmdconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestExportECS(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	env := share.EnvDev
+
+	err = os.WriteFile(
+		filepath.Join(tmp, fmt.Sprintf("config.%v.json", env)),
+		[]byte(`{"APP_FOO": "foo", "APP_BAR": "bar \"baz\""}`),
+		perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = env
+	in.Export = ExportECS
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdExport, out.Cmd)
+	is.Equal(0, out.ExitCode)
+
+	// Fragment must be valid JSON inside an object
+	s := out.Buf.String()
+	is.True(strings.HasPrefix(s, "\"environment\": "))
+	m := make(map[string][]ecsKeyValuePair)
+	err = json.Unmarshal([]byte(fmt.Sprintf("{%s}", s)), &m)
+	is.NoErr(err)
+	is.Equal([]ecsKeyValuePair{
+		{Name: "APP_BAR", Value: "bar \"baz\""},
+		{Name: "APP_FOO", Value: "foo"},
+	}, m["environment"])
+
+	in.Export = "xxx"
+	_, err = Cmd(in)
+	is.True(err != nil) // Invalid export format
+}
+
+func TestExportHeroku(t *testing.T) {
+	is := testutil.Setup(t)
+
+	c := &conf{}
+	c.Map = map[string]string{
+		"APP_FOO":   "foo",
+		"APP_BAR":   "bar baz",
+		"APP_EMPTY": "",
+		"APP_QUOTE": "it's $HOME",
+	}
+	c.refreshKeys()
+
+	b, err := MarshalHeroku(c)
+	is.NoErr(err)
+	is.Equal(
+		"heroku config:set APP_BAR='bar baz' APP_EMPTY='' "+
+			"APP_FOO=foo APP_QUOTE='it'\\''s $HOME'\n",
+		string(b))
+}
+
+func TestExportMake(t *testing.T) {
+	is := testutil.Setup(t)
+
+	c := &conf{}
+	c.Map = map[string]string{
+		"APP_FOO":  "foo bar",
+		"APP_COST": "$5 #1",
+	}
+	c.refreshKeys()
+
+	b, err := MarshalMake(c)
+	is.NoErr(err)
+	is.Equal("APP_COST := $$5 \\#1\nAPP_FOO := foo bar\n", string(b))
+
+	// Trailing backslash must not continue the line
+	c.Map = map[string]string{"APP_PATH": `C:\dir\`}
+	c.refreshKeys()
+	b, err = MarshalMake(c)
+	is.NoErr(err)
+	is.Equal("APP_PATH := C:\\dir\\$()\n", string(b))
+
+	// Leading whitespace must be kept
+	c.Map = map[string]string{"APP_INDENT": "  foo"}
+	c.refreshKeys()
+	b, err = MarshalMake(c)
+	is.NoErr(err)
+	is.Equal("APP_INDENT := $()  foo\n", string(b))
+
+	c.Map["APP_FOO"] = "foo\nbar"
+	c.refreshKeys()
+	_, err = MarshalMake(c)
+	is.True(err != nil) // Newlines not supported
+}
+
+func TestExportWinService(t *testing.T) {
+	is := testutil.Setup(t)
+
+	c := &conf{}
+	c.Map = map[string]string{
+		"APP_FOO":   "foo bar",
+		"APP_QUOTE": "it's $HOME",
+	}
+	c.refreshKeys()
+
+	b, err := MarshalWinService(c)
+	is.NoErr(err)
+	is.Equal(
+		"param([Parameter(Mandatory=$true)][string]$ServiceName)\n"+
+			`Set-ItemProperty -Path "HKLM:\SYSTEM\CurrentControlSet\Services\$ServiceName"`+
+			" -Name Environment -Type MultiString -Value @(\n"+
+			"    'APP_FOO=foo bar',\n"+
+			"    'APP_QUOTE=it''s $HOME'\n"+
+			")\n",
+		string(b))
+
+	c.Map["APP_FOO"] = "foo\nbar"
+	_, err = MarshalWinService(c)
+	is.True(err != nil) // Newlines not supported
+}
@@ -0,0 +1,54 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"path/filepath"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/pkg/errors"
+)
+
+// fmtConfig returns the config files for the envs, as per the all and env
+// flags, that are not in canonical form: keys sorted, JSON indented with
+// four spaces, and env values quoted where required, see marshalComments.
+// Comments are kept. With the dry run flag the exit code is set if
+// files are not formatted, e.g. for use as a pre-commit hook
+func fmtConfig(in *CmdIn) (
+	buf *bytes.Buffer, files []File, exitCode int, err error) {
+
+	buf = new(bytes.Buffer)
+
+	envs, err := updateEnvs(in)
+	if err != nil {
+		return buf, files, exitCode, err
+	}
+	for _, env := range envs {
+		configPath, b, err := readConfigFile(in.dirs, in.AppDir, env)
+		if err != nil {
+			return buf, files, exitCode, err
+		}
+		configMap, err := share.UnmarshalConfig(configPath, b)
+		if err != nil {
+			return buf, files, exitCode, errors.WithMessagef(
+				err, "invalid %s", configPath)
+		}
+		c := &conf{Map: configMap}
+		c.refreshKeys()
+		fileType := filepath.Ext(configPath)
+		formatted, err := marshalComments(c, fileType, readComments(fileType, b))
+		if err != nil {
+			return buf, files, exitCode, errors.WithMessagef(
+				err, "format %s", configPath)
+		}
+		if bytes.Equal(b, formatted) {
+			continue
+		}
+		files = append(files,
+			File{Path: configPath, Buf: bytes.NewBuffer(formatted)})
+	}
+	if in.DryRun && len(files) > 0 {
+		exitCode = 1
+	}
+
+	return buf, files, exitCode, nil
+}
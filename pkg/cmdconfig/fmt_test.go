@@ -0,0 +1,180 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestFmtConfig(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	devPath := filepath.Join(tmp, "config.dev.json")
+	err = os.WriteFile(devPath, []byte(`{"APP_FOO": "foo", "APP_BAR": "bar"}`),
+		perms)
+	is.NoErr(err)
+	prodPath := filepath.Join(tmp, ".env.prod.sh")
+	err = os.WriteFile(prodPath, []byte(`APP_NAME=foo bar
+# Comments are kept
+  export   APP_EMPTY=""
+`), perms)
+	is.NoErr(err)
+	stagePath := filepath.Join(tmp, "config.stage.yaml")
+	err = os.WriteFile(stagePath, []byte("APP_FOO: foo\n"), perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = "dev,prod,stage"
+	in.Fmt = true
+	in.DryRun = true
+
+	// Dry run lists files that are not formatted
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdFmt, out.Cmd)
+	is.Equal(1, out.ExitCode)
+	stdout := new(bytes.Buffer)
+	in.Stdout = stdout
+	exitCode, err := in.Process(out)
+	is.NoErr(err)
+	is.Equal(1, exitCode)
+	is.Equal(devPath+"\n"+prodPath+"\n", stdout.String())
+
+	in.DryRun = false
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(0, out.ExitCode)
+	_, err = in.Process(out)
+	is.NoErr(err)
+	b, err := os.ReadFile(devPath)
+	is.NoErr(err)
+	is.Equal("{\n    \"APP_BAR\": \"bar\",\n    \"APP_FOO\": \"foo\"\n}", string(b))
+	b, err = os.ReadFile(prodPath)
+	is.NoErr(err)
+	is.Equal(`# Comments are kept
+export APP_EMPTY=''
+export APP_NAME='foo bar'
+`, string(b))
+
+	// Formatted files are not changed
+	in.DryRun = true
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(0, out.ExitCode)
+	is.Equal(0, len(out.Files))
+}
+
+func TestFmtConfigComments(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	prodPath := filepath.Join(tmp, ".env.prod.sh")
+	err = os.WriteFile(prodPath, []byte(`# Header separated by a blank line
+
+
+# Bar
+APP_BAR=bar
+APP_NAME=foo
+
+# Trailing
+`), perms)
+	is.NoErr(err)
+	stagePath := filepath.Join(tmp, "config.stage.yaml")
+	err = os.WriteFile(stagePath, []byte(`APP_FOO: foo # inline
+APP_BAR: 'it''s # not a comment' # quoted
+APP_URL: http://example.com/#anchor
+`), perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = "prod,stage"
+	in.Fmt = true
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	_, err = in.Process(out)
+	is.NoErr(err)
+	b, err := os.ReadFile(prodPath)
+	is.NoErr(err)
+	is.Equal(`# Header separated by a blank line
+
+# Bar
+export APP_BAR=bar
+export APP_NAME=foo
+
+# Trailing
+`, string(b))
+	b, err = os.ReadFile(stagePath)
+	is.NoErr(err)
+	is.Equal(`APP_BAR: 'it''s # not a comment' # quoted
+APP_FOO: foo # inline
+APP_URL: http://example.com/#anchor
+`, string(b))
+
+	// Formatted files are not changed
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(0, len(out.Files))
+
+	// Inline comments are moved above the key for env files
+	c := &conf{Map: map[string]string{"APP_FOO": "foo"}}
+	c.refreshKeys()
+	b, err = marshalComments(c, share.FileTypeSH,
+		readComments(share.FileTypeYAML, []byte("APP_FOO: foo # inline\n")))
+	is.NoErr(err)
+	is.Equal("# inline\nexport APP_FOO=foo\n", string(b))
+}
+
+func TestFmtConfigHeader(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	// The first key is not the first key when sorted
+	devPath := filepath.Join(tmp, "config.dev.yaml")
+	err = os.WriteFile(devPath,
+		[]byte("# top\n\n# foo\nAPP_FOO: foo\nAPP_BAR: bar\n"), perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	in.Fmt = true
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	_, err = in.Process(out)
+	is.NoErr(err)
+	b, err := os.ReadFile(devPath)
+	is.NoErr(err)
+	is.Equal("# top\n\nAPP_BAR: bar\n# foo\nAPP_FOO: foo\n", string(b))
+
+	// Formatted files are not changed
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(0, len(out.Files))
+}
@@ -3,15 +3,26 @@ package cmdconfig
 import (
 	"bytes"
 	"fmt"
+	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"text/template"
 	"unicode"
 
+	"github.com/mozey/config/pkg/share"
 	"github.com/pkg/errors"
 )
 
+// Precedence of env and package vars in generated code
+const (
+	PrecedenceEnv  = "env"
+	PrecedenceVars = "vars"
+)
+
 func KeyPrefixTemplate(prefix string) string {
 	return fmt.Sprintf("%sTEMPLATE", prefix)
 }
@@ -28,6 +39,33 @@ type GenerateKey struct {
 	KeyPrefix  string
 	KeyPrivate string
 	Key        string
+	// Type declared in FileNameTypes, empty for untyped keys
+	Type string
+	// GoType returned by the getter of typed keys
+	GoType string
+	// Parse expression for typed keys, returns (value, error)
+	Parse string
+	// Default value as a Go string literal, empty if there is no default
+	Default string
+	// Required keys must not be empty, see MustNew
+	Required bool
+	// Locked keys are ignored by the generated SetEnv
+	Locked bool
+	// Secret keys are redacted by the generated GetMap
+	Secret bool
+	// FlagName for the generated RegisterFlags, e.g. template-fiz
+	FlagName string
+	// StructTag for the generated Env struct, a Go string literal
+	StructTag string
+	// Deprecated keys print a warning when the getter is called
+	Deprecated bool
+	// ReplacedByPrefix is the key replacing a deprecated key, with prefix
+	ReplacedByPrefix string
+	// ReplacedBy is the getter of the replacement,
+	// empty if the replacement is not in the config
+	ReplacedBy string
+	// ReplacedByPrivate is the field of the replacement
+	ReplacedByPrivate string
 }
 
 type TemplateParam struct {
@@ -49,38 +87,145 @@ type GenerateData struct {
 	AppDir       string
 	Keys         []GenerateKey
 	TemplateKeys []TemplateKey
+	// TypedKeys are parsed and validated, see FileNameTypes
+	TypedKeys []GenerateKey
+	// Imports required by TypedKeys
+	Imports []string
+	// ParseImports required by the Parse expressions of TypedKeys
+	ParseImports []string
+	// TypeImports required by the Go types of TypedKeys, e.g. time.Duration
+	TypeImports []string
 	// KeyMap can be used to lookup an index in Keys given a key
 	KeyMap map[string]int
+	// KeysHash of all config file keys, see share.KeysHash
+	KeysHash string
+	// Must getters are generated
+	Must bool
+	// Precedence is the default EnvPrecedence in generated code
+	Precedence string
+	// Watch generates FileNameWatchGo
+	Watch bool
+	// ConfigTest generates the DirConfigTest package
+	ConfigTest bool
+	// BindFlags generates FileNameFlagsGo
+	BindFlags bool
+	// NoVars omits the package vars set with ldflags, and SetVars
+	NoVars bool
+	// Envconfig generates FileNameEnvconfigGo
+	Envconfig bool
+	// ImportPath of the generated config package, see importPath
+	ImportPath string
 }
 
+// NewGenerateData reads config and returns data for executing templates
 func NewGenerateData(in *CmdIn) (data *GenerateData, err error) {
-	// Init
-	data = &GenerateData{
-		Prefix: in.Prefix,
-		AppDir: in.AppDir,
+	config, err := newGenerateConf(in)
+	if err != nil {
+		return &GenerateData{Prefix: in.Prefix, AppDir: in.AppDir}, err
+	}
+	schema, err := readGenerateSchema(in, config)
+	if err != nil {
+		return &GenerateData{Prefix: in.Prefix, AppDir: in.AppDir}, err
 	}
+	return newGenerateData(in, config, config.Keys, schema), nil
+}
 
-	_, config, err := newConf(confParams{
+// newGenerateConf reads config for the generate command
+func newGenerateConf(in *CmdIn) (config *conf, err error) {
+	_, config, err = newConf(confParams{
+		dirs:   in.dirs,
+		prefix: in.Prefix,
 		appDir: in.AppDir,
 		env:    in.Env,
 		extend: in.Extend,
 		merge:  in.Merge,
+		parent: in.Parent,
 	})
+	return config, err
+}
+
+// readGenerateSchema reads the schema for the generate command.
+// With the sample defaults flag, sample values are used as defaults
+func readGenerateSchema(in *CmdIn, config *conf) (schema Schema, err error) {
+	schema, err = readSchema(in.dirs, in.AppDir)
 	if err != nil {
-		return data, err
+		return schema, err
+	}
+	if !in.SampleDefaults {
+		return schema, nil
+	}
+	_, sample, err := newConf(confParams{
+		dirs:   in.dirs,
+		prefix: in.Prefix,
+		appDir: in.AppDir,
+		env:    fmt.Sprintf("%s%s", share.SamplePrefix(), in.Env),
+		extend: in.Extend,
+		merge:  in.Merge,
+		parent: in.Parent,
+	})
+	if err != nil {
+		return schema, errors.WithMessagef(err, "sample defaults")
+	}
+	schema.sampleDefaults(config, sample)
+	return schema, nil
+}
+
+// newGenerateData returns data for executing templates,
+// only the listed config keys are included
+func newGenerateData(
+	in *CmdIn, config *conf, configKeys []string, schema Schema) (
+	data *GenerateData) {
+
+	// Init
+	data = &GenerateData{
+		Prefix: in.Prefix,
+		AppDir: in.AppDir,
+		Must:   in.Must,
+		Watch:  in.Watch,
+		// ImportPath is set by generateTarget
+		ConfigTest: in.ConfigTest,
+		BindFlags:  in.BindFlags,
+		NoVars:     in.NoVars,
+		Envconfig:  in.Envconfig,
+		// Env overrides package vars by default
+		Precedence: PrecedenceEnv,
+	}
+	if in.Precedence != "" {
+		data.Precedence = in.Precedence
+	}
+
+	// Hash of all keys, also for targets with a subset of the keys,
+	// since the env contains all keys at runtime
+	hashKeys := make([]string, 0, len(config.Keys))
+	for _, key := range config.Keys {
+		if key != fmt.Sprintf("%vDIR", in.Prefix) {
+			hashKeys = append(hashKeys, key)
+		}
 	}
+	data.KeysHash = share.KeysHash(hashKeys)
 
 	// APP_DIR is usually not set in the config.json file
-	keys := make([]string, len(config.Keys))
-	copy(keys, config.Keys)
+	keys := make([]string, len(configKeys))
+	copy(keys, configKeys)
 	keys = append(keys, fmt.Sprintf("%vDIR", in.Prefix))
 
 	data.Keys = make([]GenerateKey, len(keys))
 	data.TemplateKeys = make([]TemplateKey, 0)
+	data.TypedKeys = make([]GenerateKey, 0)
 	data.KeyMap = make(map[string]int)
+	imports := make(map[string]bool)
+	parseImports := make(map[string]bool)
+	typeImports := make(map[string]bool)
+	if in.Must {
+		imports["strings"] = true
+	}
 
 	configFileKeys := make(map[string]bool)
 	templateKeys := make([]GenerateKey, 0)
+	generateKeys := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		generateKeys[key] = true
+	}
 
 	// Prepare data for generating config helper files
 	for i, keyWithPrefix := range keys {
@@ -91,6 +236,40 @@ func NewGenerateData(in *CmdIn) (data *GenerateData, err error) {
 			KeyPrivate: ToPrivate(formattedKey),
 			Key:        formattedKey,
 		}
+		generateKey.FlagName = strings.ReplaceAll(
+			strings.ToLower(strings.TrimPrefix(keyWithPrefix, in.Prefix)), "_", "-")
+		generateKey.Locked = schema[keyWithPrefix].Locked
+		generateKey.Secret = schema[keyWithPrefix].Secret ||
+			in.manifest.secretKey(keyWithPrefix)
+		// Keys are required unless optional or deprecated,
+		// APP_DIR is set by the user
+		generateKey.Required = !schema[keyWithPrefix].Optional &&
+			!schema[keyWithPrefix].Deprecated &&
+			keyWithPrefix != fmt.Sprintf("%vDIR", in.Prefix)
+		generateKey.Deprecated = schema[keyWithPrefix].Deprecated
+		if replacement := schema[keyWithPrefix].ReplacedBy; replacement != "" {
+			generateKey.ReplacedByPrefix = replacement
+			if generateKeys[replacement] {
+				generateKey.ReplacedBy = FormatKey(in.Prefix, replacement)
+				generateKey.ReplacedByPrivate = ToPrivate(generateKey.ReplacedBy)
+			}
+		}
+		if schema[keyWithPrefix].Default != "" {
+			generateKey.Default = strconv.Quote(schema[keyWithPrefix].Default)
+		}
+		generateKey.StructTag = structTag(
+			keyWithPrefix, schema[keyWithPrefix].Default)
+		if t, ok := goTypes[schema[keyWithPrefix].Type]; ok {
+			generateKey.Type = schema[keyWithPrefix].Type
+			generateKey.GoType = t.Name
+			generateKey.Parse = t.parseExpr(generateKey.KeyPrivate)
+			data.TypedKeys = append(data.TypedKeys, generateKey)
+			imports[t.Import] = true
+			parseImports[t.Import] = true
+			if strings.Contains(t.Name, ".") {
+				typeImports[t.Import] = true
+			}
+		}
 		data.Keys[i] = generateKey
 		data.KeyMap[formattedKey] = i
 
@@ -129,7 +308,95 @@ func NewGenerateData(in *CmdIn) (data *GenerateData, err error) {
 		data.TemplateKeys = append(data.TemplateKeys, templateKey)
 	}
 
-	return data, nil
+	data.Imports = make([]string, 0, len(imports))
+	for imp := range imports {
+		data.Imports = append(data.Imports, imp)
+	}
+	sort.Strings(data.Imports)
+	data.ParseImports = make([]string, 0, len(parseImports))
+	for imp := range parseImports {
+		data.ParseImports = append(data.ParseImports, imp)
+	}
+	sort.Strings(data.ParseImports)
+	data.TypeImports = make([]string, 0, len(typeImports))
+	for imp := range typeImports {
+		data.TypeImports = append(data.TypeImports, imp)
+	}
+	sort.Strings(data.TypeImports)
+
+	return data
+}
+
+// extensionTargets returns the config keys to generate for each target dir.
+// Keys loaded from an extension config file are generated in targets
+// inside the extension dir, instead of the root config package.
+// Extensions without a target inside the extension dir are generated
+// in the targets outside extension dirs, as before
+func extensionTargets(appDir string, dirs []string, c *conf) (
+	targets map[string][]string) {
+
+	// Extension dirs, i.e. sub dirs of appDir that keys were loaded from.
+	// Note that a parent config, see newMergedConf, is not inside appDir
+	extDirs := make(map[string]bool)
+	for _, dir := range c.Dirs {
+		rel, err := filepath.Rel(appDir, dir)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		extDirs[filepath.Clean(dir)] = true
+	}
+
+	// Find the extension each target is in, if any
+	owners := make(map[string]string)
+	owned := make(map[string]bool)
+	for _, dir := range dirs {
+		owner := ""
+		for extDir := range extDirs {
+			rel, err := filepath.Rel(extDir, dir)
+			if err != nil || strings.HasPrefix(rel, "..") {
+				continue
+			}
+			// Nested extensions, the longest path wins
+			if len(extDir) > len(owner) {
+				owner = extDir
+			}
+		}
+		owners[dir] = owner
+		if owner != "" {
+			owned[owner] = true
+		}
+	}
+
+	targets = make(map[string][]string)
+	for _, dir := range dirs {
+		keys := make([]string, 0)
+		for _, key := range c.Keys {
+			keyDir := filepath.Clean(c.Dirs[key])
+			if owners[dir] != "" {
+				if keyDir == owners[dir] {
+					keys = append(keys, key)
+				}
+			} else if !owned[keyDir] {
+				keys = append(keys, key)
+			}
+		}
+		targets[dir] = keys
+	}
+
+	return targets
+}
+
+// structTag returns the tag of the Env struct field for key,
+// compatible with caarlos0/env and kelseyhightower/envconfig
+func structTag(key, defaultValue string) string {
+	tag := fmt.Sprintf(`env:%q envconfig:%q`, key, key)
+	if defaultValue != "" {
+		tag += fmt.Sprintf(` envDefault:%q default:%q`, defaultValue, defaultValue)
+	}
+	if strings.Contains(tag, "`") {
+		return strconv.Quote(tag)
+	}
+	return "`" + tag + "`"
 }
 
 // GetTemplateParams from template, e.g.
@@ -169,11 +436,12 @@ func ToPrivate(str string) string {
 	return ""
 }
 
-// executeTemplate executes the template for the specified file name and data
-func executeTemplate(in *CmdIn, fileName string, data *GenerateData) (
+// executeTemplate executes the template for the specified file name and data,
+// dir is the path to generate the file in
+func executeTemplate(dir string, fileName string, data *GenerateData) (
 	filePath string, buf *bytes.Buffer, err error) {
 
-	filePath = filepath.Join(in.AppDir, in.Generate, fileName)
+	filePath = filepath.Join(dir, fileName)
 	textTemplate, err := GetTemplate(fileName)
 	if err != nil {
 		return filePath, buf, err
@@ -188,14 +456,43 @@ func executeTemplate(in *CmdIn, fileName string, data *GenerateData) (
 	return filePath, buf, nil
 }
 
+// generatePaths returns the Generate path followed by GenerateAll
+func (in *CmdIn) generatePaths() (paths []string) {
+	if in.Generate != "" {
+		paths = append(paths, in.Generate)
+	}
+	return append(paths, in.GenerateAll...)
+}
+
 // generateHelpers generates helper files, config.go, template.go, etc.
 // These files can then be included by users in their own projects
-// when they import the config package at the path as per the "generate" flag
-func generateHelpers(in *CmdIn) (files []File, err error) {
-	// Generate data for executing template
-	data, err := NewGenerateData(in)
+// when they import the config package at the path as per the "generate" flag.
+// The flag may be repeated to generate multiple packages in one run,
+// config is parsed once and shared by all targets
+func generateHelpers(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
+	buf = new(bytes.Buffer)
+
+	// Config is read once and shared by all targets
+	config, err := newGenerateConf(in)
 	if err != nil {
-		return files, err
+		return buf, files, err
+	}
+	schema, err := readGenerateSchema(in, config)
+	if err != nil {
+		return buf, files, err
+	}
+	files, err = generateConf(in, buf, config, schema)
+	return buf, files, err
+}
+
+// generateConf generates helper files for config and schema,
+// in the targets listed by the generate and typescript flags
+func generateConf(in *CmdIn, buf *bytes.Buffer, config *conf, schema Schema) (
+	files []File, err error) {
+
+	for _, key := range schema.missingKeys(config) {
+		in.warn(fmt.Sprintf("%s declared in %s is not in the config file",
+			key, FileNameTypes))
 	}
 
 	// NOTE buf is usually filled with content to be written to stdout.
@@ -205,25 +502,96 @@ func generateHelpers(in *CmdIn) (files []File, err error) {
 	// files contains file paths and generated code,
 	// depending on the dry run flag it may be written
 	// to stdout or the file system
+	files = make([]File, 0)
+
+	paths := in.generatePaths()
+	dirs := make([]string, 0, len(paths))
+	unique := make(map[string]bool)
+	for _, target := range paths {
+		dir := filepath.Join(in.AppDir, target)
+		if unique[dir] {
+			return files, errors.Errorf("duplicate generate path %s", target)
+		}
+		unique[dir] = true
+		dirs = append(dirs, dir)
+	}
+
+	// Extension keys are generated in targets inside the extension dir
+	targets := extensionTargets(in.AppDir, dirs, config)
+
+	for _, dir := range dirs {
+		// Generate data for executing template
+		data := newGenerateData(in, config, targets[dir], schema)
+
+		targetFiles, err := generateTarget(in, buf, dir, data)
+		if err != nil {
+			return files, err
+		}
+		files = append(files, targetFiles...)
+	}
+
+	files, err = generateStaleTargets(in, buf, dirs, files)
+	if err != nil {
+		return files, err
+	}
+
+	if in.TypeScript != "" {
+		tsFiles, err := generateTypeScript(
+			in, newGenerateData(in, config, config.Keys, schema))
+		if err != nil {
+			return files, err
+		}
+		files = append(files, tsFiles...)
+	}
+
+	return files, nil
+}
+
+// generateTypeScript generates definitions for the keys,
+// e.g. for a frontend that consumes the same env vars
+func generateTypeScript(in *CmdIn, data *GenerateData) (
+	files []File, err error) {
+
+	dir := filepath.Join(in.AppDir, in.TypeScript)
+	fileNames := []string{FileNameConfigDTs}
+	if in.TypeScriptLoader {
+		fileNames = append(fileNames, FileNameLoaderTs)
+	}
+	for _, fileName := range fileNames {
+		filePath, b, err := executeTemplate(dir, fileName, data)
+		if err != nil {
+			return files, err
+		}
+		files = append(files, File{Path: filePath, Buf: b})
+	}
+	return files, nil
+}
+
+// generateTarget generates helper files in dir,
+// messages about orphaned files are written to buf
+func generateTarget(
+	in *CmdIn, buf *bytes.Buffer, dir string, data *GenerateData) (
+	files []File, err error) {
+
 	files = make([]File, 3)
 
-	filePath, buf, err := executeTemplate(in, FileNameConfigGo, data)
+	filePath, b, err := executeTemplate(dir, FileNameConfigGo, data)
 	if err != nil {
 		return files, err
 	}
 	files[0] = File{
 		Path: filePath,
-		Buf:  bytes.NewBuffer(buf.Bytes()),
+		Buf:  bytes.NewBuffer(b.Bytes()),
 	}
 
 	if len(data.TemplateKeys) > 0 {
-		filePath, buf, err = executeTemplate(in, FileNameTemplateGo, data)
+		filePath, b, err = executeTemplate(dir, FileNameTemplateGo, data)
 		if err != nil {
 			return files, err
 		}
 		files[1] = File{
 			Path: filePath,
-			Buf:  bytes.NewBuffer(buf.Bytes()),
+			Buf:  bytes.NewBuffer(b.Bytes()),
 		}
 	} else {
 		files[1] = File{
@@ -232,14 +600,405 @@ func generateHelpers(in *CmdIn) (files []File, err error) {
 		}
 	}
 
-	filePath, buf, err = executeTemplate(in, FileNameFnGo, data)
+	filePath, b, err = executeTemplate(dir, FileNameFnGo, data)
 	if err != nil {
 		return files, err
 	}
 	files[2] = File{
 		Path: filePath,
-		Buf:  bytes.NewBuffer(buf.Bytes()),
+		Buf:  bytes.NewBuffer(b.Bytes()),
+	}
+
+	for _, fileName := range []string{FileNameSafeGo, FileNameConfigerGo} {
+		filePath, b, err = executeTemplate(dir, fileName, data)
+		if err != nil {
+			return files, err
+		}
+		files = append(files, File{
+			Path: filePath,
+			Buf:  bytes.NewBuffer(b.Bytes()),
+		})
+	}
+
+	// The configtest package is optional, it imports the config package
+	if data.ConfigTest {
+		data.ImportPath, err = importPath(dir)
+		if err != nil {
+			return files, err
+		}
+		filePath, b, err = executeTemplate(
+			filepath.Join(dir, DirConfigTest), FileNameConfigTestGo, data)
+		if err != nil {
+			return files, err
+		}
+		files = append(files, File{
+			Path: filePath,
+			Buf:  bytes.NewBuffer(b.Bytes()),
+		})
+	}
+
+	if data.BindFlags {
+		filePath, b, err = executeTemplate(dir, FileNameFlagsGo, data)
+		if err != nil {
+			return files, err
+		}
+		files = append(files, File{
+			Path: filePath,
+			Buf:  bytes.NewBuffer(b.Bytes()),
+		})
+	}
+
+	if data.Envconfig {
+		filePath, b, err = executeTemplate(dir, FileNameEnvconfigGo, data)
+		if err != nil {
+			return files, err
+		}
+		files = append(files, File{
+			Path: filePath,
+			Buf:  bytes.NewBuffer(b.Bytes()),
+		})
+	}
+
+	// The watch file is optional, it depends on fsnotify
+	if data.Watch {
+		filePath, b, err = executeTemplate(dir, FileNameWatchGo, data)
+		if err != nil {
+			return files, err
+		}
+		files = append(files, File{
+			Path: filePath,
+			Buf:  bytes.NewBuffer(b.Bytes()),
+		})
+	}
+
+	// Files generated previously might not be produced anymore,
+	// e.g. template.go after the last template key was removed
+	orphans, err := orphanedFiles(dir, files)
+	if err != nil {
+		return files, err
+	}
+	deleted, err := cleanOrphans(in, buf, dir, orphans)
+	if err != nil {
+		return files, err
+	}
+	files = append(files, deleted...)
+
+	// Manifest of generated files
+	files = append(files, File{
+		Path: filepath.Join(dir, FileNameGenerated),
+		Buf:  generatedManifest(dir, files),
+	})
+
+	return files, nil
+}
+
+// cleanOrphans returns files to delete the orphans with the clean flag,
+// and dirs that are empty after that. Otherwise the orphans are reported
+func cleanOrphans(in *CmdIn, buf *bytes.Buffer, dir string, orphans []string) (
+	files []File, err error) {
+
+	files = make([]File, 0)
+	if !in.Clean {
+		for _, orphan := range orphans {
+			buf.WriteString(fmt.Sprintf(
+				"orphaned generated file %s, use -%s to delete it\n",
+				orphan, FlagClean))
+		}
+		return files, nil
+	}
+	deleted := make(map[string]bool)
+	for _, orphan := range orphans {
+		deleted[orphan] = true
+		files = append(files, File{
+			Path: orphan,
+			Buf:  bytes.NewBuffer([]byte("")),
+			Del:  true,
+		})
+	}
+	dirs, err := emptiedDirs(dir, deleted)
+	if err != nil {
+		return files, err
+	}
+	for _, d := range dirs {
+		files = append(files, File{Path: d, Buf: new(bytes.Buffer), Del: true})
+	}
+	return files, nil
+}
+
+// emptiedDirs returns the dirs in root, including root, that are empty
+// after the deleted files are removed. Deeper dirs are listed first,
+// so they are removed before their parents
+func emptiedDirs(root string, deleted map[string]bool) (
+	dirs []string, err error) {
+
+	candidates := make(map[string]bool)
+	for filePath := range deleted {
+		for d := filepath.Dir(filePath); ; d = filepath.Dir(d) {
+			rel, err := filepath.Rel(root, d)
+			if err != nil || strings.HasPrefix(rel, "..") {
+				break
+			}
+			candidates[d] = true
+			if rel == "." {
+				break
+			}
+		}
+	}
+	sorted := make([]string, 0, len(candidates))
+	for d := range candidates {
+		sorted = append(sorted, d)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return len(sorted[i]) > len(sorted[j])
+	})
+
+	dirs = make([]string, 0)
+	removed := make(map[string]bool)
+	for _, d := range sorted {
+		entries, err := os.ReadDir(d)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return dirs, errors.WithStack(err)
+		}
+		empty := true
+		for _, entry := range entries {
+			entryPath := filepath.Join(d, entry.Name())
+			if !deleted[entryPath] && !removed[entryPath] {
+				empty = false
+				break
+			}
+		}
+		if empty {
+			removed[d] = true
+			dirs = append(dirs, d)
+		}
+	}
+	return dirs, nil
+}
+
+// generatedDirSuffix marks target dirs in the manifest in APP_DIR,
+// other lines are file names, see generatedManifest
+const generatedDirSuffix = "/"
+
+// readGenerated returns the lines in the manifest in dir,
+// lines outside dir are skipped
+func readGenerated(dir string) (lines []string, err error) {
+	lines = make([]string, 0)
+	b, err := os.ReadFile(filepath.Join(dir, FileNameGenerated))
+	if err != nil && !os.IsNotExist(err) {
+		return lines, errors.WithStack(err)
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		// Files outside dir are never deleted
+		if strings.Contains(line, "..") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// generateStaleTargets checks target dirs listed in the manifest in APP_DIR,
+// that are not generated anymore, e.g. after the generate path changed.
+// Files generated in those dirs are orphaned. The manifest lists the target
+// dirs, and stale dirs that still have orphans without the clean flag
+func generateStaleTargets(
+	in *CmdIn, buf *bytes.Buffer, dirs []string, files []File) (
+	[]File, error) {
+
+	current := make(map[string]bool)
+	recorded := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		current[dir] = true
+		recorded = append(recorded, generatedName(in.AppDir, dir))
 	}
 
+	lines, err := readGenerated(in.AppDir)
+	if err != nil {
+		return files, err
+	}
+	for _, line := range lines {
+		if !strings.HasSuffix(line, generatedDirSuffix) {
+			continue
+		}
+		dir := filepath.Join(in.AppDir, filepath.FromSlash(line))
+		if current[dir] {
+			continue
+		}
+		orphans, err := orphanedFiles(dir, nil)
+		if err != nil {
+			return files, err
+		}
+		if len(orphans) == 0 {
+			continue
+		}
+		if in.Clean {
+			// The manifest of the stale target is not needed anymore
+			orphans = append(orphans, filepath.Join(dir, FileNameGenerated))
+		} else {
+			recorded = append(recorded, generatedName(in.AppDir, dir))
+		}
+		deleted, err := cleanOrphans(in, buf, dir, orphans)
+		if err != nil {
+			return files, err
+		}
+		files = append(files, deleted...)
+	}
+
+	if len(recorded) == 0 && len(lines) == 0 {
+		// Nothing to record, e.g. only TypeScript is generated
+		return files, nil
+	}
+	sort.Strings(recorded)
+	b := new(bytes.Buffer)
+	for _, name := range recorded {
+		b.WriteString(strings.TrimSuffix(name, generatedDirSuffix))
+		b.WriteString(generatedDirSuffix + "\n")
+	}
+	manifestPath := filepath.Join(in.AppDir, FileNameGenerated)
+	for i, file := range files {
+		if file.Path == manifestPath && !file.Del {
+			// APP_DIR is also a target
+			files[i].Buf.Write(b.Bytes())
+			return files, nil
+		}
+	}
+	manifest := bytes.NewBufferString(GeneratedHeader)
+	manifest.WriteString("\n")
+	manifest.Write(b.Bytes())
+	files = append(files, File{Path: manifestPath, Buf: manifest})
 	return files, nil
 }
+
+// importPath returns the import path of the package in dir,
+// the module path is read from the nearest go.mod file
+func importPath(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	for moduleDir := dir; ; {
+		b, err := os.ReadFile(filepath.Join(moduleDir, "go.mod"))
+		if err == nil {
+			modulePath := ""
+			for _, line := range strings.Split(string(b), "\n") {
+				fields := strings.Fields(line)
+				if len(fields) == 2 && fields[0] == "module" {
+					modulePath = strings.Trim(fields[1], `"`)
+					break
+				}
+			}
+			if modulePath == "" {
+				return "", errors.Errorf("module path not found in %s",
+					filepath.Join(moduleDir, "go.mod"))
+			}
+			rel, err := filepath.Rel(moduleDir, dir)
+			if err != nil {
+				return "", errors.WithStack(err)
+			}
+			return path.Join(modulePath, filepath.ToSlash(rel)), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", errors.WithStack(err)
+		}
+		parent := filepath.Dir(moduleDir)
+		if parent == moduleDir {
+			return "", errors.Errorf("go.mod not found for %s", dir)
+		}
+		moduleDir = parent
+	}
+}
+
+// generatedName returns the path of a generated file relative to dir,
+// with forward slashes, e.g. configtest/configtest.go
+func generatedName(dir, filePath string) string {
+	name, err := filepath.Rel(dir, filePath)
+	if err != nil {
+		return filepath.Base(filePath)
+	}
+	return filepath.ToSlash(name)
+}
+
+// generatedManifest lists the names of files to be written, see generatedName
+func generatedManifest(dir string, files Files) *bytes.Buffer {
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		// empty file.Path implies nothing was generated
+		if file.Path != "" && !file.Del {
+			names = append(names, generatedName(dir, file.Path))
+		}
+	}
+	sort.Strings(names)
+	buf := bytes.NewBufferString(GeneratedHeader)
+	buf.WriteString("\n")
+	for _, name := range names {
+		buf.WriteString(name)
+		buf.WriteString("\n")
+	}
+	return buf
+}
+
+// orphanedFiles returns paths to files in dir that were generated previously,
+// but are not included in files. Candidates are read from the manifest,
+// files with the default names are also checked for the generated header
+func orphanedFiles(dir string, files Files) (orphans []string, err error) {
+	orphans = make([]string, 0)
+
+	produced := make(map[string]bool)
+	for _, file := range files {
+		if file.Path != "" {
+			produced[generatedName(dir, file.Path)] = true
+		}
+	}
+
+	// Files listed in the manifest were generated by a previous run
+	manifest := make(map[string]bool)
+	lines, err := readGenerated(dir)
+	if err != nil {
+		return orphans, err
+	}
+	for _, line := range lines {
+		if !strings.HasSuffix(line, generatedDirSuffix) {
+			manifest[line] = true
+		}
+	}
+
+	// Projects generated before the manifest was added
+	candidates := []string{
+		FileNameConfigGo, FileNameTemplateGo, FileNameFnGo, FileNameSafeGo,
+		FileNameConfigerGo, FileNameFlagsGo, FileNameEnvconfigGo, FileNameWatchGo,
+		path.Join(DirConfigTest, FileNameConfigTestGo)}
+	for name := range manifest {
+		candidates = append(candidates, name)
+	}
+	sort.Strings(candidates)
+
+	checked := make(map[string]bool)
+	for _, name := range candidates {
+		if produced[name] || checked[name] {
+			continue
+		}
+		checked[name] = true
+		filePath := filepath.Join(dir, filepath.FromSlash(name))
+		b, err := os.ReadFile(filePath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return orphans, errors.WithStack(err)
+		}
+		// Don't touch files the user created with a default name
+		if manifest[name] ||
+			strings.HasPrefix(strings.TrimSpace(string(b)), GeneratedHeader) {
+			orphans = append(orphans, filePath)
+		}
+	}
+
+	return orphans, nil
+}
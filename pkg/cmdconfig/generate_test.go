@@ -1,9 +1,20 @@
 package cmdconfig
 
 import (
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 
 	config "github.com/mozey/config/pkg/cmdconfig/testdata"
@@ -30,6 +41,9 @@ func TestGenerateHelpersPrint(t *testing.T) {
 	in.DryRun = true // Do not write files to disk
 	in.Prefix = "APP_"
 	in.Env = share.EnvDev
+	in.Must = true
+	in.BindFlags = true
+	in.Envconfig = true
 
 	// Path to generate config helpers is not used since dry run is set.
 	// Compare with TestGenerateHelpers
@@ -41,11 +55,14 @@ func TestGenerateHelpersPrint(t *testing.T) {
 	is.NoErr(err)
 	is.Equal(CmdGenerate, out.Cmd)
 	is.Equal(0, out.ExitCode)
-	is.Equal(3, len(out.Files)) // Unexpected number of files
+	is.Equal(9, len(out.Files)) // Unexpected number of files
 
-	is.Equal(len(out.Files), 3) // Count generated file
 	for _, file := range out.Files {
 		is.True(strings.TrimSpace(file.Path) != "") // File path empty
+		if file.Path == filepath.Join(in.AppDir, FileNameGenerated) {
+			// Manifest of target dirs in APP_DIR
+			continue
+		}
 		fileName := filepath.Base(file.Path)
 		os.WriteFile(
 			filepath.Join("testdata", "compare", fileName),
@@ -75,6 +92,128 @@ func TestGenerateHelpersPrint(t *testing.T) {
 	is.Equal("bar", c.Bar())
 	is.Equal("Buzz", c.Buz())
 	is.Equal("FizzBuzz-FizzBuzz", c.ExecTemplateFiz("-FizzBuzz"))
+	// Secret keys are redacted
+	is.Equal("****", c.GetMap()["APP_BAR"])
+	is.Equal("bar", c.GetMapUnredacted()["APP_BAR"])
+	is.True(!strings.Contains(c.String(), "bar"))
+	is.True(strings.Contains(c.String(), `"APP_FOO":"foo"`))
+	// Typed keys, see testdata/config.types.json
+	is.Equal(8080, c.Port())
+	is.NoErr(c.Validate())
+	c.SetPort("http")
+	is.True(c.Validate() != nil)
+	// Defaults are used if the env is not set
+	t.Setenv("APP_PORT", "")
+	is.Equal(80, config.New().Port())
+
+	// Must getters panic if the value is empty
+	mustPanic := func(f func()) (msg string) {
+		defer func() {
+			msg = fmt.Sprint(recover())
+		}()
+		f()
+		return ""
+	}
+	c = config.MustNew()
+	is.Equal("foo", c.MustFoo())
+	is.Equal(80, c.MustPort())
+	c.SetFoo("")
+	is.Equal("APP_FOO must not be empty", mustPanic(func() { c.MustFoo() }))
+	t.Setenv("APP_FOO", "")
+	t.Setenv("APP_BAR", "")
+	is.Equal("required keys must not be empty: APP_BAR, APP_FOO",
+		mustPanic(func() { config.MustNew() }))
+}
+
+func TestGeneratedPrecedence(t *testing.T) {
+	is := testutil.Setup(t)
+
+	is.Equal(config.PrecedenceEnv, config.EnvPrecedence)
+	defer (func() {
+		config.EnvPrecedence = config.PrecedenceEnv
+		config.EnvAllowlist = map[string]bool{}
+	})()
+
+	t.Setenv("APP_FOO", "env")
+	t.Setenv("APP_BAR", "env")
+	t.Setenv("APP_DIR", "dir")
+	c := config.New()
+	is.Equal("env", c.Foo())
+
+	// Env is ignored, except for allowed keys and APP_DIR
+	config.EnvPrecedence = config.PrecedenceVars
+	config.EnvAllowlist["APP_BAR"] = true
+	c = config.New()
+	is.Equal("", c.Foo())
+	is.Equal("env", c.Bar())
+	is.Equal("dir", c.Dir())
+
+	// Values in the map take precedence
+	c = config.LoadMap(map[string]string{"APP_FOO": "map"})
+	is.Equal("map", c.Foo())
+
+	// Locked keys are never set by env, see testdata/config.types.json
+	t.Setenv("APP_BUZ", "env")
+	config.EnvAllowlist["APP_BUZ"] = true
+	is.True(config.New().Buz() != "env")
+	config.EnvPrecedence = config.PrecedenceEnv
+	is.True(config.New().Buz() != "env")
+}
+
+func TestGenerateSampleDefaults(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"),
+		[]byte(`{"APP_FOO": "", "APP_BAR": "", "APP_BUZ": ""}`), perms)
+	is.NoErr(err)
+	err = os.WriteFile(filepath.Join(tmp, "sample.config.dev.json"),
+		[]byte(`{"APP_FOO": "foo \"bar\"", "APP_BAR": "bar", "APP_BUZ": ""}`),
+		perms)
+	is.NoErr(err)
+	// Schema defaults take precedence
+	err = os.WriteFile(filepath.Join(tmp, FileNameTypes),
+		[]byte(`{"APP_BAR": {"default": "schema"}}`), perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.DryRun = true
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	in.Generate = filepath.Join("pkg", "config")
+
+	// Sample values are not used by default
+	data, err := NewGenerateData(in)
+	is.NoErr(err)
+	is.Equal("", data.Keys[data.KeyMap["Foo"]].Default)
+
+	in.SampleDefaults = true
+	data, err = NewGenerateData(in)
+	is.NoErr(err)
+	is.Equal(`"foo \"bar\""`, data.Keys[data.KeyMap["Foo"]].Default)
+	is.Equal(`"schema"`, data.Keys[data.KeyMap["Bar"]].Default)
+	is.Equal("", data.Keys[data.KeyMap["Buz"]].Default)
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	for _, file := range out.Files {
+		if filepath.Base(file.Path) == FileNameConfigGo {
+			is.True(strings.Contains(file.Buf.String(),
+				`conf.foo = "foo \"bar\""`))
+		}
+	}
+
+	// Sample config file is required
+	err = os.Remove(filepath.Join(tmp, "sample.config.dev.json"))
+	is.NoErr(err)
+	_, err = Cmd(in)
+	is.True(err != nil)
 }
 
 // TestGenerateHelpersSave also covers Files_Save
@@ -93,6 +232,10 @@ func TestGenerateHelpersSave(t *testing.T) {
 	in.Prefix = "APP_"
 	in.Env = share.EnvDev
 
+	in.Must = true // Same as TestGenerateHelpersPrint
+	in.BindFlags = true
+	in.Envconfig = true
+
 	// Convention is to keep the helpers in YOUR_PROJECTS_APP_DIR/pkg/config
 	in.Generate = filepath.Join("pkg", "config")
 
@@ -105,21 +248,30 @@ func TestGenerateHelpersSave(t *testing.T) {
 	is.NoErr(err)
 	err = Copy(configFilePath, dstConfigFilePath)
 	is.NoErr(err)
+	err = Copy(filepath.Join("testdata", FileNameTypes),
+		filepath.Join(tmp, FileNameTypes))
+	is.NoErr(err)
 
 	out, err := Cmd(in)
 	is.NoErr(err)
 	is.Equal(CmdGenerate, out.Cmd)
 	is.Equal(0, out.ExitCode)
-	is.Equal(3, len(out.Files)) // Unexpected number of files
+	is.Equal(9, len(out.Files)) // Unexpected number of files
 
-	// Write the files
-	// TODO in.Process calls fmt.Println, capture stdout and verify output?
-	// See https://github.com/mozey/go-capturer
+	// Write the files, paths are printed to stdout
+	stdout := new(bytes.Buffer)
+	in.Stdout = stdout
 	exitCode, err := in.Process(out)
 	is.NoErr(err)
 	is.Equal(0, exitCode)
+	is.True(strings.Contains(stdout.String(),
+		filepath.Join(tmp, in.Generate, FileNameConfigGo)))
 
 	for _, file := range out.Files {
+		if file.Path == filepath.Join(tmp, FileNameGenerated) {
+			// Manifest of target dirs in APP_DIR
+			continue
+		}
 		fileName := filepath.Base(file.Path)
 
 		// Read generated file from disk
@@ -138,3 +290,813 @@ func TestGenerateHelpersSave(t *testing.T) {
 		}
 	}
 }
+
+func TestGenerateHelpersOrphans(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	in.Generate = filepath.Join("pkg", "config")
+
+	configFilePath, err := share.GetConfigFilePath(
+		tmp, in.Env, share.FileTypeJSON)
+	is.NoErr(err)
+	err = os.WriteFile(configFilePath,
+		[]byte(`{"APP_FOO": "foo", "APP_TEMPLATE_BAR": "{{.Foo}}"}`), perms)
+	is.NoErr(err)
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	_, err = in.Process(out)
+	is.NoErr(err)
+	templatePath := filepath.Join(tmp, in.Generate, FileNameTemplateGo)
+	_, err = os.Stat(templatePath)
+	is.NoErr(err) // template.go must be generated
+
+	// Remove the template key, template.go is not produced anymore
+	err = os.WriteFile(configFilePath, []byte(`{"APP_FOO": "foo"}`), perms)
+	is.NoErr(err)
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.True(strings.Contains(out.Buf.String(), templatePath)) // Orphan reported
+	for _, file := range out.Files {
+		is.True(!file.Del) // Files must not be deleted without the clean flag
+	}
+
+	in.Clean = true
+	out, err = Cmd(in)
+	is.NoErr(err)
+	_, err = in.Process(out)
+	is.NoErr(err)
+	_, err = os.Stat(templatePath)
+	is.True(os.IsNotExist(err)) // Orphan must be deleted
+
+	b, err := os.ReadFile(filepath.Join(tmp, in.Generate, FileNameGenerated))
+	is.NoErr(err)
+	is.True(!strings.Contains(string(b), FileNameTemplateGo))
+
+	// Files at the previous generate path are orphaned
+	oldDir := filepath.Join(tmp, in.Generate)
+	in.Clean = false
+	in.Generate = filepath.Join("internal", "config")
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.True(strings.Contains(out.Buf.String(),
+		filepath.Join(oldDir, FileNameConfigGo))) // Orphan reported
+	_, err = in.Process(out)
+	is.NoErr(err)
+	_, err = os.Stat(oldDir)
+	is.NoErr(err) // Files must not be deleted without the clean flag
+
+	in.Clean = true
+	out, err = Cmd(in)
+	is.NoErr(err)
+	_, err = in.Process(out)
+	is.NoErr(err)
+	_, err = os.Stat(oldDir)
+	is.True(os.IsNotExist(err)) // Emptied dir must be deleted
+	b, err = os.ReadFile(filepath.Join(tmp, FileNameGenerated))
+	is.NoErr(err)
+	is.Equal(GeneratedHeader+"\ninternal/config/\n", string(b))
+}
+
+func TestGenerateWatch(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	in.Generate = filepath.Join("pkg", "config")
+	in.Watch = true
+
+	configFilePath, err := share.GetConfigFilePath(
+		tmp, in.Env, share.FileTypeJSON)
+	is.NoErr(err)
+	err = os.WriteFile(configFilePath, []byte(`{"APP_FOO": "foo"}`), perms)
+	is.NoErr(err)
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	_, err = in.Process(out)
+	is.NoErr(err)
+	// The module does not depend on fsnotify, see TestGenerateWatchCompiles
+	watchPath := filepath.Join(tmp, in.Generate, FileNameWatchGo)
+	_, err = parser.ParseFile(token.NewFileSet(), watchPath, nil, 0)
+	is.NoErr(err)
+	b, err := os.ReadFile(watchPath)
+	is.NoErr(err)
+	is.True(strings.Contains(string(b), "func WatchEnvs("))
+	is.True(strings.Contains(string(b), "Reloads.Failed(err)"))
+	b, err = os.ReadFile(filepath.Join(tmp, in.Generate, FileNameGenerated))
+	is.NoErr(err)
+	is.True(strings.Contains(string(b), FileNameWatchGo))
+
+	// Without the flag watch.go is orphaned
+	in.Watch = false
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.True(strings.Contains(out.Buf.String(), watchPath))
+}
+
+// stubFSNotify is the subset of the fsnotify API used by watch.go
+var stubFSNotify = `package fsnotify
+
+type Op uint32
+
+const (
+	Create Op = 1 << iota
+	Write
+	Remove
+	Rename
+	Chmod
+)
+
+type Event struct {
+	Name string
+	Op   Op
+}
+
+type Watcher struct {
+	Events chan Event
+	Errors chan error
+}
+
+func NewWatcher() (*Watcher, error) {
+	return &Watcher{Events: make(chan Event), Errors: make(chan error)}, nil
+}
+
+func (w *Watcher) Add(name string) error { return nil }
+
+func (w *Watcher) Close() error { return nil }
+`
+
+// stubReloadTest is added to the generated package
+var stubReloadTest = `package config
+
+import (
+	"os"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	t.Setenv("APP_FOO", "stale")
+	t.Setenv("APP_BAR", "other")
+
+	// Env is not read
+	conf, err := reload(map[string]string{"APP_FOO": "foo"}, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Foo() != "foo" || conf.Bar() != "" {
+		t.Fatal("config must be read from the file only")
+	}
+	if os.Getenv("APP_FOO") != "stale" {
+		t.Fatal("env must not be set")
+	}
+
+	// Removed keys are unset
+	conf, err = reload(map[string]string{"APP_FOO": "foo"},
+		map[string]string{"APP_FOO": "foo", "APP_BAR": "bar"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.Getenv("APP_FOO") != "foo" {
+		t.Fatal("env must be set")
+	}
+	if _, ok := os.LookupEnv("APP_BAR"); ok {
+		t.Fatal("removed key must be unset")
+	}
+}
+`
+
+// goTestModule runs go test in dir, for the module example.com/app that
+// depends on this module and stubFSNotify. Dependencies must be in the
+// module cache. The test is skipped if the go command is not found
+func goTestModule(t *testing.T, dir string, files map[string]string) {
+	is := testutil.Setup(t)
+
+	goCmd, err := exec.LookPath("go")
+	if err != nil || testing.Short() {
+		t.Skip("go command required")
+	}
+	root, err := filepath.Abs(filepath.Join("..", ".."))
+	is.NoErr(err)
+
+	files["go.mod"] = fmt.Sprintf(`module example.com/app
+
+go 1.21
+
+require (
+	github.com/fsnotify/fsnotify v1.7.0
+	github.com/mozey/config v0.0.0
+)
+
+replace github.com/mozey/config => %s
+
+replace github.com/fsnotify/fsnotify => ./fsnotify
+`, strconv.Quote(root))
+	files["fsnotify/go.mod"] = "module github.com/fsnotify/fsnotify\n\ngo 1.21\n"
+	files["fsnotify/fsnotify.go"] = stubFSNotify
+	goSum, err := os.ReadFile(filepath.Join(root, "go.sum"))
+	is.NoErr(err)
+	files["go.sum"] = string(goSum)
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		is.NoErr(os.MkdirAll(filepath.Dir(path), dirPerms))
+		is.NoErr(os.WriteFile(path, []byte(content), perms))
+	}
+
+	cmd := exec.Command(goCmd, "test", "./...")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GOFLAGS=-mod=mod", "GOPROXY=off", "GOSUMDB=off", "APP_DIR="+dir)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s\n%s", err, b)
+	}
+}
+
+// TestGenerateWatchCompiles builds the generated package in a temporary
+// module, fsnotify is not a dependency of this module, so it's stubbed
+func TestGenerateWatchCompiles(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	in.Generate = filepath.Join("pkg", "config")
+	in.Watch = true
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"),
+		[]byte(`{"APP_FOO": "foo", "APP_BAR": "bar"}`), perms)
+	is.NoErr(err)
+	out, err := Cmd(in)
+	is.NoErr(err)
+	_, err = in.Process(out)
+	is.NoErr(err)
+
+	goTestModule(t, tmp, map[string]string{
+		"pkg/config/reload_test.go": stubReloadTest,
+	})
+}
+
+func TestGenerateNoVars(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	in.Generate = filepath.Join("pkg", "config")
+	in.NoVars = true
+
+	configFilePath, err := share.GetConfigFilePath(
+		tmp, in.Env, share.FileTypeJSON)
+	is.NoErr(err)
+	err = os.WriteFile(configFilePath, []byte(`{"APP_FOO": "foo"}`), perms)
+	is.NoErr(err)
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(FileNameConfigGo, filepath.Base(out.Files[0].Path))
+	src := out.Files[0].Buf.String()
+	_, err = parser.ParseFile(token.NewFileSet(), FileNameConfigGo, src, 0)
+	is.NoErr(err)
+	is.True(!strings.Contains(src, "SetVars"))
+	is.True(!strings.Contains(src, "var foo string"))
+	is.True(strings.Contains(src, "foo string // APP_FOO"))
+}
+
+func TestGenerateDeprecated(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"), []byte(
+		`{"APP_OLD_PORT": "80", "APP_PORT": "8080", "APP_LEGACY": "x"}`), perms)
+	is.NoErr(err)
+	err = os.WriteFile(filepath.Join(tmp, FileNameTypes), []byte(`{
+		"APP_OLD_PORT": {"type": "int", "replacedBy": "APP_PORT"},
+		"APP_PORT": "int",
+		"APP_LEGACY": {"deprecated": true}
+	}`), perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	in.Generate = filepath.Join("pkg", "config")
+	in.Must = true
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(FileNameConfigGo, filepath.Base(out.Files[0].Path))
+	src := out.Files[0].Buf.String()
+	_, err = parser.ParseFile(token.NewFileSet(), FileNameConfigGo, src, 0)
+	is.NoErr(err)
+
+	// Getter aliases the replacement
+	is.True(strings.Contains(src, `// Deprecated: use APP_PORT instead
+func (c *Config) OldPort() int {
+	share.WarnDeprecated("APP_OLD_PORT", "APP_PORT")
+	if c.port != "" {
+		return c.Port()
+	}
+	// Invalid values are rejected by Validate`))
+	is.True(strings.Contains(src,
+		`if c.oldPort == "" && c.port == "" {`))
+	is.True(strings.Contains(src, `// Deprecated: APP_LEGACY will be removed
+func (c *Config) Legacy() string {
+	share.WarnDeprecated("APP_LEGACY", "")
+	return c.legacy
+}`))
+	// Other getters are not affected
+	is.True(strings.Contains(src, `func (c *Config) Port() int {
+	// Invalid values are rejected by Validate`))
+}
+
+func TestGenerateTypeScript(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"),
+		[]byte(`{"APP_FOO": "foo", "APP_PORT": "8080", "APP_URL": ""}`), perms)
+	is.NoErr(err)
+	err = os.WriteFile(filepath.Join(tmp, FileNameTypes),
+		[]byte(`{"APP_PORT": {"type": "int", "default": "80"}, "APP_URL": "url"}`),
+		perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	in.TypeScript = "web"
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdGenerate, out.Cmd)
+	is.Equal(1, len(out.Files))
+	is.Equal(filepath.Join(tmp, "web", FileNameConfigDTs), out.Files[0].Path)
+	dts := out.Files[0].Buf.String()
+	is.True(strings.Contains(dts, `  | "APP_FOO"`))
+	is.True(strings.Contains(dts, "  readonly foo: string;"))
+	is.True(strings.Contains(dts, "  readonly port: number;"))
+	is.True(strings.Contains(dts, "  readonly url: URL | undefined;"))
+
+	in.TypeScriptLoader = true
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(2, len(out.Files))
+	loader := out.Files[1].Buf.String()
+	is.True(strings.Contains(loader,
+		`    port: toInt("APP_PORT", value(env, "APP_PORT", "80")),`))
+	is.True(strings.Contains(loader, `    foo: value(env, "APP_FOO", ""),`))
+	// Only the parse funcs for the types in use are generated
+	is.True(strings.Contains(loader, "function toURL("))
+	is.True(!strings.Contains(loader, "function toBool("))
+}
+
+func TestGenerateHelpersMultiTarget(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	in.GenerateAll = ArgMap{
+		filepath.Join("pkg", "config"),
+		filepath.Join("ext1", "pkg", "config"),
+	}
+
+	configFilePath, err := share.GetConfigFilePath("testdata", in.Env, share.FileTypeJSON)
+	is.NoErr(err)
+	dstConfigFilePath, err := share.GetConfigFilePath(tmp, in.Env, share.FileTypeJSON)
+	is.NoErr(err)
+	err = Copy(configFilePath, dstConfigFilePath)
+	is.NoErr(err)
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdGenerate, out.Cmd)
+	is.Equal(13, len(out.Files)) // Files for both targets
+
+	// Target dirs are recorded in the manifest in APP_DIR
+	manifest := out.Files[12]
+	is.Equal(filepath.Join(tmp, FileNameGenerated), manifest.Path)
+	is.Equal(GeneratedHeader+"\next1/pkg/config/\npkg/config/\n",
+		manifest.Buf.String())
+
+	// Generated code must be the same for all targets
+	for i := 0; i < 6; i++ {
+		is.Equal(filepath.Base(out.Files[i].Path),
+			filepath.Base(out.Files[i+6].Path))
+		is.Equal(out.Files[i].Buf.String(), out.Files[i+6].Buf.String())
+	}
+
+	// Generate and GenerateAll may be combined
+	in.Generate = filepath.Join("pkg", "config")
+	in.GenerateAll = ArgMap{filepath.Join("ext1", "pkg", "config")}
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(13, len(out.Files))
+
+	in.Generate = ""
+	in.GenerateAll = ArgMap{"pkg/config", "pkg/config/"}
+	_, err = Cmd(in)
+	is.True(err != nil) // Duplicate path
+}
+
+func TestGenerateHelpersExtensions(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	// Main config lists the extensions
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"), []byte(
+		`{"APP_MAIN": "main", "APP_X": "ext1,ext2", "APP_X_DIR": "."}`), perms)
+	is.NoErr(err)
+	for _, ext := range []string{"ext1", "ext2"} {
+		err = os.Mkdir(filepath.Join(tmp, ext), dirPerms)
+		is.NoErr(err)
+		key := fmt.Sprintf("APP_%s", strings.ToUpper(ext))
+		err = os.WriteFile(filepath.Join(tmp, ext, "config.dev.json"),
+			[]byte(fmt.Sprintf(`{"%s": "%s"}`, key, ext)), perms)
+		is.NoErr(err)
+	}
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	// Only ext1 has a target inside the extension dir
+	in.GenerateAll = ArgMap{
+		filepath.Join("pkg", "config"),
+		filepath.Join("ext1", "pkg", "config"),
+	}
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(13, len(out.Files))
+
+	rootConfig := out.Files[0].Buf.String()
+	is.True(strings.Contains(rootConfig, "APP_MAIN"))
+	is.True(strings.Contains(rootConfig, "APP_EXT2")) // No target for ext2
+	is.True(!strings.Contains(rootConfig, "APP_EXT1"))
+
+	extConfig := out.Files[6].Buf.String()
+	is.True(strings.Contains(extConfig, "APP_EXT1"))
+	is.True(!strings.Contains(extConfig, "APP_MAIN"))
+	is.True(!strings.Contains(extConfig, "APP_EXT2"))
+}
+
+func TestGenerateKeysHash(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"),
+		[]byte(`{"APP_FOO": "foo", "APP_BAR": "bar", "APP_DIR": "x"}`), perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	data, err := NewGenerateData(in)
+	is.NoErr(err)
+	// APP_DIR is excluded, same as share.EnvKeys
+	is.Equal(share.KeysHash([]string{"APP_BAR", "APP_FOO"}), data.KeysHash)
+}
+
+func TestGeneratedLoadTestEnv(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	// The test config file is searched relative to the package under test
+	pkgDir := filepath.Join(tmp, "pkg", "foo")
+	err = os.MkdirAll(pkgDir, 0700)
+	is.NoErr(err)
+	wd, err := os.Getwd()
+	is.NoErr(err)
+	err = os.Chdir(pkgDir)
+	is.NoErr(err)
+	defer (func() {
+		_ = os.Chdir(wd)
+	})()
+	t.Setenv("APP_DIR", tmp)
+	t.Setenv("APP_FOO", "")
+
+	_, err = config.LoadFile(share.EnvTest)
+	is.True(err != nil) // Not found
+
+	err = os.WriteFile(filepath.Join(tmp, "config.test.json"),
+		[]byte(`{"APP_FOO": "test"}`), perms)
+	is.NoErr(err)
+	c, err := config.LoadFile(share.EnvTest)
+	is.NoErr(err)
+	is.Equal("test", c.Foo())
+}
+
+func TestGeneratedSafeConfig(t *testing.T) {
+	is := testutil.Setup(t)
+
+	s := config.NewSafe(config.LoadMap(map[string]string{"APP_FOO": "foo"}))
+	snapshot := s.Load()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			s.SetFoo(fmt.Sprintf("foo%d", i))
+		}(i)
+		go func() {
+			defer wg.Done()
+			_ = s.Foo()
+		}()
+	}
+	wg.Wait()
+
+	// Setters don't modify a loaded Config
+	is.Equal("foo", snapshot.Foo())
+	is.True(strings.HasPrefix(s.Foo(), "foo"))
+	s.Store(snapshot)
+	is.Equal("foo", s.Foo())
+}
+
+func TestGeneratedLoadFileT(t *testing.T) {
+	is := testutil.Setup(t)
+
+	t.Setenv("APP_DIR", "testdata")
+	t.Setenv("APP_FOO", "before")
+	t.Run("LoadFileT", func(t *testing.T) {
+		c := config.LoadFileT(t, share.EnvDev)
+		is.Equal("foo", c.Foo())
+		is.Equal("foo", os.Getenv("APP_FOO"))
+	})
+	// Env is restored when the subtest completes
+	is.Equal("before", os.Getenv("APP_FOO"))
+}
+
+func TestGeneratedOptions(t *testing.T) {
+	is := testutil.Setup(t)
+
+	t.Setenv("APP_DIR", "testdata")
+	t.Setenv("APP_FOO", "env")
+	t.Setenv("APP_BAR", "env")
+	is.Equal("env", config.New().Foo())
+
+	// Later options override earlier ones
+	configBase64 := base64.StdEncoding.EncodeToString(
+		[]byte(`{"APP_FOO": "base64"}`))
+	c := config.New(
+		config.WithEnvFile(share.EnvDev),
+		config.WithBase64(configBase64))
+	is.Equal("base64", c.Foo())
+	is.Equal("bar", c.Bar())
+	is.Equal("env", os.Getenv("APP_BAR")) // Env is not set by options
+
+	c = config.New(config.WithMap(map[string]string{"APP_FOO": "map"}),
+		config.WithoutOSEnv())
+	is.Equal("map", c.Foo())
+	is.Equal("", c.Bar())
+
+	defer func() {
+		is.True(recover() != nil) // Options must not fail silently
+	}()
+	config.New(config.WithBase64("invalid"))
+}
+
+func TestGeneratedKeyNames(t *testing.T) {
+	is := testutil.Setup(t)
+
+	is.Equal("APP_FOO", config.KeyFoo)
+	is.Equal("APP_TEMPLATE_FIZ", config.KeyTemplateFiz)
+	t.Setenv(config.KeyFoo, "foo")
+	c := config.New()
+	is.Equal(os.Getenv(config.KeyFoo), c.Foo())
+}
+
+func TestGeneratedMockConfig(t *testing.T) {
+	is := testutil.Setup(t)
+
+	var c config.Configer = &config.MockConfig{
+		Values: config.MockValues{Foo: "mock", Port: 80}}
+	t.Setenv("APP_FOO", "env")
+	is.Equal("mock", c.Foo())
+	is.Equal(80, c.Port())
+	is.Equal("", c.Bar())
+}
+
+func TestGenerateTypeImports(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"),
+		[]byte(`{"APP_PORT": "", "APP_TIMEOUT": "", "APP_API": ""}`), perms)
+	is.NoErr(err)
+	err = os.WriteFile(filepath.Join(tmp, FileNameTypes), []byte(`{
+		"APP_PORT": "int",
+		"APP_TIMEOUT": "duration",
+		"APP_API": "url"
+	}`), perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	in.Generate = filepath.Join("pkg", "config")
+
+	// strconv is only used to parse values in config.go
+	data, err := NewGenerateData(in)
+	is.NoErr(err)
+	is.Equal([]string{"net/url", "strconv", "time"}, data.Imports)
+	is.Equal([]string{"net/url", "time"}, data.TypeImports)
+}
+
+func TestGenerateConfigTest(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	in.Generate = filepath.Join("pkg", "config")
+	in.ConfigTest = true
+
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"),
+		[]byte(`{"APP_FOO": "foo"}`), perms)
+	is.NoErr(err)
+	_, err = Cmd(in)
+	is.True(err != nil) // go.mod is required for the import path
+
+	err = os.WriteFile(filepath.Join(tmp, "go.mod"),
+		[]byte("module example.com/app\n\ngo 1.21\n"), perms)
+	is.NoErr(err)
+	out, err := Cmd(in)
+	is.NoErr(err)
+	_, err = in.Process(out)
+	is.NoErr(err)
+
+	configTestPath := filepath.Join(
+		tmp, in.Generate, DirConfigTest, FileNameConfigTestGo)
+	// Only configtest imports testing, not the config package
+	f, err := parser.ParseFile(token.NewFileSet(),
+		filepath.Join(tmp, in.Generate, FileNameConfigGo), nil, parser.ImportsOnly)
+	is.NoErr(err)
+	for _, imp := range f.Imports {
+		is.True(imp.Path.Value != `"testing"`)
+	}
+	b, err := os.ReadFile(filepath.Join(tmp, in.Generate, FileNameGenerated))
+	is.NoErr(err)
+	is.True(strings.Contains(string(b), "configtest/configtest.go"))
+
+	// Importing configtest loads the test env
+	err = os.WriteFile(filepath.Join(tmp, "config.test.json"),
+		[]byte(`{"APP_FOO": "test"}`), perms)
+	is.NoErr(err)
+	goTestModule(t, tmp, map[string]string{
+		"pkg/foo/foo_test.go": `package foo
+
+import (
+	"os"
+	"testing"
+
+	"example.com/app/pkg/config/configtest"
+)
+
+func TestFoo(t *testing.T) {
+	if os.Getenv("APP_FOO") != "test" {
+		t.Fatal("test env must be loaded")
+	}
+	conf := configtest.Load(t, map[string]string{"APP_FOO": "bar"})
+	if conf.Foo() != "bar" {
+		t.Fatal("overrides must take precedence")
+	}
+}
+`,
+	})
+
+	// Without the flag the configtest package is orphaned
+	in.ConfigTest = false
+	in.Clean = true
+	out, err = Cmd(in)
+	is.NoErr(err)
+	_, err = in.Process(out)
+	is.NoErr(err)
+	_, err = os.Stat(configTestPath)
+	is.True(os.IsNotExist(err))
+	_, err = os.Stat(filepath.Dir(configTestPath))
+	is.True(os.IsNotExist(err)) // Emptied dir must be deleted
+}
+
+func TestGeneratedEnvconfig(t *testing.T) {
+	is := testutil.Setup(t)
+
+	field, ok := reflect.TypeOf(config.Env{}).FieldByName("Port")
+	is.True(ok)
+	is.Equal(reflect.TypeOf(0), field.Type)
+	is.Equal("APP_PORT", field.Tag.Get("env"))
+	is.Equal("APP_PORT", field.Tag.Get("envconfig"))
+	is.Equal("80", field.Tag.Get("envDefault"))
+	is.Equal("80", field.Tag.Get("default"))
+	field, ok = reflect.TypeOf(config.Env{}).FieldByName("Foo")
+	is.True(ok)
+	is.Equal("", field.Tag.Get("envDefault"))
+
+	// Defaults with backquotes are escaped
+	tag, err := strconv.Unquote(structTag("APP_FOO", "`\"x"))
+	is.NoErr(err)
+	is.Equal("`\"x", reflect.StructTag(tag).Get("default"))
+}
+
+func TestGeneratedRegisterFlags(t *testing.T) {
+	is := testutil.Setup(t)
+
+	t.Setenv("APP_FOO", "env")
+	t.Setenv("APP_BAR", "env")
+	c := config.New()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	c.RegisterFlags(fs)
+	err := fs.Parse([]string{"-foo", "flag", "-template-fiz", "x", "-port", "81"})
+	is.NoErr(err)
+	is.Equal("flag", c.Foo()) // Flags override env
+	is.Equal("env", c.Bar())
+	is.Equal("x", c.TemplateFiz())
+	is.Equal(81, c.Port())
+
+	// Typed values are validated
+	err = fs.Parse([]string{"-port", "http"})
+	is.True(err != nil)
+	is.Equal(81, c.Port())
+}
@@ -0,0 +1,41 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// importEnv adds or updates the keys in the config file for env,
+// with the env vars matching the prefix in the current process,
+// e.g. to onboard an existing deployment. The app dir key is skipped
+func importEnv(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
+	buf = new(bytes.Buffer)
+
+	if in.manifest.protected(in.Env) && !in.Force {
+		return buf, files, ErrProtectedEnv(in.Env)
+	}
+
+	// Vars exported by conf for another env are likely not intended
+	active := os.Getenv(ActiveEnvKey)
+	if active != "" && active != in.Env {
+		in.warn(fmt.Sprintf("%s is %s, env vars may be set for that env",
+			ActiveEnvKey, active))
+	}
+
+	appDirKey := fmt.Sprintf("%sDIR", in.Prefix)
+	imported := make(map[string]string)
+	for _, v := range os.Environ() {
+		key, value, _ := strings.Cut(v, "=")
+		if strings.HasPrefix(key, in.Prefix) && key != appDirKey {
+			imported[key] = value
+		}
+	}
+	if len(imported) == 0 {
+		in.warn(fmt.Sprintf("no env vars with prefix %s", in.Prefix))
+		return buf, files, nil
+	}
+
+	return importKeys(in, imported, make(map[string]bool))
+}
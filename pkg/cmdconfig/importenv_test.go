@@ -0,0 +1,121 @@
+package cmdconfig
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/mozey/config/pkg/testutil"
+)
+
+// unsetPrefix unsets env vars with prefix, e.g. set by the shell running
+// the tests, they are restored after the test
+func unsetPrefix(t *testing.T, prefix string) {
+	for _, v := range os.Environ() {
+		key, _, _ := strings.Cut(v, "=")
+		if strings.HasPrefix(key, prefix) {
+			t.Setenv(key, "")
+			err := os.Unsetenv(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestImportEnv(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	unsetPrefix(t, "APP_")
+	t.Setenv(ActiveEnvKey, "")
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = EnvProd
+	in.ImportEnv = true
+
+	// Nothing to import
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdImportEnv, out.Cmd)
+	is.Equal(0, len(out.Files))
+	is.Equal(1, len(out.Warnings))
+
+	t.Setenv("APP_DIR", tmp)
+	t.Setenv("APP_HOST", "prod.example.com")
+	t.Setenv("APP_DB_PASSWORD", "s3cr3t")
+	t.Setenv("OTHER", "x")
+
+	// New config file
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(0, len(out.Warnings))
+	is.Equal(1, len(out.Files))
+	is.Equal(filepath.Join(tmp, "config.prod.json"), out.Files[0].Path)
+	configMap, err := share.UnmarshalConfig(
+		out.Files[0].Path, out.Files[0].Buf.Bytes())
+	is.NoErr(err)
+	is.Equal(map[string]string{
+		"APP_DB_PASSWORD": "s3cr3t",
+		"APP_HOST":        "prod.example.com",
+	}, configMap)
+	// Secret values are redacted in the changes
+	is.True(!strings.Contains(out.Buf.String(), "s3cr3t"))
+
+	// Up to date
+	is.NoErr(out.Files.Save(out.Buf))
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(0, len(out.Files))
+	is.True(strings.Contains(out.Buf.String(), "up to date"))
+
+	// Vars may be set for another env
+	t.Setenv(ActiveEnvKey, "dev")
+	t.Setenv("APP_HOST", "localhost")
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(1, len(out.Warnings))
+	is.True(strings.Contains(out.Buf.String(), "localhost"))
+
+	// Derived values are not replaced with the resolved value
+	t.Setenv(ActiveEnvKey, "")
+	err = os.WriteFile(filepath.Join(tmp, "config.prod.json"), []byte(`{
+		"APP_DB_PASSWORD": "helper://db/password",
+		"APP_HOST": "localhost",
+		"APP_TOKEN": "enc:v1:abc",
+		"APP_TEMPLATE_URL": "https://{{.Host}}"
+	}`), perms)
+	is.NoErr(err)
+	t.Setenv("APP_TOKEN", "t0ken")
+	t.Setenv("APP_TEMPLATE_URL", "https://localhost")
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(0, len(out.Files))
+	is.Equal([]string{"keys with secret references, encrypted values, " +
+		"or templates skipped, use the force flag to import them: " +
+		"APP_DB_PASSWORD, APP_TEMPLATE_URL, APP_TOKEN"}, out.Warnings)
+	in.Force = true
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(0, len(out.Warnings))
+	is.Equal(1, len(out.Files))
+	configMap, err = share.UnmarshalConfig(
+		out.Files[0].Path, out.Files[0].Buf.Bytes())
+	is.NoErr(err)
+	is.Equal("t0ken", configMap["APP_TOKEN"])
+	in.Force = false
+
+	// Protected env
+	in.manifest = &Manifest{Protected: []string{EnvProd}}
+	_, err = Cmd(in)
+	is.True(err != nil)
+}
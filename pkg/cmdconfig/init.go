@@ -0,0 +1,118 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+
+	"github.com/mozey/config/pkg/share"
+)
+
+// DirInitGenerate is the config package generated by init,
+// relative to APP_DIR
+var DirInitGenerate = filepath.Join("pkg", "config")
+
+// templatePosixSetup is printed by init for bash and zsh
+var templatePosixSetup = `
+Set %[1]sDIR and load the config into the env
+    export %[1]sDIR="%[2]s"
+    eval "$(configu -env %[3]s)"
+
+Or add the conf func to ~/.bashrc or ~/.zshrc, then run "conf %[3]s" in the project dir
+    eval "$(configu -shell bash)"
+`
+
+// templatePowerShellSetup is printed by init for Windows
+var templatePowerShellSetup = `
+Set %[1]sDIR and load the config into the env
+    $env:%[1]sDIR = "%[2]s"
+    configu -env %[3]s | Out-String | Invoke-Expression
+
+Or add the conf func to $PROFILE, then run "conf %[3]s" in the project dir
+    configu -shell powershell | Out-String | Invoke-Expression
+`
+
+// initProject scaffolds the config file and sample for env,
+// and generates the config package in DirInitGenerate.
+// Existing config files are not changed.
+// Instructions to set APP_DIR are printed to buf
+func initProject(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
+	buf = new(bytes.Buffer)
+
+	exists, err := configFileExists(in.dirs, in.AppDir, in.Env)
+	if err != nil {
+		return buf, files, err
+	}
+	var config *conf
+	if exists {
+		in.warn(fmt.Sprintf("config file for env %s exists, it's not changed",
+			in.Env))
+		_, config, err = newCachedConf(in.dirs, in.AppDir, in.Env)
+		if err != nil {
+			return buf, files, err
+		}
+	} else {
+		// Example key, so the generated package has a getter
+		config = &conf{Map: map[string]string{
+			fmt.Sprintf("%sNAME", in.Prefix): filepath.Base(in.AppDir),
+		}}
+		config.refreshKeys()
+		file, err := initConfigFile(in.AppDir, in.Env, config)
+		if err != nil {
+			return buf, files, err
+		}
+		files = append(files, file)
+	}
+
+	schema, err := readSchema(in.dirs, in.AppDir)
+	if err != nil {
+		return buf, files, err
+	}
+
+	sampleEnv := fmt.Sprintf("%s%s", share.SamplePrefix(), in.Env)
+	exists, err = configFileExists(in.dirs, in.AppDir, sampleEnv)
+	if err != nil {
+		return buf, files, err
+	}
+	if !exists {
+		sample := &conf{Map: make(map[string]string)}
+		for _, key := range config.Keys {
+			sample.Map[key] = sampleValue(in.Prefix, key, config, nil, schema)
+		}
+		sample.refreshKeys()
+		file, err := initConfigFile(in.AppDir, sampleEnv, sample)
+		if err != nil {
+			return buf, files, err
+		}
+		files = append(files, file)
+	}
+
+	data := newGenerateData(in, config, config.Keys, schema)
+	targetFiles, err := generateTarget(
+		in, buf, filepath.Join(in.AppDir, DirInitGenerate), data)
+	if err != nil {
+		return buf, files, err
+	}
+	files = append(files, targetFiles...)
+
+	setup := templatePosixSetup
+	if in.OS == OSWindows || in.OS == OSPowerShell {
+		setup = templatePowerShellSetup
+	}
+	buf.WriteString(fmt.Sprintf(setup, in.Prefix, in.AppDir, in.Env))
+
+	return buf, files, nil
+}
+
+// initConfigFile returns the JSON config file for env
+func initConfigFile(appDir, env string, c *conf) (file File, err error) {
+	configPath, err := share.GetConfigFilePath(appDir, env, share.FileTypeJSON)
+	if err != nil {
+		return file, err
+	}
+	b, err := marshalConf(c, share.FileTypeJSON)
+	if err != nil {
+		return file, err
+	}
+	return File{Path: configPath, Buf: bytes.NewBuffer(b)}, nil
+}
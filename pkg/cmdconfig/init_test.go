@@ -0,0 +1,70 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestInitProject(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	in.Init = true
+	in.OS = OSPosix
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdInit, out.Cmd)
+	is.Equal(filepath.Join(tmp, "config.dev.json"), out.Files[0].Path)
+	is.Equal(filepath.Join(tmp, "sample.config.dev.json"), out.Files[1].Path)
+	is.Equal(filepath.Join(tmp, DirInitGenerate, FileNameConfigGo),
+		out.Files[2].Path)
+	is.True(strings.Contains(out.Buf.String(),
+		`export APP_DIR="`+tmp+`"`))
+	is.NoErr(out.Files.Save(new(bytes.Buffer)))
+
+	configMap, err := share.UnmarshalConfig(
+		out.Files[0].Path, out.Files[0].Buf.Bytes())
+	is.NoErr(err)
+	is.Equal(map[string]string{"APP_NAME": filepath.Base(tmp)}, configMap)
+	configMap, err = share.UnmarshalConfig(
+		out.Files[1].Path, out.Files[1].Buf.Bytes())
+	is.NoErr(err)
+	is.Equal(map[string]string{"APP_NAME": ""}, configMap)
+	is.True(strings.Contains(out.Files[2].Buf.String(),
+		"func (c *Config) Name() string"))
+
+	// Existing config files are not changed,
+	// the config package is generated from the config file
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"),
+		[]byte(`{"APP_FOO": "foo"}`), perms)
+	is.NoErr(err)
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(1, len(out.Warnings))
+	is.Equal(filepath.Join(tmp, DirInitGenerate, FileNameConfigGo),
+		out.Files[0].Path)
+	is.True(strings.Contains(out.Files[0].Buf.String(),
+		"func (c *Config) Foo() string"))
+
+	// PowerShell
+	in.OS = OSPowerShell
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.True(strings.Contains(out.Buf.String(), `$env:APP_DIR = "`+tmp+`"`))
+}
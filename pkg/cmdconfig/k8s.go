@@ -0,0 +1,254 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/pkg/errors"
+)
+
+// k8sScheme prefixes Kubernetes URIs,
+// e.g. k8s://namespace/configmap/name or k8s://namespace/secret/name
+const k8sScheme = "k8s://"
+
+// Kubernetes object kinds that may be imported
+const (
+	K8sConfigMap = "configmap"
+	K8sSecret    = "secret"
+)
+
+// kubectlCmd runs kubectl and returns stdout, the cluster is selected by
+// the kubeconfig. It's a variable so tests can stub it
+var kubectlCmd = func(args ...string) ([]byte, error) {
+	cmd := exec.Command("kubectl", args...)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	b, err := cmd.Output()
+	if err != nil {
+		return b, errors.Wrapf(err,
+			"kubectl %s", strings.TrimSpace(stderr.String()))
+	}
+	return b, nil
+}
+
+// k8sObject is a ConfigMap or Secret
+type k8sObject struct {
+	URI       string
+	Namespace string
+	Kind      string
+	Name      string
+}
+
+// newK8sObject parses the URI
+func newK8sObject(uri string) (o k8sObject, err error) {
+	if !strings.HasPrefix(uri, k8sScheme) {
+		return o, errors.Errorf("invalid Kubernetes URI %s", uri)
+	}
+	parts := strings.Split(strings.TrimPrefix(uri, k8sScheme), "/")
+	if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+		return o, errors.Errorf(
+			"invalid Kubernetes URI %s, expected %snamespace/kind/name",
+			uri, k8sScheme)
+	}
+	kind := strings.ToLower(parts[1])
+	if kind == "cm" {
+		kind = K8sConfigMap
+	}
+	if kind != K8sConfigMap && kind != K8sSecret {
+		return o, errors.Errorf("invalid kind %s in %s, expected %s or %s",
+			parts[1], uri, K8sConfigMap, K8sSecret)
+	}
+	return k8sObject{
+		URI: uri, Namespace: parts[0], Kind: kind, Name: parts[2]}, nil
+}
+
+// data returns the key value pairs of the object,
+// secret values are decoded
+func (o k8sObject) data() (data map[string]string, err error) {
+	b, err := kubectlCmd("get", o.Kind, o.Name,
+		"--namespace", o.Namespace, "--output", "json")
+	if err != nil {
+		return data, err
+	}
+	result := struct {
+		Data map[string]string `json:"data"`
+	}{}
+	err = json.Unmarshal(b, &result)
+	if err != nil {
+		return data, errors.Wrapf(err, "invalid kubectl output for %s", o.URI)
+	}
+	data = make(map[string]string, len(result.Data))
+	for key, value := range result.Data {
+		if o.Kind == K8sSecret {
+			decoded, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return data, errors.Wrapf(err,
+					"invalid secret value for key %s in %s", key, o.URI)
+			}
+			value = string(decoded)
+		}
+		data[key] = value
+	}
+	return data, nil
+}
+
+// configFileExists returns true if there is a config file for env
+func configFileExists(dirs *dirCache, appDir, env string) (bool, error) {
+	paths, err := share.GetConfigFilePaths(appDir, env)
+	if err != nil {
+		return false, err
+	}
+	for _, configPath := range paths {
+		exists, err := dirs.exists(configPath)
+		if err != nil || exists {
+			return exists, err
+		}
+	}
+	return false, nil
+}
+
+// importConfig reads ConfigMaps and Secrets from the cluster,
+// and adds or updates the keys in the config file for env.
+// Keys in the config file that are not in the cluster are kept,
+// since an env may be composed of more than one object.
+// Later URIs take precedence if keys are repeated
+func importConfig(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
+	buf = new(bytes.Buffer)
+
+	if in.manifest.protected(in.Env) && !in.Force {
+		return buf, files, ErrProtectedEnv(in.Env)
+	}
+
+	imported := make(map[string]string)
+	secrets := make(map[string]bool)
+	for _, uri := range in.Import {
+		o, err := newK8sObject(uri)
+		if err != nil {
+			return buf, files, err
+		}
+		data, err := o.data()
+		if err != nil {
+			return buf, files, err
+		}
+		skipped := make([]string, 0)
+		for key, value := range data {
+			if !strings.HasPrefix(key, in.Prefix) {
+				skipped = append(skipped, key)
+				continue
+			}
+			imported[key] = value
+			secrets[key] = o.Kind == K8sSecret
+		}
+		if len(skipped) > 0 {
+			sort.Strings(skipped)
+			in.warn(fmt.Sprintf("%s keys without prefix %s skipped: %s",
+				o.URI, in.Prefix, strings.Join(skipped, ", ")))
+		}
+		if o.Kind == K8sSecret && len(data) > len(skipped) {
+			in.warn(fmt.Sprintf(
+				"%s values are written to the config file in plain text", o.URI))
+		}
+	}
+
+	return importKeys(in, imported, secrets)
+}
+
+// derivedValue returns true if value in a config file is exported as
+// another value, i.e. a secret reference, encrypted, or a template
+func derivedValue(value string) bool {
+	_, _, ref := share.SecretRef(value)
+	return ref || share.Encrypted(value) ||
+		templateActionRegexp.MatchString(value)
+}
+
+// importKeys adds or updates the imported keys in the config file for env,
+// the changes are printed to buf. Secret values are redacted.
+// Keys with derived values are skipped unless forced, the imported value
+// is the resolved plaintext, see derivedValue
+func importKeys(in *CmdIn, imported map[string]string, secrets map[string]bool) (
+	buf *bytes.Buffer, files []File, err error) {
+
+	buf = new(bytes.Buffer)
+
+	// Format flag takes precedence over the manifest
+	format := in.Format
+	if format == "" {
+		format = in.manifest.format()
+	}
+
+	exists, err := configFileExists(in.dirs, in.AppDir, in.Env)
+	if err != nil {
+		return buf, files, err
+	}
+	var existingPath, configPath string
+	c := &conf{Map: make(map[string]string)}
+	if exists {
+		existingPath, c, err = loadConf(in.dirs, in.AppDir, in.Env)
+		if err != nil {
+			return buf, files, err
+		}
+		configPath = existingPath
+	}
+	if !exists || format != "" {
+		if format == "" {
+			format = strings.TrimPrefix(share.FileTypeJSON, ".")
+		}
+		configPath, err = share.GetConfigFilePath(
+			in.AppDir, in.Env, fmt.Sprintf(".%s", format))
+		if err != nil {
+			return buf, files, err
+		}
+	}
+
+	changes := make(envChanges, 0)
+	skipped := make([]string, 0)
+	for key, value := range imported {
+		old, ok := c.Map[key]
+		if ok && old != value && derivedValue(old) && !in.Force {
+			skipped = append(skipped, key)
+			continue
+		}
+		if !ok {
+			changes = append(changes, envChange{
+				Change: ChangeAdd, Key: key, New: value,
+				Secret: secrets[key] || in.manifest.secretKey(key)})
+		} else if old != value {
+			changes = append(changes, envChange{
+				Change: ChangeUpdate, Key: key, Old: old, New: value,
+				Secret: secrets[key] || in.manifest.secretKey(key)})
+		}
+		c.Map[key] = value
+	}
+	if len(skipped) > 0 {
+		sort.Strings(skipped)
+		in.warn(fmt.Sprintf(
+			"keys with secret references, encrypted values, or templates "+
+				"skipped, use the force flag to import them: %s",
+			strings.Join(skipped, ", ")))
+	}
+	if len(changes) == 0 && configPath == existingPath {
+		buf.WriteString(fmt.Sprintf("%s is up to date\n", configPath))
+		return buf, files, nil
+	}
+	changes.sort()
+	changes.write(buf)
+	c.refreshKeys()
+
+	b, err := marshalConf(c, filepath.Ext(configPath))
+	if err != nil {
+		return buf, files, err
+	}
+	files = append(files, File{Path: configPath, Buf: bytes.NewBuffer(b)})
+	if exists && configPath != existingPath {
+		// Otherwise the old file takes precedence when loading the env
+		files = append(files, File{Path: existingPath, Del: true})
+	}
+	return buf, files, nil
+}
@@ -0,0 +1,155 @@
+package cmdconfig
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/mozey/config/pkg/testutil"
+)
+
+// stubKubectl replaces kubectlCmd with objects keyed by
+// namespace/kind/name for the duration of the test
+func stubKubectl(t *testing.T, objects map[string]string) {
+	original := kubectlCmd
+	kubectlCmd = func(args ...string) ([]byte, error) {
+		// get <kind> <name> --namespace <ns> --output json
+		key := fmt.Sprintf("%s/%s/%s", args[4], args[1], args[2])
+		b, ok := objects[key]
+		if !ok {
+			return nil, fmt.Errorf("kubectl %s %q not found", args[1], args[2])
+		}
+		return []byte(b), nil
+	}
+	t.Cleanup(func() {
+		kubectlCmd = original
+	})
+}
+
+func TestK8sObject(t *testing.T) {
+	is := testutil.Setup(t)
+
+	o, err := newK8sObject("k8s://myapp/configmap/myapp-env")
+	is.NoErr(err)
+	is.Equal(k8sObject{URI: "k8s://myapp/configmap/myapp-env",
+		Namespace: "myapp", Kind: K8sConfigMap, Name: "myapp-env"}, o)
+
+	o, err = newK8sObject("k8s://myapp/cm/myapp-env")
+	is.NoErr(err)
+	is.Equal(K8sConfigMap, o.Kind)
+
+	for _, uri := range []string{
+		"s3://bucket/key",
+		"k8s://myapp/configmap",
+		"k8s:///configmap/myapp-env",
+		"k8s://myapp/deployment/myapp",
+		"k8s://myapp/configmap/a/b",
+	} {
+		_, err = newK8sObject(uri)
+		is.True(err != nil)
+	}
+}
+
+func TestImportConfig(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	encode := base64.StdEncoding.EncodeToString
+	stubKubectl(t, map[string]string{
+		"myapp/configmap/myapp-env": `{"data": {
+			"APP_HOST": "prod.example.com",
+			"APP_PORT": "443",
+			"OTHER": "x"
+		}}`,
+		"myapp/secret/myapp-secrets": fmt.Sprintf(
+			`{"data": {"APP_DB_PASSWORD": %q}}`, encode([]byte("s3cr3t"))),
+	})
+
+	err = os.WriteFile(filepath.Join(tmp, "config.prod.json"),
+		[]byte(`{"APP_HOST": "old.example.com", "APP_LOCAL": "x"}`), perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = EnvProd
+	in.Import = ArgMap{
+		"k8s://myapp/configmap/myapp-env",
+		"k8s://myapp/secret/myapp-secrets",
+	}
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdImport, out.Cmd)
+	is.Equal(2, len(out.Warnings))
+	is.True(strings.Contains(out.Warnings[0], "OTHER"))
+	is.True(strings.Contains(out.Warnings[1], "plain text"))
+
+	// Secret values are redacted in the changes
+	lines := strings.Split(strings.TrimSpace(out.Buf.String()), "\n")
+	is.Equal(4, len(lines))
+	is.Equal([]string{"add", "APP_DB_PASSWORD", redacted},
+		strings.Fields(lines[1]))
+	is.Equal([]string{"change", "APP_HOST",
+		"old.example.com", "→", "prod.example.com"},
+		strings.Fields(lines[2]))
+	is.Equal([]string{"add", "APP_PORT", "443"}, strings.Fields(lines[3]))
+
+	// Keys not in the cluster are kept
+	is.Equal(1, len(out.Files))
+	is.Equal(filepath.Join(tmp, "config.prod.json"), out.Files[0].Path)
+	configMap, err := share.UnmarshalConfig(
+		out.Files[0].Path, out.Files[0].Buf.Bytes())
+	is.NoErr(err)
+	is.Equal(map[string]string{
+		"APP_DB_PASSWORD": "s3cr3t",
+		"APP_HOST":        "prod.example.com",
+		"APP_LOCAL":       "x",
+		"APP_PORT":        "443",
+	}, configMap)
+
+	// Up to date
+	is.NoErr(out.Files.Save(out.Buf))
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(0, len(out.Files))
+	is.True(strings.Contains(out.Buf.String(), "up to date"))
+
+	// Existing config file in another format is replaced
+	in.Format = "yaml"
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(2, len(out.Files))
+	is.Equal(filepath.Join(tmp, "config.prod.yaml"), out.Files[0].Path)
+	is.Equal(filepath.Join(tmp, "config.prod.json"), out.Files[1].Path)
+	is.True(out.Files[1].Del)
+	in.Format = ""
+
+	// New config file in the given format
+	in.Env = "stage"
+	in.Format = "yaml"
+	in.Import = ArgMap{"k8s://myapp/configmap/myapp-env"}
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(filepath.Join(tmp, "config.stage.yaml"), out.Files[0].Path)
+
+	// Protected env
+	in.manifest = &Manifest{Protected: []string{"stage"}}
+	_, err = Cmd(in)
+	is.True(err != nil)
+
+	// Not found
+	in.manifest = nil
+	in.Import = ArgMap{"k8s://myapp/configmap/missing"}
+	_, err = Cmd(in)
+	is.True(err != nil)
+}
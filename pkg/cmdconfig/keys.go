@@ -0,0 +1,150 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+)
+
+// keyInfo describes a key in the config for env
+type keyInfo struct {
+	Env string `json:"env"`
+	Key string `json:"key"`
+	// Type declared in the schema, string by default
+	Type string `json:"type"`
+	// Source is the config file the key was loaded from,
+	// relative to APP_DIR
+	Source string `json:"source"`
+}
+
+// envKeyInfo returns the keys in config, sorted by key.
+// Config paths are the files loaded for env, see newConf
+func envKeyInfo(appDir, env string, c *conf, configPaths []string,
+	schema Schema) (keys []keyInfo) {
+
+	sources := make(map[string]string)
+	for _, configPath := range configPaths {
+		source, err := filepath.Rel(appDir, configPath)
+		if err != nil || strings.HasPrefix(source, "..") {
+			// E.g. the file flag
+			source = configPath
+		}
+		sources[filepath.Clean(filepath.Dir(configPath))] = filepath.ToSlash(source)
+	}
+
+	keys = make([]keyInfo, 0, len(c.Keys))
+	// Assuming c.Keys is already sorted
+	for _, key := range c.Keys {
+		t := schema[key].Type
+		if t == "" {
+			t = TypeString
+		}
+		keys = append(keys, keyInfo{
+			Env:    env,
+			Key:    key,
+			Type:   t,
+			Source: sources[filepath.Clean(c.Dirs[key])],
+		})
+	}
+	return keys
+}
+
+// printKeys lists the keys for the envs as per the all and env flags.
+// By default only the distinct key names are printed,
+// the type and source of each key is listed with the detail flag
+func printKeys(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
+	buf = new(bytes.Buffer)
+
+	envs, err := updateEnvs(in)
+	if err != nil {
+		return buf, files, err
+	}
+	schema, err := readSchema(in.dirs, in.AppDir)
+	if err != nil {
+		return buf, files, err
+	}
+
+	keysByEnv := make([][]keyInfo, len(envs))
+	err = eachEnv(envs, func(i int, env string) error {
+		configPaths, config, err := newConf(confParams{
+			dirs:   in.dirs,
+			prefix: in.Prefix,
+			appDir: in.AppDir,
+			env:    env,
+			extend: in.Extend,
+			merge:  in.Merge,
+			parent: in.Parent,
+			file:   in.File,
+		})
+		if err != nil {
+			return err
+		}
+		keysByEnv[i] = envKeyInfo(in.AppDir, env, config, configPaths, schema)
+		return nil
+	})
+	if err != nil {
+		return buf, files, err
+	}
+	keys := make([]keyInfo, 0)
+	for _, infos := range keysByEnv {
+		keys = append(keys, infos...)
+	}
+
+	if !in.KeysDetail {
+		// Distinct names, envs may have the same keys
+		names := make([]string, 0)
+		seen := make(map[string]bool)
+		for _, k := range keys {
+			if !seen[k.Key] {
+				seen[k.Key] = true
+				names = append(names, k.Key)
+			}
+		}
+		sort.Strings(names)
+		if in.JSON {
+			b, err := json.MarshalIndent(names, "", "    ")
+			if err != nil {
+				return buf, files, errors.WithStack(err)
+			}
+			buf.Write(b)
+			buf.WriteString("\n")
+			return buf, files, nil
+		}
+		for _, name := range names {
+			buf.WriteString(name)
+			buf.WriteString("\n")
+		}
+		return buf, files, nil
+	}
+
+	if in.JSON {
+		b, err := json.MarshalIndent(keys, "", "    ")
+		if err != nil {
+			return buf, files, errors.WithStack(err)
+		}
+		buf.Write(b)
+		buf.WriteString("\n")
+		return buf, files, nil
+	}
+
+	if len(keys) == 0 {
+		return buf, files, nil
+	}
+	w := tabwriter.NewWriter(buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ENV\tKEY\tTYPE\tSOURCE")
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", k.Env, k.Key, k.Type, k.Source)
+	}
+	err = w.Flush()
+	if err != nil {
+		return buf, files, errors.WithStack(err)
+	}
+
+	return buf, files, nil
+}
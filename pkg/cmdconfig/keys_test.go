@@ -0,0 +1,68 @@
+package cmdconfig
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestPrintKeys(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"),
+		[]byte(`{"APP_PORT": "8080", "APP_FOO": "foo"}`), perms)
+	is.NoErr(err)
+	err = os.WriteFile(filepath.Join(tmp, "config.prod.json"),
+		[]byte(`{"APP_PORT": "443", "APP_BAR": "bar"}`), perms)
+	is.NoErr(err)
+	err = os.WriteFile(filepath.Join(tmp, FileNameTypes),
+		[]byte(`{"APP_PORT": "int"}`), perms)
+	is.NoErr(err)
+	ext := filepath.Join(tmp, "ext")
+	is.NoErr(os.MkdirAll(ext, 0755))
+	err = os.WriteFile(filepath.Join(ext, "config.dev.json"),
+		[]byte(`{"APP_EXT": "ext"}`), perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = "*"
+	in.ListKeys = true
+
+	// Distinct names for all envs
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdKeys, out.Cmd)
+	is.Equal("APP_BAR\nAPP_FOO\nAPP_PORT\n", out.Buf.String())
+
+	// Type and source with extensions
+	in.Env = "dev"
+	in.Extend = ArgMap{"ext"}
+	in.KeysDetail = true
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(`ENV  KEY       TYPE    SOURCE
+dev  APP_EXT   string  ext/config.dev.json
+dev  APP_FOO   string  config.dev.json
+dev  APP_PORT  int     config.dev.json
+`, out.Buf.String())
+
+	in.JSON = true
+	out, err = Cmd(in)
+	is.NoErr(err)
+	keys := make([]keyInfo, 0)
+	is.NoErr(json.Unmarshal(out.Buf.Bytes(), &keys))
+	is.Equal(3, len(keys))
+	is.Equal(keyInfo{Env: "dev", Key: "APP_PORT", Type: TypeInt,
+		Source: "config.dev.json"}, keys[2])
+}
@@ -0,0 +1,75 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/pkg/errors"
+)
+
+// ldflagsQuote quotes s as per the go command's flag splitting,
+// quotes can't be escaped, so a value with both quote types is rejected
+func ldflagsQuote(s string) (string, error) {
+	if !strings.ContainsAny(s, " \t\n\r'\"") {
+		return s, nil
+	}
+	if !strings.Contains(s, "'") {
+		return "'" + s + "'", nil
+	}
+	if !strings.Contains(s, `"`) {
+		return `"` + s + `"`, nil
+	}
+	return s, errors.Errorf("value contains single and double quotes")
+}
+
+// printLdflags prints the -X flags that set the package vars of the
+// config package generated at the ldflags path, e.g.
+//
+//	go build -ldflags "$(configu -env prod -ldflags pkg/config)"
+//
+// Empty values are skipped, since SetVars ignores them
+func printLdflags(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
+	buf = new(bytes.Buffer)
+
+	config, err := newGenerateConf(in)
+	if err != nil {
+		return buf, files, err
+	}
+
+	dir := filepath.Join(in.AppDir, in.Ldflags)
+	pkg, err := importPath(dir)
+	if err != nil {
+		return buf, files, err
+	}
+
+	// Secret references are only resolved if explicitly enabled
+	if in.Secrets {
+		_, err = share.ResolveSecrets(config.Map)
+		if err != nil {
+			return buf, files, err
+		}
+	}
+
+	// Keys in extension dirs are generated in the extension package
+	keys := extensionTargets(in.AppDir, []string{dir}, config)[dir]
+	flags := make([]string, 0, len(keys))
+	for _, key := range keys {
+		value := config.Map[key]
+		if value == "" {
+			continue
+		}
+		name := ToPrivate(FormatKey(in.Prefix, key))
+		arg, err := ldflagsQuote(fmt.Sprintf("%s.%s=%s", pkg, name, value))
+		if err != nil {
+			return buf, files, errors.WithMessagef(err, "key %s", key)
+		}
+		flags = append(flags, "-X "+arg)
+	}
+	buf.WriteString(strings.Join(flags, " "))
+	buf.WriteString("\n")
+
+	return buf, files, nil
+}
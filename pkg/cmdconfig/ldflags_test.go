@@ -0,0 +1,57 @@
+package cmdconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestLdflagsQuote(t *testing.T) {
+	is := testutil.Setup(t)
+
+	s, err := ldflagsQuote("a.b=c")
+	is.NoErr(err)
+	is.Equal("a.b=c", s)
+	s, err = ldflagsQuote("a.b=c d")
+	is.NoErr(err)
+	is.Equal("'a.b=c d'", s)
+	s, err = ldflagsQuote("a.b=it's")
+	is.NoErr(err)
+	is.Equal(`"a.b=it's"`, s)
+	_, err = ldflagsQuote(`a.b='"`)
+	is.True(err != nil)
+}
+
+func TestPrintLdflags(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	err = os.WriteFile(filepath.Join(tmp, "go.mod"),
+		[]byte("module example.com/app\n"), perms)
+	is.NoErr(err)
+	err = os.WriteFile(filepath.Join(tmp, "config.prod.json"),
+		[]byte(`{"APP_FOO": "foo", "APP_BAR": "b a r", "APP_BUZ": ""}`), perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = EnvProd
+	in.Ldflags = filepath.Join("pkg", "config")
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdLdflags, out.Cmd)
+	pkg := "example.com/app/pkg/config"
+	// Empty values are skipped
+	is.Equal(fmt.Sprintf("-X '%s.bar=b a r' -X %s.foo=foo\n", pkg, pkg),
+		out.Buf.String())
+}
@@ -0,0 +1,148 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// Lint rules for key names
+const (
+	LintPrefix = "prefix"
+	LintCase   = "case"
+	LintLength = "length"
+)
+
+// lintMaxKeyLen is the maximum length of key names, including the prefix.
+// Longer names are hard to read, and truncated by some tools
+const lintMaxKeyLen = 64
+
+// screamingSnake matches SCREAMING_SNAKE_CASE key names
+var screamingSnake = regexp.MustCompile(`^[A-Z][A-Z0-9]*(_[A-Z0-9]+)*$`)
+
+// lintProblem is a key name that breaks a lint rule
+type lintProblem struct {
+	Env  string `json:"env"`
+	Key  string `json:"key"`
+	Rule string `json:"rule"`
+	// Fix is the suggested key name, empty if there is no suggestion
+	Fix string `json:"fix,omitempty"`
+}
+
+// toScreamingSnake converts the key name, e.g. APP_fooBar to APP_FOO_BAR
+func toScreamingSnake(key string) string {
+	b := new(strings.Builder)
+	var prev rune
+	for _, r := range key {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			// Word boundary for camel case
+			if unicode.IsUpper(r) && unicode.IsLower(prev) {
+				b.WriteRune('_')
+			}
+			b.WriteRune(unicode.ToUpper(r))
+		case prev != '_' && b.Len() > 0:
+			// Other separators, e.g. dashes and dots
+			r = '_'
+			b.WriteRune(r)
+		default:
+			r = '_'
+		}
+		prev = r
+	}
+	return strings.TrimRight(b.String(), "_")
+}
+
+// lintKey returns the problems with the key name,
+// the fix suggested for the prefix and case rules is the same
+func lintKey(prefix, env, key string) (problems []lintProblem) {
+	problems = make([]lintProblem, 0)
+	fix := toScreamingSnake(key)
+	if !strings.HasPrefix(fix, prefix) {
+		fix = prefix + fix
+	}
+	if !strings.HasPrefix(key, prefix) {
+		problems = append(problems,
+			lintProblem{Env: env, Key: key, Rule: LintPrefix, Fix: fix})
+	} else if !screamingSnake.MatchString(key) {
+		problems = append(problems,
+			lintProblem{Env: env, Key: key, Rule: LintCase, Fix: fix})
+	}
+	if len(key) > lintMaxKeyLen {
+		problems = append(problems,
+			lintProblem{Env: env, Key: key, Rule: LintLength})
+	}
+	return problems
+}
+
+// lintKeys checks the key names for the envs as per the all and env flags.
+// The exit code is set if there are problems, the suggested fixes
+// may be applied with the rename flag
+func lintKeys(in *CmdIn) (
+	buf *bytes.Buffer, files []File, exitCode int, err error) {
+
+	buf = new(bytes.Buffer)
+
+	envs, err := updateEnvs(in)
+	if err != nil {
+		return buf, files, exitCode, err
+	}
+
+	// Keys matching the ignore flags or manifest are not linted
+	ignore := make([]string, 0)
+	ignore = append(ignore, in.manifest.ignore()...)
+	ignore = append(ignore, in.Ignore...)
+
+	problems := make([]lintProblem, 0)
+	for _, env := range envs {
+		_, c, err := newCachedConf(in.dirs, in.AppDir, env)
+		if err != nil {
+			return buf, files, exitCode, err
+		}
+		// Assuming c.Keys is already sorted
+		for _, key := range c.Keys {
+			if ignoreKey(ignore, key) {
+				continue
+			}
+			for _, p := range lintKey(in.Prefix, env, key) {
+				// Renaming to an existing key would fail
+				if _, ok := c.Map[p.Fix]; ok {
+					p.Fix = ""
+				}
+				problems = append(problems, p)
+			}
+		}
+	}
+	if len(problems) > 0 {
+		exitCode = 1
+	}
+
+	if in.JSON {
+		b, err := json.MarshalIndent(problems, "", "    ")
+		if err != nil {
+			return buf, files, exitCode, errors.WithStack(err)
+		}
+		buf.Write(b)
+		buf.WriteString("\n")
+		return buf, files, exitCode, nil
+	}
+
+	for _, p := range problems {
+		switch {
+		case p.Rule == LintLength:
+			buf.WriteString(fmt.Sprintf("%s %s %s: longer than %d characters\n",
+				p.Env, p.Key, p.Rule, lintMaxKeyLen))
+		case p.Fix != "":
+			buf.WriteString(fmt.Sprintf("%s %s %s: fix with -%s %s=%s\n",
+				p.Env, p.Key, p.Rule, FlagRename, p.Key, p.Fix))
+		default:
+			buf.WriteString(fmt.Sprintf("%s %s %s\n", p.Env, p.Key, p.Rule))
+		}
+	}
+	return buf, files, exitCode, nil
+}
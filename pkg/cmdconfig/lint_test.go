@@ -0,0 +1,81 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestToScreamingSnake(t *testing.T) {
+	is := testutil.Setup(t)
+
+	for key, expected := range map[string]string{
+		"APP_FOO":      "APP_FOO",
+		"APP_bar":      "APP_BAR",
+		"APP_fooBar":   "APP_FOO_BAR",
+		"app-foo.bar":  "APP_FOO_BAR",
+		"APP__FOO_":    "APP_FOO",
+		"_APP_FOO":     "APP_FOO",
+		"APP_FOO2_BAR": "APP_FOO2_BAR",
+	} {
+		is.Equal(expected, toScreamingSnake(key))
+	}
+}
+
+func TestLintKeys(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	long := "APP_" + strings.Repeat("X", lintMaxKeyLen)
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"), []byte(`{
+		"APP_FOO": "1",
+		"APP_bar": "2",
+		"APP_foo": "3",
+		"dbHost": "4",
+		"`+long+`": "5"
+	}`), perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	in.Lint = true
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdLint, out.Cmd)
+	is.Equal(1, out.ExitCode)
+	is.Equal(strings.Join([]string{
+		"dev " + long + " length: longer than 64 characters",
+		"dev APP_bar case: fix with -rename APP_bar=APP_BAR",
+		// The fix would conflict with an existing key
+		"dev APP_foo case",
+		"dev dbHost prefix: fix with -rename dbHost=APP_DB_HOST",
+	}, "\n")+"\n", out.Buf.String())
+
+	// Apply the suggested fix
+	in.Lint = false
+	in.Rename = "dbHost=APP_DB_HOST"
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.NoErr(out.Files.Save(new(bytes.Buffer)))
+
+	in.Rename = ""
+	in.Lint = true
+	in.Ignore = ArgMap{"APP_foo", long}
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal("dev APP_bar case: fix with -rename APP_bar=APP_BAR\n",
+		out.Buf.String())
+}
@@ -2,10 +2,13 @@ package cmdconfig
 
 import (
 	"flag"
+	"fmt"
+	"io"
 	"os"
 	"strings"
 
 	"github.com/mozey/config/pkg/share"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 )
 
@@ -21,107 +24,425 @@ func (a *ArgMap) Set(value string) error {
 }
 
 const (
-	FlagAll      = "all"
-	FlagBase64   = "base64"
-	FlagCompare  = "compare"
-	FlagCSV      = "csv"
-	FlagDel      = "del"
-	FlagDryRun   = "dry-run"
-	FlagEnv      = "env"
-	FlagExtend   = "extend"
-	FlagGenerate = "generate"
-	FlagGet      = "get"
-	FlagKey      = "key"
-	FlagMerge    = "merge"
-	FlagPrefix   = "prefix"
-	FlagSep      = "sep"
-	FlagValue    = "value"
-	FlagVersion  = "version"
-	FlagOS       = "os"
-	FlagFormat   = "format"
+	FlagAll              = "all"
+	FlagAWS              = "aws"
+	FlagBase64           = "base64"
+	FlagBindFlags        = "bind-flags"
+	FlagClean            = "clean"
+	FlagCompare          = "compare"
+	FlagCompletion       = "completion"
+	FlagConfigTest       = "configtest"
+	FlagCSV              = "csv"
+	FlagDecryptKey       = "decrypt-key"
+	FlagDel              = "del"
+	FlagDryRun           = "dry-run"
+	FlagEncryptKey       = "encrypt-key"
+	FlagEnv              = "env"
+	FlagEnvconfig        = "envconfig"
+	FlagExec             = "exec"
+	FlagExport           = "export"
+	FlagExportBundle     = "export-bundle"
+	FlagExtend           = "extend"
+	FlagFile             = "file"
+	FlagFmt              = "fmt"
+	FlagForce            = "force"
+	FlagFrom             = "from"
+	FlagGenerate         = "generate"
+	FlagGet              = "get"
+	FlagGetFormat        = "get-format"
+	FlagIgnore           = "ignore"
+	FlagIgnoreValue      = "ignore-value"
+	FlagImport           = "import"
+	FlagImportBundle     = "import-bundle"
+	FlagImportEnv        = "import-env"
+	FlagInit             = "init"
+	FlagJSON             = "json"
+	FlagKey              = "key"
+	FlagKeys             = "keys"
+	FlagKeysDetail       = "keys-detail"
+	FlagLdflags          = "ldflags"
+	FlagLint             = "lint"
+	FlagMerge            = "merge"
+	FlagMust             = "must"
+	FlagNoVars           = "no-vars"
+	FlagParent           = "parent"
+	FlagParity           = "parity"
+	FlagPrecedence       = "precedence"
+	FlagPrefix           = "prefix"
+	FlagPrune            = "prune"
+	FlagPull             = "pull"
+	FlagPush             = "push"
+	FlagRefs             = "refs"
+	FlagRename           = "rename"
+	FlagRequireOwner     = "require-owner"
+	FlagPreview          = "preview"
+	FlagSafe             = "safe"
+	FlagSample           = "sample"
+	FlagSampleDefaults   = "sample-defaults"
+	FlagScaffoldCmd      = "scaffold-cmd"
+	FlagSecrets          = "secrets"
+	FlagSep              = "sep"
+	FlagService          = "service"
+	FlagShell            = "shell"
+	FlagStats            = "stats"
+	FlagSyncSamples      = "sync-samples"
+	FlagTemplate         = "template"
+	FlagTo               = "to"
+	FlagTransform        = "transform"
+	FlagTypeScript       = "typescript"
+	FlagTypeScriptLoader = "typescript-loader"
+	FlagValidate         = "validate"
+	FlagValue            = "value"
+	FlagValues           = "values"
+	FlagVerbose          = "v"
+	FlagVersion          = "version"
+	FlagWatch            = "watch"
+	FlagWorkspace        = "workspace"
+	FlagOS               = "os"
+	FlagFormat           = "format"
 )
 
 // ParseFlags before calling Cmd
 func ParseFlags(version string) *CmdIn {
-	in := NewCmdIn(CmdInParams{Version: version})
+	in, err := parseFlags(flag.CommandLine, version, os.Args[1:], nil)
+	if err != nil {
+		log.Error().Stack().Err(err).Msg("")
+		os.Exit(1)
+	}
+	return in
+}
+
+// ParseFlagSet is like ParseFlags, but parses args with fs,
+// and returns an error instead of exiting
+func ParseFlagSet(fs *flag.FlagSet, version string, args []string) (
+	*CmdIn, error) {
+
+	return parseFlags(fs, version, args, nil)
+}
+
+// parseFlags defines the flags on fs and parses args,
+// default flags and aliases are expanded with the project manifest.
+// Custom flags are defined with the custom func, if not nil
+func parseFlags(fs *flag.FlagSet, version string, args []string,
+	custom func(fs *flag.FlagSet)) (in *CmdIn, err error) {
+
+	in = NewCmdIn(CmdInParams{Version: version})
 
 	// Flags
-	flag.BoolVar(&in.PrintVersion,
+	fs.BoolVar(&in.PrintVersion,
 		FlagVersion, false, "Print build version")
-	flag.StringVar(&in.Prefix,
+	fs.BoolVar(&in.Verbose,
+		FlagVerbose, false, "Print stack traces for errors")
+	fs.StringVar(&in.Prefix,
 		FlagPrefix, "APP_", "Config key prefix")
-	flag.StringVar(&in.Env,
+	fs.StringVar(&in.Env,
 		FlagEnv, share.EnvDev,
 		"Config file to use, also supports wildcards \"*\" and \"sample.*\"")
-	flag.BoolVar(&in.All,
+	fs.BoolVar(&in.All,
 		FlagAll, false, "Apply to all config files and samples")
-	flag.BoolVar(&in.Del,
+	fs.BoolVar(&in.Del,
 		FlagDel, false, "Delete the specified keys")
 	// Default must be empty
-	flag.StringVar(&in.Compare,
+	fs.StringVar(&in.Compare,
 		FlagCompare, "", "Compare config file keys")
+	fs.BoolVar(&in.AWS,
+		FlagAWS, false, "AWS SSO login and assume role for env, credentials are kept in the session")
+	fs.Var(&in.EncryptKey, FlagEncryptKey,
+		"Encrypt the value for this key, the key is read from "+share.EncryptionKeyEnvKey)
+	fs.Var(&in.DecryptKey, FlagDecryptKey,
+		"Replace the encrypted value for this key with the plaintext")
+	fs.Var(&in.Parity, FlagParity,
+		"Compare env with the shell and this file, e.g. a CI env file or k8s manifest")
+	fs.BoolVar(&in.Exec,
+		FlagExec, false, "Run the command after the flags with the config for env")
+	fs.BoolVar(&in.SyncSamples,
+		FlagSyncSamples, false, "Add keys missing from the sample for env")
+	fs.BoolVar(&in.Prune,
+		FlagPrune, false, "Delete keys that are not in the sample for env")
+	fs.BoolVar(&in.Fmt,
+		FlagFmt, false, "Rewrite config files with sorted keys and consistent quoting")
+	fs.StringVar(&in.From,
+		FlagFrom, "", "Config file to convert, the format is set by the extension")
+	fs.StringVar(&in.To, FlagTo, "",
+		"Convert the from file to this path, or config files for env to this format")
+	fs.StringVar(&in.File,
+		FlagFile, "", "Read this config file instead of the file for env")
+	fs.BoolVar(&in.Lint,
+		FlagLint, false, "Check key names for prefix, case, and length")
+	fs.BoolVar(&in.Validate,
+		FlagValidate, false, "Check env against the sample and schema, for CI")
+	fs.BoolVar(&in.CompareValues,
+		FlagValues, false, "Also list keys with different values when comparing")
 	in.Keys = ArgMap{}
-	flag.Var(&in.Keys,
+	fs.Var(&in.Keys,
 		FlagKey, "Set key and print config JSON")
 	in.Values = ArgMap{}
-	flag.Var(&in.Values,
+	fs.Var(&in.Values,
 		FlagValue, "Value for last key specified")
+	in.PrintValues = ArgMap{}
+	fs.Var(&in.PrintValues,
+		FlagGet, "Print value for given key, may be repeated or a comma list")
 	// Default must be empty
-	flag.StringVar(&in.PrintValue,
-		FlagGet, "", "Print value for given key")
-	// Default must be empty
-	flag.StringVar(&in.Generate,
-		FlagGenerate, "", "Generate config helper at path")
-	flag.BoolVar(&in.CSV,
+	fs.StringVar(&in.Template,
+		FlagTemplate, "", "Print values with template, e.g. \"{{.APP_FOO}}\"")
+	fs.StringVar(&in.Template,
+		FlagGetFormat, "", fmt.Sprintf("Alias for the %s flag", FlagTemplate))
+	in.Transform = ArgMap{}
+	fs.Var(&in.Transform,
+		FlagTransform, fmt.Sprintf(
+			"Transform values printed with get, may be repeated, one of %s",
+			strings.Join(Transforms(), ", ")))
+	in.GenerateAll = ArgMap{}
+	fs.Var(&in.GenerateAll,
+		FlagGenerate, "Generate config helper at path, may be repeated")
+	fs.BoolVar(&in.CSV,
 		FlagCSV, false, "Print env as a list of key=value")
-	flag.StringVar(&in.Sep,
+	fs.StringVar(&in.Sep,
 		FlagSep, ",", "Separator for use with csv flag")
-	flag.BoolVar(&in.DryRun,
+	fs.BoolVar(&in.DryRun,
 		FlagDryRun, false, "Don't write files, just print result")
-	flag.BoolVar(&in.Base64,
+	fs.BoolVar(&in.Base64,
 		FlagBase64, false, "Encode config file as base64 string")
-	flag.StringVar(&in.OS,
-		FlagOS, "other",
-		"Override compiled x-platform config")
-	flag.StringVar(&in.Format,
+	// Default must be empty
+	fs.StringVar(&in.OS,
+		FlagOS, "",
+		"Override detected shell, e.g. posix, fish, windows, powershell, other")
+	fs.StringVar(&in.Format,
 		FlagFormat, "", "Override config file format")
 	in.Extend = ArgMap{}
-	flag.Var(&in.Extend,
+	fs.Var(&in.Extend,
 		FlagExtend, "Extend config")
-	flag.BoolVar(&in.Merge,
+	fs.BoolVar(&in.Merge,
 		FlagMerge, false, "Merge with parent config")
+	fs.StringVar(&in.Parent,
+		FlagParent, "", "Parent dir for merge, relative to the app dir")
+	// Default must be empty
+	fs.StringVar(&in.Export,
+		FlagExport, "", fmt.Sprintf(
+			"Export config in format %s", strings.Join(ExportFormats(), ", ")))
+	fs.StringVar(&in.ExportBundle,
+		FlagExportBundle, "", "Write config files for env, schema, and manifest to tar.gz file")
+	fs.StringVar(&in.ImportBundle,
+		FlagImportBundle, "", "Validate bundle and unpack config files in APP_DIR")
+	fs.BoolVar(&in.Stats,
+		FlagStats, false, "Print key count and size report per env")
+	fs.BoolVar(&in.Refs,
+		FlagRefs, false, "List secret references per env without resolving them")
+	// Default must be empty
+	fs.StringVar(&in.Rename,
+		FlagRename, "", "Rename key in config and sample files, e.g. APP_OLD=APP_NEW")
+	fs.BoolVar(&in.ListKeys,
+		FlagKeys, false, "Print sorted key names for env")
+	fs.BoolVar(&in.KeysDetail,
+		FlagKeysDetail, false, "Print env, type, and source file with the keys flag")
+	in.IgnoreValues = ArgMap{}
+	fs.Var(&in.IgnoreValues,
+		FlagIgnoreValue, "Value that may be repeated for distinct keys")
+	fs.BoolVar(&in.Preview,
+		FlagPreview, false, "Print table of changes to env on stderr")
+	fs.BoolVar(&in.Secrets,
+		FlagSecrets, false, "Resolve secret references, e.g. gcpsm://... or azkv://...")
+	fs.BoolVar(&in.Safe,
+		FlagSafe, false, "Only unset env vars previously exported by configu")
+	fs.BoolVar(&in.Force,
+		FlagForce, false, "Allow updating protected envs, value types, and overwriting S3 objects or files")
+	fs.StringVar(&in.Service,
+		FlagService, "", "Run for the service listed in the manifest")
+	fs.BoolVar(&in.Workspace,
+		FlagWorkspace, false, "Run for all services listed in the manifest")
+	// Default must be empty
+	fs.StringVar(&in.Ldflags,
+		FlagLdflags, "", "Print -X flags for the config package at path")
+	// Default must be empty
+	fs.StringVar(&in.Pull,
+		FlagPull, "", "Pull config file from S3 URI, e.g. s3://bucket/app/")
+	fs.BoolVar(&in.Init,
+		FlagInit, false, "Create config files for env and generate pkg/config")
+	// Default must be empty
+	fs.StringVar(&in.ScaffoldCmd,
+		FlagScaffoldCmd, "", "Generate cmd/<name>/main.go for a custom build")
+	in.Import = ArgMap{}
+	fs.Var(&in.Import,
+		FlagImport, "Import keys from ConfigMap or Secret, e.g. k8s://namespace/configmap/name")
+	fs.BoolVar(&in.ImportEnv,
+		FlagImportEnv, false, "Import env vars matching the prefix into the config file for env")
+	// Default must be empty
+	fs.StringVar(&in.Push,
+		FlagPush, "", "Push config file to S3 URI, e.g. s3://bucket/app/")
+	in.Ignore = ArgMap{}
+	fs.Var(&in.Ignore,
+		FlagIgnore, "Glob for keys skipped by compare, may be repeated")
+	fs.BoolVar(&in.RequireOwner,
+		FlagRequireOwner, false, "Keys added with the key flag must have an owner")
+	fs.BoolVar(&in.JSON,
+		FlagJSON, false, "Print compare, get, keys, lint, refs, update, or validate results as JSON")
+	fs.BoolVar(&in.Sample,
+		FlagSample, false, "Create or update sample config files with blank values")
+	fs.BoolVar(&in.SampleDefaults,
+		FlagSampleDefaults, false, "Generate defaults from the sample config file")
+	fs.BoolVar(&in.Must,
+		FlagMust, false, "Generate Must getters that panic if the value is empty")
+	// Default must be empty
+	fs.StringVar(&in.TypeScript,
+		FlagTypeScript, "", "Generate TypeScript definitions at path")
+	fs.BoolVar(&in.TypeScriptLoader,
+		FlagTypeScriptLoader, false, "Also generate a TypeScript loader")
+	fs.BoolVar(&in.Envconfig,
+		FlagEnvconfig, false, "Generate Env struct with tags for env loaders")
+	fs.BoolVar(&in.NoVars,
+		FlagNoVars, false, "Generate config without package vars for ldflags")
+	fs.BoolVar(&in.BindFlags,
+		FlagBindFlags, false, "Generate RegisterFlags to set keys with flags")
+	fs.BoolVar(&in.ConfigTest,
+		FlagConfigTest, false, "Generate the configtest package for tests")
+	fs.BoolVar(&in.Watch,
+		FlagWatch, false, "Generate Watch to reload the config file on change")
+	fs.StringVar(&in.Precedence,
+		FlagPrecedence, "", fmt.Sprintf(
+			"Generated precedence, %s overrides vars, or %s override env",
+			PrecedenceEnv, PrecedenceVars))
+	fs.BoolVar(&in.Clean,
+		FlagClean, false, "Delete orphaned generated files")
+	// Default must be empty
+	fs.StringVar(&in.Shell,
+		FlagShell, "", fmt.Sprintf(
+			"Print conf func for shell %s", strings.Join(Shells(), ", ")))
+	fs.StringVar(&in.Completion,
+		FlagCompletion, "", fmt.Sprintf(
+			"Print completion script for shell %s", strings.Join(Completions(), ", ")))
 
-	flag.Parse()
+	if custom != nil {
+		custom(fs)
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		in.flags = append(in.flags, f.Name)
+	})
 
-	return in
+	// Project manifest may define default flags and aliases
+	wd, err := os.Getwd()
+	if err == nil {
+		in.manifest, err = LoadManifest(wd)
+	}
+	if err != nil {
+		return in, err
+	}
+	args, err = in.manifest.ExpandArgs(args)
+	if err != nil {
+		return in, err
+	}
+	// Not wrapped, callers check for flag.ErrHelp
+	err = fs.Parse(args)
+	in.Command = fs.Args()
+	return in, err
+}
+
+// Main function for cmd/configu, see Run
+func Main(version string) {
+	os.Exit(Run(version, os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// Run the configu command with args, excluding the program name,
+// and return the exit code. Unlike Main, Run never calls os.Exit or panics,
+// so it can be embedded, e.g. with deferred cleanup.
+// For custom flags and commands use RunWith, see ScaffoldCmd
+func Run(version string, args []string,
+	stdin io.Reader, stdout, stderr io.Writer) (exitCode int) {
+
+	return RunWith(RunParams{
+		Version: version,
+		Args:    args,
+		Stdin:   stdin,
+		Stdout:  stdout,
+		Stderr:  stderr,
+	})
 }
 
-// Main function for cmd/configu.
-// The configu command can be customized by copying the code below.
+// Plugin for custom commands. Plugins are called in order after the flags
+// are validated, the first plugin to return a non-nil CmdOut handles the
+// command, and Cmd is not called. Return nil to fall through.
 // Try not to change the default behaviour, e.g.
 // custom flags must only add functionality
-func Main(version string) {
+type Plugin func(in *CmdIn) (out *CmdOut, err error)
+
+type RunParams struct {
+	// Version to print with the version flag
+	Version string
+	// Args excluding the program name
+	Args   []string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// Flags defines custom flags on fs, before args are parsed
+	Flags func(fs *flag.FlagSet)
+	// Plugins for custom commands
+	Plugins []Plugin
+}
+
+// RunWith is like Run, with custom flags and plugins
+func RunWith(params RunParams) (exitCode int) {
+	stderr := params.Stderr
+
+	// Stack traces are only printed with the verbose flag
+	verbose := false
+	defer func() {
+		if r := recover(); r != nil {
+			reportError(stderr, verbose, errors.Errorf("panic: %v", r))
+			exitCode = 1
+		}
+	}()
+
 	// Parse and validate flags
-	in := ParseFlags(version)
-	err := in.Valid()
+	fs := flag.NewFlagSet("configu", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	in, err := parseFlags(fs, params.Version, params.Args, params.Flags)
+	if err == flag.ErrHelp {
+		return 0
+	}
 	if err != nil {
-		log.Error().Stack().Err(err).Msg("")
-		os.Exit(1)
+		// Invalid flags are printed by fs, with the usage
+		if !fs.Parsed() {
+			reportError(stderr, verbose, err)
+		}
+		return 2
+	}
+	verbose = in.Verbose
+	in.Stdin, in.Stdout, in.Stderr = params.Stdin, params.Stdout, stderr
+	err = in.Valid()
+	if err != nil {
+		reportError(stderr, verbose, err)
+		return 1
 	}
 
-	// Insert your custom code here...
-
-	// Run cmd
-	out, err := Cmd(in)
+	// Run plugins, then cmd
+	var out *CmdOut
+	for _, plugin := range params.Plugins {
+		out, err = plugin(in)
+		if err != nil || out != nil {
+			break
+		}
+	}
+	if err == nil && out == nil {
+		out, err = Cmd(in)
+	}
 	if err != nil {
-		log.Error().Stack().Err(err).Msg("")
-		os.Exit(1)
+		reportError(stderr, verbose, err)
+		return 1
 	}
 
 	// Process cmd results
-	exitCode, err := in.Process(out)
+	exitCode, err = in.Process(out)
 	if err != nil {
-		log.Error().Stack().Err(err).Msg("")
+		reportError(stderr, verbose, err)
+	}
+	return exitCode
+}
+
+// reportError prints the error message to w,
+// with the stack trace if verbose is set
+func reportError(w io.Writer, verbose bool, err error) {
+	if verbose {
+		fmt.Fprintf(w, "ERROR %+v\n", err)
+		return
 	}
-	os.Exit(exitCode)
+	fmt.Fprintf(w, "ERROR %v\n", err)
 }
@@ -0,0 +1,117 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestRun(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+	t.Setenv("APP_DIR", tmp)
+
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	exitCode := Run("v1.2.3", []string{"-version"}, nil, stdout, stderr)
+	is.Equal(0, exitCode)
+	is.Equal("v1.2.3", strings.TrimSpace(stdout.String()))
+
+	// Flag errors are written to stderr
+	stdout.Reset()
+	exitCode = Run("v1.2.3", []string{"-nope"}, nil, stdout, stderr)
+	is.Equal(2, exitCode)
+	is.True(strings.Contains(stderr.String(), "-nope"))
+	is.Equal("", stdout.String())
+
+	// Cmd errors return an exit code instead of exiting,
+	// the message includes the probable fix
+	stderr.Reset()
+	exitCode = Run("v1.2.3", []string{"-env", "prod"}, nil, stdout, stderr)
+	is.Equal(1, exitCode)
+	is.True(strings.HasPrefix(stderr.String(),
+		"ERROR config file not found for env prod\n"))
+	is.True(!strings.Contains(stderr.String(), "main.go"))
+
+	// Stack traces are printed with the verbose flag
+	stderr.Reset()
+	exitCode = Run("v1.2.3", []string{"-v", "-env", "prod"}, nil, stdout, stderr)
+	is.Equal(1, exitCode)
+	is.True(strings.Contains(stderr.String(), "readConfigFile"))
+
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"),
+		[]byte(`{"APP_DB_USER": "u", "APP_DB_HOST": "localhost"}`), perms)
+	is.NoErr(err)
+	stdout.Reset()
+	exitCode = Run("v1.2.3", []string{
+		"-get-format", "postgres://{{.APP_DB_USER}}@{{.APP_DB_HOST}}/app"},
+		nil, stdout, stderr)
+	is.Equal(0, exitCode)
+	is.Equal("postgres://u@localhost/app", stdout.String())
+
+	t.Setenv("APP_DIR", "")
+	stderr.Reset()
+	exitCode = Run("v1.2.3", []string{}, nil, stdout, stderr)
+	is.Equal(1, exitCode)
+	is.True(strings.Contains(stderr.String(), `export APP_DIR="$(pwd)"`))
+}
+
+func TestRunWith(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+	t.Setenv("APP_DIR", tmp)
+
+	hello := false
+	params := RunParams{
+		Version: "v1.2.3",
+		Stdout:  new(bytes.Buffer),
+		Stderr:  new(bytes.Buffer),
+		Flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&hello, "hello", false, "")
+		},
+		Plugins: []Plugin{
+			func(in *CmdIn) (*CmdOut, error) {
+				if !hello {
+					return nil, nil
+				}
+				return &CmdOut{Cmd: "hello",
+					Buf: bytes.NewBufferString("hello " + in.AppDir)}, nil
+			},
+		},
+	}
+
+	// Plugin handles the custom flag
+	params.Args = []string{"-hello"}
+	is.Equal(0, RunWith(params))
+	is.Equal("hello "+tmp, params.Stdout.(*bytes.Buffer).String())
+
+	// Other flags fall through to Cmd
+	params.Stdout.(*bytes.Buffer).Reset()
+	hello = false
+	params.Args = []string{"-version"}
+	is.Equal(0, RunWith(params))
+	is.Equal("v1.2.3", strings.TrimSpace(params.Stdout.(*bytes.Buffer).String()))
+
+	// Plugin errors set the exit code
+	params.Plugins = append([]Plugin{func(in *CmdIn) (*CmdOut, error) {
+		return nil, errors.New("plugin failed")
+	}}, params.Plugins...)
+	is.Equal(1, RunWith(params))
+	is.True(strings.Contains(
+		params.Stderr.(*bytes.Buffer).String(), "plugin failed"))
+}
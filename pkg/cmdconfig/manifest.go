@@ -0,0 +1,345 @@
+package cmdconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/mozey/config/pkg/share"
+	"github.com/pkg/errors"
+)
+
+// FileNameManifest is the optional project manifest,
+// it's looked up in the working dir and parent dirs
+const FileNameManifest = ".configu.toml"
+
+// AliasGenerate is the built-in alias for the manifest generate targets
+const AliasGenerate = "generate"
+
+// Manifest for project level settings, for example
+//
+//	prefix = "APP_"
+//	envs = ["dev", "stage", "prod"]
+//	protected = ["prod"]
+//	secrets = ["_PASSWORD$", "_TOKEN$"]
+//	generate = ["pkg/config"]
+//	format = "json"
+//	defaults = "-os linux"
+//	ignore = ["APP_DEV_TOOLS_*"]
+//
+//	[alias]
+//	prod = "-env prod -preview"
+//
+//	[owners]
+//	"APP_DB_*" = "data"
+//
+//	[refresh]
+//	APP_AWS_SESSION_TOKEN = "helper://aws/session-token"
+//
+//	[[services]]
+//	name = "api"
+//	path = "services/api"
+//	prefix = "API_"
+type Manifest struct {
+	// Path the manifest was loaded from
+	Path string `toml:"-"`
+	// Prefix for config keys, the prefix flag takes precedence
+	Prefix string `toml:"prefix"`
+	// Envs lists valid envs, any env is valid if this is empty
+	Envs []string `toml:"envs"`
+	// Protected envs are not updated unless the force flag is set
+	Protected []string `toml:"protected"`
+	// Secrets are regular expressions matching keys with secret values
+	Secrets []string `toml:"secrets"`
+	// Generate targets for the generate alias
+	Generate []string `toml:"generate"`
+	// Format for updated config files, the format flag takes precedence
+	Format string `toml:"format"`
+	// Defaults flags are inserted before the command line flags
+	Defaults string `toml:"defaults"`
+	// Alias maps a name to flags, the name may be used as the first arg
+	Alias map[string]string `toml:"alias"`
+	// Services in a monorepo, see the service and workspace flags
+	Services []Service `toml:"services"`
+	// Ignore globs for keys that are intentionally not in all envs,
+	// the keys are skipped by compare
+	Ignore []string `toml:"ignore"`
+	// Owners of keys, compare and preview output is grouped by owner
+	Owners Owners `toml:"owners"`
+	// Refresh maps keys with an expiry to the secret reference
+	// used to refresh expired values, see refreshExpired
+	Refresh map[string]string `toml:"refresh"`
+	// secretKeys are the compiled Secrets expressions
+	secretKeys []*regexp.Regexp
+}
+
+// Service with its own config files in a monorepo
+type Service struct {
+	// Name of the service, defaults to the base name of the path
+	Name string `toml:"name"`
+	// Path to the service dir, relative to the manifest
+	Path string `toml:"path"`
+	// Prefix for the service config keys, defaults to the manifest prefix
+	Prefix string `toml:"prefix"`
+}
+
+// findManifest walks up from dir and returns the path to the manifest,
+// or an empty string if no manifest is found
+func findManifest(dir string) (path string, err error) {
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return path, errors.WithStack(err)
+	}
+	for {
+		path = filepath.Join(dir, FileNameManifest)
+		_, err = os.Stat(path)
+		if err == nil {
+			return path, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", errors.WithStack(err)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// LoadManifest from dir or the closest parent dir.
+// The manifest is optional, nil is returned if it's not found
+func LoadManifest(dir string) (m *Manifest, err error) {
+	path, err := findManifest(dir)
+	if err != nil || path == "" {
+		return m, err
+	}
+	m = &Manifest{}
+	_, err = toml.DecodeFile(path, m)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid manifest %s", path)
+	}
+	m.Path = path
+
+	for _, expr := range m.Secrets {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid secrets in %s", path)
+		}
+		m.secretKeys = append(m.secretKeys, re)
+	}
+
+	err = validIgnore(m.Ignore)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "invalid ignore in %s", path)
+	}
+	err = validIgnore(m.Owners.globs())
+	if err != nil {
+		return nil, errors.WithMessagef(err, "invalid owners in %s", path)
+	}
+
+	for key, ref := range m.Refresh {
+		if _, _, ok := share.SecretRef(ref); !ok {
+			return nil, errors.Errorf(
+				"invalid refresh for %s in %s, expected one of %s", key, path,
+				strings.Join(share.SecretSchemes(), ", "))
+		}
+	}
+
+	names := make(map[string]bool)
+	for i, s := range m.Services {
+		if s.Path == "" {
+			return nil, errors.Errorf("service path required in %s", path)
+		}
+		if s.Name == "" {
+			m.Services[i].Name = filepath.Base(s.Path)
+		}
+		if names[m.Services[i].Name] {
+			return nil, errors.Errorf(
+				"duplicate service %s in %s", m.Services[i].Name, path)
+		}
+		names[m.Services[i].Name] = true
+		if s.Prefix != "" && !strings.HasSuffix(s.Prefix, "_") {
+			m.Services[i].Prefix = fmt.Sprintf("%s_", s.Prefix)
+		}
+	}
+
+	return m, nil
+}
+
+// service returns the service with the given name
+func (m *Manifest) service(name string) (s Service, err error) {
+	if m == nil {
+		return s, errors.Errorf("manifest not found for service %s", name)
+	}
+	for _, s := range m.Services {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	return s, errors.Errorf("service %s not found in %s", name, m.Path)
+}
+
+// serviceDir returns the absolute path to the service dir
+func (m *Manifest) serviceDir(s Service) string {
+	return filepath.Join(filepath.Dir(m.Path), s.Path)
+}
+
+// validEnv returns an error if the env is not listed in the manifest.
+// Sample envs must also be listed, e.g. "sample.dev" requires "dev".
+// Each env in a list is checked, e.g. "prod,stage"
+func (m *Manifest) validEnv(env string) error {
+	if m == nil || len(m.Envs) == 0 || env == "*" || env == "sample.*" {
+		return nil
+	}
+	for _, env := range splitList(ArgMap{env}) {
+		name := strings.TrimPrefix(env, share.SamplePrefix())
+		if !slices.Contains(m.Envs, name) {
+			return withSuggestions(ErrInvalidEnv(env), name, m.Envs)
+		}
+	}
+	return nil
+}
+
+// protected returns true if the env may not be updated without force,
+// sample config files are never protected
+func (m *Manifest) protected(env string) bool {
+	if m == nil {
+		return false
+	}
+	for _, e := range m.Protected {
+		if e == env {
+			return true
+		}
+	}
+	return false
+}
+
+// secretKey returns true if the key matches the secrets expressions
+func (m *Manifest) secretKey(key string) bool {
+	if m == nil {
+		return false
+	}
+	for _, re := range m.secretKeys {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// refresh returns the secret reference to refresh the key with,
+// or an empty string
+func (m *Manifest) refresh(key string) string {
+	if m == nil {
+		return ""
+	}
+	return m.Refresh[key]
+}
+
+// ignore returns the globs for keys skipped by compare
+func (m *Manifest) ignore() []string {
+	if m == nil {
+		return nil
+	}
+	return m.Ignore
+}
+
+// format for updated config files
+func (m *Manifest) format() string {
+	if m == nil {
+		return ""
+	}
+	return m.Format
+}
+
+// alias returns the flags for the alias name
+func (m *Manifest) alias(name string) (args []string, err error) {
+	flags, ok := m.Alias[name]
+	if !ok {
+		if name == AliasGenerate && len(m.Generate) > 0 {
+			for _, target := range m.Generate {
+				args = append(args, fmt.Sprintf("-%s", FlagGenerate), target)
+			}
+			return args, nil
+		}
+		return args, errors.Errorf("alias %s not found in %s", name, m.Path)
+	}
+	args, err = splitArgs(flags)
+	if err != nil {
+		return args, errors.WithMessagef(err, "alias %s", name)
+	}
+	return args, nil
+}
+
+// splitArgs splits s on white space, respecting single and double quotes
+func splitArgs(s string) (args []string, err error) {
+	args = make([]string, 0)
+	arg := strings.Builder{}
+	inArg := false
+	var quote rune
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return args, errors.Errorf("unterminated quote in %s", s)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// ExpandArgs inserts the manifest prefix and default flags before args,
+// and replaces an alias in the first arg with the aliased flags.
+// Flags given on the command line are parsed last, i.e. they take precedence
+func (m *Manifest) ExpandArgs(args []string) (expanded []string, err error) {
+	if m == nil {
+		return args, nil
+	}
+
+	expanded = make([]string, 0)
+	if m.Prefix != "" {
+		expanded = append(expanded, fmt.Sprintf("-%s", FlagPrefix), m.Prefix)
+	}
+
+	defaults, err := splitArgs(m.Defaults)
+	if err != nil {
+		return expanded, errors.WithMessage(err, "manifest defaults")
+	}
+	expanded = append(expanded, defaults...)
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		aliased, err := m.alias(args[0])
+		if err != nil {
+			return expanded, err
+		}
+		expanded = append(expanded, aliased...)
+		args = args[1:]
+	}
+
+	return append(expanded, args...), nil
+}
@@ -0,0 +1,177 @@
+package cmdconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestSplitArgs(t *testing.T) {
+	is := testutil.Setup(t)
+
+	args, err := splitArgs(` -env prod  -os linux `)
+	is.NoErr(err)
+	is.Equal([]string{"-env", "prod", "-os", "linux"}, args)
+
+	args, err = splitArgs(`-key APP_FOO -value "foo bar" -value 'a "b"' ""`)
+	is.NoErr(err)
+	is.Equal([]string{
+		"-key", "APP_FOO", "-value", "foo bar", "-value", `a "b"`, ""}, args)
+
+	_, err = splitArgs(`-value "foo`)
+	is.True(err != nil)
+}
+
+func TestManifestExpandArgs(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	// Manifest is optional
+	m, err := LoadManifest(tmp)
+	is.NoErr(err)
+	is.True(m == nil)
+	args, err := m.ExpandArgs([]string{"-env", "prod"})
+	is.NoErr(err)
+	is.Equal([]string{"-env", "prod"}, args)
+
+	err = os.WriteFile(filepath.Join(tmp, FileNameManifest), []byte(`
+defaults = "-prefix APP_"
+
+[alias]
+prod = "-env prod -os linux"
+`), perms)
+	is.NoErr(err)
+
+	// Manifest is found in parent dirs
+	dir := filepath.Join(tmp, "pkg", "foo")
+	err = os.MkdirAll(dir, dirPerms)
+	is.NoErr(err)
+	m, err = LoadManifest(dir)
+	is.NoErr(err)
+	is.Equal(filepath.Join(tmp, FileNameManifest), m.Path)
+
+	args, err = m.ExpandArgs([]string{"-env", "stage"})
+	is.NoErr(err)
+	is.Equal([]string{"-prefix", "APP_", "-env", "stage"}, args)
+
+	// Command line flags come after the alias
+	args, err = m.ExpandArgs([]string{"prod", "-preview"})
+	is.NoErr(err)
+	is.Equal([]string{
+		"-prefix", "APP_", "-env", "prod", "-os", "linux", "-preview"}, args)
+
+	_, err = m.ExpandArgs([]string{"stage"})
+	is.True(err != nil)
+
+	// Invalid manifest
+	err = os.WriteFile(filepath.Join(tmp, FileNameManifest),
+		[]byte(`alias = `), perms)
+	is.NoErr(err)
+	_, err = LoadManifest(dir)
+	is.True(err != nil)
+}
+
+func TestManifestPolicies(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	err = os.WriteFile(filepath.Join(tmp, FileNameManifest), []byte(`
+prefix = "APP_"
+envs = ["dev", "prod"]
+protected = ["prod"]
+secrets = ["_PASS$"]
+generate = ["pkg/config", "pkg/other"]
+format = "yaml"
+`), perms)
+	is.NoErr(err)
+	m, err := LoadManifest(tmp)
+	is.NoErr(err)
+
+	// Prefix and generate alias
+	args, err := m.ExpandArgs([]string{AliasGenerate, "-dry-run"})
+	is.NoErr(err)
+	is.Equal([]string{"-prefix", "APP_",
+		"-generate", "pkg/config", "-generate", "pkg/other", "-dry-run"}, args)
+
+	// Envs
+	is.NoErr(m.validEnv(EnvProd))
+	is.NoErr(m.validEnv("sample.dev"))
+	is.NoErr(m.validEnv("*"))
+	is.True(m.validEnv("stage") != nil)
+	is.NoErr(m.validEnv("dev,prod"))
+	is.True(m.validEnv("dev,stage") != nil)
+
+	// Secrets
+	is.True(m.secretKey("APP_DB_PASS"))
+	is.True(!m.secretKey("APP_DB_HOST"))
+
+	for _, env := range []string{"dev", EnvProd} {
+		err = os.WriteFile(
+			filepath.Join(tmp, fmt.Sprintf("config.%v.json", env)),
+			[]byte(`{"APP_FOO": "foo"}`), perms)
+		is.NoErr(err)
+	}
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = "stage"
+	in.manifest = m
+	t.Setenv("APP_DIR", tmp)
+	is.True(in.Valid() != nil)
+
+	// Protected envs require force
+	in.Env = EnvProd
+	is.NoErr(in.Valid())
+	in.Keys = ArgMap{"APP_BAR"}
+	in.Values = ArgMap{"bar"}
+	_, err = Cmd(in)
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "protected"))
+
+	in.Force = true
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(2, len(out.Files))
+	// Manifest format converts the file, the old file is removed,
+	// otherwise it takes precedence when loading config
+	is.Equal(filepath.Join(tmp, "config.prod.yaml"), out.Files[0].Path)
+	is.Equal(filepath.Join(tmp, "config.prod.json"), out.Files[1].Path)
+	is.True(out.Files[1].Del)
+	_, err = in.Process(out)
+	is.NoErr(err)
+	configPath, c, err := loadConf(nil, tmp, EnvProd)
+	is.NoErr(err)
+	is.Equal(filepath.Join(tmp, "config.prod.yaml"), configPath)
+	is.Equal("bar", c.Map["APP_BAR"])
+
+	// Keys matching secrets are redacted in the preview
+	t.Setenv("APP_DB_PASS", "old")
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"),
+		[]byte(`{"APP_DB_PASS": "new"}`), perms)
+	is.NoErr(err)
+	in = &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = "dev"
+	in.Preview = true
+	in.manifest = m
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.True(strings.Contains(out.Preview.String(),
+		fmt.Sprintf("%s → %s", redacted, redacted)))
+}
@@ -0,0 +1,126 @@
+package cmdconfig
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// Owners map key globs to the team that owns the keys, for example
+//
+//	[owners]
+//	"APP_DB_*" = "data"
+//	"APP_STRIPE_*" = "payments"
+//
+// The owner declared in the schema takes precedence, see KeySchema.
+// If more than one glob matches, the longest glob is used
+type Owners map[string]string
+
+// owner returns the team for the key, or an empty string
+func (o Owners) owner(key string) string {
+	match := ""
+	for glob := range o {
+		if ok, _ := path.Match(glob, key); !ok {
+			continue
+		}
+		// Longest glob is most specific, ties are broken by name
+		if len(glob) > len(match) ||
+			(len(glob) == len(match) && glob < match) {
+			match = glob
+		}
+	}
+	return o[match]
+}
+
+// globs returns the sorted globs, e.g. for validIgnore
+func (o Owners) globs() []string {
+	globs := make([]string, 0, len(o))
+	for glob := range o {
+		globs = append(globs, glob)
+	}
+	sort.Strings(globs)
+	return globs
+}
+
+// keyOwners looks up the owner of keys in the schema and manifest
+type keyOwners struct {
+	manifest *Manifest
+	schema   Schema
+}
+
+// owner of the key, or an empty string if the key is not owned.
+// Owners may be declared with a leading "@", it's removed
+func (ko keyOwners) owner(key string) string {
+	owner := ko.schema[key].Owner
+	if owner == "" && ko.manifest != nil {
+		owner = ko.manifest.Owners.owner(key)
+	}
+	return strings.TrimPrefix(owner, "@")
+}
+
+// enabled returns true if owners are declared,
+// output is only grouped by owner if this is the case
+func (ko keyOwners) enabled() bool {
+	if ko.manifest != nil && len(ko.manifest.Owners) > 0 {
+		return true
+	}
+	for _, ks := range ko.schema {
+		if ks.Owner != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// ownerLess sorts by owner then key, keys without an owner are last
+func ownerLess(ownerA, keyA, ownerB, keyB string) bool {
+	if ownerA != ownerB {
+		if ownerA == "" || ownerB == "" {
+			return ownerB == ""
+		}
+		return ownerA < ownerB
+	}
+	return keyA < keyB
+}
+
+// unowned returns the keys without an owner
+func (ko keyOwners) unowned(keys []string) []string {
+	missing := make([]string, 0)
+	for _, key := range keys {
+		if ko.owner(key) == "" {
+			missing = append(missing, key)
+		}
+	}
+	return missing
+}
+
+// mention formats the owner as in CODEOWNERS, e.g. "@payments"
+func mention(owner string) string {
+	return "@" + owner
+}
+
+// ownerHeading groups text output by owner
+func ownerHeading(owner string) string {
+	if owner == "" {
+		return "# no owner\n"
+	}
+	return "# " + mention(owner) + "\n"
+}
+
+// requireOwners returns an error if new keys are not owned,
+// the check is meant for large repos shared by many teams
+func requireOwners(in *CmdIn, newKeys []string) error {
+	if len(newKeys) == 0 {
+		return nil
+	}
+	schema, err := readSchema(in.dirs, in.AppDir)
+	if err != nil {
+		return err
+	}
+	owners := keyOwners{manifest: in.manifest, schema: schema}
+	missing := owners.unowned(newKeys)
+	if len(missing) > 0 {
+		return ErrNoOwner(missing)
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package cmdconfig
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestKeyOwners(t *testing.T) {
+	is := testutil.Setup(t)
+
+	owners := keyOwners{
+		manifest: &Manifest{Owners: Owners{
+			"APP_*":        "platform",
+			"APP_DB_*":     "data",
+			"APP_STRIPE_*": "payments",
+		}},
+		schema: Schema{"APP_DB_PASS": {Owner: "security"}},
+	}
+	is.True(owners.enabled())
+
+	// Longest glob is used
+	is.Equal("data", owners.owner("APP_DB_HOST"))
+	is.Equal("payments", owners.owner("APP_STRIPE_KEY"))
+	is.Equal("platform", owners.owner("APP_PORT"))
+	is.Equal("", owners.owner("OTHER_PORT"))
+
+	// Schema takes precedence
+	is.Equal("security", owners.owner("APP_DB_PASS"))
+
+	is.Equal([]string{"OTHER_PORT"},
+		owners.unowned([]string{"APP_PORT", "OTHER_PORT"}))
+
+	// Not owned
+	is.True(!keyOwners{}.enabled())
+	is.Equal("", keyOwners{}.owner("APP_PORT"))
+
+	// Keys without an owner are sorted last
+	is.True(ownerLess("data", "APP_Z", "platform", "APP_A"))
+	is.True(ownerLess("data", "APP_Z", "", "APP_A"))
+	is.True(!ownerLess("", "APP_A", "data", "APP_Z"))
+	is.True(ownerLess("", "APP_A", "", "APP_B"))
+}
+
+func TestCompareKeysOwners(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"), []byte(
+		`{"APP_ONE": "1", "APP_DB_HOST": "db", "APP_DB_USER": "u"}`), perms)
+	is.NoErr(err)
+	err = os.WriteFile(filepath.Join(tmp, "config.prod.json"),
+		[]byte(`{"APP_ONE": "1", "APP_CDN": "cdn", "APP_TWO": "2"}`), perms)
+	is.NoErr(err)
+	err = os.WriteFile(filepath.Join(tmp, FileNameTypes),
+		[]byte(`{"APP_TWO": {"type": "int", "owner": "@web"}}`), perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	in.Compare = EnvProd
+	in.manifest = &Manifest{Owners: Owners{"APP_DB_*": "data"}}
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(strings.Join([]string{
+		"# @data",
+		"APP_DB_HOST",
+		"APP_DB_USER",
+		"# @web",
+		"APP_TWO",
+		"# no owner",
+		"APP_CDN",
+	}, "\n")+"\n", out.Buf.String())
+	is.Equal(1, out.ExitCode)
+
+	in.JSON = true
+	out, err = Cmd(in)
+	is.NoErr(err)
+	report := compareReport{}
+	is.NoErr(json.Unmarshal(out.Buf.Bytes(), &report))
+	is.Equal(4, len(report.Keys))
+	is.Equal("APP_DB_HOST", report.Keys[0].Key)
+	is.Equal("data", report.Keys[0].Owner)
+	is.Equal("web", report.Keys[2].Owner)
+	is.Equal("", report.Keys[3].Owner)
+	is.True(!strings.Contains(out.Buf.String(), `"owner": ""`))
+}
+
+func TestSetEnvPreviewOwners(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"), []byte(`{
+		"APP_OWNED_A": "a",
+		"APP_OWNED_DB": "db"
+	}`), perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_OWNED_"
+	in.Env = share.EnvDev
+	in.Preview = true
+
+	// Owner column is omitted if keys are not owned
+	out, err := Cmd(in)
+	is.NoErr(err)
+	lines := strings.Split(strings.TrimSpace(out.Preview.String()), "\n")
+	is.Equal([]string{"CHANGE", "KEY", "VALUE"}, strings.Fields(lines[0]))
+
+	in.manifest = &Manifest{Owners: Owners{"APP_OWNED_DB": "@data"}}
+	out, err = Cmd(in)
+	is.NoErr(err)
+	lines = strings.Split(strings.TrimSpace(out.Preview.String()), "\n")
+	is.Equal(3, len(lines))
+	is.Equal([]string{"OWNER", "CHANGE", "KEY", "VALUE"},
+		strings.Fields(lines[0]))
+	is.Equal([]string{"@data", "add", "APP_OWNED_DB", "db"},
+		strings.Fields(lines[1]))
+	is.Equal([]string{"-", "add", "APP_OWNED_A", "a"},
+		strings.Fields(lines[2]))
+}
+
+func TestRequireOwner(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	err = os.WriteFile(filepath.Join(tmp, FileNameManifest), []byte(`
+[owners]
+"APP_DB_*" = "data"
+`), perms)
+	is.NoErr(err)
+	m, err := LoadManifest(tmp)
+	is.NoErr(err)
+	is.Equal("data", m.Owners["APP_DB_*"])
+
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"),
+		[]byte(`{"APP_PORT": "8080"}`), perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	in.RequireOwner = true
+	in.manifest = m
+
+	// Existing keys may be updated without an owner
+	in.Keys = ArgMap{"APP_PORT"}
+	in.Values = ArgMap{"8081"}
+	_, err = Cmd(in)
+	is.NoErr(err)
+
+	// New keys require an owner
+	in.Keys = ArgMap{"APP_DB_HOST", "APP_CACHE", "APP_QUEUE"}
+	in.Values = ArgMap{"db", "cache", "queue"}
+	_, err = Cmd(in)
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "APP_CACHE, APP_QUEUE"))
+	is.True(!strings.Contains(err.Error(), "APP_DB_HOST"))
+
+	// Owner declared in the schema
+	err = os.WriteFile(filepath.Join(tmp, FileNameTypes), []byte(`{
+		"APP_CACHE": {"owner": "platform"},
+		"APP_QUEUE": {"owner": "platform"}
+	}`), perms)
+	is.NoErr(err)
+	_, err = Cmd(in)
+	is.NoErr(err)
+
+	// Malformed glob
+	err = os.WriteFile(filepath.Join(tmp, FileNameManifest), []byte(`
+[owners]
+"APP_[" = "data"
+`), perms)
+	is.NoErr(err)
+	_, err = LoadManifest(tmp)
+	is.True(err != nil)
+}
@@ -0,0 +1,346 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Contexts that are always included in the parity report
+const (
+	ParityConfig = "config"
+	ParityShell  = "shell"
+)
+
+// parityMissing is printed for keys that are not set in a context
+const parityMissing = "<missing>"
+
+// parityJSON is a devcontainer.json, or an ECS task definition.
+// The environment fragment printed by export ecs is also supported
+type parityJSON struct {
+	// ContainerEnv and RemoteEnv as per
+	// https://containers.dev/implementors/json_reference/
+	ContainerEnv map[string]string `json:"containerEnv"`
+	RemoteEnv    map[string]string `json:"remoteEnv"`
+	// Environment of a container definition, see MarshalECS
+	Environment          []ecsKeyValuePair `json:"environment"`
+	ContainerDefinitions []struct {
+		Environment []ecsKeyValuePair `json:"environment"`
+	} `json:"containerDefinitions"`
+	// TaskDefinition is set in the output of
+	// aws ecs describe-task-definition
+	TaskDefinition *parityJSON `json:"taskDefinition"`
+}
+
+// env returns the key value pairs, found is false if there are none
+func (p parityJSON) env() (env map[string]string, found bool) {
+	env = make(map[string]string)
+	for key, value := range p.ContainerEnv {
+		env[key], found = value, true
+	}
+	for key, value := range p.RemoteEnv {
+		env[key], found = value, true
+	}
+	pairs := p.Environment
+	for _, c := range p.ContainerDefinitions {
+		pairs = append(pairs, c.Environment...)
+	}
+	for _, pair := range pairs {
+		env[pair.Name], found = pair.Value, true
+	}
+	if p.TaskDefinition != nil {
+		taskEnv, taskFound := p.TaskDefinition.env()
+		for key, value := range taskEnv {
+			env[key] = value
+		}
+		found = found || taskFound
+	}
+	return env, found
+}
+
+// k8sContainer in a pod spec
+type k8sContainer struct {
+	Env []struct {
+		Name  string `yaml:"name"`
+		Value string `yaml:"value"`
+		// ValueFrom references e.g. a Secret, the value is not known
+		ValueFrom interface{} `yaml:"valueFrom"`
+	} `yaml:"env"`
+}
+
+// k8sPodSpec has the containers of a Pod, or a Deployment template
+type k8sPodSpec struct {
+	Containers     []k8sContainer `yaml:"containers"`
+	InitContainers []k8sContainer `yaml:"initContainers"`
+}
+
+// k8sManifest is a ConfigMap, Secret, Pod, or workload object
+// with a pod template, e.g. Deployment or StatefulSet
+type k8sManifest struct {
+	Kind       string            `yaml:"kind"`
+	Data       map[string]string `yaml:"data"`
+	StringData map[string]string `yaml:"stringData"`
+	Spec       struct {
+		k8sPodSpec `yaml:",inline"`
+		Template   struct {
+			Spec k8sPodSpec `yaml:"spec"`
+		} `yaml:"template"`
+	} `yaml:"spec"`
+}
+
+// env adds the key value pairs of the object to env,
+// secret values are decoded
+func (m k8sManifest) env(env map[string]string) error {
+	for key, value := range m.Data {
+		if strings.ToLower(m.Kind) == K8sSecret {
+			decoded, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return errors.Wrapf(err, "invalid secret value for key %s", key)
+			}
+			value = string(decoded)
+		}
+		env[key] = value
+	}
+	for key, value := range m.StringData {
+		env[key] = value
+	}
+	containers := append(m.Spec.Containers, m.Spec.InitContainers...)
+	containers = append(containers, m.Spec.Template.Spec.Containers...)
+	containers = append(containers, m.Spec.Template.Spec.InitContainers...)
+	for _, c := range containers {
+		for _, e := range c.Env {
+			if e.ValueFrom != nil && e.Value == "" {
+				// Not the empty string, the object may be in another file
+				continue
+			}
+			env[e.Name] = e.Value
+		}
+	}
+	return nil
+}
+
+// readParityFile returns the env vars set by the file at path.
+// JSON files may be a devcontainer.json or ECS task definition,
+// YAML files may be Kubernetes manifests, separated by "---".
+// Otherwise the file is read as a config file, e.g. a CI env file
+func readParityFile(path string) (env map[string]string, err error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return env, errors.WithStack(err)
+	}
+
+	fileType := filepath.Ext(path)
+	switch fileType {
+	case share.FileTypeJSON:
+		trimmed := bytes.TrimSpace(b)
+		if bytes.HasPrefix(trimmed, []byte(`"`)) {
+			// Fragment printed by export ecs
+			trimmed = []byte(fmt.Sprintf("{%s}", trimmed))
+		}
+		p := parityJSON{}
+		err = json.Unmarshal(trimmed, &p)
+		if err == nil {
+			env, found := p.env()
+			if found {
+				return env, nil
+			}
+		}
+
+	case share.FileTypeYAML, ".yml":
+		env = make(map[string]string)
+		found := false
+		decoder := yaml.NewDecoder(bytes.NewReader(b))
+		for {
+			m := k8sManifest{}
+			err = decoder.Decode(&m)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				// Not a manifest, try the config file format below
+				found = false
+				break
+			}
+			if m.Kind == "" {
+				continue
+			}
+			found = true
+			err = m.env(env)
+			if err != nil {
+				return env, errors.WithMessagef(err, "invalid %s", path)
+			}
+		}
+		if found {
+			return env, nil
+		}
+		fileType = share.FileTypeYAML
+
+	case share.FileTypeENV, share.FileTypeSH:
+
+	default:
+		// E.g. ".env.ci" files
+		fileType = share.FileTypeENV
+	}
+
+	env, err = share.UnmarshalConfig(fileType, b)
+	if err != nil {
+		return env, errors.WithMessagef(err, "invalid %s", path)
+	}
+	return env, nil
+}
+
+// parityKey is a key that is missing, or has different values,
+// in one or more contexts
+type parityKey struct {
+	Key string `json:"key"`
+	// Values by context name, secret values are redacted
+	Values map[string]string `json:"values"`
+	// Missing lists contexts without the key
+	Missing []string `json:"missing,omitempty"`
+}
+
+// parityReport is printed by parity with the JSON flag
+type parityReport struct {
+	Env string `json:"env"`
+	// Contexts in the order of the columns
+	Contexts []string    `json:"contexts"`
+	Keys     []parityKey `json:"keys"`
+}
+
+// parityConfig compares the config for env, with the env vars set in the
+// local shell, and the files listed with the parity flag,
+// e.g. a CI env file, devcontainer.json, or deployment manifests.
+// Keys that are missing or have different values in any context are
+// reported, and the exit code is set. Only keys matching the prefix are
+// compared, and APP_DIR is skipped since it's the local path
+func parityConfig(in *CmdIn) (
+	buf *bytes.Buffer, files []File, exitCode int, err error) {
+
+	buf = new(bytes.Buffer)
+
+	_, config, err := newConf(confParams{
+		dirs:   in.dirs,
+		prefix: in.Prefix,
+		appDir: in.AppDir,
+		env:    in.Env,
+		extend: in.Extend,
+		merge:  in.Merge,
+		parent: in.Parent,
+	})
+	if err != nil {
+		return buf, files, exitCode, err
+	}
+	schema, err := readSchema(in.dirs, in.AppDir)
+	if err != nil {
+		return buf, files, exitCode, err
+	}
+
+	report := parityReport{
+		Env:      in.Env,
+		Contexts: []string{ParityConfig, ParityShell},
+		Keys:     make([]parityKey, 0),
+	}
+	contexts := []map[string]string{config.Map, make(map[string]string)}
+	for _, v := range os.Environ() {
+		key, value, _ := strings.Cut(v, "=")
+		contexts[1][key] = value
+	}
+	for _, path := range in.Parity {
+		env, err := readParityFile(path)
+		if err != nil {
+			return buf, files, exitCode, err
+		}
+		name := filepath.Base(path)
+		for _, existing := range report.Contexts {
+			if existing == name {
+				// E.g. dev/values.yaml and prod/values.yaml
+				name = path
+			}
+		}
+		report.Contexts = append(report.Contexts, name)
+		contexts = append(contexts, env)
+	}
+
+	// Keys matching the ignore flags or manifest are not compared
+	ignore := make([]string, 0)
+	ignore = append(ignore, in.manifest.ignore()...)
+	ignore = append(ignore, in.Ignore...)
+
+	appDirKey := fmt.Sprintf("%sDIR", in.Prefix)
+	keys := make(map[string]bool)
+	for _, env := range contexts {
+		for key := range env {
+			if strings.HasPrefix(key, in.Prefix) && key != appDirKey &&
+				!ignoreKey(ignore, key) {
+				keys[key] = true
+			}
+		}
+	}
+	for key := range keys {
+		secret := schema[key].Secret || in.manifest.secretKey(key)
+		item := parityKey{Key: key, Values: make(map[string]string)}
+		values := make(map[string]bool)
+		for i, env := range contexts {
+			value, ok := env[key]
+			if !ok {
+				item.Missing = append(item.Missing, report.Contexts[i])
+				continue
+			}
+			values[value] = true
+			if secret {
+				value = redacted
+			} else {
+				value = redact(key, value)
+			}
+			item.Values[report.Contexts[i]] = value
+		}
+		if len(item.Missing) > 0 || len(values) > 1 {
+			report.Keys = append(report.Keys, item)
+		}
+	}
+	sort.Slice(report.Keys, func(i, j int) bool {
+		return report.Keys[i].Key < report.Keys[j].Key
+	})
+	if len(report.Keys) > 0 {
+		exitCode = 1
+	}
+
+	if in.JSON {
+		b, err := json.MarshalIndent(report, "", "    ")
+		if err != nil {
+			return buf, files, exitCode, errors.WithStack(err)
+		}
+		buf.Write(b)
+		buf.WriteString("\n")
+		return buf, files, exitCode, nil
+	}
+
+	if len(report.Keys) == 0 {
+		return buf, files, exitCode, nil
+	}
+	w := tabwriter.NewWriter(buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "KEY\t%s\n", strings.Join(report.Contexts, "\t"))
+	for _, item := range report.Keys {
+		row := []string{item.Key}
+		for _, name := range report.Contexts {
+			value, ok := item.Values[name]
+			if !ok {
+				value = parityMissing
+			}
+			row = append(row, value)
+		}
+		fmt.Fprintf(w, "%s\n", strings.Join(row, "\t"))
+	}
+	return buf, files, exitCode, errors.WithStack(w.Flush())
+}
@@ -0,0 +1,159 @@
+package cmdconfig
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestReadParityFile(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	for _, tc := range []struct {
+		name     string
+		contents string
+	}{
+		{".env.ci", "APP_FOO=foo\nexport APP_BAR=\"bar\"\n"},
+		{"devcontainer.json", `{
+			"containerEnv": {"APP_FOO": "foo"},
+			"remoteEnv": {"APP_BAR": "bar"}
+		}`},
+		{"ecs.json", `"environment": [
+			{"name": "APP_FOO", "value": "foo"},
+			{"name": "APP_BAR", "value": "bar"}
+		]`},
+		{"task.json", `{"taskDefinition": {"containerDefinitions": [
+			{"environment": [{"name": "APP_FOO", "value": "foo"}]},
+			{"environment": [{"name": "APP_BAR", "value": "bar"}]}
+		]}}`},
+		{"config.json", `{"APP_FOO": "foo", "APP_BAR": "bar"}`},
+		{"deploy.yaml", `apiVersion: v1
+kind: Secret
+data:
+  APP_FOO: Zm9v
+---
+apiVersion: apps/v1
+kind: Deployment
+spec:
+  template:
+    spec:
+      containers:
+        - name: app
+          env:
+            - name: APP_FOO
+              valueFrom:
+                secretKeyRef:
+                  name: app
+                  key: APP_FOO
+            - name: APP_BAR
+              value: bar
+`},
+		{"config.yml", "APP_FOO: foo\nAPP_BAR: bar\n"},
+	} {
+		path := filepath.Join(tmp, tc.name)
+		err = os.WriteFile(path, []byte(tc.contents), perms)
+		is.NoErr(err)
+		env, err := readParityFile(path)
+		is.NoErr(err)
+		is.Equal(map[string]string{"APP_FOO": "foo", "APP_BAR": "bar"}, env)
+	}
+}
+
+func TestParity(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"), []byte(`{
+		"APP_FOO": "foo",
+		"APP_BAR": "bar",
+		"APP_PASSWORD": "secret"
+	}`), perms)
+	is.NoErr(err)
+	ci := filepath.Join(tmp, "ci.env")
+	err = os.WriteFile(ci,
+		[]byte("APP_FOO=foo\nAPP_BAR=baz\nAPP_PASSWORD=other\nOTHER=x\n"), perms)
+	is.NoErr(err)
+
+	unsetPrefix(t, "APP_")
+	t.Setenv("APP_DIR", tmp)
+	t.Setenv("APP_FOO", "foo")
+	t.Setenv("APP_BAR", "bar")
+	t.Setenv("APP_PASSWORD", "secret")
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	in.Parity = ArgMap{ci}
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdParity, out.Cmd)
+	is.Equal(1, out.ExitCode)
+	is.Equal(""+
+		"KEY           config      shell       ci.env\n"+
+		"APP_BAR       bar         bar         baz\n"+
+		"APP_PASSWORD  <redacted>  <redacted>  <redacted>\n",
+		out.Buf.String())
+
+	// Missing keys
+	t.Setenv("APP_EXTRA", "extra")
+	in.JSON = true
+	in.Ignore = ArgMap{"APP_PASSWORD"}
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(1, out.ExitCode)
+	report := parityReport{}
+	is.NoErr(json.Unmarshal(out.Buf.Bytes(), &report))
+	is.Equal([]string{ParityConfig, ParityShell, "ci.env"}, report.Contexts)
+	is.Equal(2, len(report.Keys))
+	is.Equal("APP_EXTRA", report.Keys[1].Key)
+	is.Equal([]string{ParityConfig, "ci.env"}, report.Keys[1].Missing)
+
+	// No differences
+	err = os.WriteFile(ci, []byte("APP_FOO=foo\nAPP_BAR=bar\n"), perms)
+	is.NoErr(err)
+	in.JSON = false
+	in.Ignore = ArgMap{"APP_PASSWORD", "APP_EXTRA"}
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(0, out.ExitCode)
+	is.Equal("", out.Buf.String())
+
+	// Extensions listed in the config file
+	err = os.Mkdir(filepath.Join(tmp, "ext"), dirPerms)
+	is.NoErr(err)
+	err = os.WriteFile(filepath.Join(tmp, "ext", "config.dev.json"),
+		[]byte(`{"APP_EXT": "ext"}`), perms)
+	is.NoErr(err)
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"), []byte(`{
+		"APP_FOO": "foo",
+		"APP_BAR": "bar",
+		"APP_X": "ext",
+		"APP_X_DIR": "."
+	}`), perms)
+	is.NoErr(err)
+	in.Ignore = ArgMap{"APP_PASSWORD", "APP_EXTRA", "APP_X", "APP_X_DIR"}
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(1, out.ExitCode)
+	is.Equal(""+
+		"KEY      config  shell      ci.env\n"+
+		"APP_EXT  ext     <missing>  <missing>\n",
+		out.Buf.String())
+}
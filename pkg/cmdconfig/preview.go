@@ -0,0 +1,108 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"text/tabwriter"
+)
+
+const (
+	ChangeAdd    = "add"
+	ChangeUpdate = "change"
+	ChangeUnset  = "unset"
+)
+
+// secretKey matches keys that are likely to contain secrets
+var secretKey = regexp.MustCompile(
+	`(?i)(SECRET|PASSWORD|PASSWD|TOKEN|PRIVATE|CREDENTIAL|API_?KEY)`)
+
+// redacted replaces secret values in the preview
+const redacted = "<redacted>"
+
+// redact the value if the key is likely to contain a secret
+func redact(key, value string) string {
+	if value != "" && secretKey.MatchString(key) {
+		return redacted
+	}
+	return value
+}
+
+// envChange describes the effect of set env commands on an env var
+type envChange struct {
+	Change string
+	Key    string
+	Old    string
+	New    string
+	// Secret is set if the value was resolved from a secret reference
+	Secret bool
+	// Owner of the key, see Owners
+	Owner string
+}
+
+// redact the value if the change is for a secret
+func (c envChange) redact(value string) string {
+	if c.Secret && value != "" {
+		return redacted
+	}
+	return redact(c.Key, value)
+}
+
+// envChanges sorted by owner and key
+type envChanges []envChange
+
+func (changes envChanges) sort() {
+	sort.Slice(changes, func(i, j int) bool {
+		return ownerLess(changes[i].Owner, changes[i].Key,
+			changes[j].Owner, changes[j].Key)
+	})
+}
+
+// setOwners of the changed keys
+func (changes envChanges) setOwners(owners keyOwners) {
+	for i, c := range changes {
+		changes[i].Owner = owners.owner(c.Key)
+	}
+}
+
+// owned returns true if any of the changed keys has an owner
+func (changes envChanges) owned() bool {
+	for _, c := range changes {
+		if c.Owner != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// write the changes as a table to buf, secrets are redacted.
+// The owner column is only included if changed keys are owned
+func (changes envChanges) write(buf *bytes.Buffer) {
+	owned := changes.owned()
+	w := tabwriter.NewWriter(buf, 0, 0, 2, ' ', 0)
+	if owned {
+		fmt.Fprint(w, "OWNER\t")
+	}
+	fmt.Fprintln(w, "CHANGE\tKEY\tVALUE")
+	for _, c := range changes {
+		value := ""
+		switch c.Change {
+		case ChangeAdd:
+			value = c.redact(c.New)
+		case ChangeUpdate:
+			value = fmt.Sprintf("%s → %s", c.redact(c.Old), c.redact(c.New))
+		case ChangeUnset:
+			value = c.redact(c.Old)
+		}
+		if owned {
+			owner := "-"
+			if c.Owner != "" {
+				owner = mention(c.Owner)
+			}
+			fmt.Fprintf(w, "%s\t", owner)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", c.Change, c.Key, value)
+	}
+	_ = w.Flush()
+}
@@ -0,0 +1,78 @@
+package cmdconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestRedact(t *testing.T) {
+	is := testutil.Setup(t)
+
+	is.Equal(redacted, redact("APP_DB_PASSWORD", "secret"))
+	is.Equal(redacted, redact("APP_API_KEY", "secret"))
+	is.Equal(redacted, redact("APP_GITHUB_TOKEN", "secret"))
+	is.Equal("", redact("APP_GITHUB_TOKEN", ""))
+	is.Equal("localhost", redact("APP_DB_HOST", "localhost"))
+}
+
+func TestSetEnvPreview(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	env := share.EnvDev
+	err = os.WriteFile(
+		filepath.Join(tmp, fmt.Sprintf("config.%v.json", env)),
+		[]byte(`{
+			"APP_PREVIEW_ADD": "add",
+			"APP_PREVIEW_CHANGE": "new",
+			"APP_PREVIEW_SAME": "same",
+			"APP_PREVIEW_TOKEN": "new-token"
+		}`), perms)
+	is.NoErr(err)
+
+	t.Setenv("APP_PREVIEW_CHANGE", "old")
+	t.Setenv("APP_PREVIEW_SAME", "same")
+	t.Setenv("APP_PREVIEW_TOKEN", "old-token")
+	t.Setenv("APP_PREVIEW_UNSET", "unset")
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_PREVIEW_"
+	in.Env = env
+
+	// Preview is opt-in
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.True(out.Preview == nil)
+
+	in.Preview = true
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.True(out.Preview != nil)
+	lines := strings.Split(strings.TrimSpace(out.Preview.String()), "\n")
+	is.Equal(5, len(lines))
+	is.Equal([]string{"CHANGE", "KEY", "VALUE"}, strings.Fields(lines[0]))
+	is.Equal([]string{"add", "APP_PREVIEW_ADD", "add"},
+		strings.Fields(lines[1]))
+	is.Equal([]string{"change", "APP_PREVIEW_CHANGE", "old", "→", "new"},
+		strings.Fields(lines[2]))
+	is.Equal([]string{"change", "APP_PREVIEW_TOKEN", redacted, "→", redacted},
+		strings.Fields(lines[3]))
+	is.Equal([]string{"unset", "APP_PREVIEW_UNSET", "unset"},
+		strings.Fields(lines[4]))
+
+	// Commands are not affected by the preview
+	is.True(strings.Contains(out.Buf.String(), "export APP_PREVIEW_SAME=same"))
+	is.True(!strings.Contains(out.Buf.String(), "VALUE"))
+}
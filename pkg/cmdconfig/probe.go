@@ -0,0 +1,68 @@
+package cmdconfig
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// probeReport explains why no config file was found for env,
+// every path is listed in load precedence order with the reason it was skipped.
+// Names in appDir that are similar to a path are likely the cause,
+// e.g. config.dev.yml instead of config.dev.yaml
+func probeReport(dirs *dirCache, appDir, env string, paths []string) (
+	report string, err error) {
+
+	names, err := dirs.names(appDir)
+	if err != nil {
+		return report, err
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	lines := make([]string, 0, len(paths)+1)
+	for _, p := range paths {
+		reason := "not found"
+		if similar := similarName(sorted, filepath.Base(p)); similar != "" {
+			reason = fmt.Sprintf("not found, but %s exists", similar)
+		}
+		lines = append(lines, fmt.Sprintf("  %s: %s", p, reason))
+	}
+
+	envs, err := listEnvs(dirs, appDir, false)
+	if err != nil {
+		return report, err
+	}
+	if len(envs) == 0 {
+		lines = append(lines, fmt.Sprintf("no config files in %s", appDir))
+	} else {
+		lines = append(lines, fmt.Sprintf(
+			"envs in %s: %s", appDir, strings.Join(envs, ", ")))
+		if suggestions := suggest(env, envs); len(suggestions) > 0 {
+			lines = append(lines,
+				strings.TrimPrefix(didYouMean(suggestions), ", "))
+		}
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// similarName returns the first name that differs from base
+// only by case, or by extension, otherwise the result is empty
+func similarName(names []string, base string) string {
+	stem := strings.TrimSuffix(base, filepath.Ext(base))
+	for _, name := range names {
+		if name == base {
+			continue
+		}
+		if strings.EqualFold(name, base) ||
+			strings.TrimSuffix(name, filepath.Ext(name)) == stem {
+			return name
+		}
+	}
+	return ""
+}
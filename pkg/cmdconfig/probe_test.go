@@ -0,0 +1,45 @@
+package cmdconfig
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestProbeReport(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"), []byte("{}"), perms)
+	is.NoErr(err)
+	err = os.WriteFile(filepath.Join(tmp, "Config.prod.json"), []byte("{}"), perms)
+	is.NoErr(err)
+
+	_, _, err = readConfigFile(nil, tmp, "prod")
+	is.True(err != nil)
+	lines := strings.Split(err.Error(), "\n")
+	is.Equal("config file not found for env prod", lines[0])
+	paths, err := share.GetConfigFilePaths(tmp, "prod")
+	is.NoErr(err)
+	is.Equal(len(paths)+2, len(lines))
+	// Paths are listed in load precedence order
+	for i, p := range paths {
+		is.True(strings.HasPrefix(lines[i+1], "  "+p+": not found"))
+	}
+	is.True(strings.HasSuffix(lines[2], "but Config.prod.json exists"))
+	is.Equal("envs in "+tmp+": dev", lines[len(lines)-1])
+
+	is.Equal("config.dev.yml",
+		similarName([]string{"config.dev.yml"}, "config.dev.yaml"))
+	is.Equal("", similarName([]string{"config.dev.yaml"}, "config.dev.yaml"))
+	is.Equal("", similarName([]string{"config.prod.yaml"}, "config.dev.yaml"))
+}
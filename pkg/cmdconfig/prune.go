@@ -0,0 +1,82 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/pkg/errors"
+)
+
+// pruneKeys deletes keys that are not in the sample for env, from the
+// config files for the envs as per the all and env flags. Samples are
+// skipped, and so is the app dir key, and keys matching the ignore flags
+// or manifest. It's the inverse of comparing with the sample
+func pruneKeys(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
+	buf = new(bytes.Buffer)
+
+	envs, err := updateEnvs(in)
+	if err != nil {
+		return buf, files, err
+	}
+	appDirKey := fmt.Sprintf("%sDIR", in.Prefix)
+	ignore := make([]string, 0)
+	ignore = append(ignore, in.manifest.ignore()...)
+	ignore = append(ignore, in.Ignore...)
+
+	for _, env := range envs {
+		if strings.HasPrefix(env, share.SamplePrefix()) {
+			continue
+		}
+		if !in.Force && in.manifest.protected(env) {
+			return buf, files, ErrProtectedEnv(env)
+		}
+		sampleEnv := fmt.Sprintf("%s%s", share.SamplePrefix(), env)
+		exists, err := configFileExists(in.dirs, in.AppDir, sampleEnv)
+		if err != nil {
+			return buf, files, err
+		}
+		if !exists {
+			return buf, files, errors.Errorf(
+				"no sample to prune env %s, create it with the %s flag",
+				env, FlagSample)
+		}
+		_, sample, err := newCachedConf(in.dirs, in.AppDir, sampleEnv)
+		if err != nil {
+			return buf, files, err
+		}
+		configPath, c, err := loadConf(in.dirs, in.AppDir, env)
+		if err != nil {
+			return buf, files, err
+		}
+
+		pruned := make([]string, 0)
+		// Assuming c.Keys is already sorted
+		for _, key := range c.Keys {
+			if _, ok := sample.Map[key]; ok {
+				continue
+			}
+			if key == appDirKey || ignoreKey(ignore, key) {
+				continue
+			}
+			delete(c.Map, key)
+			pruned = append(pruned, key)
+		}
+		if len(pruned) == 0 {
+			continue
+		}
+		c.refreshKeys()
+		b, err := marshalConf(c, filepath.Ext(configPath))
+		if err != nil {
+			return buf, files, err
+		}
+		files = append(files, File{Path: configPath, Buf: bytes.NewBuffer(b)})
+		for _, key := range pruned {
+			buf.WriteString(fmt.Sprintf("prune %s from %s\n", key, configPath))
+		}
+	}
+
+	return buf, files, nil
+}
@@ -0,0 +1,62 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestPruneKeys(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	devPath := filepath.Join(tmp, "config.dev.json")
+	err = os.WriteFile(devPath, []byte(`{
+		"APP_DIR": "/app",
+		"APP_FOO": "foo",
+		"APP_OLD": "old",
+		"APP_LOCAL_BAR": "bar"
+	}`), perms)
+	is.NoErr(err)
+	err = os.WriteFile(filepath.Join(tmp, "sample.config.dev.json"),
+		[]byte(`{"APP_FOO": ""}`), perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	in.Prune = true
+	in.Ignore = ArgMap{"APP_LOCAL_*"}
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdPrune, out.Cmd)
+	is.Equal("prune APP_OLD from "+devPath+"\n", out.Buf.String())
+	is.NoErr(out.Files.Save(new(bytes.Buffer)))
+	_, c, err := newSingleConf(tmp, share.EnvDev)
+	is.NoErr(err)
+	is.Equal([]string{"APP_DIR", "APP_FOO", "APP_LOCAL_BAR"}, c.Keys)
+
+	// Nothing to prune
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(0, len(out.Files))
+
+	// The sample is required
+	in.Env = "prod"
+	err = os.WriteFile(filepath.Join(tmp, "config.prod.json"),
+		[]byte(`{"APP_FOO": "foo"}`), perms)
+	is.NoErr(err)
+	_, err = Cmd(in)
+	is.True(err != nil)
+}
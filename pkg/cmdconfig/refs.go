@@ -0,0 +1,115 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/pkg/errors"
+)
+
+// secretRef is a key with a secret reference value, see share.SecretRef
+type secretRef struct {
+	Env    string `json:"env"`
+	Key    string `json:"key"`
+	Scheme string `json:"scheme"`
+	// Backend the secret is stored in,
+	// i.e. the GCP project, Azure vault, or credential helper name
+	Backend string `json:"backend"`
+	// Ref is the reference without the scheme
+	Ref string `json:"ref"`
+}
+
+// refBackend returns the backend for the secret reference
+func refBackend(scheme, ref string) string {
+	parts := strings.Split(strings.Trim(ref, "/"), "/")
+	if scheme == share.SchemeGCPSM && len(parts) > 1 && parts[0] == "projects" {
+		return parts[1]
+	}
+	return parts[0]
+}
+
+// envRefs returns the secret references in config, sorted by key
+func envRefs(env string, c *conf) (refs []secretRef) {
+	refs = make([]secretRef, 0)
+	// Assuming c.Keys is already sorted
+	for _, key := range c.Keys {
+		scheme, ref, ok := share.SecretRef(c.Map[key])
+		if !ok {
+			continue
+		}
+		refs = append(refs, secretRef{
+			Env:     env,
+			Key:     key,
+			Scheme:  scheme,
+			Backend: refBackend(scheme, ref),
+			Ref:     ref,
+		})
+	}
+	return refs
+}
+
+// printRefs lists the secret references for the envs as per the all and
+// env flags. References are not resolved, so no secrets are fetched
+func printRefs(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
+	buf = new(bytes.Buffer)
+
+	envs, err := updateEnvs(in)
+	if err != nil {
+		return buf, files, err
+	}
+
+	refsByEnv := make([][]secretRef, len(envs))
+	err = eachEnv(envs, func(i int, env string) error {
+		_, config, err := newConf(confParams{
+			dirs:   in.dirs,
+			prefix: in.Prefix,
+			appDir: in.AppDir,
+			env:    env,
+			extend: in.Extend,
+			merge:  in.Merge,
+			parent: in.Parent,
+		})
+		if err != nil {
+			return err
+		}
+		refsByEnv[i] = envRefs(env, config)
+		return nil
+	})
+	if err != nil {
+		return buf, files, err
+	}
+	refs := make([]secretRef, 0)
+	for _, envRefs := range refsByEnv {
+		refs = append(refs, envRefs...)
+	}
+
+	if in.JSON {
+		b, err := json.MarshalIndent(refs, "", "    ")
+		if err != nil {
+			return buf, files, errors.WithStack(err)
+		}
+		buf.Write(b)
+		buf.WriteString("\n")
+		return buf, files, nil
+	}
+
+	if len(refs) == 0 {
+		return buf, files, nil
+	}
+	w := tabwriter.NewWriter(buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ENV\tKEY\tSCHEME\tBACKEND\tREF")
+	for _, r := range refs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			r.Env, r.Key, r.Scheme, r.Backend, r.Ref)
+	}
+	err = w.Flush()
+	if err != nil {
+		return buf, files, errors.WithStack(err)
+	}
+
+	return buf, files, nil
+}
@@ -0,0 +1,64 @@
+package cmdconfig
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestRefs(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"),
+		[]byte(`{"APP_FOO": "foo", "APP_TOKEN": "helper://vault/dev/token"}`),
+		perms)
+	is.NoErr(err)
+	err = os.WriteFile(filepath.Join(tmp, "config.prod.json"),
+		[]byte(`{
+			"APP_DB_PASSWORD": "gcpsm://projects/p1/secrets/db/versions/2",
+			"APP_FOO": "https://example.com",
+			"APP_TOKEN": "azkv://my-vault/token"
+		}`),
+		perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = "*"
+	in.Refs = true
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdRefs, out.Cmd)
+	is.Equal(`ENV   KEY              SCHEME  BACKEND   REF
+dev   APP_TOKEN        helper  vault     vault/dev/token
+prod  APP_DB_PASSWORD  gcpsm   p1        projects/p1/secrets/db/versions/2
+prod  APP_TOKEN        azkv    my-vault  my-vault/token
+`, out.Buf.String())
+
+	in.Env = EnvProd
+	in.JSON = true
+	out, err = Cmd(in)
+	is.NoErr(err)
+	refs := make([]secretRef, 0)
+	err = json.Unmarshal(out.Buf.Bytes(), &refs)
+	is.NoErr(err)
+	is.Equal(2, len(refs))
+	is.Equal(secretRef{
+		Env:     EnvProd,
+		Key:     "APP_TOKEN",
+		Scheme:  "azkv",
+		Backend: "my-vault",
+		Ref:     "my-vault/token",
+	}, refs[1])
+}
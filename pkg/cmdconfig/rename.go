@@ -0,0 +1,284 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/pkg/errors"
+)
+
+// templateActionRegexp matches template actions in values,
+// e.g. "{{.Foo}}" in the value of a template key
+var templateActionRegexp = regexp.MustCompile(`\{\{.*?\}\}`)
+
+// keyRename is parsed from the rename flag, i.e. OLD=NEW
+type keyRename struct {
+	Prefix string
+	Old    string
+	New    string
+}
+
+// newKeyRename parses and validates s
+func newKeyRename(prefix, s string) (r keyRename, err error) {
+	oldKey, newKey, ok := strings.Cut(s, "=")
+	if !ok || oldKey == "" || newKey == "" {
+		return r, errors.Errorf("invalid %s %s, expected OLD=NEW", FlagRename, s)
+	}
+	// The old key may be missing the prefix, see lintKeys
+	if !strings.HasPrefix(newKey, prefix) {
+		return r, errors.Errorf(
+			"key %s must start with prefix %s", newKey, prefix)
+	}
+	if oldKey == newKey {
+		return r, errors.Errorf("invalid %s %s, keys are the same", FlagRename, s)
+	}
+	return keyRename{Prefix: prefix, Old: oldKey, New: newKey}, nil
+}
+
+// value returns the value with template references to the old key renamed.
+// Template params are the formatted keys, see FormatKey
+func (r keyRename) value(value string) string {
+	ref := regexp.MustCompile(
+		`\.` + regexp.QuoteMeta(FormatKey(r.Prefix, r.Old)) + `\b`)
+	return templateActionRegexp.ReplaceAllStringFunc(value,
+		func(action string) string {
+			return ref.ReplaceAllString(action, "."+FormatKey(r.Prefix, r.New))
+		})
+}
+
+// conf renames the key in c, and template references in the values.
+// Returns true if c changed, the new key must not exist
+func (r keyRename) conf(c *conf) (changed bool, err error) {
+	if _, ok := c.Map[r.New]; ok {
+		if _, ok := c.Map[r.Old]; ok {
+			return false, ErrDuplicateKey(r.New)
+		}
+	}
+	for key, value := range c.Map {
+		renamed := r.value(value)
+		if renamed != value {
+			c.Map[key] = renamed
+			changed = true
+		}
+	}
+	if value, ok := c.Map[r.Old]; ok {
+		delete(c.Map, r.Old)
+		c.Map[r.New] = value
+		if c.Dirs != nil {
+			c.Dirs[r.New] = c.Dirs[r.Old]
+			delete(c.Dirs, r.Old)
+		}
+		changed = true
+	}
+	if changed {
+		c.refreshKeys()
+	}
+	return changed, nil
+}
+
+// schema renames the key in schema, and deprecated keys replaced by it
+func (r keyRename) schema(schema Schema) {
+	if ks, ok := schema[r.Old]; ok {
+		delete(schema, r.Old)
+		schema[r.New] = ks
+	}
+	for key, ks := range schema {
+		if ks.ReplacedBy == r.Old {
+			ks.ReplacedBy = r.New
+			schema[key] = ks
+		}
+	}
+}
+
+// schemaFile returns the updated FileNameTypes in appDir.
+// The file is updated as raw JSON, so the format of the
+// declarations is kept, i.e. a type string or an object
+func (r keyRename) schemaFile(dirs *dirCache, appDir string) (
+	file File, changed bool, err error) {
+
+	schemaPath := filepath.Join(appDir, FileNameTypes)
+	exists, err := dirs.exists(schemaPath)
+	if err != nil || !exists {
+		return file, false, err
+	}
+	b, err := os.ReadFile(schemaPath)
+	if err != nil {
+		return file, false, errors.WithStack(err)
+	}
+	raw := make(map[string]json.RawMessage)
+	err = json.Unmarshal(b, &raw)
+	if err != nil {
+		return file, false, errors.Wrapf(err, "invalid %s", schemaPath)
+	}
+	if declaration, ok := raw[r.Old]; ok {
+		if _, ok := raw[r.New]; ok {
+			return file, false, errors.WithMessagef(
+				ErrDuplicateKey(r.New), "invalid %s", schemaPath)
+		}
+		delete(raw, r.Old)
+		raw[r.New] = declaration
+		changed = true
+	}
+	for key, declaration := range raw {
+		object := make(map[string]interface{})
+		if json.Unmarshal(declaration, &object) != nil {
+			// Type string
+			continue
+		}
+		if object["replacedBy"] == r.Old {
+			object["replacedBy"] = r.New
+			raw[key], err = json.Marshal(object)
+			if err != nil {
+				return file, false, errors.WithStack(err)
+			}
+			changed = true
+		}
+	}
+	if !changed {
+		return file, false, nil
+	}
+	b, err = json.MarshalIndent(raw, "", "    ")
+	if err != nil {
+		return file, false, errors.WithStack(err)
+	}
+	return File{Path: schemaPath, Buf: bytes.NewBuffer(b)}, true, nil
+}
+
+// renameEnvs returns the envs as per the all and env flags,
+// with the sample for each env, since renames often miss the sample
+func renameEnvs(in *CmdIn) (envs []string, err error) {
+	selected, err := updateEnvs(in)
+	if err != nil {
+		return envs, err
+	}
+	unique := make(map[string]bool)
+	for _, env := range selected {
+		if !unique[env] {
+			unique[env] = true
+			envs = append(envs, env)
+		}
+		if strings.HasPrefix(env, share.SamplePrefix()) {
+			continue
+		}
+		sampleEnv := fmt.Sprintf("%s%s", share.SamplePrefix(), env)
+		if unique[sampleEnv] {
+			continue
+		}
+		exists, err := configFileExists(in.dirs, in.AppDir, sampleEnv)
+		if err != nil {
+			return envs, err
+		}
+		if exists {
+			unique[sampleEnv] = true
+			envs = append(envs, sampleEnv)
+		}
+	}
+	return envs, nil
+}
+
+// renameKey renames the key in the config files for the envs, the samples,
+// and the schema. Template references to the key are also renamed.
+// With the generate or typescript flags the helpers are generated
+// for the renamed config, so code referencing the old getter fails to build
+func renameKey(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
+	buf = new(bytes.Buffer)
+
+	r, err := newKeyRename(in.Prefix, in.Rename)
+	if err != nil {
+		return buf, files, err
+	}
+	generate := len(in.generatePaths()) > 0 || in.TypeScript != ""
+	if generate && strings.ContainsAny(in.Env, "*,") {
+		return buf, files, errors.Errorf(
+			"%s with %s requires a single env", FlagRename, FlagGenerate)
+	}
+	envs, err := renameEnvs(in)
+	if err != nil {
+		return buf, files, err
+	}
+	if !in.Force {
+		for _, env := range envs {
+			if in.manifest.protected(env) {
+				return buf, files, ErrProtectedEnv(env)
+			}
+		}
+	}
+
+	found := false
+	for _, env := range envs {
+		configPath, c, err := loadConf(in.dirs, in.AppDir, env)
+		if err != nil {
+			return buf, files, err
+		}
+		_, ok := c.Map[r.Old]
+		found = found || ok
+		changed, err := r.conf(c)
+		if err != nil {
+			return buf, files, errors.WithMessagef(err, "env %s", env)
+		}
+		if !changed {
+			continue
+		}
+		b, err := marshalConf(c, filepath.Ext(configPath))
+		if err != nil {
+			return buf, files, err
+		}
+		files = append(files, File{Path: configPath, Buf: bytes.NewBuffer(b)})
+		buf.WriteString(fmt.Sprintf("rename %s → %s in %s\n",
+			r.Old, r.New, configPath))
+	}
+	if !found {
+		keys := make([]string, 0)
+		for _, env := range envs {
+			_, c, err := newCachedConf(in.dirs, in.AppDir, env)
+			if err != nil {
+				return buf, files, err
+			}
+			keys = append(keys, c.Keys...)
+		}
+		return buf, files, withSuggestions(ErrMissingKey(r.Old), r.Old, keys)
+	}
+
+	schemaFile, changed, err := r.schemaFile(in.dirs, in.AppDir)
+	if err != nil {
+		return buf, files, err
+	}
+	if changed {
+		files = append(files, schemaFile)
+		buf.WriteString(fmt.Sprintf("rename %s → %s in %s\n",
+			r.Old, r.New, schemaFile.Path))
+	}
+
+	if !generate {
+		in.warn(fmt.Sprintf(
+			"getter %s is renamed to %s, use the %s flag to update the config package",
+			FormatKey(in.Prefix, r.Old), FormatKey(in.Prefix, r.New), FlagGenerate))
+		return buf, files, nil
+	}
+	config, err := newGenerateConf(in)
+	if err != nil {
+		return buf, files, err
+	}
+	_, err = r.conf(config)
+	if err != nil {
+		return buf, files, err
+	}
+	schema, err := readGenerateSchema(in, config)
+	if err != nil {
+		return buf, files, err
+	}
+	r.schema(schema)
+	generated, err := generateConf(in, buf, config, schema)
+	if err != nil {
+		return buf, files, err
+	}
+	files = append(files, generated...)
+
+	return buf, files, nil
+}
@@ -0,0 +1,104 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestRenameKey(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	files := map[string]string{
+		"config.dev.json": `{"APP_HOST": "localhost",
+			"APP_TEMPLATE_URL": "http://{{.Host}}:{{.Port}} {{.HostPort}}"}`,
+		"sample.config.dev.json": `{"APP_HOST": "", "APP_TEMPLATE_URL": ""}`,
+		"config.prod.yaml":       "APP_HOST: example.com\n",
+		"config.stage.json":      `{"APP_PORT": "443"}`,
+		FileNameTypes: `{"APP_HOST": "string",
+			"APP_SERVER": {"type": "string", "replacedBy": "APP_HOST"}}`,
+	}
+	for name, content := range files {
+		err = os.WriteFile(filepath.Join(tmp, name), []byte(content), perms)
+		is.NoErr(err)
+	}
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = "dev,prod,stage"
+	in.Rename = "APP_HOST=APP_HOSTNAME"
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdRename, out.Cmd)
+	is.Equal(1, len(out.Warnings))
+	paths := make([]string, 0)
+	for _, file := range out.Files {
+		paths = append(paths, filepath.Base(file.Path))
+	}
+	is.Equal([]string{"config.dev.json", "sample.config.dev.json",
+		"config.prod.yaml", FileNameTypes}, paths)
+
+	configMap, err := share.UnmarshalConfig(
+		out.Files[0].Path, out.Files[0].Buf.Bytes())
+	is.NoErr(err)
+	is.Equal(map[string]string{
+		"APP_HOSTNAME":     "localhost",
+		"APP_TEMPLATE_URL": "http://{{.Hostname}}:{{.Port}} {{.HostPort}}",
+	}, configMap)
+	is.Equal("APP_HOSTNAME: example.com\n", out.Files[2].Buf.String())
+	is.True(strings.Contains(out.Files[3].Buf.String(),
+		`"replacedBy": "APP_HOSTNAME"`))
+	is.NoErr(out.Files.Save(new(bytes.Buffer)))
+	schema, err := readSchema(newDirCache(), tmp)
+	is.NoErr(err)
+	is.Equal(TypeString, schema["APP_HOSTNAME"].Type)
+
+	// Key not found
+	_, err = Cmd(in)
+	is.True(err != nil)
+	is.True(strings.HasPrefix(err.Error(), "missing key APP_HOST"))
+
+	// New key must not exist
+	in.Rename = "APP_HOSTNAME=APP_PORT"
+	err = os.WriteFile(filepath.Join(tmp, "config.stage.json"),
+		[]byte(`{"APP_PORT": "443", "APP_HOSTNAME": "stage"}`), perms)
+	is.NoErr(err)
+	_, err = Cmd(in)
+	is.True(err != nil)
+
+	// Generate the renamed getter
+	in.Env = share.EnvDev
+	in.Rename = "APP_HOSTNAME=APP_HOST"
+	in.Generate = "pkg/config"
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(1, len(out.Warnings))
+	is.True(strings.HasPrefix(out.Warnings[0], "APP_SERVER declared"))
+	generated := ""
+	for _, file := range out.Files {
+		if filepath.Base(file.Path) == FileNameConfigGo {
+			generated = file.Buf.String()
+		}
+	}
+	is.True(strings.Contains(generated, "func (c *Config) Host() string"))
+	is.True(!strings.Contains(generated, "Hostname"))
+
+	for _, rename := range []string{"APP_HOST", "APP_HOST=FOO", "APP_A=APP_A"} {
+		in.Rename = rename
+		_, err = Cmd(in)
+		is.True(err != nil)
+	}
+}
@@ -0,0 +1,30 @@
+//go:build !windows
+// +build !windows
+
+package cmdconfig
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestRootDir(t *testing.T) {
+	is := testutil.Setup(t)
+
+	is.True(rootDir("/"))
+	is.True(rootDir("."))
+	is.True(rootDir(""))
+	is.True(!rootDir("/home"))
+	is.True(!rootDir("/home/app"))
+	is.True(!rootDir("app"))
+
+	// Parent search terminates at the root
+	dir := "/home/app/ext"
+	for i := 0; !rootDir(dir); i++ {
+		is.True(i < 10)
+		dir = filepath.Dir(dir)
+	}
+	is.Equal("/", dir)
+}
@@ -0,0 +1,32 @@
+//go:build windows
+// +build windows
+
+package cmdconfig
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestRootDirWindows(t *testing.T) {
+	is := testutil.Setup(t)
+
+	is.True(rootDir(`C:\`))
+	is.True(rootDir(`C:`))
+	is.True(rootDir(`D:\`))
+	is.True(rootDir(`\\host\share\`))
+	is.True(rootDir(`\\host\share`))
+	is.True(!rootDir(`C:\Users`))
+	is.True(!rootDir(`C:\Users\app`))
+	is.True(!rootDir(`\\host\share\app`))
+
+	// Parent search terminates at the drive root
+	dir := `C:\Users\app\ext`
+	for i := 0; !rootDir(dir); i++ {
+		is.True(i < 10)
+		dir = filepath.Dir(dir)
+	}
+	is.Equal(`C:\`, dir)
+}
@@ -0,0 +1,268 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/pkg/errors"
+)
+
+// s3Scheme prefixes S3 URIs, e.g. s3://bucket/app/config.prod.json
+const s3Scheme = "s3://"
+
+// awsCmd runs the aws CLI and returns stdout,
+// it's a variable so tests can stub it
+var awsCmd = func(args ...string) ([]byte, error) {
+	cmd := exec.Command("aws", args...)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	b, err := cmd.Output()
+	if err != nil {
+		return b, errors.Wrapf(err, "aws %s", strings.TrimSpace(stderr.String()))
+	}
+	return b, nil
+}
+
+// s3Object location
+type s3Object struct {
+	URI    string
+	Bucket string
+	Key    string
+}
+
+// newS3Object parses the URI. If the URI ends with a slash,
+// the base name of configPath is appended to the key
+func newS3Object(uri, configPath string) (o s3Object, err error) {
+	if !strings.HasPrefix(uri, s3Scheme) {
+		return o, errors.Errorf("invalid S3 URI %s", uri)
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(uri, s3Scheme), "/")
+	if strings.HasSuffix(uri, "/") {
+		key = fmt.Sprintf("%s%s", key, filepath.Base(configPath))
+		uri = fmt.Sprintf("%s%s", uri, filepath.Base(configPath))
+	}
+	if bucket == "" || key == "" {
+		return o, errors.Errorf("invalid S3 URI %s", uri)
+	}
+	return s3Object{URI: uri, Bucket: bucket, Key: key}, nil
+}
+
+// s3Result is the relevant part of the aws s3api JSON output
+type s3Result struct {
+	ETag string `json:"ETag"`
+}
+
+// headETag returns the current ETag of the object,
+// or an empty string if the object does not exist
+func (o s3Object) headETag() (etag string, err error) {
+	b, err := awsCmd("s3api", "head-object",
+		"--bucket", o.Bucket, "--key", o.Key)
+	if err != nil {
+		if strings.Contains(err.Error(), "Not Found") ||
+			strings.Contains(err.Error(), "404") {
+			return "", nil
+		}
+		return etag, err
+	}
+	result := s3Result{}
+	err = json.Unmarshal(b, &result)
+	if err != nil {
+		return etag, errors.WithStack(err)
+	}
+	return result.ETag, nil
+}
+
+// etagPath returns the path to the file with the last known ETag,
+// and the hash of the local file when it was last pulled or pushed.
+// It's kept in the user cache dir
+func (o s3Object) etagPath() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return filepath.Join(cacheDir, "configu", "s3",
+		unsafeFileName.ReplaceAllString(o.URI, "_")), nil
+}
+
+// knownETag returns the ETag of the object, and the hash of the local file,
+// when it was last pulled or pushed
+func (o s3Object) knownETag() (etagPath, etag, hash string, err error) {
+	etagPath, err = o.etagPath()
+	if err != nil {
+		return etagPath, etag, hash, err
+	}
+	b, err := os.ReadFile(etagPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return etagPath, "", "", nil
+		}
+		return etagPath, etag, hash, errors.WithStack(err)
+	}
+	etag, hash, _ = strings.Cut(strings.TrimSpace(string(b)), "\n")
+	return etagPath, etag, hash, nil
+}
+
+// syncState is written to the etag path after pull or push
+func syncState(etag string, b []byte) *bytes.Buffer {
+	return bytes.NewBufferString(fmt.Sprintf("%s\n%x\n", etag, sha256.Sum256(b)))
+}
+
+// localChanges returns true if the file at configPath was modified since it
+// was last pulled or pushed. Files that were never synced are modified,
+// unless the content is the same as b
+func localChanges(configPath, hash string, b []byte) (bool, error) {
+	local, err := os.ReadFile(configPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, errors.WithStack(err)
+	}
+	if bytes.Equal(local, b) {
+		return false, nil
+	}
+	return hash != fmt.Sprintf("%x", sha256.Sum256(local)), nil
+}
+
+// preconditionFailed returns true if a conditional put was rejected
+func preconditionFailed(err error) bool {
+	return strings.Contains(err.Error(), "PreconditionFailed") ||
+		strings.Contains(err.Error(), "412")
+}
+
+// pullConfig downloads the config file for env from S3
+func pullConfig(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
+	buf = new(bytes.Buffer)
+
+	// Existing config file is replaced,
+	// otherwise the file type is the same as the object
+	configPath, _, err := readConfigFile(in.dirs, in.AppDir, in.Env)
+	if err != nil {
+		configPath, err = share.GetConfigFilePath(
+			in.AppDir, in.Env, filepath.Ext(in.Pull))
+		if err != nil {
+			return buf, files, err
+		}
+	}
+	o, err := newS3Object(in.Pull, configPath)
+	if err != nil {
+		return buf, files, err
+	}
+	etagPath, _, hash, err := o.knownETag()
+	if err != nil {
+		return buf, files, err
+	}
+
+	tmp, err := os.CreateTemp("", "configu-s3")
+	if err != nil {
+		return buf, files, errors.WithStack(err)
+	}
+	_ = tmp.Close()
+	defer (func() {
+		_ = os.Remove(tmp.Name())
+	})()
+	b, err := awsCmd("s3api", "get-object",
+		"--bucket", o.Bucket, "--key", o.Key, tmp.Name())
+	if err != nil {
+		return buf, files, err
+	}
+	result := s3Result{}
+	err = json.Unmarshal(b, &result)
+	if err != nil {
+		return buf, files, errors.WithStack(err)
+	}
+	b, err = os.ReadFile(tmp.Name())
+	if err != nil {
+		return buf, files, errors.WithStack(err)
+	}
+	_, err = share.UnmarshalConfig(configPath, b)
+	if err != nil {
+		return buf, files, errors.WithMessagef(err, "pull %s", o.URI)
+	}
+	// Uncommitted edits to the local file are not overwritten
+	modified, err := localChanges(configPath, hash, b)
+	if err != nil {
+		return buf, files, err
+	}
+	if modified && !in.Force {
+		return buf, files, ErrLocalChanges(configPath)
+	}
+
+	buf.WriteString(fmt.Sprintf("pulled %s %s\n", o.URI, result.ETag))
+	files = append(files,
+		File{Path: configPath, Buf: bytes.NewBuffer(b)},
+		File{Path: etagPath, Buf: syncState(result.ETag, b)})
+	return buf, files, nil
+}
+
+// pushConfig uploads the config file for env to S3.
+// The object must not have changed since it was last pulled or pushed,
+// unless the force flag is set. The put is conditional on the known ETag,
+// so concurrent pushes can't overwrite each other
+func pushConfig(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
+	buf = new(bytes.Buffer)
+
+	configPath, _, err := readConfigFile(in.dirs, in.AppDir, in.Env)
+	if err != nil {
+		return buf, files, err
+	}
+	o, err := newS3Object(in.Push, configPath)
+	if err != nil {
+		return buf, files, err
+	}
+	body, err := os.ReadFile(configPath)
+	if err != nil {
+		return buf, files, errors.WithStack(err)
+	}
+
+	etagPath, known, _, err := o.knownETag()
+	if err != nil {
+		return buf, files, err
+	}
+	current, err := o.headETag()
+	if err != nil {
+		return buf, files, err
+	}
+	if current != "" && current != known && !in.Force {
+		return buf, files, ErrConflict(o.URI)
+	}
+
+	if in.DryRun {
+		buf.WriteString(fmt.Sprintf("push %s %s\n", configPath, o.URI))
+		return buf, files, nil
+	}
+
+	args := []string{"s3api", "put-object",
+		"--bucket", o.Bucket, "--key", o.Key, "--body", configPath}
+	if !in.Force {
+		if current == "" {
+			args = append(args, "--if-none-match", "*")
+		} else {
+			args = append(args, "--if-match", current)
+		}
+	}
+	b, err := awsCmd(args...)
+	if err != nil {
+		if preconditionFailed(err) {
+			return buf, files, ErrConflict(o.URI)
+		}
+		return buf, files, err
+	}
+	result := s3Result{}
+	err = json.Unmarshal(b, &result)
+	if err != nil {
+		return buf, files, errors.WithStack(err)
+	}
+
+	buf.WriteString(fmt.Sprintf("pushed %s %s\n", o.URI, result.ETag))
+	files = append(files,
+		File{Path: etagPath, Buf: syncState(result.ETag, body)})
+	return buf, files, nil
+}
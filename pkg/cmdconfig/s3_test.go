@@ -0,0 +1,174 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mozey/config/pkg/testutil"
+	"github.com/pkg/errors"
+)
+
+// stubS3 replaces awsCmd with an in-memory bucket for the duration of the test
+func stubS3(t *testing.T, objects map[string][]byte) {
+	original := awsCmd
+	etag := func(b []byte) string {
+		return fmt.Sprintf(`"%x"`, md5.Sum(b))
+	}
+	awsCmd = func(args ...string) ([]byte, error) {
+		// s3api <cmd> --bucket b --key k ...
+		key := fmt.Sprintf("%s/%s", args[3], args[5])
+		b, ok := objects[key]
+		switch args[1] {
+		case "head-object":
+			if !ok {
+				return nil, fmt.Errorf("aws An error occurred (404): Not Found")
+			}
+		case "get-object":
+			if !ok {
+				return nil, fmt.Errorf("aws NoSuchKey")
+			}
+			err := os.WriteFile(args[6], b, perms)
+			if err != nil {
+				return nil, err
+			}
+		case "put-object":
+			// Conditional put, i.e. --if-match etag or --if-none-match *
+			if len(args) > 9 {
+				if (args[8] == "--if-match" && (!ok || etag(b) != args[9])) ||
+					(args[8] == "--if-none-match" && ok) {
+					return nil, fmt.Errorf("aws An error occurred (PreconditionFailed)")
+				}
+			}
+			var err error
+			b, err = os.ReadFile(args[7])
+			if err != nil {
+				return nil, err
+			}
+			objects[key] = b
+		}
+		return []byte(fmt.Sprintf(`{"ETag": %q}`, etag(b))), nil
+	}
+	t.Cleanup(func() {
+		awsCmd = original
+	})
+}
+
+func TestS3Object(t *testing.T) {
+	is := testutil.Setup(t)
+
+	o, err := newS3Object("s3://bucket/app/config.prod.json", "")
+	is.NoErr(err)
+	is.Equal("bucket", o.Bucket)
+	is.Equal("app/config.prod.json", o.Key)
+
+	o, err = newS3Object("s3://bucket/app/", "/tmp/config.prod.yaml")
+	is.NoErr(err)
+	is.Equal("app/config.prod.yaml", o.Key)
+	is.Equal("s3://bucket/app/config.prod.yaml", o.URI)
+
+	_, err = newS3Object("s3://bucket", "")
+	is.True(err != nil)
+	_, err = newS3Object("https://bucket/app", "")
+	is.True(err != nil)
+}
+
+func TestPushPull(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	// ETags are kept in the user cache dir
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(tmp, "cache"))
+	t.Setenv("HOME", tmp)
+
+	objects := make(map[string][]byte)
+	stubS3(t, objects)
+
+	appDir := filepath.Join(tmp, "app")
+	err = os.Mkdir(appDir, dirPerms)
+	is.NoErr(err)
+	configPath := filepath.Join(appDir, "config.prod.json")
+	err = os.WriteFile(configPath, []byte(`{"APP_FOO": "foo"}`), perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = appDir
+	in.Prefix = "APP_"
+	in.Env = EnvProd
+	in.Push = "s3://bucket/app/"
+
+	// Push new object
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdPush, out.Cmd)
+	is.NoErr(out.Files.Save(new(bytes.Buffer)))
+	is.Equal(`{"APP_FOO": "foo"}`, string(objects["bucket/app/config.prod.json"]))
+
+	// Push again, the object has not changed
+	err = os.WriteFile(configPath, []byte(`{"APP_FOO": "bar"}`), perms)
+	is.NoErr(err)
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.NoErr(out.Files.Save(new(bytes.Buffer)))
+
+	// Object changed by someone else
+	objects["bucket/app/config.prod.json"] = []byte(`{"APP_FOO": "buz"}`)
+	_, err = Cmd(in)
+	is.True(errors.Is(err, ErrConflict("")))
+
+	// Object changed by someone else between head and put
+	headETag := awsCmd
+	awsCmd = func(args ...string) ([]byte, error) {
+		b, err := headETag(args...)
+		if args[1] == "head-object" {
+			objects["bucket/app/config.prod.json"] = []byte(`{"APP_FOO": "buz"}`)
+		}
+		return b, err
+	}
+	objects["bucket/app/config.prod.json"] = []byte(`{"APP_FOO": "bar"}`)
+	_, err = Cmd(in)
+	is.True(errors.Is(err, ErrConflict("")))
+	awsCmd = headETag
+
+	// Pull does not overwrite local changes
+	in.Push = ""
+	in.Pull = "s3://bucket/app/config.prod.json"
+	err = os.WriteFile(configPath, []byte(`{"APP_FOO": "local"}`), perms)
+	is.NoErr(err)
+	_, err = Cmd(in)
+	is.True(errors.Is(err, ErrLocalChanges("")))
+	err = os.WriteFile(configPath, []byte(`{"APP_FOO": "bar"}`), perms)
+	is.NoErr(err)
+
+	// Pull replaces the config file
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdPull, out.Cmd)
+	is.NoErr(out.Files.Save(new(bytes.Buffer)))
+	b, err := os.ReadFile(configPath)
+	is.NoErr(err)
+	is.Equal(`{"APP_FOO": "buz"}`, string(b))
+
+	// Push after pull
+	in.Pull = ""
+	in.Push = "s3://bucket/app/"
+	_, err = Cmd(in)
+	is.NoErr(err)
+
+	// Force overwrite
+	objects["bucket/app/config.prod.json"] = []byte(`{"APP_FOO": "qux"}`)
+	_, err = Cmd(in)
+	is.True(errors.Is(err, ErrConflict("")))
+	in.Force = true
+	_, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(`{"APP_FOO": "buz"}`, string(objects["bucket/app/config.prod.json"]))
+}
@@ -0,0 +1,208 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/mozey/config/pkg/share"
+)
+
+// sampleValue returns the value for key in the sample config.
+// Values in the existing sample are kept, e.g. placeholders edited by hand,
+// otherwise the default is used if declared in FileNameTypes.
+// Template keys are kept, their values don't contain secrets
+func sampleValue(prefix string, key string, c *conf, existing *conf,
+	schema Schema) string {
+
+	if existing != nil {
+		if value, ok := existing.Map[key]; ok {
+			return value
+		}
+	}
+	if schema[key].Default != "" {
+		return schema[key].Default
+	}
+	if strings.HasPrefix(key, KeyPrefixTemplate(prefix)) {
+		return c.Map[key]
+	}
+	return ""
+}
+
+// sampleConfigByEnv returns the sample config file for env,
+// with the same keys as the config file. If the format changes the file
+// type of the existing sample, the existing sample is removed
+func sampleConfigByEnv(in *CmdIn, env string,
+	schema Schema, format string) (files []File, err error) {
+
+	configPaths, c, err := newCachedConf(in.dirs, in.AppDir, env)
+	if err != nil {
+		return files, err
+	}
+	fileType := filepath.Ext(configPaths[0])
+
+	sampleEnv := fmt.Sprintf("%s%s", share.SamplePrefix(), env)
+	var existing *conf
+	samplePath := ""
+	exists, err := configFileExists(in.dirs, in.AppDir, sampleEnv)
+	if err != nil {
+		return files, err
+	}
+	if exists {
+		var samplePaths []string
+		samplePaths, existing, err = newCachedConf(in.dirs, in.AppDir, sampleEnv)
+		if err != nil {
+			return files, err
+		}
+		samplePath = samplePaths[0]
+		fileType = filepath.Ext(samplePath)
+	}
+
+	// Format flag overrides the file type
+	dotFormat := fmt.Sprintf(".%s", format)
+	if dotFormat == share.FileTypeENV ||
+		dotFormat == share.FileTypeSH ||
+		dotFormat == share.FileTypeJSON ||
+		dotFormat == share.FileTypeYAML {
+		fileType = dotFormat
+	}
+	existingPath := samplePath
+	if samplePath == "" || filepath.Ext(samplePath) != fileType {
+		samplePath, err = share.GetConfigFilePath(in.AppDir, sampleEnv, fileType)
+		if err != nil {
+			return files, err
+		}
+	}
+
+	// Keys not in the config file are removed from the sample
+	sample := &conf{Map: make(map[string]string)}
+	for _, key := range c.Keys {
+		sample.Map[key] = sampleValue(in.Prefix, key, c, existing, schema)
+	}
+	sample.refreshKeys()
+
+	b, err := marshalConf(sample, fileType)
+	if err != nil {
+		return files, err
+	}
+	files = append(files, File{Path: samplePath, Buf: bytes.NewBuffer(b)})
+	if existingPath != "" && existingPath != samplePath {
+		// The existing sample would take precedence when loading
+		files = append(files, File{Path: existingPath, Del: true})
+	}
+	return files, nil
+}
+
+// generateSamples creates or updates sample config files
+// for the envs as per the all and env flags
+func generateSamples(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
+	buf = new(bytes.Buffer)
+
+	envs, err := updateEnvs(in)
+	if err != nil {
+		return buf, files, err
+	}
+	// Samples are generated from the config files, not other samples
+	configEnvs := make([]string, 0, len(envs))
+	for _, env := range envs {
+		if !strings.HasPrefix(env, share.SamplePrefix()) {
+			configEnvs = append(configEnvs, env)
+		}
+	}
+
+	schema, err := readSchema(in.dirs, in.AppDir)
+	if err != nil {
+		return buf, files, err
+	}
+
+	// Format flag takes precedence over the manifest
+	format := in.Format
+	if format == "" {
+		format = in.manifest.format()
+	}
+
+	envFiles := make([][]File, len(configEnvs))
+	err = eachEnv(configEnvs, func(i int, env string) error {
+		sampleFiles, err := sampleConfigByEnv(in, env, schema, format)
+		envFiles[i] = sampleFiles
+		return err
+	})
+	if err != nil {
+		return buf, files, err
+	}
+	for _, f := range envFiles {
+		files = append(files, f...)
+	}
+
+	return buf, files, nil
+}
+
+// syncSamples adds keys in the config files for the envs,
+// as per the all and env flags, that are missing from the existing samples.
+// Unlike generateSamples, keys and values already in the samples are not
+// changed, and the file format is kept. Keys matching the ignore flags
+// or manifest are not added
+func syncSamples(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
+	buf = new(bytes.Buffer)
+
+	envs, err := updateEnvs(in)
+	if err != nil {
+		return buf, files, err
+	}
+	schema, err := readSchema(in.dirs, in.AppDir)
+	if err != nil {
+		return buf, files, err
+	}
+	ignore := make([]string, 0)
+	ignore = append(ignore, in.manifest.ignore()...)
+	ignore = append(ignore, in.Ignore...)
+
+	for _, env := range envs {
+		if strings.HasPrefix(env, share.SamplePrefix()) {
+			continue
+		}
+		sampleEnv := fmt.Sprintf("%s%s", share.SamplePrefix(), env)
+		exists, err := configFileExists(in.dirs, in.AppDir, sampleEnv)
+		if err != nil {
+			return buf, files, err
+		}
+		if !exists {
+			in.warn(fmt.Sprintf("no sample for env %s, create it with the %s flag",
+				env, FlagSample))
+			continue
+		}
+		_, c, err := newCachedConf(in.dirs, in.AppDir, env)
+		if err != nil {
+			return buf, files, err
+		}
+		samplePath, sample, err := loadConf(in.dirs, in.AppDir, sampleEnv)
+		if err != nil {
+			return buf, files, err
+		}
+
+		added := make([]string, 0)
+		// Assuming c.Keys is already sorted
+		for _, key := range c.Keys {
+			if _, ok := sample.Map[key]; ok || ignoreKey(ignore, key) {
+				continue
+			}
+			sample.Map[key] = sampleValue(in.Prefix, key, c, nil, schema)
+			added = append(added, key)
+		}
+		if len(added) == 0 {
+			continue
+		}
+		sample.refreshKeys()
+		b, err := marshalConf(sample, filepath.Ext(samplePath))
+		if err != nil {
+			return buf, files, err
+		}
+		files = append(files, File{Path: samplePath, Buf: bytes.NewBuffer(b)})
+		for _, key := range added {
+			buf.WriteString(fmt.Sprintf("add %s to %s\n", key, samplePath))
+		}
+	}
+
+	return buf, files, nil
+}
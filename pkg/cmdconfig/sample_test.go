@@ -0,0 +1,130 @@
+package cmdconfig
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestGenerateSamples(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"), []byte(`{
+		"APP_DB_PASSWORD": "s3cret",
+		"APP_HOST": "localhost",
+		"APP_PORT": "8080",
+		"APP_TEMPLATE_URL": "http://{{.Host}}"
+	}`), perms)
+	is.NoErr(err)
+	err = os.WriteFile(filepath.Join(tmp, "config.prod.yaml"),
+		[]byte("APP_HOST: example.com\n"), perms)
+	is.NoErr(err)
+	// Existing sample values are kept, removed keys are dropped
+	err = os.WriteFile(filepath.Join(tmp, "sample.config.dev.json"),
+		[]byte(`{"APP_HOST": "<host>", "APP_OLD": "x"}`), perms)
+	is.NoErr(err)
+	err = os.WriteFile(filepath.Join(tmp, FileNameTypes),
+		[]byte(`{"APP_PORT": {"type": "int", "default": "80"}}`), perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = "*"
+	in.Sample = true
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdSample, out.Cmd)
+	is.Equal(1, len(out.Files))
+
+	is.Equal(filepath.Join(tmp, "sample.config.dev.json"), out.Files[0].Path)
+	m := make(map[string]string)
+	err = json.Unmarshal(out.Files[0].Buf.Bytes(), &m)
+	is.NoErr(err)
+	is.Equal(map[string]string{
+		"APP_DB_PASSWORD":  "",
+		"APP_HOST":         "<host>",
+		"APP_PORT":         "80",
+		"APP_TEMPLATE_URL": "http://{{.Host}}",
+	}, m)
+
+	// New samples have the same format as the config file
+	in.Env = EnvProd
+	out, err = Cmd(in)
+	is.NoErr(err)
+	path, err := share.GetConfigFilePath(tmp, "sample.prod", share.FileTypeYAML)
+	is.NoErr(err)
+	is.Equal(path, out.Files[0].Path)
+	is.Equal("APP_HOST: \"\"\n", out.Files[0].Buf.String())
+
+	// Format flag converts the existing sample
+	_, err = in.Process(out)
+	is.NoErr(err)
+	in.Format = "json"
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(2, len(out.Files))
+	is.Equal(filepath.Join(tmp, "sample.config.prod.json"), out.Files[0].Path)
+	is.Equal(path, out.Files[1].Path)
+	is.True(out.Files[1].Del)
+}
+
+func TestSyncSamples(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"), []byte(`{
+		"APP_FOO": "foo",
+		"APP_NEW": "secret",
+		"APP_LOCAL_BAR": "bar"
+	}`), perms)
+	is.NoErr(err)
+	err = os.WriteFile(filepath.Join(tmp, "config.prod.json"),
+		[]byte(`{"APP_FOO": "foo"}`), perms)
+	is.NoErr(err)
+	samplePath := filepath.Join(tmp, "sample.config.dev.json")
+	err = os.WriteFile(samplePath,
+		[]byte(`{"APP_FOO": "placeholder", "APP_SAMPLE_ONLY": ""}`), perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = "*"
+	in.SyncSamples = true
+	in.Ignore = ArgMap{"APP_LOCAL_*"}
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdSyncSamples, out.Cmd)
+	is.Equal("add APP_NEW to "+samplePath+"\n", out.Buf.String())
+	// There is no sample for prod
+	is.Equal(1, len(out.Warnings))
+	is.Equal(1, len(out.Files))
+
+	// Existing keys and values are kept, new values are blank
+	m := make(map[string]string)
+	err = json.Unmarshal(out.Files[0].Buf.Bytes(), &m)
+	is.NoErr(err)
+	is.Equal(map[string]string{
+		"APP_FOO":         "placeholder",
+		"APP_NEW":         "",
+		"APP_SAMPLE_ONLY": "",
+	}, m)
+}
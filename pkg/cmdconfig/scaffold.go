@@ -0,0 +1,114 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// DirScaffoldCmd is the parent dir for commands generated by scaffold,
+// relative to APP_DIR
+const DirScaffoldCmd = "cmd"
+
+// cmdNameRegexp matches valid command names, i.e. a single dir
+var cmdNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// templateScaffoldCmd is the main.go for a custom build,
+// it only depends on the exported API, see RunWith
+var templateScaffoldCmd = `// Command {{.Name}} is a custom build of configu,
+// generated with "configu -{{.Flag}} {{.Name}}".
+// Custom flags and commands are added below, configu is upgraded with
+// go get github.com/mozey/config@latest
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/mozey/config/pkg/cmdconfig"
+)
+
+// version may be set with ldflags, e.g.
+// go build -ldflags "-X main.version=v1.0.0" ./cmd/{{.Name}}
+var version = "{{.Version}}"
+
+// hello is an example custom flag
+var hello bool
+
+// flags defines custom flags, in addition to the configu flags
+func flags(fs *flag.FlagSet) {
+	fs.BoolVar(&hello, "hello", false, "Print hello and the app dir")
+}
+
+// helloPlugin is an example custom command.
+// Return nil to fall through to the next plugin, or the configu commands
+func helloPlugin(in *cmdconfig.CmdIn) (*cmdconfig.CmdOut, error) {
+	if !hello {
+		return nil, nil
+	}
+	return &cmdconfig.CmdOut{
+		Cmd: "hello",
+		Buf: bytes.NewBufferString(fmt.Sprintf("hello %s\n", in.AppDir)),
+	}, nil
+}
+
+func main() {
+	os.Exit(cmdconfig.RunWith(cmdconfig.RunParams{
+		Version: version,
+		Args:    os.Args[1:],
+		Stdin:   os.Stdin,
+		Stdout:  os.Stdout,
+		Stderr:  os.Stderr,
+		Flags:   flags,
+		Plugins: []cmdconfig.Plugin{helloPlugin},
+	}))
+}
+`
+
+// scaffoldCmd generates main.go for a custom build in DirScaffoldCmd,
+// existing files are only overwritten with the force flag
+func scaffoldCmd(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
+	buf = new(bytes.Buffer)
+
+	name := in.ScaffoldCmd
+	if !cmdNameRegexp.MatchString(name) {
+		return buf, files, errors.Errorf(
+			"invalid command name %s, expected a dir name, e.g. mycompany-config",
+			name)
+	}
+	filePath := filepath.Join(in.AppDir, DirScaffoldCmd, name, "main.go")
+	exists, err := in.dirs.exists(filePath)
+	if err != nil {
+		return buf, files, err
+	}
+	if exists && !in.Force {
+		return buf, files, errors.Errorf(
+			"%s exists, use the %s flag to overwrite it", filePath, FlagForce)
+	}
+
+	version := in.version
+	if version == "" {
+		version = "dev"
+	}
+	t := template.Must(template.New(FlagScaffoldCmd).Parse(templateScaffoldCmd))
+	b := new(bytes.Buffer)
+	err = t.Execute(b, map[string]string{
+		"Name":    name,
+		"Flag":    FlagScaffoldCmd,
+		"Version": version,
+	})
+	if err != nil {
+		return buf, files, errors.WithStack(err)
+	}
+	files = append(files, File{Path: filePath, Buf: b})
+
+	buf.WriteString(fmt.Sprintf("\nBuild the command\n    go build -o %s ./%s\n",
+		name, filepath.ToSlash(filepath.Join(DirScaffoldCmd, name))))
+	return buf, files, nil
+}
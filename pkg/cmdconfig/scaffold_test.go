@@ -0,0 +1,59 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"go/format"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestScaffoldCmd(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	in := NewCmdIn(CmdInParams{Version: "v1.2.3"})
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.ScaffoldCmd = "mycompany-config"
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdScaffoldCmd, out.Cmd)
+	is.Equal(1, len(out.Files))
+	is.Equal(filepath.Join(tmp, "cmd", "mycompany-config", "main.go"),
+		out.Files[0].Path)
+	is.True(strings.Contains(out.Buf.String(),
+		"go build -o mycompany-config ./cmd/mycompany-config"))
+
+	// Generated code is formatted
+	b := out.Files[0].Buf.Bytes()
+	formatted, err := format.Source(b)
+	is.NoErr(err)
+	is.Equal(string(formatted), string(b))
+	is.True(strings.Contains(string(b), `var version = "v1.2.3"`))
+	is.True(strings.Contains(string(b), "cmdconfig.RunWith("))
+
+	// Existing files require force
+	is.NoErr(out.Files.Save(new(bytes.Buffer)))
+	_, err = Cmd(in)
+	is.True(err != nil)
+	in.Force = true
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(1, len(out.Files))
+
+	for _, name := range []string{"../config", "a/b", ".hidden"} {
+		in.ScaffoldCmd = name
+		_, err = Cmd(in)
+		is.True(err != nil)
+	}
+}
@@ -0,0 +1,253 @@
+package cmdconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FileNameTypes declares key types in APP_DIR, for example
+//
+//	{
+//	    "APP_PORT": {"type": "int", "default": "8080"},
+//	    "APP_DEBUG": "bool"
+//	}
+const FileNameTypes = "config.types.json"
+
+// EnvTypes is not a valid env, i.e. FileNameTypes is not a config file
+const EnvTypes = "types"
+
+// Key types
+const (
+	TypeString   = "string"
+	TypeInt      = "int"
+	TypeBool     = "bool"
+	TypeFloat    = "float"
+	TypeDuration = "duration"
+	TypeURL      = "url"
+)
+
+// goType describes the generated code for a key type
+type goType struct {
+	// Name of the Go type returned by the getter
+	Name string
+	// Parse is a format string for an expression returning (value, error),
+	// the verb is replaced with the string value
+	Parse string
+	// Import required by Parse
+	Import string
+}
+
+// goTypes by key type, string keys are not parsed
+var goTypes = map[string]goType{
+	TypeInt:      {Name: "int", Parse: "strconv.Atoi(%s)", Import: "strconv"},
+	TypeBool:     {Name: "bool", Parse: "strconv.ParseBool(%s)", Import: "strconv"},
+	TypeFloat:    {Name: "float64", Parse: "strconv.ParseFloat(%s, 64)", Import: "strconv"},
+	TypeDuration: {Name: "time.Duration", Parse: "time.ParseDuration(%s)", Import: "time"},
+	TypeURL:      {Name: "*url.URL", Parse: "url.ParseRequestURI(%s)", Import: "net/url"},
+}
+
+// KeyTypes returns the sorted list of supported key types
+func KeyTypes() []string {
+	types := []string{TypeString}
+	for t := range goTypes {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// KeySchema declares the type of a key
+type KeySchema struct {
+	Type string `json:"type"`
+	// Optional keys may be missing in some envs, see compareKeys
+	Optional bool `json:"optional"`
+	// Default is compiled into generated code,
+	// and used if the value is not set by ldflags, env, or file
+	Default string `json:"default"`
+	// Locked keys may not be set by env in generated code,
+	// e.g. to prevent overriding a signing key at runtime
+	Locked bool `json:"locked"`
+	// Secret values are redacted by the generated GetMap and String
+	Secret bool `json:"secret"`
+	// Owner is the team responsible for the key, see Owners
+	Owner string `json:"owner"`
+	// Deprecated keys are reported by compare,
+	// and generated getters print a warning
+	Deprecated bool `json:"deprecated"`
+	// ReplacedBy is the key that replaces a deprecated key,
+	// the generated getter returns its value if set. Implies deprecated
+	ReplacedBy string `json:"replacedBy"`
+}
+
+// UnmarshalJSON accepts a type string, or an object
+func (ks *KeySchema) UnmarshalJSON(b []byte) error {
+	var t string
+	if err := json.Unmarshal(b, &t); err == nil {
+		ks.Type = t
+		return nil
+	}
+	// Alias to avoid recursion
+	type keySchema KeySchema
+	var v keySchema
+	err := json.Unmarshal(b, &v)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	*ks = KeySchema(v)
+	return nil
+}
+
+// Schema maps keys to the declared schema
+type Schema map[string]KeySchema
+
+// readSchema reads FileNameTypes in appDir,
+// the schema is empty if the file does not exist
+func readSchema(dirs *dirCache, appDir string) (schema Schema, err error) {
+	schema = make(Schema)
+	schemaPath := filepath.Join(appDir, FileNameTypes)
+	exists, err := dirs.exists(schemaPath)
+	if err != nil || !exists {
+		return schema, err
+	}
+	b, err := os.ReadFile(schemaPath)
+	if err != nil {
+		return schema, errors.WithStack(err)
+	}
+	err = json.Unmarshal(b, &schema)
+	if err != nil {
+		return schema, errors.Wrapf(err, "invalid %s", schemaPath)
+	}
+	for key, ks := range schema {
+		if ks.Type == "" {
+			ks.Type = TypeString
+			schema[key] = ks
+		}
+		if _, ok := goTypes[ks.Type]; !ok && ks.Type != TypeString {
+			return schema, errors.Errorf(
+				"invalid type %s for key %s, expected one of %s",
+				ks.Type, key, strings.Join(KeyTypes(), ", "))
+		}
+		if ks.ReplacedBy != "" {
+			ks.Deprecated = true
+			schema[key] = ks
+		}
+	}
+	err = schema.validReplacements()
+	if err != nil {
+		return schema, errors.WithMessagef(err, "invalid %s", schemaPath)
+	}
+	return schema, nil
+}
+
+// validReplacements checks the keys that replace deprecated keys.
+// The replacement must have the same type, since the getter returns it,
+// and must not be deprecated itself
+func (schema Schema) validReplacements() error {
+	for key, ks := range schema {
+		if ks.ReplacedBy == "" {
+			continue
+		}
+		if ks.ReplacedBy == key {
+			return errors.Errorf("key %s can't replace itself", key)
+		}
+		replacement := schema[ks.ReplacedBy]
+		if replacement.Type == "" {
+			replacement.Type = TypeString
+		}
+		if replacement.Type != ks.Type {
+			return errors.Errorf(
+				"key %s has type %s, but replacement %s has type %s",
+				key, ks.Type, ks.ReplacedBy, replacement.Type)
+		}
+		if replacement.Deprecated {
+			return errors.Errorf(
+				"key %s is replaced by %s, which is also deprecated",
+				key, ks.ReplacedBy)
+		}
+	}
+	return nil
+}
+
+// missingKeys returns sorted schema keys that are not in the config
+func (schema Schema) missingKeys(c *conf) (keys []string) {
+	keys = make([]string, 0)
+	for key := range schema {
+		if _, ok := c.Map[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sampleDefaults sets non-empty sample values as the default for config keys,
+// defaults declared in the schema take precedence
+func (schema Schema) sampleDefaults(c *conf, sample *conf) {
+	for _, key := range c.Keys {
+		ks := schema[key]
+		if ks.Default == "" && sample.Map[key] != "" {
+			ks.Default = sample.Map[key]
+			schema[key] = ks
+		}
+	}
+}
+
+// parseType returns an error if value does not parse as type t,
+// the same way as the generated getter. Empty values are not parsed
+func parseType(t, value string) (err error) {
+	if value == "" {
+		return nil
+	}
+	switch t {
+	case TypeInt:
+		_, err = strconv.Atoi(value)
+	case TypeBool:
+		_, err = strconv.ParseBool(value)
+	case TypeFloat:
+		_, err = strconv.ParseFloat(value, 64)
+	case TypeDuration:
+		_, err = time.ParseDuration(value)
+	case TypeURL:
+		_, err = url.ParseRequestURI(value)
+	}
+	return errors.WithStack(err)
+}
+
+// inferType returns the most specific type value parses as,
+// e.g. "8080" is an int, and "https://example.com" a url.
+// Bools must be spelled out, since "1" is an int
+func inferType(value string) string {
+	if value == "" {
+		return ""
+	}
+	if _, err := strconv.Atoi(value); err == nil {
+		return TypeInt
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return TypeFloat
+	}
+	if b := strings.ToLower(value); b == "true" || b == "false" {
+		return TypeBool
+	}
+	if _, err := time.ParseDuration(value); err == nil {
+		return TypeDuration
+	}
+	if u, err := url.ParseRequestURI(value); err == nil && u.Host != "" {
+		return TypeURL
+	}
+	return TypeString
+}
+
+// parseExpr returns the expression to parse the field of the generated Config
+func (t goType) parseExpr(keyPrivate string) string {
+	return fmt.Sprintf(t.Parse, fmt.Sprintf("c.%s", keyPrivate))
+}
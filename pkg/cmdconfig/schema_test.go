@@ -0,0 +1,116 @@
+package cmdconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestReadSchema(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	// Schema is optional
+	schema, err := readSchema(newDirCache(), tmp)
+	is.NoErr(err)
+	is.Equal(0, len(schema))
+
+	schemaPath := filepath.Join(tmp, FileNameTypes)
+	err = os.WriteFile(schemaPath, []byte(`{
+		"APP_PORT": "int",
+		"APP_DEBUG": {"type": "bool"},
+		"APP_NAME": {}
+	}`), perms)
+	is.NoErr(err)
+	schema, err = readSchema(newDirCache(), tmp)
+	is.NoErr(err)
+	is.Equal(TypeInt, schema["APP_PORT"].Type)
+	is.Equal(TypeBool, schema["APP_DEBUG"].Type)
+	is.Equal(TypeString, schema["APP_NAME"].Type)
+
+	c := &conf{Map: map[string]string{"APP_PORT": "8080"}}
+	is.Equal([]string{"APP_DEBUG", "APP_NAME"}, schema.missingKeys(c))
+
+	is.Equal("strconv.Atoi(c.port)", goTypes[TypeInt].parseExpr("port"))
+
+	// The types file is not a config file
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"),
+		[]byte(`{"APP_PORT": "8080"}`), perms)
+	is.NoErr(err)
+	envs, err := getEnvs(tmp, false)
+	is.NoErr(err)
+	is.Equal(1, len(envs))
+
+	// Invalid type
+	err = os.WriteFile(schemaPath, []byte(`{"APP_PORT": "uint"}`), perms)
+	is.NoErr(err)
+	_, err = readSchema(newDirCache(), tmp)
+	is.True(err != nil)
+}
+
+func TestReadSchemaDeprecated(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	schemaPath := filepath.Join(tmp, FileNameTypes)
+	err = os.WriteFile(schemaPath, []byte(`{
+		"APP_OLD_PORT": {"type": "int", "replacedBy": "APP_PORT"},
+		"APP_PORT": "int",
+		"APP_LEGACY": {"deprecated": true}
+	}`), perms)
+	is.NoErr(err)
+	schema, err := readSchema(newDirCache(), tmp)
+	is.NoErr(err)
+	// Replacement implies deprecated
+	is.True(schema["APP_OLD_PORT"].Deprecated)
+	is.True(schema["APP_LEGACY"].Deprecated)
+	is.True(!schema["APP_PORT"].Deprecated)
+
+	for _, s := range []string{
+		// Types must match
+		`{"APP_OLD_PORT": {"type": "int", "replacedBy": "APP_PORT"}}`,
+		// Replacement is deprecated
+		`{"APP_A": {"replacedBy": "APP_B"}, "APP_B": {"replacedBy": "APP_C"}}`,
+		`{"APP_A": {"replacedBy": "APP_A"}}`,
+	} {
+		err = os.WriteFile(schemaPath, []byte(s), perms)
+		is.NoErr(err)
+		_, err = readSchema(newDirCache(), tmp)
+		is.True(err != nil)
+	}
+}
+
+func TestInferType(t *testing.T) {
+	is := testutil.Setup(t)
+
+	for value, expected := range map[string]string{
+		"":                    "",
+		"8080":                TypeInt,
+		"0.5":                 TypeFloat,
+		"true":                TypeBool,
+		"FALSE":               TypeBool,
+		"5s":                  TypeDuration,
+		"https://example.com": TypeURL,
+		"/var/run":            TypeString,
+		"localhost":           TypeString,
+	} {
+		is.Equal(expected, inferType(value))
+	}
+
+	is.NoErr(parseType(TypeInt, ""))
+	is.NoErr(parseType(TypeInt, "1"))
+	is.True(parseType(TypeInt, "x") != nil)
+	is.NoErr(parseType(TypeString, "x"))
+}
@@ -0,0 +1,127 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SessionEnvKey may be set to identify the shell session,
+// by default the parent process ID is used,
+// i.e. the shell that evaluates the set env commands
+const SessionEnvKey = "CONFIGU_SESSION"
+
+// session state, used with the safe flag,
+// and for overrides that are never written to config files
+type session struct {
+	// Keys exported by configu in this session
+	Keys []string `json:"keys"`
+	// Overrides by env, e.g. temporary credentials, see awsLogin
+	Overrides map[string]sessionOverride `json:"overrides,omitempty"`
+}
+
+// sessionOverride values are exported instead of config values until expired
+type sessionOverride struct {
+	Values  map[string]string `json:"values"`
+	Expires time.Time         `json:"expires"`
+}
+
+// unsafeFileName matches characters not allowed in the session file name
+var unsafeFileName = regexp.MustCompile(`[^\w\-.]`)
+
+// sessionPath returns the path to the session state file
+func sessionPath() (string, error) {
+	id := os.Getenv(SessionEnvKey)
+	if id == "" {
+		id = strconv.Itoa(os.Getppid())
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return filepath.Join(cacheDir, "configu", "sessions",
+		fmt.Sprintf("%s.json", unsafeFileName.ReplaceAllString(id, "_"))), nil
+}
+
+// readSession returns the session state,
+// the state is empty if the file does not exist
+func readSession(path string) (s *session, err error) {
+	s = &session{Keys: make([]string, 0)}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return s, errors.WithStack(err)
+	}
+	err = json.Unmarshal(b, s)
+	if err != nil {
+		return s, errors.WithStack(err)
+	}
+	return s, nil
+}
+
+// exported returns the set of keys exported in this session
+func (s *session) exported() map[string]bool {
+	keys := make(map[string]bool)
+	for _, key := range s.Keys {
+		keys[key] = true
+	}
+	return keys
+}
+
+// override returns the override values for env,
+// expired is set if the override expired before t
+func (s *session) override(env string, t time.Time) (
+	values map[string]string, expired bool) {
+
+	o, ok := s.Overrides[env]
+	if !ok {
+		return nil, false
+	}
+	if !o.Expires.IsZero() && !t.Before(o.Expires) {
+		return nil, true
+	}
+	return o.Values, false
+}
+
+// overridden returns the override keys, for any env, that are still set
+// to the override value, i.e. the value was most likely exported by configu
+func (s *session) overridden(
+	lookup func(string) (string, bool)) map[string]bool {
+
+	keys := make(map[string]bool)
+	for _, o := range s.Overrides {
+		for key, value := range o.Values {
+			if v, ok := lookup(key); ok && v == value {
+				keys[key] = true
+			}
+		}
+	}
+	return keys
+}
+
+// setOverride for env, replacing the previous override
+func (s *session) setOverride(env string, o sessionOverride) {
+	if s.Overrides == nil {
+		s.Overrides = make(map[string]sessionOverride)
+	}
+	s.Overrides[env] = o
+}
+
+// file to save the session state,
+// it's only readable by the user since overrides may contain credentials
+func (s *session) file(path string) (file File, err error) {
+	b, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		return file, errors.WithStack(err)
+	}
+	return File{Path: path, Buf: bytes.NewBuffer(b), Perm: 0600}, nil
+}
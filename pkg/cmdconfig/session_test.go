@@ -0,0 +1,103 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestSetEnvSafe(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	// Session state is kept in the user cache dir
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(tmp, "cache"))
+	t.Setenv("HOME", tmp)
+	t.Setenv(SessionEnvKey, t.Name())
+
+	env := share.EnvDev
+	configPath := filepath.Join(tmp, fmt.Sprintf("config.%v.json", env))
+	err = os.WriteFile(configPath,
+		[]byte(`{"APP_BAR": "bar", "APP_BUZ": "buz"}`), perms)
+	is.NoErr(err)
+
+	// Set manually by the user
+	t.Setenv("APP_FOO", "foo")
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = env
+	in.Safe = true
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.True(strings.Contains(out.Buf.String(), "export APP_BAR=bar"))
+	is.True(!strings.Contains(out.Buf.String(), "unset APP_FOO"))
+	is.Equal(1, len(out.Files)) // Session state
+	err = out.Files.Save(new(bytes.Buffer))
+	is.NoErr(err)
+
+	// APP_BUZ removed from the config file after it was exported
+	t.Setenv("APP_BAR", "bar")
+	t.Setenv("APP_BUZ", "buz")
+	err = os.WriteFile(configPath, []byte(`{"APP_BAR": "bar"}`), perms)
+	is.NoErr(err)
+
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.True(strings.Contains(out.Buf.String(), "unset APP_BUZ"))
+	is.True(!strings.Contains(out.Buf.String(), "unset APP_FOO"))
+
+	// Without the safe flag all prefixed vars are unset
+	in.Safe = false
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.True(strings.Contains(out.Buf.String(), "unset APP_FOO"))
+	is.Equal(0, len(out.Files))
+}
+
+func TestSetEnvNoCacheDir(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	// The user cache dir can't be determined
+	t.Setenv("XDG_CACHE_HOME", "")
+	t.Setenv("HOME", "")
+
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"),
+		[]byte(`{"APP_BAR": "bar"}`), perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+
+	// No overrides
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.True(strings.Contains(out.Buf.String(), "export APP_BAR=bar"))
+	is.Equal(0, len(out.Files))
+
+	// Session state is required with the safe flag
+	in.Safe = true
+	_, err = Cmd(in)
+	is.True(err != nil)
+}
@@ -0,0 +1,113 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// ActiveEnvKey is exported by the shell function after toggling env,
+// e.g. to show the active env in the prompt
+const ActiveEnvKey = "CONFIGU_ENV"
+
+// Shells supported by the shell flag
+const (
+	ShellBash       = "bash"
+	ShellZsh        = "zsh"
+	ShellPowerShell = "powershell"
+)
+
+// templatePosixInit defines the conf func for bash and zsh.
+// The output of configu is only evaluated if the exit code is zero,
+// otherwise partial output could leave the env in an unknown state
+var templatePosixInit = `# Shell function to toggle env with configu, add to ~/.{{.Shell}}rc
+#   eval "$(configu -shell {{.Shell}})"
+# Usage: conf [env] [flags...]
+conf() {
+    local conf_env="dev"
+    if [ $# -gt 0 ] && [ "${1#-}" = "${1}" ]; then
+        conf_env="${1}"
+        shift
+    fi
+    # Declare before assignment, otherwise the exit code is lost
+    local conf_output
+    if ! conf_output="$({{.Prefix}}DIR="$(pwd)" command configu -prefix {{.Prefix}} -os posix -env "${conf_env}" "$@")"; then
+        echo "configu failed, env not changed" >&2
+        return 1
+    fi
+    eval "${conf_output}"
+    export {{.Prefix}}DIR="$(pwd)"
+    export {{.ActiveEnvKey}}="${conf_env}"
+    echo "Active env ${conf_env}"
+}
+`
+
+// templatePowerShellInit defines the conf func for PowerShell
+var templatePowerShellInit = `# PowerShell function to toggle env with configu, add to $PROFILE
+#   configu -shell powershell | Out-String | Invoke-Expression
+# Usage: conf [env] [flags...]
+function conf {
+    $confEnv = "dev"
+    $confArgs = @($args)
+    if ($confArgs.Count -gt 0 -and -not "$($confArgs[0])".StartsWith("-")) {
+        $confEnv = $confArgs[0]
+        $confArgs = @($confArgs | Select-Object -Skip 1)
+    }
+    $appDir = $env:{{.Prefix}}DIR
+    $env:{{.Prefix}}DIR = (Get-Location).Path
+    $confOutput = & configu -prefix {{.Prefix}} -os powershell -env $confEnv @confArgs | Out-String
+    if ($LASTEXITCODE -ne 0) {
+        $env:{{.Prefix}}DIR = $appDir
+        Write-Error "configu failed, env not changed"
+        return
+    }
+    Invoke-Expression $confOutput
+    $env:{{.ActiveEnvKey}} = $confEnv
+    Write-Host "Active env $confEnv"
+}
+`
+
+// shellTemplates maps shells to the init template
+var shellTemplates = map[string]string{
+	ShellBash:       templatePosixInit,
+	ShellZsh:        templatePosixInit,
+	ShellPowerShell: templatePowerShellInit,
+}
+
+// Shells returns the sorted list of shells supported by the shell flag
+func Shells() []string {
+	shells := make([]string, 0, len(shellTemplates))
+	for shell := range shellTemplates {
+		shells = append(shells, shell)
+	}
+	sort.Strings(shells)
+	return shells
+}
+
+// shellInit prints the conf func for the given shell
+func shellInit(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
+	buf = new(bytes.Buffer)
+
+	s, ok := shellTemplates[in.Shell]
+	if !ok {
+		return buf, files, errors.Errorf("invalid shell %s, expected one of %s",
+			in.Shell, strings.Join(Shells(), ", "))
+	}
+	t, err := template.New(in.Shell).Parse(s)
+	if err != nil {
+		return buf, files, errors.WithStack(err)
+	}
+	err = t.Execute(buf, map[string]string{
+		"Shell":        in.Shell,
+		"Prefix":       in.Prefix,
+		"ActiveEnvKey": ActiveEnvKey,
+	})
+	if err != nil {
+		return buf, files, errors.WithStack(err)
+	}
+
+	return buf, files, nil
+}
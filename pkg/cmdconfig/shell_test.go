@@ -0,0 +1,73 @@
+package cmdconfig
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestShellInit(t *testing.T) {
+	is := testutil.Setup(t)
+
+	in := &CmdIn{}
+	in.Prefix = "APP_"
+	in.Shell = "fish"
+	is.NoErr(in.Valid()) // App dir is not required
+	_, err := Cmd(in)
+	is.True(err != nil)
+
+	in.Shell = ShellPowerShell
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdShell, out.Cmd)
+	is.True(strings.Contains(out.Buf.String(), "function conf"))
+	is.True(strings.Contains(out.Buf.String(), "$LASTEXITCODE"))
+
+	in.Shell = ShellBash
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.True(strings.Contains(out.Buf.String(), "conf()"))
+
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not found")
+	}
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	// Stub configu, partial output is printed for the fail env
+	err = os.WriteFile(filepath.Join(tmp, "configu"), []byte(`#!/bin/sh
+case "$*" in
+    *"-env fail"*) echo "export APP_FOO=partial"; exit 1 ;;
+    *) echo "export APP_FOO=$APP_DIR" ;;
+esac
+`), 0755)
+	is.NoErr(err)
+
+	cmd := exec.Command(bash, "--norc", "-c", fmt.Sprintf(`
+eval "$(cat)"
+export APP_FOO=old
+conf fail 2>/dev/null
+echo "${APP_FOO}"
+conf prod >/dev/null
+echo "${APP_FOO} ${%s}"
+`, ActiveEnvKey))
+	cmd.Dir = tmp
+	cmd.Stdin = out.Buf
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("PATH=%s%c%s", tmp, os.PathListSeparator, os.Getenv("PATH")))
+	b, err := cmd.CombinedOutput()
+	is.NoErr(err)
+	tmp, err = filepath.EvalSymlinks(tmp)
+	is.NoErr(err)
+	is.Equal(fmt.Sprintf("old\n%s prod\n", tmp), string(b))
+}
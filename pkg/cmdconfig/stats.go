@@ -0,0 +1,189 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// statsLargest is the number of largest values listed per env
+const statsLargest = 3
+
+// keySize for listing the largest values
+type keySize struct {
+	Key  string
+	Size int
+}
+
+// envStats summarizes the config for an env
+type envStats struct {
+	Env string
+	// Keys is the number of keys
+	Keys int
+	// Bytes is the size of the env, i.e. the sum of "KEY=value" lengths
+	Bytes int
+	// Largest values, in descending order of size
+	Largest []keySize
+	// Duplicates lists keys sharing the same value
+	Duplicates [][]string
+	// Groups maps key prefix groups to the number of keys,
+	// e.g. APP_DB_HOST and APP_DB_NAME are in the APP_DB group
+	Groups map[string]int
+	// Collisions with well-known env vars, see keyCollisions
+	Collisions []string
+}
+
+// keyGroup returns the prefix group for the key,
+// i.e. the prefix followed by the first word of the key
+func keyGroup(prefix, key string) string {
+	word, _, _ := strings.Cut(strings.TrimPrefix(key, prefix), "_")
+	return fmt.Sprintf("%s%s", prefix, word)
+}
+
+// duplicateMinLen is the minimum length of values checked for duplicates
+const duplicateMinLen = 4
+
+// trivialValue returns true for values that are expected to be repeated,
+// e.g. empty strings, short values, and bools
+func trivialValue(value string) bool {
+	value = strings.TrimSpace(value)
+	if len(value) < duplicateMinLen {
+		return true
+	}
+	switch strings.ToLower(value) {
+	case "true", "false", "null", "none":
+		return true
+	}
+	return false
+}
+
+// duplicateValues returns lists of distinct keys sharing the same value.
+// That is often a sign that one key should reference the other,
+// e.g. with a template key. Trivial values are ignored, see trivialValue,
+// and values in the ignore list are legitimately repeated
+func duplicateValues(c *conf, ignore ArgMap) (duplicates [][]string) {
+	ignored := make(map[string]bool)
+	for _, value := range ignore {
+		ignored[value] = true
+	}
+
+	duplicates = make([][]string, 0)
+	byValue := make(map[string][]string)
+	// Assuming c.Keys is already sorted
+	for _, key := range c.Keys {
+		value := c.Map[key]
+		if trivialValue(value) || ignored[value] {
+			continue
+		}
+		byValue[value] = append(byValue[value], key)
+	}
+	for _, keys := range byValue {
+		if len(keys) > 1 {
+			duplicates = append(duplicates, keys)
+		}
+	}
+	sort.Slice(duplicates, func(i, j int) bool {
+		return duplicates[i][0] < duplicates[j][0]
+	})
+	return duplicates
+}
+
+func newEnvStats(prefix string, others []string,
+	env string, c *conf, ignore ArgMap) *envStats {
+
+	stats := &envStats{
+		Env:     env,
+		Keys:    len(c.Keys),
+		Largest: make([]keySize, 0, len(c.Keys)),
+		Groups:  make(map[string]int),
+	}
+	for _, key := range c.Keys {
+		value := c.Map[key]
+		stats.Bytes += len(key) + len("=") + len(value)
+		stats.Largest = append(stats.Largest, keySize{Key: key, Size: len(value)})
+		stats.Groups[keyGroup(prefix, key)]++
+	}
+	sort.SliceStable(stats.Largest, func(i, j int) bool {
+		return stats.Largest[i].Size > stats.Largest[j].Size
+	})
+	if len(stats.Largest) > statsLargest {
+		stats.Largest = stats.Largest[:statsLargest]
+	}
+	stats.Duplicates = duplicateValues(c, ignore)
+	stats.Collisions = keyCollisions(prefix, others, c)
+	return stats
+}
+
+// write the stats as text to buf
+func (stats *envStats) write(buf *bytes.Buffer) {
+	buf.WriteString(fmt.Sprintf("env: %s\n", stats.Env))
+	buf.WriteString(fmt.Sprintf("keys: %d\n", stats.Keys))
+	buf.WriteString(fmt.Sprintf("bytes: %d\n", stats.Bytes))
+
+	buf.WriteString("largest values:\n")
+	for _, largest := range stats.Largest {
+		buf.WriteString(fmt.Sprintf("  %s %d\n", largest.Key, largest.Size))
+	}
+
+	buf.WriteString("duplicate values:\n")
+	for _, keys := range stats.Duplicates {
+		buf.WriteString(fmt.Sprintf("  %s\n", strings.Join(keys, ", ")))
+	}
+
+	buf.WriteString("groups:\n")
+	groups := make([]string, 0, len(stats.Groups))
+	for group := range stats.Groups {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	for _, group := range groups {
+		buf.WriteString(fmt.Sprintf("  %s %d\n", group, stats.Groups[group]))
+	}
+
+	buf.WriteString("collisions:\n")
+	for _, collision := range stats.Collisions {
+		buf.WriteString(fmt.Sprintf("  %s\n", collision))
+	}
+}
+
+// printStats summarizes config for the envs as per the all and env flags
+func printStats(in *CmdIn) (buf *bytes.Buffer, files []File, err error) {
+	buf = new(bytes.Buffer)
+
+	envs, err := updateEnvs(in)
+	if err != nil {
+		return buf, files, err
+	}
+
+	stats := make([]*envStats, len(envs))
+	err = eachEnv(envs, func(i int, env string) error {
+		_, config, err := newConf(confParams{
+			dirs:   in.dirs,
+			prefix: in.Prefix,
+			appDir: in.AppDir,
+			env:    env,
+			extend: in.Extend,
+			merge:  in.Merge,
+			parent: in.Parent,
+		})
+		if err != nil {
+			return err
+		}
+		stats[i] = newEnvStats(
+			in.Prefix, in.otherPrefixes(), env, config, in.IgnoreValues)
+		return nil
+	})
+	if err != nil {
+		return buf, files, err
+	}
+
+	for i, s := range stats {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		s.write(buf)
+	}
+
+	return buf, files, nil
+}
@@ -0,0 +1,92 @@
+package cmdconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestStats(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	env := share.EnvDev
+
+	err = os.WriteFile(
+		filepath.Join(tmp, fmt.Sprintf("config.%v.json", env)),
+		[]byte(`{
+			"APP_DB_HOST": "localhost",
+			"APP_DB_NAME": "app",
+			"APP_API_HOST": "localhost",
+			"APP_EMPTY": "",
+			"APP_CERT": "xxxxxxxxxxxxxxxxxxxx"
+		}`),
+		perms)
+	is.NoErr(err)
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = env
+	in.Stats = true
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdStats, out.Cmd)
+	is.Equal(0, out.ExitCode)
+	is.Equal(`env: dev
+keys: 5
+bytes: 97
+largest values:
+  APP_CERT 20
+  APP_API_HOST 9
+  APP_DB_HOST 9
+duplicate values:
+  APP_API_HOST, APP_DB_HOST
+groups:
+  APP_API 1
+  APP_CERT 1
+  APP_DB 2
+  APP_EMPTY 1
+collisions:
+`, out.Buf.String())
+}
+
+func TestDuplicateValues(t *testing.T) {
+	is := testutil.Setup(t)
+
+	c := &conf{}
+	c.Map = map[string]string{
+		"APP_API_HOST": "api.example.com",
+		"APP_CDN_HOST": "api.example.com",
+		"APP_DB_HOST":  "localhost",
+		"APP_SMTP":     "localhost",
+		"APP_DEBUG":    "true",
+		"APP_VERBOSE":  "true",
+		"APP_PORT":     "80",
+		"APP_WEB_PORT": "80",
+		"APP_EMPTY":    "",
+		"APP_BLANK":    "",
+	}
+	c.refreshKeys()
+
+	// Trivial values are ignored
+	is.Equal([][]string{
+		{"APP_API_HOST", "APP_CDN_HOST"},
+		{"APP_DB_HOST", "APP_SMTP"},
+	}, duplicateValues(c, ArgMap{}))
+
+	// Legitimately repeated values
+	is.Equal([][]string{
+		{"APP_API_HOST", "APP_CDN_HOST"},
+	}, duplicateValues(c, ArgMap{"localhost"}))
+}
@@ -0,0 +1,109 @@
+package cmdconfig
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxSuggestions limits the number of suggestions for a mistyped name
+const maxSuggestions = 3
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// suggest returns the candidates closest to name, nearest first.
+// Candidates that differ in more than a third of the characters are ignored,
+// comparison is case insensitive since keys are usually upper case
+func suggest(name string, candidates []string) []string {
+	type match struct {
+		candidate string
+		distance  int
+	}
+	maxDistance := max(1, len(name)/3)
+	matches := make([]match, 0)
+	seen := make(map[string]bool)
+	for _, c := range candidates {
+		if c == name || seen[c] {
+			continue
+		}
+		seen[c] = true
+		d := levenshtein(strings.ToLower(name), strings.ToLower(c))
+		if d <= maxDistance {
+			matches = append(matches, match{candidate: c, distance: d})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].distance != matches[j].distance {
+			return matches[i].distance < matches[j].distance
+		}
+		return matches[i].candidate < matches[j].candidate
+	})
+	suggestions := make([]string, 0, maxSuggestions)
+	for i := 0; i < len(matches) && i < maxSuggestions; i++ {
+		suggestions = append(suggestions, matches[i].candidate)
+	}
+	return suggestions
+}
+
+// didYouMean formats suggestions to append to a message,
+// the result is empty if there are no suggestions
+func didYouMean(suggestions []string) string {
+	if len(suggestions) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %s?", strings.Join(suggestions, " or "))
+}
+
+// suggestError appends suggestions to the message of err,
+// errors.Is matches the wrapped error
+type suggestError struct {
+	err         error
+	suggestions []string
+}
+
+func (e suggestError) Error() string {
+	return e.err.Error() + didYouMean(e.suggestions)
+}
+
+func (e suggestError) Unwrap() error {
+	return e.err
+}
+
+// Format prints the stack trace of the wrapped error for %+v
+func (e suggestError) Format(s fmt.State, verb rune) {
+	if verb == 'v' && s.Flag('+') {
+		fmt.Fprintf(s, "%+v%s", e.err, didYouMean(e.suggestions))
+		return
+	}
+	fmt.Fprint(s, e.Error())
+}
+
+// withSuggestions returns err with the closest candidates to name,
+// err is returned as is if there are no suggestions
+func withSuggestions(err error, name string, candidates []string) error {
+	suggestions := suggest(name, candidates)
+	if len(suggestions) == 0 {
+		return err
+	}
+	return suggestError{err: err, suggestions: suggestions}
+}
@@ -0,0 +1,48 @@
+package cmdconfig
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mozey/config/pkg/testutil"
+	"github.com/pkg/errors"
+)
+
+func TestLevenshtein(t *testing.T) {
+	is := testutil.Setup(t)
+
+	is.Equal(0, levenshtein("", ""))
+	is.Equal(3, levenshtein("", "abc"))
+	is.Equal(0, levenshtein("APP_FOO", "APP_FOO"))
+	is.Equal(1, levenshtein("APP_FOO", "APP_FO"))
+	is.Equal(1, levenshtein("APP_FOO", "APP_BOO"))
+	is.Equal(3, levenshtein("kitten", "sitting"))
+}
+
+func TestSuggest(t *testing.T) {
+	is := testutil.Setup(t)
+
+	keys := []string{"APP_BAR", "APP_BAZ", "APP_FOO", "APP_PORT"}
+	is.Equal([]string{"APP_FOO"}, suggest("APP_FO", keys))
+	is.Equal([]string{"APP_BAR", "APP_BAZ"}, suggest("APP_BAQ", keys))
+	is.Equal([]string{"APP_PORT"}, suggest("app_port", keys))
+	is.Equal(0, len(suggest("APP_FOO", keys)))
+	is.Equal(0, len(suggest("APP_SOMETHING", keys)))
+
+	envs := []string{"dev", "prod", "stage"}
+	is.Equal([]string{"prod"}, suggest("prd", envs))
+	is.Equal(0, len(suggest("test", envs)))
+}
+
+func TestWithSuggestions(t *testing.T) {
+	is := testutil.Setup(t)
+
+	envs := []string{"dev", "prod"}
+	err := withSuggestions(ErrInvalidEnv("prd"), "prd", envs)
+	is.Equal("env prd not listed in manifest, did you mean prod?", err.Error())
+	is.True(errors.Is(err, ErrInvalidEnv("")))
+
+	err = withSuggestions(errors.New("missing"), "x", envs)
+	is.Equal("missing", err.Error())
+	is.Equal("missing", fmt.Sprintf("%v", err))
+}
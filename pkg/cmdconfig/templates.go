@@ -16,6 +16,43 @@ const FileNameTemplateGo = "template.go"
 // FileNameFnGo for fn.go
 const FileNameFnGo = "fn.go"
 
+// FileNameSafeGo for safe.go
+const FileNameSafeGo = "safe.go"
+
+// FileNameConfigerGo for configer.go
+const FileNameConfigerGo = "configer.go"
+
+// DirConfigTest is the package generated inside the config package,
+// with helpers for tests that use the config
+const DirConfigTest = "configtest"
+
+// FileNameConfigTestGo for configtest/configtest.go
+const FileNameConfigTestGo = "configtest.go"
+
+// FileNameFlagsGo for flags.go
+const FileNameFlagsGo = "flags.go"
+
+// FileNameEnvconfigGo for envconfig.go
+const FileNameEnvconfigGo = "envconfig.go"
+
+// FileNameWatchGo for watch.go
+const FileNameWatchGo = "watch.go"
+
+// FileNameConfigDTs for config.d.ts, TypeScript definitions for the keys
+const FileNameConfigDTs = "config.d.ts"
+
+// FileNameLoaderTs for loader.ts, it's not named config.ts,
+// since TypeScript ignores config.d.ts if config.ts exists
+const FileNameLoaderTs = "loader.ts"
+
+// FileNameGenerated lists the files written by the generate command,
+// it's used to detect orphaned files when the generated files change.
+// The manifest in APP_DIR lists the generate target dirs
+const FileNameGenerated = ".configu.generated"
+
+// GeneratedHeader is the first line of generated files
+const GeneratedHeader = "// Code generated with https://github.com/mozey/config DO NOT EDIT"
+
 // GetTemplate returns the text template for the given file name.
 func GetTemplate(fileName string) (s string, err error) {
 	if fileName == FileNameConfigGo {
@@ -30,16 +67,56 @@ func GetTemplate(fileName string) (s string, err error) {
 		return templateFnGo, nil
 	}
 
+	if fileName == FileNameSafeGo {
+		return templateSafeGo, nil
+	}
+
+	if fileName == FileNameConfigerGo {
+		return templateConfigerGo, nil
+	}
+
+	if fileName == FileNameConfigTestGo {
+		return templateConfigTestGo, nil
+	}
+
+	if fileName == FileNameFlagsGo {
+		return templateFlagsGo, nil
+	}
+
+	if fileName == FileNameEnvconfigGo {
+		return templateEnvconfigGo, nil
+	}
+
+	if fileName == FileNameWatchGo {
+		return templateWatchGo, nil
+	}
+
+	if fileName == FileNameConfigDTs {
+		return templateConfigDTs, nil
+	}
+
+	if fileName == FileNameLoaderTs {
+		return templateLoaderTs, nil
+	}
+
 	return s, errors.Errorf("invalid file name %s", fileName)
 }
 
 // .............................................................................
 // Template strings
 
+// templateDeprecatedGetter prints a warning in the getter of deprecated keys,
+// and returns the value of the replacement if it's set
+var templateDeprecatedGetter = `{{define "deprecatedGetter"}}{{if .Deprecated}}
+	share.WarnDeprecated("{{.KeyPrefix}}", "{{.ReplacedByPrefix}}"){{if .ReplacedBy}}
+	if c.{{.ReplacedByPrivate}} != "" {
+		return c.{{.ReplacedBy}}()
+	}{{end}}{{end}}{{end}}`
+
 // templateConfigGo text template to generate FileNameConfigGo.
 // NOTE the "standard header" for recognizing machine-generated files
 // https://github.com/golang/go/issues/13560#issuecomment-276866852
-var templateConfigGo = `
+var templateConfigGo = templateDeprecatedGetter + `
 // Code generated with https://github.com/mozey/config DO NOT EDIT
 
 package config
@@ -47,19 +124,34 @@ package config
 import (
 	"encoding/base64"
 	"encoding/json"
-	"os"
+	"fmt"
+	"os"{{range .Imports}}
+	"{{.}}"{{end}}
 
 	"github.com/mozey/config/pkg/share"
 	"github.com/pkg/errors"
 )
 
-// KeyPrefix is not made publicly available on this package,
+{{if not .NoVars}}// Values are not made publicly available on this package,
 // users must use the getter or setter methods.
 // This package must not change the config file
 
 {{range .Keys}}
 // {{.KeyPrefix}}
 var {{.KeyPrivate}} string{{end}}
+{{end}}
+// Key names, for code that must reference the env vars directly,
+// e.g. setting the env for exec.Command
+const ({{range .Keys}}
+	Key{{.Key}} = "{{.KeyPrefix}}"{{end}}
+)
+
+// keysHash of the config file keys when this package was generated
+const keysHash = "{{.KeysHash}}"
+
+// CheckStale if set, New prints a warning to stderr if the keys in the env
+// don't match the config file keys when this package was generated
+var CheckStale = false
 
 // Config fields correspond to config file keys less the prefix
 type Config struct {
@@ -68,11 +160,29 @@ type Config struct {
 }
 
 {{range .Keys}}
-// {{.Key}} is {{.KeyPrefix}}
+// {{.Key}} is {{.KeyPrefix}}{{if .Locked}}, it's locked and may not be set by env{{end}}{{if .Deprecated}}
+//
+// Deprecated: {{if .ReplacedByPrefix}}use {{.ReplacedByPrefix}} instead{{else}}{{.KeyPrefix}} will be removed{{end}}{{end}}{{if .GoType}}
+func (c *Config) {{.Key}}() {{.GoType}} {
+	{{- template "deprecatedGetter" .}}
+	// Invalid values are rejected by Validate
+	v, _ := {{.Parse}}
+	return v
+}{{else}}
 func (c *Config) {{.Key}}() string {
+	{{- template "deprecatedGetter" .}}
 	return c.{{.KeyPrivate}}
+}{{end}}{{end}}
+{{if .Must}}
+{{range .Keys}}
+// Must{{.Key}} is the same as {{.Key}}, but panics if {{.KeyPrefix}} is empty
+func (c *Config) Must{{.Key}}() {{if .GoType}}{{.GoType}}{{else}}string{{end}} {
+	if c.{{.KeyPrivate}} == ""{{if .ReplacedBy}} && c.{{.ReplacedByPrivate}} == ""{{end}} {
+		panic("{{.KeyPrefix}} must not be empty")
+	}
+	return c.{{.Key}}()
 }{{end}}
-
+{{end}}
 {{range .Keys}}
 // Set{{.Key}} overrides the value of {{.KeyPrivate}}
 func (c *Config) Set{{.Key}}(v string) {
@@ -80,19 +190,189 @@ func (c *Config) Set{{.Key}}(v string) {
 }
 {{end}}
 
+// Precedence of env and package vars, see EnvPrecedence
+const (
+	// PrecedenceEnv env overrides package vars
+	PrecedenceEnv = "env"
+	// PrecedenceVars env is ignored, except for keys in EnvAllowlist,
+	// e.g. for hardened deployments where ambient env must not change config
+	PrecedenceVars = "vars"
+)
+
+// EnvPrecedence is set with the precedence flag when generating this package
+var EnvPrecedence = "{{.Precedence}}"
+
+// EnvAllowlist keys may be set by env if EnvPrecedence is PrecedenceVars,
+// {{.Prefix}}DIR may always be set by env
+var EnvAllowlist = map[string]bool{}
+
+// Option configures New, see the With funcs
+type Option func(o *options) error
+
+// options for New
+type options struct {
+	// osEnv is false if env must not be read
+	osEnv bool
+	// configMap values take precedence over env and package vars
+	configMap map[string]string
+}
+
+// merge non-empty values from m, overriding previous options
+func (o *options) merge(m map[string]string) {
+	for key, val := range m {
+		if val != "" {
+			o.configMap[key] = val
+		}
+	}
+}
+
+// WithMap values take precedence over env and package vars.
+// Options are applied in order, later options override earlier ones
+func WithMap(m map[string]string) Option {
+	return func(o *options) error {
+		o.merge(m)
+		return nil
+	}
+}
+
+// WithEnvFile reads values from the config file for env, see LoadFile.
+// Unlike LoadFile, the env is not set
+func WithEnvFile(env string) Option {
+	return func(o *options) error {
+		configMap, err := readFile(env, false)
+		if err != nil {
+			return err
+		}
+		o.merge(configMap)
+		return nil
+	}
+}
+
+// WithBase64 reads values from base64 encoded JSON, see SetEnvBase64.
+// Unlike SetEnvBase64, the env is not set
+func WithBase64(configBase64 string) Option {
+	return func(o *options) error {
+		configMap, err := decodeBase64(configBase64)
+		if err != nil {
+			return err
+		}
+		o.merge(configMap)
+		return nil
+	}
+}
+
+// WithoutOSEnv ignores env, only defaults, package vars,
+// and values from other options are used
+func WithoutOSEnv() Option {
+	return func(o *options) error {
+		o.osEnv = false
+		return nil
+	}
+}
+
 // New creates an instance of Config.
-// Build with ldflags to set the package vars.
-// Env overrides package vars.
+{{if not .NoVars}}// Build with ldflags to set the package vars.
+// Env overrides package vars, see EnvPrecedence.
+{{end}}// Values from options override env, see Option.
 // Fields correspond to the config file keys less the prefix.
-// The config file must have a flat structure
-func New() *Config {
+// The config file must have a flat structure.
+// New panics if an option fails, or a typed value is invalid, see Validate
+func New(opts ...Option) *Config {
+	o := &options{osEnv: true, configMap: make(map[string]string)}
+	for _, opt := range opts {
+		err := opt(o)
+		if err != nil {
+			panic(err)
+		}
+	}
+	conf := o.newConfig(){{if .TypedKeys}}
+	err := conf.Validate()
+	if err != nil {
+		panic(err)
+	}{{end}}
+	return conf
+}
+
+{{if .Must}}
+// MustNew is the same as New, but panics if required keys are empty,
+// keys are required unless marked optional in config.types.json
+func MustNew(opts ...Option) *Config {
+	conf := New(opts...)
+	missing := make([]string, 0)
+	{{range .Keys}}{{if .Required}}
+	if conf.{{.KeyPrivate}} == "" {
+		missing = append(missing, "{{.KeyPrefix}}")
+	}{{end}}{{end}}
+	if len(missing) > 0 {
+		panic("required keys must not be empty: " + strings.Join(missing, ", "))
+	}
+	return conf
+}
+{{end}}
+// newConfig sets defaults, package vars, and env on a new Config.
+// Non-empty values in configMap take precedence, e.g. from a config file
+func newConfig(configMap map[string]string) *Config {
+	o := &options{osEnv: true, configMap: configMap}
+	return o.newConfig()
+}
+
+// newConfig applies the options on a new Config, env is read unless disabled
+func (o *options) newConfig() *Config {
+	if CheckStale {
+		checkStale()
+	}
 	conf := &Config{}
-	SetVars(conf)
-	SetEnv(conf)
+	setDefaults(conf){{if not .NoVars}}
+	SetVars(conf){{end}}
+	if o.osEnv {
+		SetEnv(conf)
+	}
+	{{range .Keys}}
+	if v := o.configMap["{{.KeyPrefix}}"]; v != "" {
+		conf.{{.KeyPrivate}} = v
+	}{{end}}
 	return conf
 }
 
-// SetVars sets non-empty package vars on Config
+// envOverride returns true if env may set the key, see EnvPrecedence
+func envOverride(key string) bool {
+	return EnvPrecedence != PrecedenceVars ||
+		EnvAllowlist[key] || key == "{{.Prefix}}DIR"
+}
+
+// checkStale prints a warning if the keys changed since generate.
+// Config compiled with ldflags doesn't depend on the env, it's not checked
+func checkStale() {
+	{{if not .NoVars}}if varsSet() {
+		return
+	}
+	{{end}}if share.KeysHash(share.EnvKeys("{{.Prefix}}")) != keysHash {
+		_, _ = fmt.Fprintln(os.Stderr,
+			"WARNING config keys changed, run configu -generate to update the config package")
+	}
+}
+
+// setDefaults sets the default values,
+// package vars and env take precedence
+func setDefaults(conf *Config) {
+	{{range .Keys}}{{if .Default}}
+	conf.{{.KeyPrivate}} = {{.Default}}{{end}}{{end}}
+}
+
+// Validate returns an error if a typed value is invalid,
+// empty values are not validated
+func (c *Config) Validate() error {
+	{{range .TypedKeys}}
+	if c.{{.KeyPrivate}} != "" {
+		if _, err := {{.Parse}}; err != nil {
+			return errors.Wrapf(err, "invalid {{.Type}} {{.KeyPrefix}}")
+		}
+	}
+	{{end}}
+	return nil
+}
+
+{{if not .NoVars}}// SetVars sets non-empty package vars on Config
 func SetVars(conf *Config) {
 	{{range .Keys}}
 	if {{.KeyPrivate}} != "" {
@@ -101,20 +381,46 @@ func SetVars(conf *Config) {
 	{{end}}
 }
 
-// SetEnv sets non-empty env vars on Config
+// varsSet returns true if any package var is set, i.e. with ldflags
+func varsSet() bool {
+	{{range .Keys}}
+	if {{.KeyPrivate}} != "" {
+		return true
+	}{{end}}
+	return false
+}
+{{end}}
+// SetEnv sets non-empty env vars on Config,
+// unless env may not override the key, see EnvPrecedence.
+// Locked keys are never set by env
 func SetEnv(conf *Config) {
 	var v string
 
-	{{range .Keys}}
+	{{range .Keys}}{{if .Locked}}
+	// {{.KeyPrefix}} is locked{{else}}
 	v = os.Getenv("{{.KeyPrefix}}")
-	if v != "" {
+	if v != "" && envOverride("{{.KeyPrefix}}") {
 		conf.{{.KeyPrivate}} = v
-	}
+	}{{end}}
 	{{end}}
 }
 
-// GetMap of all env vars
+// redacted replaces non-empty secret values
+const redacted = "****"
+
+// GetMap of all env vars, secret values are redacted,
+// use GetMapUnredacted to include secrets
 func (c *Config) GetMap() map[string]string {
+	m := c.GetMapUnredacted()
+	{{range .Keys}}{{if .Secret}}
+	if m["{{.KeyPrefix}}"] != "" {
+		m["{{.KeyPrefix}}"] = redacted
+	}{{end}}{{end}}
+	return m
+}
+
+// GetMapUnredacted of all env vars, including secret values
+func (c *Config) GetMapUnredacted() map[string]string {
 	m := make(map[string]string)
 	{{range .Keys}}
 	m["{{.KeyPrefix}}"] = c.{{.KeyPrivate}}
@@ -122,26 +428,36 @@ func (c *Config) GetMap() map[string]string {
 	return m
 }
 
-// LoadMap sets the env from a map and returns a new instance of Config
+// String returns the config as JSON with secret values redacted,
+// it's safe for logging
+func (c *Config) String() string {
+	b, err := json.Marshal(c.GetMap())
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+// LoadMap sets the env from a map and returns a new instance of Config,
+// values in the map take precedence.
+// LoadMap panics if a typed value is invalid, see Validate
 func LoadMap(configMap map[string]string) (conf *Config)  {
 	for key, val := range configMap {
 		_ = os.Setenv(key, val)
 	}
-	return New()
+	conf = newConfig(configMap){{if .TypedKeys}}
+	err := conf.Validate()
+	if err != nil {
+		panic(err)
+	}{{end}}
+	return conf
 }
 
 // SetEnvBase64 decodes and sets env from the given base64 string
 func SetEnvBase64(configBase64 string) (err error) {
-	// Decode base64
-	decoded, err := base64.StdEncoding.DecodeString(configBase64)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-	// UnMarshall json
-	configMap := make(map[string]string)
-	err = json.Unmarshal(decoded, &configMap)
+	configMap, err := decodeBase64(configBase64)
 	if err != nil {
-		return errors.WithStack(err)
+		return err
 	}
 	// Set config
 	for key, value := range configMap {
@@ -154,21 +470,132 @@ func SetEnvBase64(configBase64 string) (err error) {
 	return nil
 }
 
+// decodeBase64 decodes the config map from base64 encoded JSON
+func decodeBase64(configBase64 string) (configMap map[string]string, err error) {
+	// Decode base64
+	decoded, err := base64.StdEncoding.DecodeString(configBase64)
+	if err != nil {
+		return configMap, errors.WithStack(err)
+	}
+	// UnMarshall json
+	configMap = make(map[string]string)
+	err = json.Unmarshal(decoded, &configMap)
+	if err != nil {
+		return configMap, errors.WithStack(err)
+	}
+	return configMap, nil
+}
+
 // LoadFile sets the env from file and returns a new instance of Config
 func LoadFile(env string) (conf *Config, err error) {
+	return loadFile(env, false)
+}
+
+// LoadFileSecrets is the same as LoadFile, but also resolves secret references,
+// e.g. gcpsm://projects/x/secrets/y
+func LoadFileSecrets(env string) (conf *Config, err error) {
+	return loadFile(env, true)
+}
+
+// LoadURL sets the env from a JSON or YAML config file fetched over HTTPS,
+// and returns a new instance of Config. If the share.TokenEnvKey env var is set,
+// it's used as the bearer token
+func LoadURL(url string) (conf *Config, err error) {
+	configMap, err := share.FetchConfig(url, os.Getenv(share.TokenEnvKey))
+	if err != nil {
+		return conf, err
+	}
+	for key, val := range configMap {
+		_ = os.Setenv(key, val)
+	}
+	conf = newConfig(configMap)
+	return conf, conf.Validate()
+}
+
+// TB is the subset of testing.TB used by LoadFileT,
+// this package doesn't import testing
+type TB interface {
+	Helper()
+	Setenv(key, value string)
+	Fatal(args ...any)
+}
+
+// LoadFileT is the same as LoadFile, but the env is set with t.Setenv,
+// and restored when the test completes. The test fails if the file is invalid.
+// Like t.Setenv, it may not be used in parallel tests
+func LoadFileT(t TB, env string) *Config {
+	t.Helper()
+	configMap, err := readFile(env, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, val := range configMap {
+		t.Setenv(key, val)
+	}
+	conf := newConfig(configMap)
+	err = conf.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conf
+}
+
+// testConfigPath searches the working dir and its parents for the test config
+// file, up to APP_DIR or the module root. The path is empty if not found
+func testConfigPath() (configPath string, err error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return configPath, errors.WithStack(err)
+	}
+	return share.FindConfigFile(wd, os.Getenv("APP_DIR"), share.EnvTest)
+}
+
+func loadFile(env string, secrets bool) (conf *Config, err error) {
+	configMap, err := readFile(env, secrets)
+	if err != nil {
+		return conf, err
+	}
+	return loadConfigMap(configMap)
+}
+
+// loadConfigMap sets the env from the map,
+// and returns a new instance of Config
+func loadConfigMap(configMap map[string]string) (conf *Config, err error) {
+	for key, val := range configMap {
+		_ = os.Setenv(key, val)
+	}
+	conf = newConfig(configMap)
+	return conf, conf.Validate()
+}
+
+// readFile reads the config file for env
+func readFile(env string, secrets bool) (
+	configMap map[string]string, err error) {
+
+	if env == share.EnvTest {
+		configPath, err := testConfigPath()
+		if err != nil {
+			return configMap, err
+		}
+		if configPath == "" {
+			return configMap, errors.Errorf("test config file not found")
+		}
+		return readConfigPath(configPath, secrets)
+	}
+
 	appDir := os.Getenv("APP_DIR")
 	if appDir == "" {
 		// Use current working dir
 		appDir, err = os.Getwd()
 		if err != nil {
-			return conf, errors.WithStack(err)
+			return configMap, errors.WithStack(err)
 		}
 	}
 
 	var configPath string
 	filePaths, err := share.GetConfigFilePaths(appDir, env)
 	if err != nil {
-		return conf, err
+		return configMap, err
 	}
 	for _, configPath = range filePaths {
 		_, err := os.Stat(configPath)
@@ -177,28 +604,39 @@ func LoadFile(env string) (conf *Config, err error) {
 				// Path does not exist
 				continue
 			}
-			return conf, errors.WithStack(err)
+			return configMap, errors.WithStack(err)
 		}
 		// Path exists
 		break
 	}
 	if configPath == "" {
-		return conf, errors.Errorf("config file not found in %s", appDir)
+		return configMap, errors.Errorf("config file not found in %s", appDir)
 	}
 
+	return readConfigPath(configPath, secrets)
+}
+
+// readConfigPath reads the config file,
+// secret references are resolved if secrets is set
+func readConfigPath(configPath string, secrets bool) (
+	configMap map[string]string, err error) {
+
 	b, err := os.ReadFile(configPath)
 	if err != nil {
-		return conf, errors.WithStack(err)
+		return configMap, errors.WithStack(err)
 	}
 
-	configMap, err := share.UnmarshalConfig(configPath, b)
+	configMap, err = share.UnmarshalConfig(configPath, b)
 	if err != nil {
-		return conf, err
+		return configMap, err
 	}
-	for key, val := range configMap {
-		_ = os.Setenv(key, val)
+	if secrets {
+		_, err = share.ResolveSecrets(configMap)
+		if err != nil {
+			return configMap, err
+		}
 	}
-	return New(), nil
+	return configMap, nil
 }
 `
 
@@ -235,14 +673,20 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"strconv"
 	"strings"
 )
 
+// Fn functions can be chained, e.g.
+// c.FnFoo().Trim().Lower().Default("x").String()
 type Fn struct {
+	// key the input was read from
+	key string
+	// input is the config value
 	input string
 	// output of the last function,
-	// might be useful when chaining multiple functions?
+	// transforms and type conversions use the output
 	output string
 }
 
@@ -253,12 +697,60 @@ type Fn struct {
 // Fn{{.Key}} sets the function input to the value of {{.KeyPrefix}}
 func (c *Config) Fn{{.Key}}() *Fn {
 	fn := Fn{}
+	fn.key = "{{.KeyPrefix}}"
 	fn.input = c.{{.KeyPrivate}}
-	fn.output = ""
+	fn.output = c.{{.KeyPrivate}}
 	return &fn
 }
 {{end}}
 
+// .............................................................................
+// Transform functions
+
+// Trim removes leading and trailing white space
+func (fn *Fn) Trim() *Fn {
+	fn.output = strings.TrimSpace(fn.output)
+	return fn
+}
+
+// TrimPrefix removes the prefix if present
+func (fn *Fn) TrimPrefix(prefix string) *Fn {
+	fn.output = strings.TrimPrefix(fn.output, prefix)
+	return fn
+}
+
+// TrimSuffix removes the suffix if present
+func (fn *Fn) TrimSuffix(suffix string) *Fn {
+	fn.output = strings.TrimSuffix(fn.output, suffix)
+	return fn
+}
+
+// Lower converts to lower case
+func (fn *Fn) Lower() *Fn {
+	fn.output = strings.ToLower(fn.output)
+	return fn
+}
+
+// Upper converts to upper case
+func (fn *Fn) Upper() *Fn {
+	fn.output = strings.ToUpper(fn.output)
+	return fn
+}
+
+// Replace all instances of old with new
+func (fn *Fn) Replace(old, new string) *Fn {
+	fn.output = strings.ReplaceAll(fn.output, old, new)
+	return fn
+}
+
+// Default sets the output to fallback if it is empty
+func (fn *Fn) Default(fallback string) *Fn {
+	if fn.output == "" {
+		fn.output = fallback
+	}
+	return fn
+}
+
 // .............................................................................
 // Type conversion functions
 
@@ -266,19 +758,19 @@ func (c *Config) Fn{{.Key}}() *Fn {
 // Valid values are "1", "0", "true", or "false".
 // The value is not case-sensitive
 func (fn *Fn) Bool() (bool, error) {
-	v := strings.ToLower(fn.input)
+	v := strings.ToLower(fn.output)
 	if v == "1" || v == "true" {
 		return true, nil
 	}
 	if v == "0" || v == "false" {
 		return false, nil
 	}
-	return false, fmt.Errorf("invalid value %s", fn.input)
+	return false, fmt.Errorf("invalid value %s", fn.output)
 }
 
 // Float64 parses a float64 from the value or returns an error
 func (fn *Fn) Float64() (float64, error) {
-	f, err := strconv.ParseFloat(fn.input, 64)
+	f, err := strconv.ParseFloat(fn.output, 64)
 	if err != nil {
 		return f, err
 	}
@@ -287,15 +779,595 @@ func (fn *Fn) Float64() (float64, error) {
 
 // Int64 parses an int64 from the value or returns an error
 func (fn *Fn) Int64() (int64, error) {
-	i, err := strconv.ParseInt(fn.input, 10, 64)
+	i, err := strconv.ParseInt(fn.output, 10, 64)
 	if err != nil {
 		return i, err
 	}
 	return i, nil
 }
 
-// String returns the input as is
+// Int64s splits the output on sep and parses each element as an int64,
+// or returns an error. See Strings
+func (fn *Fn) Int64s(sep string) ([]int64, error) {
+	a := make([]int64, 0)
+	for _, s := range fn.Strings(sep) {
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return a, err
+		}
+		a = append(a, i)
+	}
+	return a, nil
+}
+
+// Port parses a TCP or UDP port number from the value or returns an error.
+// Valid ports are in the range 1 to 65535
+func (fn *Fn) Port() (int, error) {
+	p, err := strconv.Atoi(fn.output)
+	if err != nil {
+		return p, err
+	}
+	if p < 1 || p > 65535 {
+		return p, fmt.Errorf("port out of range %d", p)
+	}
+	return p, nil
+}
+
+// String returns the output, i.e. the input if no transforms were applied
 func (fn *Fn) String() string {
-	return fn.input
+	return fn.output
+}
+
+// Strings splits the output on sep, e.g. for list-valued keys.
+// Elements are trimmed, and empty elements are skipped
+func (fn *Fn) Strings(sep string) []string {
+	a := make([]string, 0)
+	for _, s := range strings.Split(fn.output, sep) {
+		s = strings.TrimSpace(s)
+		if s != "" {
+			a = append(a, s)
+		}
+	}
+	return a
+}
+
+// URL parses an absolute URL from the value or returns an error,
+// the scheme and host must not be empty
+func (fn *Fn) URL() (*url.URL, error) {
+	u, err := url.Parse(fn.output)
+	if err != nil {
+		return u, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return u, fmt.Errorf("invalid URL %s", fn.output)
+	}
+	return u, nil
+}
+
+// .............................................................................
+// Type conversion variants for different call-site styles
+
+// TryBool returns the parsed bool, ok is false if the value is not valid
+func (fn *Fn) TryBool() (v bool, ok bool) {
+	v, err := fn.Bool()
+	return v, err == nil
+}
+
+// TryFloat64 returns the parsed float64, ok is false if the value is not valid
+func (fn *Fn) TryFloat64() (v float64, ok bool) {
+	v, err := fn.Float64()
+	return v, err == nil
+}
+
+// TryInt64 returns the parsed int64, ok is false if the value is not valid
+func (fn *Fn) TryInt64() (v int64, ok bool) {
+	v, err := fn.Int64()
+	return v, err == nil
+}
+
+// MustBool returns the parsed bool or panics, e.g. for use in main()
+func (fn *Fn) MustBool() bool {
+	v, err := fn.Bool()
+	if err != nil {
+		panic(fmt.Sprintf("%s must be a bool: %s", fn.key, err))
+	}
+	return v
+}
+
+// MustFloat64 returns the parsed float64 or panics, e.g. for use in main()
+func (fn *Fn) MustFloat64() float64 {
+	v, err := fn.Float64()
+	if err != nil {
+		panic(fmt.Sprintf("%s must be a float: %s", fn.key, err))
+	}
+	return v
+}
+
+// MustInt64 returns the parsed int64 or panics, e.g. for use in main()
+func (fn *Fn) MustInt64() int64 {
+	v, err := fn.Int64()
+	if err != nil {
+		panic(fmt.Sprintf("%s must be an integer: %s", fn.key, err))
+	}
+	return v
+}
+`
+
+// templateSafeGo text template to generate FileNameSafeGo
+var templateSafeGo = `
+// Code generated with https://github.com/mozey/config DO NOT EDIT
+
+package config
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// SafeConfig is safe for concurrent use, unlike Config.
+// Getters read the current Config, and setters replace it with an updated copy,
+// so a Config returned by Load never changes
+type SafeConfig struct {
+	// mu serializes writers, readers don't block
+	mu   sync.Mutex
+	conf atomic.Pointer[Config]
+}
+
+// NewSafe returns a SafeConfig for the given Config,
+// the Config must not be modified afterwards
+func NewSafe(conf *Config) *SafeConfig {
+	s := &SafeConfig{}
+	s.conf.Store(conf)
+	return s
+}
+
+// Load returns the current Config, e.g. to read several keys consistently
+func (s *SafeConfig) Load() *Config {
+	return s.conf.Load()
+}
+
+// Store replaces the current Config, e.g. the callback for Watch
+func (s *SafeConfig) Store(conf *Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conf.Store(conf)
+}
+
+// Update calls f with a copy of the current Config and stores the copy
+func (s *SafeConfig) Update(f func(conf *Config)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conf := *s.conf.Load()
+	f(&conf)
+	s.conf.Store(&conf)
+}
+{{range .Keys}}
+// {{.Key}} is {{.KeyPrefix}}
+func (s *SafeConfig) {{.Key}}() {{if .GoType}}{{.GoType}}{{else}}string{{end}} {
+	return s.Load().{{.Key}}()
+}
+{{end}}
+{{range .Keys}}
+// Set{{.Key}} overrides the value of {{.KeyPrefix}}
+func (s *SafeConfig) Set{{.Key}}(v string) {
+	s.Update(func(conf *Config) {
+		conf.Set{{.Key}}(v)
+	})
+}
+{{end}}
+`
+
+// templateConfigerGo text template to generate FileNameConfigerGo
+var templateConfigerGo = `
+// Code generated with https://github.com/mozey/config DO NOT EDIT
+
+package config
+{{if .TypeImports}}
+import ({{range .TypeImports}}
+	"{{.}}"{{end}}
+)
+{{end}}
+// Configer has the getters of Config,
+// packages can depend on it instead of Config, e.g. to inject MockConfig in tests
+type Configer interface {
+	{{range .Keys}}
+	{{.Key}}() {{if .GoType}}{{.GoType}}{{else}}string{{end}}{{end}}
+}
+
+var _ Configer = (*Config)(nil)
+var _ Configer = (*SafeConfig)(nil)
+var _ Configer = (*MockConfig)(nil)
+
+// MockValues are returned by the MockConfig getters
+type MockValues struct {
+	{{range .Keys}}
+	{{.Key}} {{if .GoType}}{{.GoType}}{{else}}string{{end}} // {{.KeyPrefix}}{{end}}
+}
+
+// MockConfig implements Configer with the given values,
+// tests can use it instead of setting env
+type MockConfig struct {
+	Values MockValues
+}
+{{range .Keys}}
+// {{.Key}} returns Values.{{.Key}}
+func (m *MockConfig) {{.Key}}() {{if .GoType}}{{.GoType}}{{else}}string{{end}} {
+	return m.Values.{{.Key}}
+}
+{{end}}
+`
+
+// templateConfigTestGo text template to generate FileNameConfigTestGo
+var templateConfigTestGo = `
+// Code generated with https://github.com/mozey/config DO NOT EDIT
+
+// Package configtest loads config in tests,
+// the env is restored when the test completes.
+// Importing the package also loads the test env, see init
+package configtest
+
+import (
+	"os"
+	"testing"
+
+	"{{.ImportPath}}"
+	"github.com/mozey/config/pkg/share"
+)
+
+// initErr is reported by LoadEnv if the test env failed to load
+var initErr error
+
+// init sets the env from the test config file under go test, if found.
+// The file is searched in the package dir and its parents,
+// up to APP_DIR or the module root, see config.LoadFile
+func init() {
+	if !testing.Testing() {
+		return
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		initErr = err
+		return
+	}
+	configPath, err := share.FindConfigFile(
+		wd, os.Getenv("APP_DIR"), share.EnvTest)
+	if err != nil || configPath == "" {
+		initErr = err
+		return
+	}
+	_, initErr = config.LoadFile(share.EnvTest)
+}
+
+// Load the dev config, see LoadEnv
+func Load(t testing.TB, overrides ...map[string]string) *config.Config {
+	t.Helper()
+	return LoadEnv(t, "dev", overrides...)
+}
+
+// LoadEnv loads the config file for env, and sets the env with t.Setenv.
+// Non-empty values in overrides take precedence over the config file,
+// e.g. to change a key for a single test.
+// Like t.Setenv, it may not be used in parallel tests
+func LoadEnv(t testing.TB, env string,
+	overrides ...map[string]string) *config.Config {
+
+	t.Helper()
+	if initErr != nil {
+		t.Fatal(initErr)
+	}
+	conf := config.LoadFileT(t, env)
+	if len(overrides) == 0 {
+		return conf
+	}
+	opts := []config.Option{config.WithEnvFile(env)}
+	for _, m := range overrides {
+		for key, val := range m {
+			t.Setenv(key, val)
+		}
+		opts = append(opts, config.WithMap(m))
+	}
+	return config.New(opts...)
+}
+`
+
+// templateFlagsGo text template to generate FileNameFlagsGo
+var templateFlagsGo = `
+// Code generated with https://github.com/mozey/config DO NOT EDIT
+
+package config
+
+import (
+	"flag"{{range .ParseImports}}
+	"{{.}}"{{end}}{{if .TypedKeys}}
+
+	"github.com/pkg/errors"{{end}}
+)
+
+// RegisterFlags defines a flag on fs for each key, e.g. -foo for APP_FOO.
+// Flags override package vars, env, and the config file,
+// i.e. call RegisterFlags after loading the config, and before fs.Parse.
+// Keys that are not set with a flag are not changed
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	{{range .Keys}}
+	fs.Func("{{.FlagName}}", "Set {{.KeyPrefix}}", func(v string) error { {{- if .GoType}}
+		prev := c.{{.KeyPrivate}}
+		c.{{.KeyPrivate}} = v
+		if _, err := {{.Parse}}; err != nil {
+			c.{{.KeyPrivate}} = prev
+			return errors.Wrapf(err, "invalid {{.Type}}")
+		}{{else}}
+		c.{{.KeyPrivate}} = v{{end}}
+		return nil
+	}){{end}}
+}
+`
+
+// templateEnvconfigGo text template to generate FileNameEnvconfigGo
+var templateEnvconfigGo = `
+// Code generated with https://github.com/mozey/config DO NOT EDIT
+
+package config
+{{if .TypeImports}}
+import ({{range .TypeImports}}
+	"{{.}}"{{end}}
+)
+{{end}}
+// Env has a field for each key, for tag-driven loaders, e.g.
+// caarlos0/env with env.Parse(&e), or kelseyhightower/envconfig with
+// envconfig.Process("", &e). This package does not use Env
+type Env struct {
+	{{range .Keys}}
+	{{.Key}} {{if .GoType}}{{.GoType}}{{else}}string{{end}} {{.StructTag}}{{end}}
+}
+`
+
+// templateWatchGo text template to generate FileNameWatchGo
+var templateWatchGo = `
+// Code generated with https://github.com/mozey/config DO NOT EDIT
+
+package config
+
+import (
+	"context"
+	"os"
+	"reflect"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/mozey/config/pkg/share"
+	"github.com/pkg/errors"
+)
+
+// Reloads has metrics about reloads by Watch and WatchEnvs,
+// e.g. to alert on services failing to pick up config changes
+var Reloads = &share.ReloadMetrics{}
+
+// Watch reloads the config file for env when it changes,
+// sets the env, and calls onChange with the new Config, see WatchEnvs.
+// Keys removed from the file are unset.
+// Watch blocks until ctx is done
+func Watch(ctx context.Context, env string, onChange func(*Config)) error {
+	return watch(ctx, []string{env}, true, func(_ string, conf *Config) {
+		onChange(conf)
+	})
+}
+
+// WatchEnvs reloads the config files for envs with a single watcher,
+// and calls onChange with the env and new Config.
+// Config is read from the file only, the env is not set,
+// since envs have the same keys.
+// The dir is watched, since editors often replace the file on save.
+// Events are debounced, see share.WatchDebounce, so a save triggers one
+// reload, and onChange is only called if the values changed.
+// Invalid config files are skipped, i.e. onChange is not called,
+// see Reloads. WatchEnvs blocks until ctx is done
+func WatchEnvs(ctx context.Context, envs []string,
+	onChange func(env string, conf *Config)) error {
+
+	return watch(ctx, envs, false, onChange)
+}
+
+func watch(ctx context.Context, envs []string, setEnv bool,
+	onChange func(env string, conf *Config)) error {
+
+	appDir := os.Getenv("{{.Prefix}}DIR")
+	if appDir == "" {
+		// Use current working dir
+		var err error
+		appDir, err = os.Getwd()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	files, err := share.NewWatchFiles(appDir, envs)
+	if err != nil {
+		return err
+	}
+
+	// Values are compared with the last load
+	last := make(map[string]map[string]string)
+	for _, env := range envs {
+		last[env], _ = readFile(env, false)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer (func() {
+		_ = watcher.Close()
+	})()
+	err = watcher.Add(appDir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	batch := &share.WatchBatch{Debounce: share.WatchDebounce}
+	defer batch.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			// Permissions don't change the config, e.g. vim sets them on save
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			batch.Add(files.Envs(event.Name)...)
+
+		case <-batch.C():
+			for _, env := range batch.Flush() {
+				configMap, err := readFile(env, false)
+				if err != nil {
+					Reloads.Failed(err)
+					continue
+				}
+				if reflect.DeepEqual(configMap, last[env]) {
+					continue
+				}
+				conf, err := reload(configMap, last[env], setEnv)
+				if err != nil {
+					Reloads.Failed(err)
+					continue
+				}
+				last[env] = configMap
+				Reloads.Reloaded()
+				onChange(env, conf)
+			}
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			return errors.WithStack(err)
+		}
+	}
+}
+
+// reload returns a new Config from configMap, the env is not read,
+// it may have stale values, or values for another env.
+// If setEnv is true the env is set, and keys in last that were removed
+// from the file are unset
+func reload(configMap map[string]string, last map[string]string,
+	setEnv bool) (conf *Config, err error) {
+
+	o := &options{osEnv: false, configMap: configMap}
+	conf = o.newConfig()
+	err = conf.Validate()
+	if err != nil {
+		return conf, err
+	}
+	if setEnv {
+		for key := range last {
+			if _, ok := configMap[key]; !ok {
+				_ = os.Unsetenv(key)
+			}
+		}
+		for key, val := range configMap {
+			_ = os.Setenv(key, val)
+		}
+	}
+	return conf, nil
+}
+`
+
+// templateTSType is the TypeScript type for the key type
+var templateTSType = `{{define "tsType"}}
+{{- if eq .Type "int" "float"}}number
+{{- else if eq .Type "bool"}}boolean
+{{- else if eq .Type "url"}}URL | undefined
+{{- else}}string{{end}}{{end}}`
+
+// templateConfigDTs text template to generate FileNameConfigDTs
+var templateConfigDTs = templateTSType + `
+// Code generated with https://github.com/mozey/config DO NOT EDIT
+
+/** Config file keys, i.e. env var names */
+export type ConfigKey ={{range .Keys}}
+  | "{{.KeyPrefix}}"{{else}} never{{end}};
+
+/** Env var values, e.g. process.env */
+export type ConfigEnv = Partial<Record<ConfigKey, string>>;
+
+/** Config values, typed as per config.types.json */
+export interface Config {{"{"}}{{range .Keys}}
+  /** {{.KeyPrefix}} */
+  readonly {{.KeyPrivate}}: {{template "tsType" .}};{{end}}
+}
+`
+
+// templateLoaderTs text template to generate FileNameLoaderTs,
+// values are parsed the same as the generated Go getters
+var templateLoaderTs = templateTSType + `
+// Code generated with https://github.com/mozey/config DO NOT EDIT
+
+import type { Config, ConfigEnv, ConfigKey } from "./config";
+{{- $int := false}}{{$float := false}}{{$bool := false}}{{$url := false}}
+{{- range .TypedKeys}}
+{{- if eq .Type "int"}}{{$int = true}}{{end}}
+{{- if eq .Type "float"}}{{$float = true}}{{end}}
+{{- if eq .Type "bool"}}{{$bool = true}}{{end}}
+{{- if eq .Type "url"}}{{$url = true}}{{end}}
+{{- end}}
+
+function value(env: ConfigEnv, key: ConfigKey, defaultValue: string): string {
+  const v = env[key];
+  return v === undefined || v === "" ? defaultValue : v;
+}
+{{if $int}}
+function toInt(key: ConfigKey, v: string): number {
+  if (v === "") {
+    return 0;
+  }
+  if (!/^[+-]?\d+$/.test(v)) {
+    throw new Error(` + "`invalid int ${key}`" + `);
+  }
+  return Number(v);
+}
+{{end}}{{if $float}}
+function toFloat(key: ConfigKey, v: string): number {
+  if (v === "") {
+    return 0;
+  }
+  const n = Number(v);
+  if (Number.isNaN(n)) {
+    throw new Error(` + "`invalid float ${key}`" + `);
+  }
+  return n;
+}
+{{end}}{{if $bool}}
+function toBool(key: ConfigKey, v: string): boolean {
+  if (v === "" || ["0", "f", "F", "FALSE", "false", "False"].includes(v)) {
+    return false;
+  }
+  if (["1", "t", "T", "TRUE", "true", "True"].includes(v)) {
+    return true;
+  }
+  throw new Error(` + "`invalid bool ${key}`" + `);
+}
+{{end}}{{if $url}}
+function toURL(key: ConfigKey, v: string): URL | undefined {
+  if (v === "") {
+    return undefined;
+  }
+  try {
+    return new URL(v);
+  } catch {
+    throw new Error(` + "`invalid url ${key}`" + `);
+  }
+}
+{{end}}
+/**
+ * loadConfig reads the keys from env, e.g. process.env,
+ * defaults are used for empty values. Throws if a typed value is invalid
+ */
+export function loadConfig(env: ConfigEnv): Config {
+  return {{"{"}}{{range .Keys}}
+    {{.KeyPrivate}}: {{if eq .Type "int"}}toInt("{{.KeyPrefix}}", {{else if eq .Type "float"}}toFloat("{{.KeyPrefix}}", {{else if eq .Type "bool"}}toBool("{{.KeyPrefix}}", {{else if eq .Type "url"}}toURL("{{.KeyPrefix}}", {{end -}}
+    value(env, "{{.KeyPrefix}}", {{if .Default}}{{.Default}}{{else}}""{{end}}){{if and .Type (ne .Type "string") (ne .Type "duration")}}){{end}},{{end}}
+  };
 }
 `
@@ -6,13 +6,16 @@ package config
 import (
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/mozey/config/pkg/share"
 	"github.com/pkg/errors"
 )
 
-// KeyPrefix is not made publicly available on this package,
+// Values are not made publicly available on this package,
 // users must use the getter or setter methods.
 // This package must not change the config file
 
@@ -23,17 +26,38 @@ var bar string
 var buz string
 // APP_FOO
 var foo string
+// APP_PORT
+var port string
 // APP_TEMPLATE_FIZ
 var templateFiz string
 // APP_DIR
 var dir string
 
+// Key names, for code that must reference the env vars directly,
+// e.g. setting the env for exec.Command
+const (
+	KeyBar = "APP_BAR"
+	KeyBuz = "APP_BUZ"
+	KeyFoo = "APP_FOO"
+	KeyPort = "APP_PORT"
+	KeyTemplateFiz = "APP_TEMPLATE_FIZ"
+	KeyDir = "APP_DIR"
+)
+
+// keysHash of the config file keys when this package was generated
+const keysHash = "904acebc7e9943c54d657f644f2ed80f34c74909ccfea8a19d15b6169f174a50"
+
+// CheckStale if set, New prints a warning to stderr if the keys in the env
+// don't match the config file keys when this package was generated
+var CheckStale = false
+
 // Config fields correspond to config file keys less the prefix
 type Config struct {
 	
 	bar string // APP_BAR
 	buz string // APP_BUZ
 	foo string // APP_FOO
+	port string // APP_PORT
 	templateFiz string // APP_TEMPLATE_FIZ
 	dir string // APP_DIR
 }
@@ -43,7 +67,7 @@ type Config struct {
 func (c *Config) Bar() string {
 	return c.bar
 }
-// Buz is APP_BUZ
+// Buz is APP_BUZ, it's locked and may not be set by env
 func (c *Config) Buz() string {
 	return c.buz
 }
@@ -51,6 +75,12 @@ func (c *Config) Buz() string {
 func (c *Config) Foo() string {
 	return c.foo
 }
+// Port is APP_PORT
+func (c *Config) Port() int {
+	// Invalid values are rejected by Validate
+	v, _ := strconv.Atoi(c.port)
+	return v
+}
 // TemplateFiz is APP_TEMPLATE_FIZ
 func (c *Config) TemplateFiz() string {
 	return c.templateFiz
@@ -61,6 +91,50 @@ func (c *Config) Dir() string {
 }
 
 
+// MustBar is the same as Bar, but panics if APP_BAR is empty
+func (c *Config) MustBar() string {
+	if c.bar == "" {
+		panic("APP_BAR must not be empty")
+	}
+	return c.Bar()
+}
+// MustBuz is the same as Buz, but panics if APP_BUZ is empty
+func (c *Config) MustBuz() string {
+	if c.buz == "" {
+		panic("APP_BUZ must not be empty")
+	}
+	return c.Buz()
+}
+// MustFoo is the same as Foo, but panics if APP_FOO is empty
+func (c *Config) MustFoo() string {
+	if c.foo == "" {
+		panic("APP_FOO must not be empty")
+	}
+	return c.Foo()
+}
+// MustPort is the same as Port, but panics if APP_PORT is empty
+func (c *Config) MustPort() int {
+	if c.port == "" {
+		panic("APP_PORT must not be empty")
+	}
+	return c.Port()
+}
+// MustTemplateFiz is the same as TemplateFiz, but panics if APP_TEMPLATE_FIZ is empty
+func (c *Config) MustTemplateFiz() string {
+	if c.templateFiz == "" {
+		panic("APP_TEMPLATE_FIZ must not be empty")
+	}
+	return c.TemplateFiz()
+}
+// MustDir is the same as Dir, but panics if APP_DIR is empty
+func (c *Config) MustDir() string {
+	if c.dir == "" {
+		panic("APP_DIR must not be empty")
+	}
+	return c.Dir()
+}
+
+
 // SetBar overrides the value of bar
 func (c *Config) SetBar(v string) {
 	c.bar = v
@@ -76,6 +150,11 @@ func (c *Config) SetFoo(v string) {
 	c.foo = v
 }
 
+// SetPort overrides the value of port
+func (c *Config) SetPort(v string) {
+	c.port = v
+}
+
 // SetTemplateFiz overrides the value of templateFiz
 func (c *Config) SetTemplateFiz(v string) {
 	c.templateFiz = v
@@ -87,18 +166,216 @@ func (c *Config) SetDir(v string) {
 }
 
 
+// Precedence of env and package vars, see EnvPrecedence
+const (
+	// PrecedenceEnv env overrides package vars
+	PrecedenceEnv = "env"
+	// PrecedenceVars env is ignored, except for keys in EnvAllowlist,
+	// e.g. for hardened deployments where ambient env must not change config
+	PrecedenceVars = "vars"
+)
+
+// EnvPrecedence is set with the precedence flag when generating this package
+var EnvPrecedence = "env"
+
+// EnvAllowlist keys may be set by env if EnvPrecedence is PrecedenceVars,
+// APP_DIR may always be set by env
+var EnvAllowlist = map[string]bool{}
+
+// Option configures New, see the With funcs
+type Option func(o *options) error
+
+// options for New
+type options struct {
+	// osEnv is false if env must not be read
+	osEnv bool
+	// configMap values take precedence over env and package vars
+	configMap map[string]string
+}
+
+// merge non-empty values from m, overriding previous options
+func (o *options) merge(m map[string]string) {
+	for key, val := range m {
+		if val != "" {
+			o.configMap[key] = val
+		}
+	}
+}
+
+// WithMap values take precedence over env and package vars.
+// Options are applied in order, later options override earlier ones
+func WithMap(m map[string]string) Option {
+	return func(o *options) error {
+		o.merge(m)
+		return nil
+	}
+}
+
+// WithEnvFile reads values from the config file for env, see LoadFile.
+// Unlike LoadFile, the env is not set
+func WithEnvFile(env string) Option {
+	return func(o *options) error {
+		configMap, err := readFile(env, false)
+		if err != nil {
+			return err
+		}
+		o.merge(configMap)
+		return nil
+	}
+}
+
+// WithBase64 reads values from base64 encoded JSON, see SetEnvBase64.
+// Unlike SetEnvBase64, the env is not set
+func WithBase64(configBase64 string) Option {
+	return func(o *options) error {
+		configMap, err := decodeBase64(configBase64)
+		if err != nil {
+			return err
+		}
+		o.merge(configMap)
+		return nil
+	}
+}
+
+// WithoutOSEnv ignores env, only defaults, package vars,
+// and values from other options are used
+func WithoutOSEnv() Option {
+	return func(o *options) error {
+		o.osEnv = false
+		return nil
+	}
+}
+
 // New creates an instance of Config.
 // Build with ldflags to set the package vars.
-// Env overrides package vars.
+// Env overrides package vars, see EnvPrecedence.
+// Values from options override env, see Option.
 // Fields correspond to the config file keys less the prefix.
-// The config file must have a flat structure
-func New() *Config {
+// The config file must have a flat structure.
+// New panics if an option fails, or a typed value is invalid, see Validate
+func New(opts ...Option) *Config {
+	o := &options{osEnv: true, configMap: make(map[string]string)}
+	for _, opt := range opts {
+		err := opt(o)
+		if err != nil {
+			panic(err)
+		}
+	}
+	conf := o.newConfig()
+	err := conf.Validate()
+	if err != nil {
+		panic(err)
+	}
+	return conf
+}
+
+
+// MustNew is the same as New, but panics if required keys are empty,
+// keys are required unless marked optional in config.types.json
+func MustNew(opts ...Option) *Config {
+	conf := New(opts...)
+	missing := make([]string, 0)
+	
+	if conf.bar == "" {
+		missing = append(missing, "APP_BAR")
+	}
+	if conf.buz == "" {
+		missing = append(missing, "APP_BUZ")
+	}
+	if conf.foo == "" {
+		missing = append(missing, "APP_FOO")
+	}
+	if conf.port == "" {
+		missing = append(missing, "APP_PORT")
+	}
+	if conf.templateFiz == "" {
+		missing = append(missing, "APP_TEMPLATE_FIZ")
+	}
+	if len(missing) > 0 {
+		panic("required keys must not be empty: " + strings.Join(missing, ", "))
+	}
+	return conf
+}
+
+// newConfig sets defaults, package vars, and env on a new Config.
+// Non-empty values in configMap take precedence, e.g. from a config file
+func newConfig(configMap map[string]string) *Config {
+	o := &options{osEnv: true, configMap: configMap}
+	return o.newConfig()
+}
+
+// newConfig applies the options on a new Config, env is read unless disabled
+func (o *options) newConfig() *Config {
+	if CheckStale {
+		checkStale()
+	}
 	conf := &Config{}
+	setDefaults(conf)
 	SetVars(conf)
-	SetEnv(conf)
+	if o.osEnv {
+		SetEnv(conf)
+	}
+	
+	if v := o.configMap["APP_BAR"]; v != "" {
+		conf.bar = v
+	}
+	if v := o.configMap["APP_BUZ"]; v != "" {
+		conf.buz = v
+	}
+	if v := o.configMap["APP_FOO"]; v != "" {
+		conf.foo = v
+	}
+	if v := o.configMap["APP_PORT"]; v != "" {
+		conf.port = v
+	}
+	if v := o.configMap["APP_TEMPLATE_FIZ"]; v != "" {
+		conf.templateFiz = v
+	}
+	if v := o.configMap["APP_DIR"]; v != "" {
+		conf.dir = v
+	}
 	return conf
 }
 
+// envOverride returns true if env may set the key, see EnvPrecedence
+func envOverride(key string) bool {
+	return EnvPrecedence != PrecedenceVars ||
+		EnvAllowlist[key] || key == "APP_DIR"
+}
+
+// checkStale prints a warning if the keys changed since generate.
+// Config compiled with ldflags doesn't depend on the env, it's not checked
+func checkStale() {
+	if varsSet() {
+		return
+	}
+	if share.KeysHash(share.EnvKeys("APP_")) != keysHash {
+		_, _ = fmt.Fprintln(os.Stderr,
+			"WARNING config keys changed, run configu -generate to update the config package")
+	}
+}
+
+// setDefaults sets the default values,
+// package vars and env take precedence
+func setDefaults(conf *Config) {
+	
+	conf.buz = "Buzz"
+	conf.port = "80"
+}
+
+// Validate returns an error if a typed value is invalid,
+// empty values are not validated
+func (c *Config) Validate() error {
+	
+	if c.port != "" {
+		if _, err := strconv.Atoi(c.port); err != nil {
+			return errors.Wrapf(err, "invalid int APP_PORT")
+		}
+	}
+	
+	return nil
+}
+
 // SetVars sets non-empty package vars on Config
 func SetVars(conf *Config) {
 	
@@ -114,6 +391,10 @@ func SetVars(conf *Config) {
 		conf.foo = foo
 	}
 	
+	if port != "" {
+		conf.port = port
+	}
+	
 	if templateFiz != "" {
 		conf.templateFiz = templateFiz
 	}
@@ -124,40 +405,82 @@ func SetVars(conf *Config) {
 	
 }
 
-// SetEnv sets non-empty env vars on Config
+// varsSet returns true if any package var is set, i.e. with ldflags
+func varsSet() bool {
+	
+	if bar != "" {
+		return true
+	}
+	if buz != "" {
+		return true
+	}
+	if foo != "" {
+		return true
+	}
+	if port != "" {
+		return true
+	}
+	if templateFiz != "" {
+		return true
+	}
+	if dir != "" {
+		return true
+	}
+	return false
+}
+
+// SetEnv sets non-empty env vars on Config,
+// unless env may not override the key, see EnvPrecedence.
+// Locked keys are never set by env
 func SetEnv(conf *Config) {
 	var v string
 
 	
 	v = os.Getenv("APP_BAR")
-	if v != "" {
+	if v != "" && envOverride("APP_BAR") {
 		conf.bar = v
 	}
 	
-	v = os.Getenv("APP_BUZ")
-	if v != "" {
-		conf.buz = v
-	}
+	// APP_BUZ is locked
 	
 	v = os.Getenv("APP_FOO")
-	if v != "" {
+	if v != "" && envOverride("APP_FOO") {
 		conf.foo = v
 	}
 	
+	v = os.Getenv("APP_PORT")
+	if v != "" && envOverride("APP_PORT") {
+		conf.port = v
+	}
+	
 	v = os.Getenv("APP_TEMPLATE_FIZ")
-	if v != "" {
+	if v != "" && envOverride("APP_TEMPLATE_FIZ") {
 		conf.templateFiz = v
 	}
 	
 	v = os.Getenv("APP_DIR")
-	if v != "" {
+	if v != "" && envOverride("APP_DIR") {
 		conf.dir = v
 	}
 	
 }
 
-// GetMap of all env vars
+// redacted replaces non-empty secret values
+const redacted = "****"
+
+// GetMap of all env vars, secret values are redacted,
+// use GetMapUnredacted to include secrets
 func (c *Config) GetMap() map[string]string {
+	m := c.GetMapUnredacted()
+	
+	if m["APP_BAR"] != "" {
+		m["APP_BAR"] = redacted
+	}
+	return m
+}
+
+// GetMapUnredacted of all env vars, including secret values
+func (c *Config) GetMapUnredacted() map[string]string {
 	m := make(map[string]string)
 	
 	m["APP_BAR"] = c.bar
@@ -166,6 +489,8 @@ func (c *Config) GetMap() map[string]string {
 	
 	m["APP_FOO"] = c.foo
 	
+	m["APP_PORT"] = c.port
+	
 	m["APP_TEMPLATE_FIZ"] = c.templateFiz
 	
 	m["APP_DIR"] = c.dir
@@ -173,26 +498,36 @@ func (c *Config) GetMap() map[string]string {
 	return m
 }
 
-// LoadMap sets the env from a map and returns a new instance of Config
+// String returns the config as JSON with secret values redacted,
+// it's safe for logging
+func (c *Config) String() string {
+	b, err := json.Marshal(c.GetMap())
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+// LoadMap sets the env from a map and returns a new instance of Config,
+// values in the map take precedence.
+// LoadMap panics if a typed value is invalid, see Validate
 func LoadMap(configMap map[string]string) (conf *Config)  {
 	for key, val := range configMap {
 		_ = os.Setenv(key, val)
 	}
-	return New()
+	conf = newConfig(configMap)
+	err := conf.Validate()
+	if err != nil {
+		panic(err)
+	}
+	return conf
 }
 
 // SetEnvBase64 decodes and sets env from the given base64 string
 func SetEnvBase64(configBase64 string) (err error) {
-	// Decode base64
-	decoded, err := base64.StdEncoding.DecodeString(configBase64)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-	// UnMarshall json
-	configMap := make(map[string]string)
-	err = json.Unmarshal(decoded, &configMap)
+	configMap, err := decodeBase64(configBase64)
 	if err != nil {
-		return errors.WithStack(err)
+		return err
 	}
 	// Set config
 	for key, value := range configMap {
@@ -205,21 +540,132 @@ func SetEnvBase64(configBase64 string) (err error) {
 	return nil
 }
 
+// decodeBase64 decodes the config map from base64 encoded JSON
+func decodeBase64(configBase64 string) (configMap map[string]string, err error) {
+	// Decode base64
+	decoded, err := base64.StdEncoding.DecodeString(configBase64)
+	if err != nil {
+		return configMap, errors.WithStack(err)
+	}
+	// UnMarshall json
+	configMap = make(map[string]string)
+	err = json.Unmarshal(decoded, &configMap)
+	if err != nil {
+		return configMap, errors.WithStack(err)
+	}
+	return configMap, nil
+}
+
 // LoadFile sets the env from file and returns a new instance of Config
 func LoadFile(env string) (conf *Config, err error) {
+	return loadFile(env, false)
+}
+
+// LoadFileSecrets is the same as LoadFile, but also resolves secret references,
+// e.g. gcpsm://projects/x/secrets/y
+func LoadFileSecrets(env string) (conf *Config, err error) {
+	return loadFile(env, true)
+}
+
+// LoadURL sets the env from a JSON or YAML config file fetched over HTTPS,
+// and returns a new instance of Config. If the share.TokenEnvKey env var is set,
+// it's used as the bearer token
+func LoadURL(url string) (conf *Config, err error) {
+	configMap, err := share.FetchConfig(url, os.Getenv(share.TokenEnvKey))
+	if err != nil {
+		return conf, err
+	}
+	for key, val := range configMap {
+		_ = os.Setenv(key, val)
+	}
+	conf = newConfig(configMap)
+	return conf, conf.Validate()
+}
+
+// TB is the subset of testing.TB used by LoadFileT,
+// this package doesn't import testing
+type TB interface {
+	Helper()
+	Setenv(key, value string)
+	Fatal(args ...any)
+}
+
+// LoadFileT is the same as LoadFile, but the env is set with t.Setenv,
+// and restored when the test completes. The test fails if the file is invalid.
+// Like t.Setenv, it may not be used in parallel tests
+func LoadFileT(t TB, env string) *Config {
+	t.Helper()
+	configMap, err := readFile(env, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, val := range configMap {
+		t.Setenv(key, val)
+	}
+	conf := newConfig(configMap)
+	err = conf.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conf
+}
+
+// testConfigPath searches the working dir and its parents for the test config
+// file, up to APP_DIR or the module root. The path is empty if not found
+func testConfigPath() (configPath string, err error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return configPath, errors.WithStack(err)
+	}
+	return share.FindConfigFile(wd, os.Getenv("APP_DIR"), share.EnvTest)
+}
+
+func loadFile(env string, secrets bool) (conf *Config, err error) {
+	configMap, err := readFile(env, secrets)
+	if err != nil {
+		return conf, err
+	}
+	return loadConfigMap(configMap)
+}
+
+// loadConfigMap sets the env from the map,
+// and returns a new instance of Config
+func loadConfigMap(configMap map[string]string) (conf *Config, err error) {
+	for key, val := range configMap {
+		_ = os.Setenv(key, val)
+	}
+	conf = newConfig(configMap)
+	return conf, conf.Validate()
+}
+
+// readFile reads the config file for env
+func readFile(env string, secrets bool) (
+	configMap map[string]string, err error) {
+
+	if env == share.EnvTest {
+		configPath, err := testConfigPath()
+		if err != nil {
+			return configMap, err
+		}
+		if configPath == "" {
+			return configMap, errors.Errorf("test config file not found")
+		}
+		return readConfigPath(configPath, secrets)
+	}
+
 	appDir := os.Getenv("APP_DIR")
 	if appDir == "" {
 		// Use current working dir
 		appDir, err = os.Getwd()
 		if err != nil {
-			return conf, errors.WithStack(err)
+			return configMap, errors.WithStack(err)
 		}
 	}
 
 	var configPath string
 	filePaths, err := share.GetConfigFilePaths(appDir, env)
 	if err != nil {
-		return conf, err
+		return configMap, err
 	}
 	for _, configPath = range filePaths {
 		_, err := os.Stat(configPath)
@@ -228,26 +674,37 @@ func LoadFile(env string) (conf *Config, err error) {
 				// Path does not exist
 				continue
 			}
-			return conf, errors.WithStack(err)
+			return configMap, errors.WithStack(err)
 		}
 		// Path exists
 		break
 	}
 	if configPath == "" {
-		return conf, errors.Errorf("config file not found in %s", appDir)
+		return configMap, errors.Errorf("config file not found in %s", appDir)
 	}
 
+	return readConfigPath(configPath, secrets)
+}
+
+// readConfigPath reads the config file,
+// secret references are resolved if secrets is set
+func readConfigPath(configPath string, secrets bool) (
+	configMap map[string]string, err error) {
+
 	b, err := os.ReadFile(configPath)
 	if err != nil {
-		return conf, errors.WithStack(err)
+		return configMap, errors.WithStack(err)
 	}
 
-	configMap, err := share.UnmarshalConfig(configPath, b)
+	configMap, err = share.UnmarshalConfig(configPath, b)
 	if err != nil {
-		return conf, err
+		return configMap, err
 	}
-	for key, val := range configMap {
-		_ = os.Setenv(key, val)
+	if secrets {
+		_, err = share.ResolveSecrets(configMap)
+		if err != nil {
+			return configMap, err
+		}
 	}
-	return New(), nil
+	return configMap, nil
 }
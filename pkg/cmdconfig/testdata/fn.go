@@ -5,14 +5,20 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"strconv"
 	"strings"
 )
 
+// Fn functions can be chained, e.g.
+// c.FnFoo().Trim().Lower().Default("x").String()
 type Fn struct {
+	// key the input was read from
+	key string
+	// input is the config value
 	input string
 	// output of the last function,
-	// might be useful when chaining multiple functions?
+	// transforms and type conversions use the output
 	output string
 }
 
@@ -23,44 +29,105 @@ type Fn struct {
 // FnBar sets the function input to the value of APP_BAR
 func (c *Config) FnBar() *Fn {
 	fn := Fn{}
+	fn.key = "APP_BAR"
 	fn.input = c.bar
-	fn.output = ""
+	fn.output = c.bar
 	return &fn
 }
 
 // FnBuz sets the function input to the value of APP_BUZ
 func (c *Config) FnBuz() *Fn {
 	fn := Fn{}
+	fn.key = "APP_BUZ"
 	fn.input = c.buz
-	fn.output = ""
+	fn.output = c.buz
 	return &fn
 }
 
 // FnFoo sets the function input to the value of APP_FOO
 func (c *Config) FnFoo() *Fn {
 	fn := Fn{}
+	fn.key = "APP_FOO"
 	fn.input = c.foo
-	fn.output = ""
+	fn.output = c.foo
+	return &fn
+}
+
+// FnPort sets the function input to the value of APP_PORT
+func (c *Config) FnPort() *Fn {
+	fn := Fn{}
+	fn.key = "APP_PORT"
+	fn.input = c.port
+	fn.output = c.port
 	return &fn
 }
 
 // FnTemplateFiz sets the function input to the value of APP_TEMPLATE_FIZ
 func (c *Config) FnTemplateFiz() *Fn {
 	fn := Fn{}
+	fn.key = "APP_TEMPLATE_FIZ"
 	fn.input = c.templateFiz
-	fn.output = ""
+	fn.output = c.templateFiz
 	return &fn
 }
 
 // FnDir sets the function input to the value of APP_DIR
 func (c *Config) FnDir() *Fn {
 	fn := Fn{}
+	fn.key = "APP_DIR"
 	fn.input = c.dir
-	fn.output = ""
+	fn.output = c.dir
 	return &fn
 }
 
 
+// .............................................................................
+// Transform functions
+
+// Trim removes leading and trailing white space
+func (fn *Fn) Trim() *Fn {
+	fn.output = strings.TrimSpace(fn.output)
+	return fn
+}
+
+// TrimPrefix removes the prefix if present
+func (fn *Fn) TrimPrefix(prefix string) *Fn {
+	fn.output = strings.TrimPrefix(fn.output, prefix)
+	return fn
+}
+
+// TrimSuffix removes the suffix if present
+func (fn *Fn) TrimSuffix(suffix string) *Fn {
+	fn.output = strings.TrimSuffix(fn.output, suffix)
+	return fn
+}
+
+// Lower converts to lower case
+func (fn *Fn) Lower() *Fn {
+	fn.output = strings.ToLower(fn.output)
+	return fn
+}
+
+// Upper converts to upper case
+func (fn *Fn) Upper() *Fn {
+	fn.output = strings.ToUpper(fn.output)
+	return fn
+}
+
+// Replace all instances of old with new
+func (fn *Fn) Replace(old, new string) *Fn {
+	fn.output = strings.ReplaceAll(fn.output, old, new)
+	return fn
+}
+
+// Default sets the output to fallback if it is empty
+func (fn *Fn) Default(fallback string) *Fn {
+	if fn.output == "" {
+		fn.output = fallback
+	}
+	return fn
+}
+
 // .............................................................................
 // Type conversion functions
 
@@ -68,19 +135,19 @@ func (c *Config) FnDir() *Fn {
 // Valid values are "1", "0", "true", or "false".
 // The value is not case-sensitive
 func (fn *Fn) Bool() (bool, error) {
-	v := strings.ToLower(fn.input)
+	v := strings.ToLower(fn.output)
 	if v == "1" || v == "true" {
 		return true, nil
 	}
 	if v == "0" || v == "false" {
 		return false, nil
 	}
-	return false, fmt.Errorf("invalid value %s", fn.input)
+	return false, fmt.Errorf("invalid value %s", fn.output)
 }
 
 // Float64 parses a float64 from the value or returns an error
 func (fn *Fn) Float64() (float64, error) {
-	f, err := strconv.ParseFloat(fn.input, 64)
+	f, err := strconv.ParseFloat(fn.output, 64)
 	if err != nil {
 		return f, err
 	}
@@ -89,14 +156,115 @@ func (fn *Fn) Float64() (float64, error) {
 
 // Int64 parses an int64 from the value or returns an error
 func (fn *Fn) Int64() (int64, error) {
-	i, err := strconv.ParseInt(fn.input, 10, 64)
+	i, err := strconv.ParseInt(fn.output, 10, 64)
 	if err != nil {
 		return i, err
 	}
 	return i, nil
 }
 
-// String returns the input as is
+// Int64s splits the output on sep and parses each element as an int64,
+// or returns an error. See Strings
+func (fn *Fn) Int64s(sep string) ([]int64, error) {
+	a := make([]int64, 0)
+	for _, s := range fn.Strings(sep) {
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return a, err
+		}
+		a = append(a, i)
+	}
+	return a, nil
+}
+
+// Port parses a TCP or UDP port number from the value or returns an error.
+// Valid ports are in the range 1 to 65535
+func (fn *Fn) Port() (int, error) {
+	p, err := strconv.Atoi(fn.output)
+	if err != nil {
+		return p, err
+	}
+	if p < 1 || p > 65535 {
+		return p, fmt.Errorf("port out of range %d", p)
+	}
+	return p, nil
+}
+
+// String returns the output, i.e. the input if no transforms were applied
 func (fn *Fn) String() string {
-	return fn.input
+	return fn.output
+}
+
+// Strings splits the output on sep, e.g. for list-valued keys.
+// Elements are trimmed, and empty elements are skipped
+func (fn *Fn) Strings(sep string) []string {
+	a := make([]string, 0)
+	for _, s := range strings.Split(fn.output, sep) {
+		s = strings.TrimSpace(s)
+		if s != "" {
+			a = append(a, s)
+		}
+	}
+	return a
+}
+
+// URL parses an absolute URL from the value or returns an error,
+// the scheme and host must not be empty
+func (fn *Fn) URL() (*url.URL, error) {
+	u, err := url.Parse(fn.output)
+	if err != nil {
+		return u, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return u, fmt.Errorf("invalid URL %s", fn.output)
+	}
+	return u, nil
+}
+
+// .............................................................................
+// Type conversion variants for different call-site styles
+
+// TryBool returns the parsed bool, ok is false if the value is not valid
+func (fn *Fn) TryBool() (v bool, ok bool) {
+	v, err := fn.Bool()
+	return v, err == nil
+}
+
+// TryFloat64 returns the parsed float64, ok is false if the value is not valid
+func (fn *Fn) TryFloat64() (v float64, ok bool) {
+	v, err := fn.Float64()
+	return v, err == nil
+}
+
+// TryInt64 returns the parsed int64, ok is false if the value is not valid
+func (fn *Fn) TryInt64() (v int64, ok bool) {
+	v, err := fn.Int64()
+	return v, err == nil
+}
+
+// MustBool returns the parsed bool or panics, e.g. for use in main()
+func (fn *Fn) MustBool() bool {
+	v, err := fn.Bool()
+	if err != nil {
+		panic(fmt.Sprintf("%s must be a bool: %s", fn.key, err))
+	}
+	return v
+}
+
+// MustFloat64 returns the parsed float64 or panics, e.g. for use in main()
+func (fn *Fn) MustFloat64() float64 {
+	v, err := fn.Float64()
+	if err != nil {
+		panic(fmt.Sprintf("%s must be a float: %s", fn.key, err))
+	}
+	return v
+}
+
+// MustInt64 returns the parsed int64 or panics, e.g. for use in main()
+func (fn *Fn) MustInt64() int64 {
+	v, err := fn.Int64()
+	if err != nil {
+		panic(fmt.Sprintf("%s must be an integer: %s", fn.key, err))
+	}
+	return v
 }
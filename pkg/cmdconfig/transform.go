@@ -0,0 +1,100 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// .............................................................................
+// Transforms for values printed with the get flag,
+// applied in the order listed, e.g. "-transform trim,base64"
+
+// TransformBase64 encodes the value as a base64 string
+const TransformBase64 = "base64"
+
+// TransformBase64Decode decodes a base64 string
+const TransformBase64Decode = "base64-decode"
+
+// TransformJSON escapes the value for use in a JSON string,
+// the surrounding quotes are not included
+const TransformJSON = "json"
+
+// TransformTrim removes leading and trailing white space
+const TransformTrim = "trim"
+
+// TransformURL escapes the value for use in a URL query
+const TransformURL = "url"
+
+// transformFunc converts a value
+type transformFunc func(value string) (string, error)
+
+// transforms maps transform name to func
+var transforms = map[string]transformFunc{
+	TransformBase64: func(value string) (string, error) {
+		return base64.StdEncoding.EncodeToString([]byte(value)), nil
+	},
+	TransformBase64Decode: func(value string) (string, error) {
+		b, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return value, errors.WithStack(err)
+		}
+		return string(b), nil
+	},
+	TransformJSON: func(value string) (string, error) {
+		buf := new(bytes.Buffer)
+		enc := json.NewEncoder(buf)
+		enc.SetEscapeHTML(false)
+		err := enc.Encode(value)
+		if err != nil {
+			return value, errors.WithStack(err)
+		}
+		s := strings.TrimSuffix(buf.String(), "\n")
+		return s[1 : len(s)-1], nil
+	},
+	TransformTrim: func(value string) (string, error) {
+		return strings.TrimSpace(value), nil
+	},
+	TransformURL: func(value string) (string, error) {
+		return url.QueryEscape(value), nil
+	},
+}
+
+// Transforms returns the sorted list of supported transforms
+func Transforms() []string {
+	names := make([]string, 0, len(transforms))
+	for name := range transforms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newTransform returns a func that applies the named transforms in order
+func newTransform(names []string) (transformFunc, error) {
+	fns := make([]transformFunc, 0, len(names))
+	for _, name := range names {
+		fn, ok := transforms[name]
+		if !ok {
+			return nil, withSuggestions(errors.Errorf(
+				"invalid transform %s, expected one of %s",
+				name, strings.Join(Transforms(), ", ")), name, Transforms())
+		}
+		fns = append(fns, fn)
+	}
+	return func(value string) (s string, err error) {
+		s = value
+		for i, fn := range fns {
+			s, err = fn(s)
+			if err != nil {
+				return value, errors.Wrapf(err, "transform %s", names[i])
+			}
+		}
+		return s, nil
+	}, nil
+}
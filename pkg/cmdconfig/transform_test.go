@@ -0,0 +1,36 @@
+package cmdconfig
+
+import (
+	"testing"
+
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestTransform(t *testing.T) {
+	is := testutil.Setup(t)
+
+	transform := func(value string, names ...string) string {
+		fn, err := newTransform(names)
+		is.NoErr(err)
+		s, err := fn(value)
+		is.NoErr(err)
+		return s
+	}
+
+	is.Equal(" foo ", transform(" foo "))
+	is.Equal("foo", transform(" foo ", TransformTrim))
+	is.Equal("Zm9v", transform(" foo ", TransformTrim, TransformBase64))
+	is.Equal("foo", transform("Zm9v", TransformBase64Decode))
+	is.Equal("a+b%26c%3D%2F", transform("a b&c=/", TransformURL))
+	is.Equal(`say \"hi\"\n<b>`, transform("say \"hi\"\n<b>", TransformJSON))
+
+	_, err := newTransform([]string{"trm"})
+	is.True(err != nil)
+	is.Equal("invalid transform trm, expected one of "+
+		"base64, base64-decode, json, trim, url, did you mean trim?", err.Error())
+
+	fn, err := newTransform([]string{TransformBase64Decode})
+	is.NoErr(err)
+	_, err = fn("not base64!")
+	is.True(err != nil)
+}
@@ -0,0 +1,165 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/pkg/errors"
+)
+
+// Problems reported by validate
+const (
+	ProblemMissing = "missing"
+	ProblemUnknown = "unknown"
+	ProblemType    = "type"
+)
+
+// validateProblem is a key that does not match the sample or schema
+type validateProblem struct {
+	Env     string `json:"env"`
+	Key     string `json:"key"`
+	Problem string `json:"problem"`
+	// Detail describes the problem, e.g. the type parse error
+	Detail string `json:"detail"`
+}
+
+// validateEnv checks config for env against the sample and schema.
+// Keys in the sample are required, unless optional or deprecated in the
+// schema, and so are keys declared in the schema. Other keys are unknown.
+// Values must parse as the type declared in the schema
+func validateEnv(prefix, env string, c *conf, sample *conf, schema Schema,
+	ignore []string) (problems []validateProblem) {
+
+	problems = make([]validateProblem, 0)
+	appDirKey := fmt.Sprintf("%sDIR", prefix)
+
+	expected := make(map[string]bool)
+	if sample != nil {
+		for _, key := range sample.Keys {
+			expected[key] = true
+		}
+	}
+	for key := range schema {
+		expected[key] = true
+	}
+	keys := make([]string, 0, len(expected))
+	for key := range expected {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if _, ok := c.Map[key]; ok || ignoreKey(ignore, key) {
+			continue
+		}
+		if schema[key].Optional || schema[key].Deprecated || key == appDirKey {
+			continue
+		}
+		problems = append(problems, validateProblem{
+			Env: env, Key: key, Problem: ProblemMissing,
+			Detail: "required key is not set"})
+	}
+	// Assuming c.Keys is already sorted
+	for _, key := range c.Keys {
+		if ignoreKey(ignore, key) {
+			continue
+		}
+		if !expected[key] {
+			problems = append(problems, validateProblem{
+				Env: env, Key: key, Problem: ProblemUnknown,
+				Detail: "not in the sample or schema"})
+			continue
+		}
+		t := schema[key].Type
+		if err := parseType(t, c.Map[key]); err != nil {
+			problems = append(problems, validateProblem{
+				Env: env, Key: key, Problem: ProblemType,
+				Detail: fmt.Sprintf("value is not a valid %s", t)})
+		}
+	}
+	return problems
+}
+
+// validateConfig checks the envs as per the all and env flags against
+// the sample for each env, and FileNameTypes. The exit code is set if
+// there are problems, e.g. for use in CI
+func validateConfig(in *CmdIn) (
+	buf *bytes.Buffer, files []File, exitCode int, err error) {
+
+	buf = new(bytes.Buffer)
+
+	envs, err := updateEnvs(in)
+	if err != nil {
+		return buf, files, exitCode, err
+	}
+	schema, err := readSchema(in.dirs, in.AppDir)
+	if err != nil {
+		return buf, files, exitCode, err
+	}
+
+	// Keys matching the ignore flags or manifest are not validated
+	ignore := make([]string, 0)
+	ignore = append(ignore, in.manifest.ignore()...)
+	ignore = append(ignore, in.Ignore...)
+
+	problems := make([]validateProblem, 0)
+	for _, env := range envs {
+		if strings.HasPrefix(env, share.SamplePrefix()) {
+			continue
+		}
+		_, config, err := newConf(confParams{
+			dirs:   in.dirs,
+			prefix: in.Prefix,
+			appDir: in.AppDir,
+			env:    env,
+			extend: in.Extend,
+			merge:  in.Merge,
+			parent: in.Parent,
+			file:   in.File,
+		})
+		if err != nil {
+			return buf, files, exitCode, err
+		}
+		sampleEnv := fmt.Sprintf("%s%s", share.SamplePrefix(), env)
+		exists, err := configFileExists(in.dirs, in.AppDir, sampleEnv)
+		if err != nil {
+			return buf, files, exitCode, err
+		}
+		var sample *conf
+		if exists {
+			_, sample, err = newCachedConf(in.dirs, in.AppDir, sampleEnv)
+			if err != nil {
+				return buf, files, exitCode, err
+			}
+		} else if len(schema) == 0 {
+			return buf, files, exitCode, errors.Errorf(
+				"no sample or %s to validate env %s, create it with the %s flag",
+				FileNameTypes, env, FlagSample)
+		}
+		problems = append(problems,
+			validateEnv(in.Prefix, env, config, sample, schema, ignore)...)
+	}
+	if len(problems) > 0 {
+		exitCode = 1
+	}
+
+	if in.JSON {
+		b, err := json.MarshalIndent(problems, "", "    ")
+		if err != nil {
+			return buf, files, exitCode, errors.WithStack(err)
+		}
+		buf.Write(b)
+		buf.WriteString("\n")
+		return buf, files, exitCode, nil
+	}
+
+	for _, p := range problems {
+		buf.WriteString(fmt.Sprintf("%s %s %s: %s\n",
+			p.Env, p.Key, p.Problem, p.Detail))
+	}
+	return buf, files, exitCode, nil
+}
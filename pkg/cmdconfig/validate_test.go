@@ -0,0 +1,77 @@
+package cmdconfig
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestValidateConfig(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	files := map[string]string{
+		"config.dev.json": `{"APP_HOST": "localhost", "APP_PORT": "x",
+			"APP_EXTRA": "1"}`,
+		"sample.config.dev.json": `{"APP_HOST": "", "APP_PORT": "",
+			"APP_DEBUG": "", "APP_TOKEN": ""}`,
+		FileNameTypes: `{"APP_PORT": "int", "APP_DEBUG": {"optional": true},
+			"APP_TIMEOUT": "duration"}`,
+	}
+	for name, content := range files {
+		err = os.WriteFile(filepath.Join(tmp, name), []byte(content), perms)
+		is.NoErr(err)
+	}
+
+	in := &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	in.Validate = true
+
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdValidate, out.Cmd)
+	is.Equal(1, out.ExitCode)
+	is.Equal(`dev APP_TIMEOUT missing: required key is not set
+dev APP_TOKEN missing: required key is not set
+dev APP_EXTRA unknown: not in the sample or schema
+dev APP_PORT type: value is not a valid int
+`, out.Buf.String())
+
+	in.JSON = true
+	in.Ignore = ArgMap{"APP_T*"}
+	out, err = Cmd(in)
+	is.NoErr(err)
+	problems := make([]validateProblem, 0)
+	is.NoErr(json.Unmarshal(out.Buf.Bytes(), &problems))
+	is.Equal(2, len(problems))
+	is.Equal(ProblemUnknown, problems[0].Problem)
+
+	// Valid
+	err = os.WriteFile(filepath.Join(tmp, "config.dev.json"), []byte(
+		`{"APP_HOST": "localhost", "APP_PORT": "8080", "APP_TOKEN": "t",
+			"APP_TIMEOUT": "1s"}`), perms)
+	is.NoErr(err)
+	in.JSON = false
+	in.Ignore = nil
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(0, out.ExitCode)
+	is.Equal("", out.Buf.String())
+
+	// Nothing to validate against
+	is.NoErr(os.Remove(filepath.Join(tmp, "sample.config.dev.json")))
+	is.NoErr(os.Remove(filepath.Join(tmp, FileNameTypes)))
+	_, err = Cmd(in)
+	is.True(err != nil)
+}
@@ -0,0 +1,66 @@
+package cmdconfig
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// workspaceReports are commands with output that is labelled per service
+var workspaceReports = map[string]bool{
+	CmdCompare: true,
+	CmdRefs:    true,
+	CmdStats:   true,
+}
+
+// useService sets the app dir and prefix for the service
+func (in *CmdIn) useService(s Service) {
+	in.AppDir = in.manifest.serviceDir(s)
+	if s.Prefix != "" {
+		in.Prefix = s.Prefix
+	}
+}
+
+// workspaceCmd runs the command for each service listed in the manifest,
+// e.g. to generate config helpers or compare keys for all services
+func workspaceCmd(in *CmdIn) (out *CmdOut, err error) {
+	out = &CmdOut{Buf: new(bytes.Buffer), Files: Files{}}
+
+	if in.manifest == nil || len(in.manifest.Services) == 0 {
+		return out, errors.Errorf("workspace requires services in %s",
+			FileNameManifest)
+	}
+
+	for _, s := range in.manifest.Services {
+		serviceIn := *in
+		serviceIn.Workspace = false
+		serviceIn.useService(s)
+
+		serviceOut, err := Cmd(&serviceIn)
+		if err != nil {
+			return out, errors.WithMessagef(err, "service %s", s.Name)
+		}
+
+		out.Cmd = serviceOut.Cmd
+		if serviceOut.ExitCode > out.ExitCode {
+			out.ExitCode = serviceOut.ExitCode
+		}
+		if workspaceReports[out.Cmd] && !in.JSON && serviceOut.Buf.Len() > 0 {
+			out.Buf.WriteString(fmt.Sprintf("service: %s\n", s.Name))
+		}
+		out.Buf.Write(serviceOut.Buf.Bytes())
+		out.Files = append(out.Files, serviceOut.Files...)
+		if serviceOut.Preview != nil {
+			if out.Preview == nil {
+				out.Preview = new(bytes.Buffer)
+			}
+			out.Preview.Write(serviceOut.Preview.Bytes())
+		}
+		for _, warning := range serviceOut.Warnings {
+			in.warn(fmt.Sprintf("%s: %s", s.Name, warning))
+		}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,113 @@
+package cmdconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mozey/config/pkg/share"
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestWorkspace(t *testing.T) {
+	is := testutil.Setup(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	err = os.WriteFile(filepath.Join(tmp, FileNameManifest), []byte(`
+prefix = "APP_"
+
+[[services]]
+path = "services/api"
+prefix = "API"
+
+[[services]]
+name = "worker"
+path = "services/worker-svc"
+`), perms)
+	is.NoErr(err)
+	m, err := LoadManifest(tmp)
+	is.NoErr(err)
+	is.Equal("api", m.Services[0].Name)
+	is.Equal("API_", m.Services[0].Prefix)
+
+	services := map[string]string{
+		"services/api":        `{"API_FOO": "foo"}`,
+		"services/worker-svc": `{"APP_BAR": "bar"}`,
+	}
+	for dir, config := range services {
+		dir = filepath.Join(tmp, dir)
+		err = os.MkdirAll(dir, dirPerms)
+		is.NoErr(err)
+		err = os.WriteFile(
+			filepath.Join(dir, fmt.Sprintf("config.%v.json", share.EnvDev)),
+			[]byte(config), perms)
+		is.NoErr(err)
+	}
+	// Sample is missing a key
+	err = os.WriteFile(
+		filepath.Join(tmp, "services/api", "sample.config.dev.json"),
+		[]byte(`{}`), perms)
+	is.NoErr(err)
+	err = os.WriteFile(
+		filepath.Join(tmp, "services/worker-svc", "sample.config.dev.json"),
+		[]byte(`{"APP_BAR": ""}`), perms)
+	is.NoErr(err)
+
+	// Service flag
+	t.Setenv("APP_DIR", tmp)
+	in := &CmdIn{}
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	in.Service = "api"
+	in.manifest = m
+	is.NoErr(in.Valid())
+	is.Equal(filepath.Join(tmp, "services/api"), in.AppDir)
+	is.Equal("API_", in.Prefix)
+	out, err := Cmd(in)
+	is.NoErr(err)
+	is.True(strings.Contains(out.Buf.String(), "API_FOO=foo"))
+
+	in.Service = "web"
+	is.True(in.Valid() != nil)
+
+	// Workspace generate
+	in = &CmdIn{}
+	in.AppDir = tmp
+	in.Prefix = "APP_"
+	in.Env = share.EnvDev
+	in.Workspace = true
+	in.Generate = "pkg/config"
+	in.manifest = m
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdGenerate, out.Cmd)
+	paths := make(map[string]bool)
+	for _, file := range out.Files {
+		paths[file.Path] = true
+	}
+	is.True(paths[filepath.Join(
+		tmp, "services/api/pkg/config", FileNameConfigGo)])
+	is.True(paths[filepath.Join(
+		tmp, "services/worker-svc/pkg/config", FileNameConfigGo)])
+
+	// Workspace compare
+	in.Generate = ""
+	in.Compare = "sample.dev"
+	out, err = Cmd(in)
+	is.NoErr(err)
+	is.Equal(CmdCompare, out.Cmd)
+	is.Equal(1, out.ExitCode)
+	is.Equal("service: api\nAPI_FOO\n", out.Buf.String())
+
+	// Workspace requires services
+	in.manifest = &Manifest{}
+	_, err = Cmd(in)
+	is.True(err != nil)
+}
@@ -1,5 +1,11 @@
 package cmdconfig
 
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
 // This file defines cross-platform config,
 // the corresponding "x_${GOOS}.go" file must set values appropriate for GOOS
 
@@ -9,15 +15,121 @@ package cmdconfig
 // OS Detection at compile time
 // https://stackoverflow.com/a/19847868/639133
 
+// Values for the os flag
+const (
+	OSWindows    = "windows"
+	OSPowerShell = "powershell"
+	OSPosix      = "posix"
+	OSFish       = "fish"
+	// OSOther uses the compiled x-platform config
+	OSOther = "other"
+)
+
+// .............................................................................
+// Runtime detection
+
+// shellOS returns the os flag value for the shell process name,
+// or an empty string if the shell is not known
+func shellOS(name string) string {
+	name = strings.ToLower(filepath.Base(name))
+	// Login shells are prefixed with a dash, e.g. "-bash"
+	name = strings.TrimPrefix(name, "-")
+	name = strings.TrimSuffix(name, ".exe")
+	switch name {
+	case "pwsh", "powershell":
+		return OSPowerShell
+	case "cmd":
+		return OSWindows
+	case "fish":
+		return OSFish
+	case "sh", "bash", "zsh", "dash", "ksh", "ash", "mksh":
+		return OSPosix
+	}
+	return ""
+}
+
+// envOS returns the os flag value given the env of the shell,
+// or an empty string if the env is not conclusive
+func envOS(getenv func(key string) string) string {
+	// Git Bash (MSYS) and Cygwin set these on Windows
+	if getenv("SHELL") != "" || getenv("MSYSTEM") != "" {
+		return OSPosix
+	}
+	// Set by cmd.exe, and inherited by PowerShell
+	if getenv("ComSpec") != "" {
+		return OSWindows
+	}
+	return ""
+}
+
+// DetectOS returns the os flag value for the shell that runs configu,
+// i.e. the parent process, falling back to the env.
+// The compiled x-platform config is used if detection fails
+func DetectOS() string {
+	if detected := shellOS(parentProcessName()); detected != "" {
+		return detected
+	}
+	if detected := envOS(os.Getenv); detected != "" {
+		return detected
+	}
+	return OSOther
+}
+
 // .............................................................................
 // Windows
 
 // Note the difference between set and setx
 // https://superuser.com/a/916652/537059
 const WindowsExportFormat = "set %v=%v"
-const WindowsUnsetFormat = "set %v=\"\""
+
+// WindowsUnsetFormat removes the var,
+// note that set APP_FOO="" sets the value to a pair of quotes
+const WindowsUnsetFormat = "set %v="
+
 const WindowsLineBreak = "\r\n"
 
+// .............................................................................
+// PowerShell
+
+// PowerShellExportFormat expects the value to be escaped,
+// see PowerShellEscape
+const PowerShellExportFormat = "$env:%v = \"%v\""
+const PowerShellUnsetFormat = "Remove-Item Env:%v"
+
+// PowerShellEscape escapes special characters for use inside
+// double-quoted strings, i.e. backtick, double quote, and dollar sign
+// https://learn.microsoft.com/en-us/powershell/module/microsoft.powershell.core/about/about_quoting_rules
+func PowerShellEscape(s string) string {
+	return powerShellReplacer.Replace(s)
+}
+
+var powerShellReplacer = strings.NewReplacer(
+	"`", "``",
+	"\"", "`\"",
+	"$", "`$",
+)
+
+// .............................................................................
+// Fish
+
+// FishExportFormat expects the value to be escaped, see FishEscape.
+// Fish doesn't have export or unset
+// https://fishshell.com/docs/current/cmds/set.html
+const FishExportFormat = "set -gx %v '%v'"
+const FishUnsetFormat = "set -e %v"
+
+// FishEscape escapes special characters for use inside
+// single-quoted strings, i.e. backslash and single quote
+// https://fishshell.com/docs/current/language.html#quotes
+func FishEscape(s string) string {
+	return fishReplacer.Replace(s)
+}
+
+var fishReplacer = strings.NewReplacer(
+	"\\", "\\\\",
+	"'", "\\'",
+)
+
 // .............................................................................
 // Other
 
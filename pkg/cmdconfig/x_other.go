@@ -3,6 +3,31 @@
 
 package cmdconfig
 
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
 const ExportFormat = OtherExportFormat
 const UnsetFormat = OtherUnsetFormat
 const LineBreak = OtherLineBreak
+
+// parentProcessName returns the name of the parent process,
+// or an empty string if it can't be determined
+func parentProcessName() string {
+	ppid := strconv.Itoa(os.Getppid())
+	// Linux
+	b, err := os.ReadFile(filepath.Join("/proc", ppid, "comm"))
+	if err == nil {
+		return strings.TrimSpace(string(b))
+	}
+	// macOS and other systems without procfs
+	b, err = exec.Command("ps", "-o", "comm=", "-p", ppid).Output()
+	if err == nil {
+		return strings.TrimSpace(string(b))
+	}
+	return ""
+}
@@ -0,0 +1,59 @@
+package cmdconfig
+
+import (
+	"testing"
+
+	"github.com/mozey/config/pkg/testutil"
+)
+
+func TestShellOS(t *testing.T) {
+	is := testutil.Setup(t)
+
+	is.Equal(OSPosix, shellOS("bash"))
+	is.Equal(OSPosix, shellOS("-zsh"))
+	is.Equal(OSPosix, shellOS("/bin/sh"))
+	is.Equal(OSPosix, shellOS("bash.exe")) // Git Bash
+	is.Equal(OSFish, shellOS("/usr/bin/fish"))
+	is.Equal(OSPowerShell, shellOS("pwsh"))
+	is.Equal(OSPowerShell, shellOS("PowerShell.exe"))
+	is.Equal(OSWindows, shellOS("cmd.exe"))
+	is.Equal("", shellOS("go"))
+	is.Equal("", shellOS(""))
+}
+
+func TestEnvOS(t *testing.T) {
+	is := testutil.Setup(t)
+
+	env := func(m map[string]string) func(string) string {
+		return func(key string) string { return m[key] }
+	}
+	is.Equal(OSPosix, envOS(env(map[string]string{"SHELL": "/bin/bash"})))
+	is.Equal(OSPosix, envOS(env(map[string]string{
+		"MSYSTEM": "MINGW64", "ComSpec": `C:\Windows\system32\cmd.exe`})))
+	is.Equal(OSWindows, envOS(env(map[string]string{
+		"ComSpec": `C:\Windows\system32\cmd.exe`})))
+	is.Equal("", envOS(env(map[string]string{})))
+}
+
+func TestSetEnvOS(t *testing.T) {
+	is := testutil.Setup(t)
+
+	t.Setenv("APP_DIR", "/tmp")
+	in := &CmdIn{}
+	in.Prefix = "APP_"
+	in.OS = OSPowerShell
+	is.NoErr(in.Valid())
+	// Flag overrides detection
+	is.Equal(OSPowerShell, in.OS)
+
+	in.OS = ""
+	is.NoErr(in.Valid())
+	is.True(in.OS != "")
+}
+
+func TestFishEscape(t *testing.T) {
+	is := testutil.Setup(t)
+
+	is.Equal(`it\'s $HOME`, FishEscape("it's $HOME"))
+	is.Equal(`C:\\dir\\`, FishEscape(`C:\dir\`))
+}
@@ -3,6 +3,36 @@
 
 package cmdconfig
 
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
 const ExportFormat = WindowsExportFormat
 const UnsetFormat = WindowsUnsetFormat
 const LineBreak = WindowsLineBreak
+
+// parentProcessName returns the name of the parent process,
+// or an empty string if it can't be determined
+func parentProcessName() string {
+	ppid := uint32(os.Getppid())
+	snapshot, err := syscall.CreateToolhelp32Snapshot(
+		syscall.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return ""
+	}
+	defer (func() {
+		_ = syscall.CloseHandle(snapshot)
+	})()
+	var entry syscall.ProcessEntry32
+	entry.Size = uint32(unsafe.Sizeof(entry))
+	err = syscall.Process32First(snapshot, &entry)
+	for err == nil {
+		if entry.ProcessID == ppid {
+			return syscall.UTF16ToString(entry.ExeFile[:])
+		}
+		err = syscall.Process32Next(snapshot, &entry)
+	}
+	return ""
+}
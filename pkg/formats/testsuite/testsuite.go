@@ -0,0 +1,205 @@
+// Package testsuite is the conformance suite for config file formats.
+//
+// Config files have a flat key value structure, and are written by
+// configu, e.g. when a key is set, and read by generated code. Each format
+// must read back the exact values it writes, so call Run from a test for
+// every format, including formats implemented outside this module:
+//
+//	func TestConformance(t *testing.T) {
+//		testsuite.Run(t, testsuite.Format{
+//			Name:      "toml",
+//			Marshal:   marshalTOML,
+//			Unmarshal: unmarshalTOML,
+//			Multiline: true,
+//		})
+//	}
+package testsuite
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Format to test
+type Format struct {
+	// Name of the format, e.g. the file extension without the dot
+	Name string
+	// Marshal config to file contents
+	Marshal func(config map[string]string) ([]byte, error)
+	// Unmarshal file contents to config
+	Unmarshal func(b []byte) (map[string]string, error)
+	// Multiline is set if values may contain newlines,
+	// otherwise Marshal must return an error for those values,
+	// instead of writing a file that reads back a different value
+	Multiline bool
+}
+
+// Values that must survive the round trip, by test name
+var (
+	Common = map[string]string{
+		"APP_HOST":     "example.com",
+		"APP_PORT":     "8080",
+		"APP_URL":      "https://example.com/path?a=1&b=2#frag",
+		"APP_TEMPLATE": "{{.APP_HOST}}:{{.APP_PORT}}",
+		"APP_EXPORT":   "export",
+	}
+	Escaping = map[string]string{
+		"APP_EMPTY":     "",
+		"APP_SPACE":     "foo bar",
+		"APP_LEADING":   "  foo",
+		"APP_TRAILING":  "foo  ",
+		"APP_TAB":       "foo\tbar",
+		"APP_QUOTED":    `"foo"`,
+		"APP_QUOTE":     `"`,
+		"APP_SINGLE":    `it's`,
+		"APP_HASH":      "foo # bar",
+		"APP_EQUALS":    "a=b=c",
+		"APP_BACKSLASH": `C:\path\to\file`,
+		"APP_DOLLAR":    "$HOME/${USER}",
+		"APP_SUBSHELL":  "$(id) `id`",
+		"APP_ESCAPED":   `it'\''s`,
+		"APP_COLON":     "key: value",
+		"APP_BRACKETS":  "[1, 2] {a: b}",
+	}
+	// Scalars other formats may parse as another type
+	Scalars = map[string]string{
+		"APP_TRUE":   "true",
+		"APP_YES":    "yes",
+		"APP_NULL":   "null",
+		"APP_TILDE":  "~",
+		"APP_HEX":    "0x10",
+		"APP_OCTAL":  "0123",
+		"APP_FLOAT":  "1e3",
+		"APP_NUMBER": "1.50",
+	}
+	Unicode = map[string]string{
+		"APP_ACCENT": "café",
+		"APP_CJK":    "日本語",
+		"APP_EMOJI":  "🚀",
+		"APP_RTL":    "مرحبا",
+		"APP_ESCAPE": "\u00e9\u200b",
+	}
+	Multiline = map[string]string{
+		"APP_LINES": "foo\nbar",
+		"APP_CRLF":  "foo\r\nbar",
+		"APP_CERT":  "-----BEGIN-----\nMIIB\n-----END-----\n",
+	}
+)
+
+// Run the conformance tests for f as subtests of t
+func Run(t *testing.T, f Format) {
+	t.Run(f.Name, func(t *testing.T) {
+		t.Run("RoundTrip", func(t *testing.T) {
+			roundTrip(t, f, Common)
+		})
+		t.Run("Escaping", func(t *testing.T) {
+			roundTrip(t, f, Escaping)
+		})
+		t.Run("Scalars", func(t *testing.T) {
+			roundTrip(t, f, Scalars)
+		})
+		t.Run("Unicode", func(t *testing.T) {
+			roundTrip(t, f, Unicode)
+		})
+		t.Run("Empty", func(t *testing.T) {
+			roundTrip(t, f, map[string]string{})
+		})
+		t.Run("Ordering", func(t *testing.T) {
+			ordering(t, f)
+		})
+		t.Run("Multiline", func(t *testing.T) {
+			if f.Multiline {
+				roundTrip(t, f, Multiline)
+				return
+			}
+			for key, value := range Multiline {
+				_, err := f.Marshal(map[string]string{key: value})
+				if err == nil {
+					t.Errorf("%s: expected error for newlines in %s", f.Name, key)
+				}
+			}
+		})
+	})
+}
+
+// roundTrip checks config unmarshals to the same values after marshalling,
+// one key at a time, and all keys together
+func roundTrip(t *testing.T, f Format, config map[string]string) {
+	t.Helper()
+	for key, value := range config {
+		check(t, f, map[string]string{key: value})
+	}
+	check(t, f, config)
+}
+
+func check(t *testing.T, f Format, config map[string]string) {
+	t.Helper()
+	b, err := f.Marshal(config)
+	if err != nil {
+		t.Errorf("%s: marshal %v: %v", f.Name, keys(config), err)
+		return
+	}
+	actual, err := f.Unmarshal(b)
+	if err != nil {
+		t.Errorf("%s: unmarshal %q: %v", f.Name, b, err)
+		return
+	}
+	if len(actual) != len(config) {
+		t.Errorf("%s: expected keys %v, got %v from %q",
+			f.Name, keys(config), keys(actual), b)
+	}
+	for key, value := range config {
+		if actual[key] != value {
+			t.Errorf("%s: %s expected %q, got %q from %q",
+				f.Name, key, value, actual[key], b)
+		}
+	}
+}
+
+// ordering checks keys are written in sorted order,
+// and the output doesn't depend on map iteration order
+func ordering(t *testing.T, f Format) {
+	t.Helper()
+	config := make(map[string]string)
+	for i := 0; i < 50; i++ {
+		config[fmt.Sprintf("APP_KEY_%02d", i)] = fmt.Sprintf("value %d", i)
+	}
+	first, err := f.Marshal(config)
+	if err != nil {
+		t.Errorf("%s: marshal: %v", f.Name, err)
+		return
+	}
+	for i := 0; i < 10; i++ {
+		b, err := f.Marshal(config)
+		if err != nil {
+			t.Errorf("%s: marshal: %v", f.Name, err)
+			return
+		}
+		if !bytes.Equal(first, b) {
+			t.Errorf("%s: output is not deterministic", f.Name)
+			return
+		}
+	}
+	last := -1
+	for _, key := range keys(config) {
+		i := strings.Index(string(first), key)
+		if i < last {
+			t.Errorf("%s: %s is not sorted in %q", f.Name, key, first)
+			return
+		}
+		last = i
+	}
+}
+
+// keys returns the sorted keys in config
+func keys(config map[string]string) []string {
+	a := make([]string, 0, len(config))
+	for key := range config {
+		a = append(a, key)
+	}
+	sort.Strings(a)
+	return a
+}
@@ -0,0 +1,25 @@
+package testsuite_test
+
+import (
+	"testing"
+
+	"github.com/mozey/config/pkg/api"
+	"github.com/mozey/config/pkg/formats/testsuite"
+)
+
+func TestFormats(t *testing.T) {
+	for _, format := range api.Formats() {
+		format := format
+		testsuite.Run(t, testsuite.Format{
+			Name: format,
+			Marshal: func(config map[string]string) ([]byte, error) {
+				return api.Marshal(config, format)
+			},
+			Unmarshal: func(b []byte) (map[string]string, error) {
+				return api.Unmarshal(b, format)
+			},
+			// Values in env and sh files must be on a single line
+			Multiline: format == api.FormatJSON || format == api.FormatYAML,
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package share
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// DeprecationOutput is where WarnDeprecated writes warnings,
+// set it to io.Discard to silence the warnings
+var DeprecationOutput io.Writer = os.Stderr
+
+// deprecationWarned tracks keys already warned about
+var deprecationWarned sync.Map
+
+// WarnDeprecated is called by generated getters for deprecated keys.
+// The warning is only printed once per key, since getters may be called often
+func WarnDeprecated(key, replacement string) {
+	if _, warned := deprecationWarned.LoadOrStore(key, true); warned {
+		return
+	}
+	if replacement == "" {
+		_, _ = fmt.Fprintf(DeprecationOutput,
+			"WARNING %s is deprecated\n", key)
+		return
+	}
+	_, _ = fmt.Fprintf(DeprecationOutput,
+		"WARNING %s is deprecated, use %s\n", key, replacement)
+}
@@ -0,0 +1,25 @@
+package share
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestWarnDeprecated(t *testing.T) {
+	is := is.New(t)
+
+	buf := new(bytes.Buffer)
+	DeprecationOutput = buf
+	defer (func() {
+		DeprecationOutput = os.Stderr
+	})()
+
+	WarnDeprecated("DEPRECATED_TEST_OLD", "DEPRECATED_TEST_NEW")
+	WarnDeprecated("DEPRECATED_TEST_OLD", "DEPRECATED_TEST_NEW")
+	WarnDeprecated("DEPRECATED_TEST_GONE", "")
+	is.Equal("WARNING DEPRECATED_TEST_OLD is deprecated, use DEPRECATED_TEST_NEW\n"+
+		"WARNING DEPRECATED_TEST_GONE is deprecated\n", buf.String())
+}
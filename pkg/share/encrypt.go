@@ -0,0 +1,92 @@
+package share
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// EncryptionKeyEnvKey is the env var for the key used to encrypt
+// individual values in config files, see EncryptValue
+const EncryptionKeyEnvKey = "CONFIGU_ENCRYPTION_KEY"
+
+// EncryptedPrefix marks encrypted values,
+// the version is incremented for incompatible changes
+const EncryptedPrefix = "enc:v1:"
+
+// Encrypted returns true if the value was encrypted with EncryptValue
+func Encrypted(value string) bool {
+	return strings.HasPrefix(value, EncryptedPrefix)
+}
+
+// EncryptionKeySize is the size of the decoded encryption key in bytes
+const EncryptionKeySize = 32
+
+// valueCipher returns the cipher for the encryption key. The key must be
+// random bytes, base64 encoded, e.g. "openssl rand -base64 32".
+// Passphrases are not accepted, they are easier to guess
+func valueCipher(key string) (cipher.AEAD, error) {
+	if key == "" {
+		return nil, errors.Errorf("%s is not set", EncryptionKeyEnvKey)
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil || len(b) != EncryptionKeySize {
+		return nil, errors.Errorf(
+			"%s must be %d random bytes, base64 encoded, "+
+				"e.g. openssl rand -base64 %d",
+			EncryptionKeyEnvKey, EncryptionKeySize, EncryptionKeySize)
+	}
+	block, err := aes.NewCipher(b)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return aead, nil
+}
+
+// EncryptValue encrypts value with AES-GCM, the result is the
+// EncryptedPrefix followed by the base64 encoded nonce and ciphertext
+func EncryptValue(key, value string) (string, error) {
+	aead, err := valueCipher(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	b := aead.Seal(nonce, nonce, []byte(value), nil)
+	return EncryptedPrefix + base64.StdEncoding.EncodeToString(b), nil
+}
+
+// DecryptValue returns the plaintext for a value encrypted with
+// EncryptValue, values that are not encrypted are returned as is
+func DecryptValue(key, value string) (string, error) {
+	if !Encrypted(value) {
+		return value, nil
+	}
+	aead, err := valueCipher(key)
+	if err != nil {
+		return "", err
+	}
+	b, err := base64.StdEncoding.DecodeString(
+		strings.TrimPrefix(value, EncryptedPrefix))
+	if err != nil || len(b) < aead.NonceSize() {
+		return "", errors.Errorf("invalid encrypted value")
+	}
+	nonce, ciphertext := b[:aead.NonceSize()], b[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", errors.Errorf("encrypted value can't be decrypted, check %s",
+			EncryptionKeyEnvKey)
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,59 @@
+package share
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestEncryptValue(t *testing.T) {
+	is := is.New(t)
+
+	key := base64.StdEncoding.EncodeToString(
+		bytes.Repeat([]byte{1}, EncryptionKeySize))
+	other := base64.StdEncoding.EncodeToString(
+		bytes.Repeat([]byte{2}, EncryptionKeySize))
+
+	encrypted, err := EncryptValue(key, "s3cret")
+	is.NoErr(err)
+	is.True(Encrypted(encrypted))
+	is.True(!strings.Contains(encrypted, "s3cret"))
+
+	// Nonce is random
+	again, err := EncryptValue(key, "s3cret")
+	is.NoErr(err)
+	is.True(encrypted != again)
+
+	value, err := DecryptValue(key, encrypted)
+	is.NoErr(err)
+	is.Equal("s3cret", value)
+
+	// Wrong key
+	_, err = DecryptValue(other, encrypted)
+	is.True(err != nil)
+	_, err = DecryptValue("", encrypted)
+	is.True(err != nil)
+	_, err = EncryptValue("", "s3cret")
+	is.True(err != nil)
+
+	// Passphrases and keys of the wrong size are not accepted
+	_, err = EncryptValue("passphrase", "s3cret")
+	is.True(err != nil)
+	_, err = EncryptValue(base64.StdEncoding.EncodeToString(
+		[]byte("too short")), "s3cret")
+	is.True(err != nil)
+
+	// Tampered
+	_, err = DecryptValue(key, encrypted[:len(encrypted)-4]+"AAAA")
+	is.True(err != nil)
+	_, err = DecryptValue(key, EncryptedPrefix+"!")
+	is.True(err != nil)
+
+	// Plain values are returned as is
+	value, err = DecryptValue("", "plain")
+	is.NoErr(err)
+	is.Equal("plain", value)
+}
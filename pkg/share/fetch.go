@@ -0,0 +1,86 @@
+package share
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TokenEnvKey is the env var for the optional bearer token,
+// used by the generated LoadURL func, see FetchConfig
+const TokenEnvKey = "CONFIGU_TOKEN"
+
+// fetchTimeout limits the time taken to fetch remote config
+const fetchTimeout = 30 * time.Second
+
+// httpClient for fetching remote config,
+// it's a variable so tests can use a client that trusts the test server
+var httpClient = &http.Client{Timeout: fetchTimeout}
+
+// contentTypes maps media types to file types
+var contentTypes = map[string]string{
+	"application/json":   FileTypeJSON,
+	"application/yaml":   FileTypeYAML,
+	"application/x-yaml": FileTypeYAML,
+	"text/yaml":          FileTypeYAML,
+	"text/x-yaml":        FileTypeYAML,
+}
+
+// FetchConfig fetches a JSON or YAML config file over HTTPS.
+// The format is determined by the extension of the URL path,
+// or by the content type of the response, defaulting to JSON.
+// The token is optional, if not empty it's used as the bearer token
+func FetchConfig(rawURL string, token string) (
+	configMap map[string]string, err error) {
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return configMap, errors.WithStack(err)
+	}
+	if u.Scheme != "https" {
+		return configMap, errors.Errorf("config URL must use https %s", rawURL)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return configMap, errors.WithStack(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return configMap, errors.WithStack(err)
+	}
+	defer (func() {
+		_ = resp.Body.Close()
+	})()
+	if resp.StatusCode != http.StatusOK {
+		return configMap, errors.Errorf(
+			"fetch config %s %s", u.Redacted(), resp.Status)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return configMap, errors.WithStack(err)
+	}
+
+	fileType := path.Ext(u.Path)
+	if fileType != FileTypeJSON && fileType != FileTypeYAML {
+		fileType = FileTypeJSON
+		mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		if err == nil {
+			if t, ok := contentTypes[mediaType]; ok {
+				fileType = t
+			}
+		}
+	}
+
+	// UnmarshalConfig uses the extension of the path
+	return UnmarshalConfig(fmt.Sprintf("config%s", fileType), b)
+}
@@ -0,0 +1,66 @@
+package share
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+// stubHTTPClient uses the test server client for the duration of the test
+func stubHTTPClient(t *testing.T, server *httptest.Server) {
+	original := httpClient
+	httpClient = server.Client()
+	t.Cleanup(func() {
+		httpClient = original
+	})
+}
+
+func TestFetchConfig(t *testing.T) {
+	is := is.New(t)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			switch r.URL.Path {
+			case "/config.json":
+				_, _ = w.Write([]byte(`{"APP_FOO": "foo"}`))
+			case "/config.yaml":
+				_, _ = w.Write([]byte("APP_FOO: yaml\n"))
+			case "/config":
+				w.Header().Set("Content-Type", "application/yaml; charset=utf-8")
+				_, _ = w.Write([]byte("APP_FOO: content-type\n"))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+	defer server.Close()
+	stubHTTPClient(t, server)
+
+	m, err := FetchConfig(server.URL+"/config.json", "secret")
+	is.NoErr(err)
+	is.Equal("foo", m["APP_FOO"])
+
+	m, err = FetchConfig(server.URL+"/config.yaml", "secret")
+	is.NoErr(err)
+	is.Equal("yaml", m["APP_FOO"])
+
+	m, err = FetchConfig(server.URL+"/config", "secret")
+	is.NoErr(err)
+	is.Equal("content-type", m["APP_FOO"])
+
+	// Token required
+	_, err = FetchConfig(server.URL+"/config.json", "")
+	is.True(err != nil)
+
+	_, err = FetchConfig(server.URL+"/missing.json", "secret")
+	is.True(err != nil)
+
+	// HTTPS required
+	_, err = FetchConfig("http://example.com/config.json", "")
+	is.True(err != nil)
+}
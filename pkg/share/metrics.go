@@ -0,0 +1,65 @@
+package share
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// ReloadMetrics about config reloads, recorded by the generated Watch funcs.
+// It's safe for concurrent use, and implements expvar.Var.
+// This package doesn't import expvar, since that registers a handler on
+// the default HTTP mux, publish the metrics where required, e.g.
+//
+//	expvar.Publish("config_reload", config.Reloads)
+type ReloadMetrics struct {
+	mu       sync.Mutex
+	snapshot ReloadSnapshot
+}
+
+// ReloadSnapshot of the metrics, times are unix seconds, zero if never
+type ReloadSnapshot struct {
+	// Reloads is the count of successful reloads
+	Reloads int64 `json:"reloads"`
+	// Errors is the count of failed reloads, e.g. invalid config files
+	Errors     int64 `json:"errors"`
+	LastReload int64 `json:"lastReload"`
+	// LastError is the message of the last failed reload,
+	// it's cleared by the next successful reload
+	LastError     string `json:"lastError"`
+	LastErrorTime int64  `json:"lastErrorTime"`
+}
+
+// Reloaded records a successful reload
+func (m *ReloadMetrics) Reloaded() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snapshot.Reloads++
+	m.snapshot.LastReload = time.Now().Unix()
+	m.snapshot.LastError = ""
+}
+
+// Failed records a failed reload
+func (m *ReloadMetrics) Failed(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snapshot.Errors++
+	m.snapshot.LastError = err.Error()
+	m.snapshot.LastErrorTime = time.Now().Unix()
+}
+
+// Snapshot returns a copy of the metrics
+func (m *ReloadMetrics) Snapshot() ReloadSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.snapshot
+}
+
+// String returns the metrics as JSON, as per expvar.Var
+func (m *ReloadMetrics) String() string {
+	b, err := json.Marshal(m.Snapshot())
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
@@ -0,0 +1,35 @@
+package share
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestReloadMetrics(t *testing.T) {
+	is := is.New(t)
+
+	m := &ReloadMetrics{}
+	var _ expvar.Var = m
+	is.Equal(ReloadSnapshot{}, m.Snapshot())
+
+	m.Failed(fmt.Errorf("invalid config"))
+	s := m.Snapshot()
+	is.Equal(int64(0), s.Reloads)
+	is.Equal(int64(1), s.Errors)
+	is.Equal("invalid config", s.LastError)
+	is.True(s.LastErrorTime > 0)
+	is.Equal(int64(0), s.LastReload)
+
+	// The error is cleared, but the count is kept
+	m.Reloaded()
+	s = ReloadSnapshot{}
+	is.NoErr(json.Unmarshal([]byte(m.String()), &s))
+	is.Equal(int64(1), s.Reloads)
+	is.Equal(int64(1), s.Errors)
+	is.Equal("", s.LastError)
+	is.True(s.LastReload > 0)
+}
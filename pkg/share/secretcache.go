@@ -0,0 +1,182 @@
+package share
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SecretCacheKeyEnvKey is the env var for the key used to encrypt the
+// on-disk secret cache. The cache is disabled if the env var is not set
+const SecretCacheKeyEnvKey = "CONFIGU_SECRET_CACHE_KEY"
+
+// SecretCacheTTLEnvKey is the env var for the time secrets are cached on disk,
+// e.g. "1h", defaults to defaultSecretCacheTTL
+const SecretCacheTTLEnvKey = "CONFIGU_SECRET_CACHE_TTL"
+
+// defaultSecretCacheTTL is short, so rotated secrets are picked up quickly
+const defaultSecretCacheTTL = 10 * time.Minute
+
+// secretCachePath returns the path of the cache file,
+// it's a variable so tests can use a temp dir
+var secretCachePath = func() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, "configu", "secrets.json"), nil
+}
+
+// secretCacheFile serializes reading and writing the cache file
+var secretCacheFile sync.Mutex
+
+// secretCacheEntry is an encrypted secret value
+type secretCacheEntry struct {
+	Expires time.Time `json:"expires"`
+	// Value is the base64 encoded nonce and ciphertext
+	Value string `json:"value"`
+}
+
+// secretDiskCache stores secret values encrypted with AES-GCM,
+// entries are keyed by the hash of the secret reference.
+// Errors are ignored, the secret is fetched if the cache can't be used
+type secretDiskCache struct {
+	aead cipher.AEAD
+	path string
+	ttl  time.Duration
+}
+
+// newSecretDiskCache returns the cache as per the env,
+// the cache is disabled if aead is nil
+func newSecretDiskCache() *secretDiskCache {
+	c := &secretDiskCache{ttl: defaultSecretCacheTTL}
+	key := os.Getenv(SecretCacheKeyEnvKey)
+	if key == "" {
+		return c
+	}
+	if ttl, err := time.ParseDuration(
+		os.Getenv(SecretCacheTTLEnvKey)); err == nil && ttl > 0 {
+		c.ttl = ttl
+	}
+	path, err := secretCachePath()
+	if err != nil {
+		return c
+	}
+	// Any string may be used as the key, the hash has the required length
+	hash := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(hash[:])
+	if err != nil {
+		return c
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return c
+	}
+	c.aead = aead
+	c.path = path
+	return c
+}
+
+// entryKey hashes the secret reference, so references are not stored
+func (c *secretDiskCache) entryKey(value string) string {
+	hash := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(hash[:])
+}
+
+func (c *secretDiskCache) read() map[string]secretCacheEntry {
+	entries := make(map[string]secretCacheEntry)
+	b, err := os.ReadFile(c.path)
+	if err != nil {
+		return entries
+	}
+	_ = json.Unmarshal(b, &entries)
+	return entries
+}
+
+// get returns the cached value for the secret reference,
+// expired entries and entries encrypted with another key are skipped
+func (c *secretDiskCache) get(value string) (string, bool) {
+	if c.aead == nil {
+		return "", false
+	}
+	secretCacheFile.Lock()
+	defer secretCacheFile.Unlock()
+
+	key := c.entryKey(value)
+	entry, ok := c.read()[key]
+	if !ok || time.Now().After(entry.Expires) {
+		return "", false
+	}
+	b, err := base64.StdEncoding.DecodeString(entry.Value)
+	if err != nil || len(b) < c.aead.NonceSize() {
+		return "", false
+	}
+	nonce, ciphertext := b[:c.aead.NonceSize()], b[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, ciphertext, []byte(key))
+	if err != nil {
+		return "", false
+	}
+	return string(plaintext), true
+}
+
+// set encrypts and stores the resolved value,
+// expired entries are removed when the file is written
+func (c *secretDiskCache) set(value, resolved string) {
+	if c.aead == nil {
+		return
+	}
+	secretCacheFile.Lock()
+	defer secretCacheFile.Unlock()
+
+	key := c.entryKey(value)
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return
+	}
+	b := c.aead.Seal(nonce, nonce, []byte(resolved), []byte(key))
+
+	now := time.Now()
+	entries := c.read()
+	for k, entry := range entries {
+		if now.After(entry.Expires) {
+			delete(entries, k)
+		}
+	}
+	entries[key] = secretCacheEntry{
+		Expires: now.Add(c.ttl),
+		Value:   base64.StdEncoding.EncodeToString(b),
+	}
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return
+	}
+	err = os.MkdirAll(filepath.Dir(c.path), 0700)
+	if err != nil {
+		return
+	}
+	// Write to a temp file first, other processes may be reading the cache.
+	// The temp file name is unique, other processes may be writing too.
+	// Files are created with mode 0600, see os.CreateTemp
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path))
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(b)
+	closeErr := tmp.Close()
+	if err != nil || closeErr != nil {
+		_ = os.Remove(tmp.Name())
+		return
+	}
+	err = os.Rename(tmp.Name(), c.path)
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+}
@@ -0,0 +1,89 @@
+package share
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// stubSecretCachePath enables the disk cache in a temp dir,
+// call it after stubSecretCmd
+func stubSecretCachePath(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	original := secretCachePath
+	secretCachePath = func() (string, error) {
+		return path, nil
+	}
+	t.Cleanup(func() {
+		secretCachePath = original
+	})
+	t.Setenv(SecretCacheKeyEnvKey, "cache key")
+	return path
+}
+
+func TestSecretDiskCache(t *testing.T) {
+	is := is.New(t)
+
+	calls := 0
+	stubSecretCmd(t, func(name string, args ...string) ([]byte, error) {
+		calls++
+		return []byte("s3cret"), nil
+	})
+	path := stubSecretCachePath(t)
+
+	ref := "gcpsm://projects/x/secrets/y"
+	value, err := ResolveSecret(ref)
+	is.NoErr(err)
+	is.Equal("s3cret", value)
+	is.Equal(1, calls)
+
+	// Neither the reference nor the value is stored in plain text
+	b, err := os.ReadFile(path)
+	is.NoErr(err)
+	is.True(!strings.Contains(string(b), "s3cret"))
+	is.True(!strings.Contains(string(b), "projects/x"))
+	info, err := os.Stat(path)
+	is.NoErr(err)
+	is.Equal(os.FileMode(0600), info.Mode().Perm())
+	// Temp files are renamed
+	entries, err := os.ReadDir(filepath.Dir(path))
+	is.NoErr(err)
+	is.Equal(1, len(entries))
+
+	// Another process reads the value from the disk cache
+	cache := newSecretDiskCache()
+	value, ok := cache.get(ref)
+	is.True(ok)
+	is.Equal("s3cret", value)
+
+	// Entries encrypted with another key are skipped
+	t.Setenv(SecretCacheKeyEnvKey, "other key")
+	_, ok = newSecretDiskCache().get(ref)
+	is.True(!ok)
+
+	// Expired entries are skipped
+	t.Setenv(SecretCacheKeyEnvKey, "cache key")
+	t.Setenv(SecretCacheTTLEnvKey, "1ns")
+	cache = newSecretDiskCache()
+	cache.set(ref, "s3cret")
+	time.Sleep(time.Millisecond)
+	_, ok = cache.get(ref)
+	is.True(!ok)
+}
+
+func TestSecretDiskCacheDisabled(t *testing.T) {
+	is := is.New(t)
+
+	path := stubSecretCachePath(t)
+	t.Setenv(SecretCacheKeyEnvKey, "")
+	cache := newSecretDiskCache()
+	cache.set("gcpsm://projects/x/secrets/y", "s3cret")
+	_, ok := cache.get("gcpsm://projects/x/secrets/y")
+	is.True(!ok)
+	_, err := os.Stat(path)
+	is.True(os.IsNotExist(err))
+}
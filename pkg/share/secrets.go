@@ -0,0 +1,284 @@
+package share
+
+import (
+	"bytes"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SchemeGCPSM is the scheme for GCP Secret Manager references,
+// e.g. gcpsm://projects/x/secrets/y or gcpsm://projects/x/secrets/y/versions/2
+const SchemeGCPSM = "gcpsm"
+
+// SchemeAzureKV is the scheme for Azure Key Vault references,
+// e.g. azkv://vault/secret or azkv://vault/secret/version
+const SchemeAzureKV = "azkv"
+
+// SchemeHelper is the scheme for values fetched with a credential helper,
+// e.g. helper://vault/db-password runs "configu-helper-vault get db-password"
+const SchemeHelper = "helper"
+
+// HelperPrefix of credential helper commands, only commands with this prefix
+// are run, so config files can't run arbitrary commands
+const HelperPrefix = "configu-helper-"
+
+// helperName is a valid credential helper name
+var helperName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// schemeSep separates the scheme from the secret reference
+const schemeSep = "://"
+
+// SecretResolver returns the secret value for the reference,
+// ref is the value without the scheme prefix
+type SecretResolver func(ref string) (value string, err error)
+
+// secretResolvers by scheme
+var secretResolvers = map[string]SecretResolver{
+	SchemeGCPSM:   resolveGCPSM,
+	SchemeAzureKV: resolveAzureKV,
+	SchemeHelper:  resolveHelper,
+}
+
+// SecretSchemes returns the sorted list of supported secret schemes
+func SecretSchemes() []string {
+	schemes := make([]string, 0, len(secretResolvers))
+	for scheme := range secretResolvers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// SecretRef returns the scheme and reference if value is a secret reference
+func SecretRef(value string) (scheme, ref string, ok bool) {
+	scheme, ref, found := strings.Cut(value, schemeSep)
+	if !found {
+		return "", "", false
+	}
+	if _, ok := secretResolvers[scheme]; !ok {
+		return "", "", false
+	}
+	return scheme, ref, true
+}
+
+// secretCall is an in-flight fetch, callers for the same reference
+// wait for done instead of fetching the secret again
+type secretCall struct {
+	done  chan struct{}
+	value string
+	err   error
+}
+
+// secretCache maps secret references to resolved values,
+// secrets are fetched once per process
+var secretCache = struct {
+	sync.Mutex
+	values map[string]string
+	calls  map[string]*secretCall
+}{
+	values: make(map[string]string),
+	calls:  make(map[string]*secretCall),
+}
+
+// ResolveSecret returns the secret value for a secret reference,
+// values that are not secret references are returned as is.
+// Concurrent calls for the same reference share a single fetch
+func ResolveSecret(value string) (string, error) {
+	scheme, ref, ok := SecretRef(value)
+	if !ok {
+		return value, nil
+	}
+
+	secretCache.Lock()
+	if resolved, ok := secretCache.values[value]; ok {
+		secretCache.Unlock()
+		return resolved, nil
+	}
+	if call, ok := secretCache.calls[value]; ok {
+		secretCache.Unlock()
+		<-call.done
+		return call.value, call.err
+	}
+	call := &secretCall{done: make(chan struct{})}
+	secretCache.calls[value] = call
+	secretCache.Unlock()
+
+	call.value, call.err = fetchSecret(scheme, ref, value)
+
+	secretCache.Lock()
+	delete(secretCache.calls, value)
+	if call.err == nil {
+		secretCache.values[value] = call.value
+	}
+	secretCache.Unlock()
+	close(call.done)
+
+	return call.value, call.err
+}
+
+// fetchSecret uses the disk cache if enabled, see SecretCacheKeyEnvKey,
+// otherwise the secret is fetched with the resolver for the scheme
+func fetchSecret(scheme, ref, value string) (string, error) {
+	cache := newSecretDiskCache()
+	if resolved, ok := cache.get(value); ok {
+		return resolved, nil
+	}
+	resolved, err := secretResolvers[scheme](ref)
+	if err != nil {
+		return "", err
+	}
+	cache.set(value, resolved)
+	return resolved, nil
+}
+
+// secretWorkers limits the number of secrets fetched concurrently
+const secretWorkers = 8
+
+// ResolveSecrets replaces secret references in configMap with secret values,
+// and returns the sorted list of keys that were resolved.
+// Secrets are fetched concurrently, configMap is not changed on error
+func ResolveSecrets(configMap map[string]string) (keys []string, err error) {
+	keys = make([]string, 0)
+	for key, value := range configMap {
+		if _, _, ok := SecretRef(value); ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	values := make([]string, len(keys))
+	errs := make([]error, len(keys))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(secretWorkers, len(keys)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				values[i], errs[i] = ResolveSecret(configMap[keys[i]])
+			}
+		}()
+	}
+	for i := range keys {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	// Errors are reported in key order, regardless of which fetch failed first
+	for i, key := range keys {
+		if errs[i] != nil {
+			return keys[:0], errors.WithMessagef(errs[i], "resolving %s", key)
+		}
+	}
+	for i, key := range keys {
+		configMap[key] = values[i]
+	}
+	return keys, nil
+}
+
+// secretRetries is the number of attempts for failed secret commands
+const secretRetries = 3
+
+// secretBackoff is the delay before the first retry, doubled for each retry.
+// It's a variable so tests don't have to wait
+var secretBackoff = 250 * time.Millisecond
+
+// runSecretCmd runs secretCmd, and retries with backoff if it fails,
+// e.g. the backend may be rate limiting requests
+func runSecretCmd(name string, args ...string) (b []byte, err error) {
+	backoff := secretBackoff
+	for i := 0; i < secretRetries; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		b, err = secretCmd(name, args...)
+		if err == nil {
+			return b, nil
+		}
+	}
+	return b, errors.WithMessagef(err, "failed after %d attempts", secretRetries)
+}
+
+// secretCmd runs a CLI command and returns stdout,
+// it's a variable so tests can stub it
+var secretCmd = func(name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	b, err := cmd.Output()
+	if err != nil {
+		return b, errors.Wrapf(err, "%s %s",
+			name, strings.TrimSpace(stderr.String()))
+	}
+	return b, nil
+}
+
+// resolveGCPSM fetches the secret with the gcloud CLI,
+// the latest version is used if the reference does not specify a version
+func resolveGCPSM(ref string) (value string, err error) {
+	// projects/x/secrets/y[/versions/v]
+	parts := strings.Split(strings.Trim(ref, "/"), "/")
+	if (len(parts) != 4 && len(parts) != 6) ||
+		parts[0] != "projects" || parts[2] != "secrets" ||
+		(len(parts) == 6 && parts[4] != "versions") {
+		return value, errors.Errorf("invalid %s reference %s", SchemeGCPSM, ref)
+	}
+	version := "latest"
+	if len(parts) == 6 {
+		version = parts[5]
+	}
+	b, err := runSecretCmd("gcloud", "secrets", "versions", "access", version,
+		"--secret", parts[3], "--project", parts[1])
+	if err != nil {
+		return value, err
+	}
+	return string(b), nil
+}
+
+// resolveAzureKV fetches the secret with the az CLI,
+// the latest version is used if the reference does not specify a version
+func resolveAzureKV(ref string) (value string, err error) {
+	// vault/secret[/version]
+	parts := strings.Split(strings.Trim(ref, "/"), "/")
+	if len(parts) != 2 && len(parts) != 3 {
+		return value, errors.Errorf(
+			"invalid %s reference %s", SchemeAzureKV, ref)
+	}
+	args := []string{"keyvault", "secret", "show",
+		"--vault-name", parts[0], "--name", parts[1]}
+	if len(parts) == 3 {
+		args = append(args, "--version", parts[2])
+	}
+	args = append(args, "--query", "value", "--output", "tsv")
+	b, err := runSecretCmd("az", args...)
+	if err != nil {
+		return value, err
+	}
+	// Output ends with a newline
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// resolveHelper runs the credential helper with the get command,
+// the helper prints the value to stdout, a trailing newline is removed.
+// The helper must exit with a non-zero code if the value is not found
+func resolveHelper(ref string) (value string, err error) {
+	// name/ref
+	name, helperRef, _ := strings.Cut(strings.Trim(ref, "/"), "/")
+	if !helperName.MatchString(name) || helperRef == "" {
+		return value, errors.Errorf(
+			"invalid %s reference %s", SchemeHelper, ref)
+	}
+	b, err := runSecretCmd(HelperPrefix+name, "get", helperRef)
+	if err != nil {
+		return value, err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
@@ -0,0 +1,275 @@
+package share
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/pkg/errors"
+)
+
+// stubSecretCmd replaces secretCmd for the duration of the test.
+// The disk cache is disabled, and the user cache dir is a temp dir,
+// so the user's secret cache is not used, see stubSecretCachePath
+func stubSecretCmd(t *testing.T,
+	fn func(name string, args ...string) ([]byte, error)) {
+	tmp := t.TempDir()
+	t.Setenv(SecretCacheKeyEnvKey, "")
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(tmp, "cache"))
+	t.Setenv("HOME", tmp)
+	original := secretCmd
+	secretCmd = fn
+	backoff := secretBackoff
+	secretBackoff = 0
+	t.Cleanup(func() {
+		secretCmd = original
+		secretBackoff = backoff
+		secretCache.Lock()
+		secretCache.values = make(map[string]string)
+		secretCache.Unlock()
+	})
+}
+
+func TestSecretRef(t *testing.T) {
+	is := is.New(t)
+
+	scheme, ref, ok := SecretRef("gcpsm://projects/x/secrets/y")
+	is.True(ok)
+	is.Equal(SchemeGCPSM, scheme)
+	is.Equal("projects/x/secrets/y", ref)
+
+	scheme, _, ok = SecretRef("azkv://vault/secret")
+	is.True(ok)
+	is.Equal(SchemeAzureKV, scheme)
+
+	scheme, _, ok = SecretRef("helper://vault/secret")
+	is.True(ok)
+	is.Equal(SchemeHelper, scheme)
+
+	_, _, ok = SecretRef("https://example.com")
+	is.True(!ok)
+	_, _, ok = SecretRef("gcpsm")
+	is.True(!ok)
+}
+
+func TestResolveSecretsGCPSM(t *testing.T) {
+	is := is.New(t)
+
+	// Secrets are fetched concurrently
+	var mu sync.Mutex
+	calls := make([]string, 0)
+	stubSecretCmd(t, func(name string, args ...string) ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, strings.Join(append([]string{name}, args...), " "))
+		return []byte("s3cret"), nil
+	})
+
+	configMap := map[string]string{
+		"APP_FOO":      "foo",
+		"APP_PASSWORD": "gcpsm://projects/x/secrets/y",
+		"APP_TOKEN":    "gcpsm://projects/x/secrets/z/versions/2",
+		"APP_PASS":     "gcpsm://projects/x/secrets/y",
+	}
+	keys, err := ResolveSecrets(configMap)
+	is.NoErr(err)
+	is.Equal([]string{"APP_PASS", "APP_PASSWORD", "APP_TOKEN"}, keys)
+	is.Equal("foo", configMap["APP_FOO"])
+	is.Equal("s3cret", configMap["APP_PASSWORD"])
+	is.Equal("s3cret", configMap["APP_PASS"])
+
+	// Secrets are cached
+	is.Equal(2, len(calls))
+	for _, call := range calls {
+		if strings.Contains(call, "--secret y") {
+			is.Equal("gcloud secrets versions access latest "+
+				"--secret y --project x", call)
+		} else {
+			is.Equal("gcloud secrets versions access 2 "+
+				"--secret z --project x", call)
+		}
+	}
+}
+
+func TestResolveSecretsAzureKV(t *testing.T) {
+	is := is.New(t)
+
+	calls := make([]string, 0)
+	stubSecretCmd(t, func(name string, args ...string) ([]byte, error) {
+		calls = append(calls, strings.Join(append([]string{name}, args...), " "))
+		return []byte("s3cret\n"), nil
+	})
+
+	value, err := ResolveSecret("azkv://my-vault/db-password")
+	is.NoErr(err)
+	is.Equal("s3cret", value)
+	value, err = ResolveSecret("azkv://my-vault/db-password/abc123")
+	is.NoErr(err)
+	is.Equal("s3cret", value)
+	is.Equal([]string{
+		"az keyvault secret show --vault-name my-vault --name db-password " +
+			"--query value --output tsv",
+		"az keyvault secret show --vault-name my-vault --name db-password " +
+			"--version abc123 --query value --output tsv",
+	}, calls)
+
+	_, err = ResolveSecret("azkv://my-vault")
+	is.True(err != nil)
+}
+
+func TestResolveSecretsHelper(t *testing.T) {
+	is := is.New(t)
+
+	calls := make([]string, 0)
+	stubSecretCmd(t, func(name string, args ...string) ([]byte, error) {
+		calls = append(calls, strings.Join(append([]string{name}, args...), " "))
+		return []byte("s3cret\n"), nil
+	})
+
+	value, err := ResolveSecret("helper://my-store/APP_DB_PASSWORD")
+	is.NoErr(err)
+	is.Equal("s3cret", value)
+	value, err = ResolveSecret("helper://my-store/team/db/password")
+	is.NoErr(err)
+	is.Equal("s3cret", value)
+	is.Equal([]string{
+		"configu-helper-my-store get APP_DB_PASSWORD",
+		"configu-helper-my-store get team/db/password",
+	}, calls)
+
+	// Helper names can't be paths
+	_, err = ResolveSecret("helper://../bin/sh/x")
+	is.True(err != nil)
+	_, err = ResolveSecret("helper://my-store")
+	is.True(err != nil)
+	is.Equal(2, len(calls))
+}
+
+func TestResolveSecretsError(t *testing.T) {
+	is := is.New(t)
+
+	stubSecretCmd(t, func(name string, args ...string) ([]byte, error) {
+		return nil, errors.Errorf("permission denied")
+	})
+
+	_, err := ResolveSecrets(map[string]string{
+		"APP_TOKEN": "gcpsm://projects/x/secrets/y",
+	})
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "APP_TOKEN"))
+
+	// Invalid reference
+	_, err = ResolveSecret("gcpsm://projects/x/y")
+	is.True(err != nil)
+}
+
+func TestResolveSecretRetry(t *testing.T) {
+	is := is.New(t)
+
+	calls := 0
+	stubSecretCmd(t, func(name string, args ...string) ([]byte, error) {
+		calls++
+		if calls < secretRetries {
+			return nil, errors.Errorf("rate limit exceeded")
+		}
+		return []byte("s3cret"), nil
+	})
+
+	value, err := ResolveSecret("gcpsm://projects/x/secrets/y")
+	is.NoErr(err)
+	is.Equal("s3cret", value)
+	is.Equal(secretRetries, calls)
+
+	// Failed fetches are not cached
+	calls = 0
+	stubSecretCmd(t, func(name string, args ...string) ([]byte, error) {
+		calls++
+		return nil, errors.Errorf("permission denied")
+	})
+	_, err = ResolveSecret("gcpsm://projects/x/secrets/z")
+	is.True(err != nil)
+	is.Equal(secretRetries, calls)
+	_, err = ResolveSecret("gcpsm://projects/x/secrets/z")
+	is.True(err != nil)
+	is.Equal(2*secretRetries, calls)
+}
+
+func TestResolveSecretsParallel(t *testing.T) {
+	is := is.New(t)
+
+	var inFlight, maxInFlight atomic.Int32
+	stubSecretCmd(t, func(name string, args ...string) ([]byte, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		if args[5] == "s7" {
+			return nil, errors.Errorf("permission denied")
+		}
+		return []byte(args[5]), nil
+	})
+
+	configMap := make(map[string]string)
+	for i := 0; i < 3*secretWorkers; i++ {
+		configMap[fmt.Sprintf("APP_S%02d", i)] =
+			fmt.Sprintf("gcpsm://projects/x/secrets/s%d", i)
+	}
+	keys, err := ResolveSecrets(configMap)
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "APP_S07"))
+	is.Equal(0, len(keys))
+	// References are not replaced on error
+	is.Equal("gcpsm://projects/x/secrets/s0", configMap["APP_S00"])
+	is.True(maxInFlight.Load() > 1)
+	is.True(maxInFlight.Load() <= secretWorkers)
+
+	delete(configMap, "APP_S07")
+	keys, err = ResolveSecrets(configMap)
+	is.NoErr(err)
+	is.Equal(3*secretWorkers-1, len(keys))
+	is.Equal("s0", configMap["APP_S00"])
+	is.Equal("s23", configMap["APP_S23"])
+}
+
+func TestResolveSecretCoalesce(t *testing.T) {
+	is := is.New(t)
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	stubSecretCmd(t, func(name string, args ...string) ([]byte, error) {
+		calls.Add(1)
+		<-release
+		return []byte("s3cret"), nil
+	})
+
+	n := 10
+	values := make(chan string, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			value, err := ResolveSecret("gcpsm://projects/x/secrets/y")
+			if err != nil {
+				value = err.Error()
+			}
+			values <- value
+		}()
+	}
+	// Wait for the fetch to start before releasing it
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	for i := 0; i < n; i++ {
+		is.Equal("s3cret", <-values)
+	}
+	is.Equal(int32(1), calls.Load())
+}
@@ -38,14 +38,28 @@ func SamplePrefix() string {
 // It can also be used to return paths to sample config file by prefixing env,
 // for example, to get the path to "sample.config.dev.json" pass env="sample.dev"
 func GetConfigFilePath(appDir, env, fileType string) (string, error) {
+	err := checkAppDir(appDir)
+	if err != nil {
+		return "", err
+	}
+	return getConfigFilePath(appDir, env, fileType), nil
+}
+
+// checkAppDir returns an error if appDir does not exist
+func checkAppDir(appDir string) error {
 	if _, err := os.Stat(appDir); err != nil {
 		if os.IsNotExist(err) {
-			return "", errors.Errorf("app dir does not exist %v", appDir)
+			return errors.Errorf("app dir does not exist %v", appDir)
 		} else {
-			return "", errors.WithStack(err)
+			return errors.WithStack(err)
 		}
 	}
+	return nil
+}
 
+// getConfigFilePath is the same as GetConfigFilePath,
+// but does not check if appDir exists
+func getConfigFilePath(appDir, env, fileType string) string {
 	// Strip sample prefix from env
 	env = strings.TrimSpace(env)
 	sample := ""
@@ -59,7 +73,7 @@ func GetConfigFilePath(appDir, env, fileType string) (string, error) {
 
 	// Text editors usually do syntax highlighting for ".env" files
 	if fileType == FileTypeENV && sample == "" && env == "" {
-		return filepath.Join(appDir, ".env"), nil
+		return filepath.Join(appDir, ".env")
 	}
 
 	// If env is not empty, add dot separator.
@@ -73,36 +87,34 @@ func GetConfigFilePath(appDir, env, fileType string) (string, error) {
 		// E.g. .env.prod.sh or sample.env.prod.sh
 		fileNameFormat := "%v.env%v%v"
 		return filepath.Join(
-			appDir, fmt.Sprintf(fileNameFormat, sample, env, fileType)), nil
+			appDir, fmt.Sprintf(fileNameFormat, sample, env, fileType))
 	}
 
 	// E.g. config.dev.json or sample.config.dev.json
 	fileNameFormat := "%vconfig%v%v"
 	return filepath.Join(
-		appDir, fmt.Sprintf(fileNameFormat, samplePrefix, env, fileType)), nil
+		appDir, fmt.Sprintf(fileNameFormat, samplePrefix, env, fileType))
 }
 
 // GetConfigFilePaths returns paths config files might be loaded from
 func GetConfigFilePaths(appDir, env string) (paths []string, err error) {
 	paths = []string{}
 
+	// Check once, instead of for every path
+	err = checkAppDir(appDir)
+	if err != nil {
+		return paths, err
+	}
+
 	for _, fileType := range LoadPrecedence() {
 		if fileType != FileTypeENV {
-			configPath, err := GetConfigFilePath(appDir, env, fileType)
-			if err != nil {
-				return paths, err
-			}
-			paths = append(paths, configPath)
+			paths = append(paths, getConfigFilePath(appDir, env, fileType))
 		}
 
 		if env == EnvDev {
 			// For the dev config file, the env is optional, i.e.
 			// "config.dev.json" or "config.json" are both valid dev config files
-			configPath, err := GetConfigFilePath(appDir, "", fileType)
-			if err != nil {
-				return paths, err
-			}
-			paths = append(paths, configPath)
+			paths = append(paths, getConfigFilePath(appDir, "", fileType))
 		}
 	}
 
@@ -139,17 +151,24 @@ func UnmarshalENV(b []byte) (m map[string]string, err error) {
 		key = strings.TrimSpace(key)
 		value = strings.TrimSpace(value)
 
-		// Remove surrounding quotes,
-		// quotes inside the value is kept
-		value = strings.TrimPrefix(value, "\"")
-		value = strings.TrimSuffix(value, "\"")
-
-		m[key] = value
+		m[key] = UnquoteENV(value)
 	}
 
 	return m, nil
 }
 
+// UnquoteENV removes surrounding quotes from an env file value,
+// quotes inside the value is kept.
+// Single quoted values are unescaped the same as a POSIX shell
+func UnquoteENV(value string) string {
+	if len(value) > 1 && strings.HasPrefix(value, "'") &&
+		strings.HasSuffix(value, "'") {
+		return strings.ReplaceAll(value[1:len(value)-1], `'\''`, "'")
+	}
+	value = strings.TrimPrefix(value, "\"")
+	return strings.TrimSuffix(value, "\"")
+}
+
 func UnmarshalConfig(configPath string, b []byte) (
 	configMap map[string]string, err error) {
 
@@ -0,0 +1,34 @@
+package share
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// KeysHash returns a hash of the sorted keys. The hash is embedded in
+// generated code, to detect when the keys changed since generate
+func KeysHash(keys []string) string {
+	sorted := make([]string, len(keys))
+	copy(sorted, keys)
+	sort.Strings(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// EnvKeys returns the names of env vars with the prefix.
+// The prefix DIR key is excluded, it's usually not in the config file
+func EnvKeys(prefix string) (keys []string) {
+	keys = make([]string, 0)
+	appDirKey := fmt.Sprintf("%sDIR", prefix)
+	for _, kv := range os.Environ() {
+		key, _, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(key, prefix) && key != appDirKey {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
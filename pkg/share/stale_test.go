@@ -0,0 +1,28 @@
+package share
+
+import (
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestKeysHash(t *testing.T) {
+	is := is.New(t)
+
+	// Order does not matter
+	is.Equal(KeysHash([]string{"APP_A", "APP_B"}),
+		KeysHash([]string{"APP_B", "APP_A"}))
+	is.True(KeysHash([]string{"APP_A"}) != KeysHash([]string{"APP_A", "APP_B"}))
+	is.Equal(64, len(KeysHash(nil)))
+}
+
+func TestEnvKeys(t *testing.T) {
+	is := is.New(t)
+
+	t.Setenv("STALE_TEST_DIR", "/tmp")
+	t.Setenv("STALE_TEST_FOO", "foo")
+	t.Setenv("STALE_TEST_BAR", "")
+	keys := EnvKeys("STALE_TEST_")
+	is.Equal(KeysHash([]string{"STALE_TEST_BAR", "STALE_TEST_FOO"}),
+		KeysHash(keys))
+}
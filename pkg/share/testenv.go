@@ -0,0 +1,61 @@
+package share
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// EnvTest is loaded automatically by generated code under go test,
+// the config file is searched relative to the package under test
+const EnvTest = "test"
+
+// FindConfigFile searches dir and its parents for a config file for env,
+// up to and including rootDir. If rootDir is empty the search stops at the
+// module root, i.e. the first dir containing go.mod.
+// The path is empty if the config file is not found
+func FindConfigFile(dir, rootDir, env string) (configPath string, err error) {
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return configPath, errors.WithStack(err)
+	}
+	if rootDir != "" {
+		rootDir, err = filepath.Abs(rootDir)
+		if err != nil {
+			return configPath, errors.WithStack(err)
+		}
+	}
+
+	for {
+		paths, err := GetConfigFilePaths(dir, env)
+		if err != nil {
+			return configPath, err
+		}
+		for _, p := range paths {
+			_, err := os.Stat(p)
+			if err == nil {
+				return p, nil
+			}
+			if !os.IsNotExist(err) {
+				return configPath, errors.WithStack(err)
+			}
+		}
+
+		if rootDir == dir {
+			return "", nil
+		}
+		if rootDir == "" {
+			_, err = os.Stat(filepath.Join(dir, "go.mod"))
+			if err == nil {
+				return "", nil
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			// Filesystem root
+			return "", nil
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,47 @@
+package share
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestFindConfigFile(t *testing.T) {
+	is := is.New(t)
+
+	tmp, err := os.MkdirTemp("", "mozey-config")
+	is.NoErr(err)
+	defer (func() {
+		_ = os.RemoveAll(tmp)
+	})()
+
+	pkgDir := filepath.Join(tmp, "pkg", "foo")
+	is.NoErr(os.MkdirAll(pkgDir, 0700))
+	is.NoErr(os.WriteFile(filepath.Join(tmp, "go.mod"), []byte("module foo"), 0600))
+
+	// Not found
+	configPath, err := FindConfigFile(pkgDir, "", EnvTest)
+	is.NoErr(err)
+	is.Equal("", configPath)
+
+	// Found in a parent dir
+	rootConfig := filepath.Join(tmp, "config.test.json")
+	is.NoErr(os.WriteFile(rootConfig, []byte("{}"), 0600))
+	configPath, err = FindConfigFile(pkgDir, "", EnvTest)
+	is.NoErr(err)
+	is.Equal(rootConfig, configPath)
+
+	// The search stops at rootDir
+	configPath, err = FindConfigFile(pkgDir, filepath.Join(tmp, "pkg"), EnvTest)
+	is.NoErr(err)
+	is.Equal("", configPath)
+
+	// The nearest config file is used
+	pkgConfig := filepath.Join(pkgDir, "config.test.yaml")
+	is.NoErr(os.WriteFile(pkgConfig, []byte(""), 0600))
+	configPath, err = FindConfigFile(pkgDir, tmp, EnvTest)
+	is.NoErr(err)
+	is.Equal(pkgConfig, configPath)
+}
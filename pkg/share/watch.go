@@ -0,0 +1,101 @@
+package share
+
+import (
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// WatchDebounce is the quiet period after the last file event, before
+// config is reloaded by the generated Watch funcs. Editors often write a
+// file in bursts, or save by renaming a temp file over the original
+var WatchDebounce = 100 * time.Millisecond
+
+// WatchFiles maps config file names in the app dir to envs
+type WatchFiles map[string][]string
+
+// NewWatchFiles for the config files of envs in appDir,
+// for all the file types, see GetConfigFilePaths
+func NewWatchFiles(appDir string, envs []string) (WatchFiles, error) {
+	files := make(WatchFiles)
+	for _, env := range envs {
+		filePaths, err := GetConfigFilePaths(appDir, env)
+		if err != nil {
+			return files, err
+		}
+		for _, filePath := range filePaths {
+			name := filepath.Base(filePath)
+			files[name] = append(files[name], env)
+		}
+	}
+	return files, nil
+}
+
+// Envs returns the envs to reload for an event on path.
+// Temp and backup files, e.g. "config.dev.json~" written by vim,
+// don't match. The config file itself is created, or renamed,
+// when the temp file replaces it
+func (files WatchFiles) Envs(path string) []string {
+	return files[filepath.Base(path)]
+}
+
+// WatchBatch collects envs to reload from file events,
+// until there are no events for the debounce period
+type WatchBatch struct {
+	Debounce time.Duration
+	pending  map[string]bool
+	timer    *time.Timer
+}
+
+// Add envs to the batch, and restart the debounce period
+func (b *WatchBatch) Add(envs ...string) {
+	if len(envs) == 0 {
+		return
+	}
+	if b.pending == nil {
+		b.pending = make(map[string]bool)
+	}
+	for _, env := range envs {
+		b.pending[env] = true
+	}
+	if b.timer == nil {
+		b.timer = time.NewTimer(b.Debounce)
+		return
+	}
+	if !b.timer.Stop() {
+		select {
+		case <-b.timer.C:
+		default:
+		}
+	}
+	b.timer.Reset(b.Debounce)
+}
+
+// C receives when the debounce period is over, then call Flush.
+// The channel is nil if the batch is empty, i.e. it blocks
+func (b *WatchBatch) C() <-chan time.Time {
+	if b.timer == nil {
+		return nil
+	}
+	return b.timer.C
+}
+
+// Flush returns the sorted envs in the batch, and empties it
+func (b *WatchBatch) Flush() (envs []string) {
+	b.Stop()
+	envs = make([]string, 0, len(b.pending))
+	for env := range b.pending {
+		envs = append(envs, env)
+	}
+	sort.Strings(envs)
+	b.pending = nil
+	return envs
+}
+
+// Stop the debounce timer
+func (b *WatchBatch) Stop() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+}
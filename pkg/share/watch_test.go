@@ -0,0 +1,54 @@
+package share
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestWatchFiles(t *testing.T) {
+	is := is.New(t)
+
+	appDir := t.TempDir()
+	files, err := NewWatchFiles(appDir, []string{EnvDev, "prod"})
+	is.NoErr(err)
+
+	is.Equal([]string{EnvDev}, files.Envs(filepath.Join(appDir, "config.dev.json")))
+	is.Equal([]string{EnvDev}, files.Envs(filepath.Join(appDir, ".env")))
+	is.Equal([]string{"prod"}, files.Envs(filepath.Join(appDir, "config.prod.yaml")))
+	// Temp and backup files written by editors
+	is.Equal(0, len(files.Envs(filepath.Join(appDir, "config.dev.json~"))))
+	is.Equal(0, len(files.Envs(filepath.Join(appDir, ".config.dev.json.swp"))))
+	is.Equal(0, len(files.Envs(filepath.Join(appDir, "4913"))))
+	is.Equal(0, len(files.Envs(filepath.Join(appDir, "config.stage.json"))))
+}
+
+func TestWatchBatch(t *testing.T) {
+	is := is.New(t)
+
+	b := &WatchBatch{Debounce: 20 * time.Millisecond}
+	defer b.Stop()
+	is.True(b.C() == nil)
+	b.Add()
+	is.True(b.C() == nil)
+
+	// Bursts are coalesced, e.g. rename, create, and write on save
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		b.Add("prod")
+		b.Add(EnvDev)
+		time.Sleep(5 * time.Millisecond)
+	}
+	<-b.C()
+	is.True(time.Since(start) >= 40*time.Millisecond)
+	is.Equal([]string{EnvDev, "prod"}, b.Flush())
+	is.True(b.C() == nil)
+	is.Equal(0, len(b.Flush()))
+
+	// The timer restarts after a flush
+	b.Add(EnvDev)
+	<-b.C()
+	is.Equal([]string{EnvDev}, b.Flush())
+}